@@ -0,0 +1,195 @@
+// Binary gokr-kmsg forwards the messages of the Linux kernel (read from
+// /dev/kmsg) to gokr-syslogd, with tag kernel and their original facility and
+// severity. gokrazy only forwards the output of the programs it supervises, so
+// kernel events like OOM kills or USB errors are otherwise missing.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gokrazy/syslogd/senderconfig"
+)
+
+// record is a message of the kernel ring buffer, see
+// https://www.kernel.org/doc/Documentation/ABI/testing/dev-kmsg
+type record struct {
+	priority int // facility<<3 | severity
+	seq      uint64
+	uptime   time.Duration // since boot
+	message  string
+}
+
+// parseRecord parses a record as read from /dev/kmsg, e.g.:
+//
+//	6,339,5140900,-;NET: Registered protocol family 10
+//	 SUBSYSTEM=net
+//
+// Continuation lines (dictionary of key=value pairs) are skipped.
+func parseRecord(b []byte) (record, error) {
+	prefix, rest, ok := bytes.Cut(b, []byte{';'})
+	if !ok {
+		return record{}, fmt.Errorf("invalid record %q: no ; separator", b)
+	}
+	fields := strings.Split(string(prefix), ",")
+	if len(fields) < 3 {
+		return record{}, fmt.Errorf("invalid record %q: expected priority,sequence,timestamp", b)
+	}
+	priority, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return record{}, fmt.Errorf("invalid record %q: priority: %v", b, err)
+	}
+	seq, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return record{}, fmt.Errorf("invalid record %q: sequence: %v", b, err)
+	}
+	usec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return record{}, fmt.Errorf("invalid record %q: timestamp: %v", b, err)
+	}
+	message, _, _ := bytes.Cut(rest, []byte{'\n'})
+	return record{
+		priority: priority,
+		seq:      seq,
+		uptime:   time.Duration(usec) * time.Microsecond,
+		message:  string(message),
+	}, nil
+}
+
+// format formats r as an RFC3164 message of hostname, logged at t. The message
+// is prefixed with the uptime like dmesg(1) does, which gokr-syslogd
+// -boot_sessions uses to detect reboots.
+func (r record) format(hostname string, t time.Time) []byte {
+	usec := r.uptime.Microseconds()
+	return []byte(fmt.Sprintf("<%d>%s %s kernel: [%5d.%06d] %s",
+		r.priority,
+		t.Format(time.Stamp),
+		hostname,
+		usec/1000000,
+		usec%1000000,
+		r.message))
+}
+
+// bootTime returns when the system booted, based on /proc/uptime.
+func bootTime() (time.Time, error) {
+	b, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}, err
+	}
+	uptime, _, _ := strings.Cut(string(b), " ")
+	seconds, err := strconv.ParseFloat(uptime, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("/proc/uptime: %v", err)
+	}
+	return time.Now().Add(-time.Duration(seconds * float64(time.Second))), nil
+}
+
+func kmsg(ctx context.Context) error {
+	var (
+		target = flag.String("target",
+			"localhost:5514",
+			"host:port of gokr-syslogd (UDP), mdns to discover a gokr-syslogd started with -mdns on the local network, or an http:// URL of a gokr-syslogd sender configuration (e.g. http://syslog.lan:8080/.well-known/gokr-syslogd.json)")
+
+		hostname = flag.String("hostname",
+			"",
+			"hostname to send messages as (defaults to os.Hostname)")
+
+		kmsgPath = flag.String("kmsg",
+			"/dev/kmsg",
+			"path to the kernel ring buffer device")
+
+		skipExisting = flag.Bool("skip_existing",
+			false,
+			"skip the messages which are in the ring buffer already, e.g. when gokr-kmsg is restarted (by default, all messages since boot are forwarded)")
+	)
+	flag.Parse()
+
+	if *hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		*hostname = h
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*kmsgPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if *skipExisting {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	addr, err := senderconfig.ResolveTarget(ctx, *target)
+	if err != nil {
+		return err
+	}
+	if addr != *target {
+		log.Printf("discovered gokr-syslogd at %s", addr)
+		*target = addr
+	}
+	conn, err := net.Dial("udp", *target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Each read returns exactly one record.
+	buf := make([]byte, 8192)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.EPIPE) {
+				// Records were overwritten before we read them, reading
+				// continues with the next available record.
+				log.Printf("kernel ring buffer overrun, messages lost")
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r, err := parseRecord(buf[:n])
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if _, err := conn.Write(r.format(*hostname, boot.Add(r.uptime))); err != nil {
+			// E.g. connection refused while gokr-syslogd restarts.
+			log.Printf("sending message: %v", err)
+		}
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := kmsg(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRecord(t *testing.T) {
+	r, err := parseRecord([]byte("3,339,5140900,-;usb 1-1: device descriptor read/64, error -71\n SUBSYSTEM=usb\n DEVICE=c189:1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := record{
+		priority: 3,
+		seq:      339,
+		uptime:   5140900 * time.Microsecond,
+		message:  "usb 1-1: device descriptor read/64, error -71",
+	}
+	if diff := cmp.Diff(want, r, cmp.AllowUnexported(record{})); diff != "" {
+		t.Errorf("parseRecord: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	ts := time.Date(2022, time.August, 3, 16, 20, 0, 0, time.UTC)
+	if got, want := string(r.format("dr", ts)), "<3>Aug  3 16:20:00 dr kernel: [    5.140900] usb 1-1: device descriptor read/64, error -71"; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+
+	for _, input := range []string{
+		"3,339,5140900,-",
+		"3,339;message",
+		"err,339,5140900,-;message",
+	} {
+		if _, err := parseRecord([]byte(input)); err == nil {
+			t.Errorf("parseRecord(%q) unexpectedly succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/errindex"
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/gokrazy/syslogd/internal/rollup"
+)
+
+func backupCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("backup", flag.ExitOnError)
+	var (
+		syslogdDir = fset.String("syslogd_dir",
+			"/perm/syslogd",
+			"directory containing the log files written by gokr-syslogd")
+
+		syslogdURL = fset.String("syslogd_url",
+			"",
+			"base URL of the gokr-syslogd HTTP server (see its -http_listen flag), e.g. http://localhost:5515. If set, gokr-syslogd is asked to flush its buffered lines before the snapshot is taken.")
+
+		dest = fset.String("dest",
+			"",
+			"directory to create the snapshot in, e.g. /perm/syslogd-backup/2022-08-13 (must not exist yet)")
+	)
+	fset.Parse(args)
+	if *dest == "" {
+		return fmt.Errorf("syntax: gokr-syslogctl backup -dest=<directory>")
+	}
+
+	if *syslogdURL != "" {
+		if err := flush(ctx, *syslogdURL); err != nil {
+			return err
+		}
+	}
+	if err := snapshot(*syslogdDir, *dest); err != nil {
+		return err
+	}
+	log.Printf("snapshot of %s written to %s", *syslogdDir, *dest)
+	return nil
+}
+
+// flush asks gokr-syslogd to write its buffered lines to disk.
+func flush(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(baseURL, "/")+"/flush", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("flushing gokr-syslogd: unexpected HTTP response: %v: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// manifestName is the name of the manifest within a snapshot. The format is
+// the one of sha256sum(1), so that a snapshot can be verified using:
+//
+//	cd /perm/syslogd-backup/2022-08-13 && sha256sum -c SHA256SUMS
+const manifestName = "SHA256SUMS"
+
+// snapshot creates a consistent copy of the log tree in src at dest, suitable
+// for rsync or restic:
+//
+//   - Compressed log files, error indexes and manifests are never modified
+//     (only replaced or deleted), so they are hard-linked, or copied if dest
+//     is on a different file system.
+//   - Compressed log files are checked against the manifest of their host
+//     directory (see gokr-syslogd -manifest). Files which do not match are
+//     still included, but make snapshot return an error.
+//   - Uncompressed log files and boot lists might still be written to. They
+//     are copied up to their last complete line, so that the snapshot never
+//     contains a half-written line.
+//
+// The snapshot is created under a temporary name and renamed to dest once
+// complete.
+func snapshot(src, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	tmp := dest + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	hostDirs, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	var sums bytes.Buffer
+	var mismatches []string
+	for _, hostDir := range hostDirs {
+		if !hostDir.IsDir() || strings.HasPrefix(hostDir.Name(), ".") {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(src, hostDir.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(tmp, hostDir.Name()), 0755); err != nil {
+			return err
+		}
+		mfn := filepath.Join(src, hostDir.Name(), manifest.FileName)
+		m, err := manifest.ReadFile(mfn)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			rel := filepath.Join(hostDir.Name(), name)
+			var err error
+			switch {
+			case strings.HasSuffix(name, ".log.zst"), name == errindex.FileName, name == entityindex.FileName, name == bindings.FileName, name == manifest.FileName, name == retired.FileName:
+				err = linkOrCopy(filepath.Join(src, rel), filepath.Join(tmp, rel))
+			case strings.HasSuffix(name, ".log"), name == bootlog.FileName:
+				err = copyCompleteLines(filepath.Join(src, rel), filepath.Join(tmp, rel))
+			default:
+				continue
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue // compressed or deleted in the meantime
+				}
+				return err
+			}
+			sum, err := sha256File(filepath.Join(tmp, rel))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&sums, "%x  %s\n", sum, filepath.ToSlash(rel))
+			if !strings.HasSuffix(name, ".log.zst") {
+				continue
+			}
+			hash := fmt.Sprintf("%x", sum)
+			if e, ok := m.Lookup(name); ok && e.SHA256 != hash {
+				// The file might have been replaced (see gokr-syslogd
+				// -severity_retention) after the manifest was read.
+				if m, err = manifest.ReadFile(mfn); err != nil {
+					return err
+				}
+				if e, ok := m.Lookup(name); ok && e.SHA256 != hash {
+					log.Printf("%s does not match its manifest entry: got sha256 %s, want %s", rel, hash, e.SHA256)
+					mismatches = append(mismatches, rel)
+				}
+			}
+		}
+		// Rollup files are never modified once written.
+		days, err := rollup.Days(filepath.Join(src, hostDir.Name()))
+		if err != nil {
+			return err
+		}
+		for i, day := range days {
+			if i == 0 {
+				if err := os.MkdirAll(filepath.Join(tmp, hostDir.Name(), rollup.DirName), 0755); err != nil {
+					return err
+				}
+			}
+			rel := filepath.Join(hostDir.Name(), rollup.DirName, rollup.FileName(day))
+			if err := linkOrCopy(filepath.Join(src, rel), filepath.Join(tmp, rel)); err != nil {
+				if os.IsNotExist(err) {
+					continue // deleted in the meantime
+				}
+				return err
+			}
+			sum, err := sha256File(filepath.Join(tmp, rel))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&sums, "%x  %s\n", sum, filepath.ToSlash(rel))
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmp, manifestName), sums.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("snapshot written, but %d files do not match their manifest entry (corrupted?): %v", len(mismatches), mismatches)
+	}
+	return nil
+}
+
+func linkOrCopy(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dest, in)
+}
+
+// copyCompleteLines copies src to dest, up to and including the last newline.
+func copyCompleteLines(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	// Find the last newline by reading backwards in chunks.
+	buf := make([]byte, 4096)
+	end := size
+	for end > 0 {
+		off := end - int64(len(buf))
+		if off < 0 {
+			off = 0
+		}
+		chunk := buf[:end-off]
+		if _, err := in.ReadAt(chunk, off); err != nil {
+			return err
+		}
+		if idx := bytes.LastIndexByte(chunk, '\n'); idx > -1 {
+			end = off + int64(idx) + 1
+			break
+		}
+		end = off
+	}
+	return writeFile(dest, io.NewSectionReader(in, 0, end))
+}
+
+func writeFile(dest string, r io.Reader) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func sha256File(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnapshot(t *testing.T) {
+	src := t.TempDir()
+	const (
+		complete   = "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n"
+		incomplete = "rfc3339=2022-08-13T16:20:01Z seq=2 dhcpd: DHCPOF"
+		compressed = "not actually zstd, but never modified"
+	)
+	for rel, contents := range map[string]string{
+		"dr/2022-08-12.log.zst":        compressed,
+		"dr/2022-08-13.log":            complete + incomplete,
+		"dr/.2022-08-11.log.zst123456": "renameio temp file",
+	} {
+		fn := filepath.Join(src, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup")
+	if err := snapshot(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot(src, dest); err == nil {
+		t.Errorf("snapshot(existing dest) unexpectedly succeeded")
+	}
+
+	for rel, want := range map[string]string{
+		"dr/2022-08-12.log.zst": compressed,
+		"dr/2022-08-13.log":     complete, // without the half-written line
+		manifestName: fmt.Sprintf("%x  dr/2022-08-12.log.zst\n%x  dr/2022-08-13.log\n",
+			sha256.Sum256([]byte(compressed)),
+			sha256.Sum256([]byte(complete))),
+	} {
+		b, err := os.ReadFile(filepath.Join(dest, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(b)); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", rel, diff)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "dr", ".2022-08-11.log.zst123456")); !os.IsNotExist(err) {
+		t.Errorf("temporary file unexpectedly included in snapshot (err = %v)", err)
+	}
+}
+
+func TestSnapshotManifestMismatch(t *testing.T) {
+	src := t.TempDir()
+	fn := filepath.Join(src, "dr", "2022-08-12.log.zst")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte("intact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := manifest.Hash(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestLine := e.String() + "\n"
+	if err := os.WriteFile(filepath.Join(src, "dr", manifest.FileName), []byte(manifestLine), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot(src, filepath.Join(t.TempDir(), "backup")); err != nil {
+		t.Fatalf("snapshot(intact files): %v", err)
+	}
+
+	if err := os.WriteFile(fn, []byte("rotten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "backup")
+	if err := snapshot(src, dest); err == nil {
+		t.Errorf("snapshot(corrupt file) unexpectedly succeeded")
+	}
+	b, err := os.ReadFile(filepath.Join(dest, "dr", manifest.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(manifestLine, string(b)); diff != "" {
+		t.Errorf("%s: unexpected diff (-want +got):\n%s", manifest.FileName, diff)
+	}
+}
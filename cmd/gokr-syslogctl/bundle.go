@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/query"
+)
+
+func bundleCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("bundle", flag.ExitOnError)
+	var (
+		syslogdDir = fset.String("syslogd_dir",
+			"/perm/syslogd",
+			"directory containing the log files written by gokr-syslogd")
+
+		syslogdURL = fset.String("syslogd_url",
+			"",
+			"base URL of the gokr-syslogd HTTP server (see its -http_listen flag), e.g. http://localhost:5515. If set, buffered lines are flushed first and the active anomalies are included.")
+
+		queryStr = fset.String("q",
+			"",
+			`query selecting the hosts, period and messages to include (see grog -q), e.g. 'host:dr host:scan2drive since:2022-08-13T15:00:00+02:00 until:2022-08-13T17:00:00+02:00' (default: all hosts, last 24 hours)`)
+
+		out = fset.String("o",
+			"",
+			"path of the bundle to write (default: incident-<time>.tar.gz in the current directory)")
+	)
+	fset.Parse(args)
+
+	q, err := query.Parse(*queryStr)
+	if err != nil {
+		return fmt.Errorf("-q: %v", err)
+	}
+	now := time.Now()
+	if *out == "" {
+		*out = "incident-" + now.Format("20060102-150405") + ".tar.gz"
+	}
+
+	var anomalies []byte
+	if *syslogdURL != "" {
+		if err := flush(ctx, *syslogdURL); err != nil {
+			return err
+		}
+		anomalies, err = fetch(ctx, strings.TrimSuffix(*syslogdURL, "/")+"/anomalies")
+		if err != nil {
+			// Anomaly detection might be disabled (-anomaly_window=0).
+			log.Printf("not including anomalies: %v", err)
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeBundle(ctx, f, *syslogdDir, q, now, anomalies); err != nil {
+		os.Remove(*out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("incident bundle written to %s", *out)
+	return nil
+}
+
+// fetch returns the body of a GET request to u.
+func fetch(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected HTTP response: %v: %s", u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// hostStats summarizes the lines of a host in a bundle.
+type hostStats struct {
+	lines, errors int
+	first, last   string // rfc3339= fields
+	tags          map[string]int
+}
+
+func (st *hostStats) add(line string) {
+	st.lines++
+	if v, ok := logline.Field(line, "rfc3339"); ok {
+		if st.first == "" {
+			st.first = v
+		}
+		st.last = v
+	}
+	if v, ok := logline.Field(line, "severity"); ok {
+		switch v {
+		case "emerg", "alert", "crit", "err":
+			st.errors++
+		}
+	}
+	tag, _, _ := strings.Cut(logline.Strip(line), ": ")
+	st.tags[tag]++
+}
+
+func (st *hostStats) write(w io.Writer, host string) {
+	fmt.Fprintf(w, "%s: %d lines, %d errors, from %s to %s\n", host, st.lines, st.errors, st.first, st.last)
+	tags := make([]string, 0, len(st.tags))
+	for tag := range st.tags {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if a, b := st.tags[tags[i]], st.tags[tags[j]]; a != b {
+			return a > b
+		}
+		return tags[i] < tags[j]
+	})
+	for _, tag := range tags {
+		fmt.Fprintf(w, "  %8d %s\n", st.tags[tag], tag)
+	}
+}
+
+// writeBundle writes a gzip-compressed tar archive to w with:
+//
+//   - QUERY: the query and period of the bundle.
+//   - <host>.log: the stored lines of each host matching q.
+//   - stats.txt: the number of lines, errors and lines per tag of each host.
+//   - anomalies.json: the anomalies active in gokr-syslogd, if non-nil.
+func writeBundle(ctx context.Context, w io.Writer, dir string, q *query.Query, now time.Time, anomalies []byte) error {
+	logs := make(map[string]*bytes.Buffer)
+	stats := make(map[string]*hostStats)
+	var hosts []string
+	err := logtree.Search(ctx, dir, nil, q, now, func(host, line string) error {
+		buf, ok := logs[host]
+		if !ok {
+			buf = new(bytes.Buffer)
+			logs[host] = buf
+			stats[host] = &hostStats{tags: make(map[string]int)}
+			hosts = append(hosts, host)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		stats[host].add(line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	start, end := q.Period(now)
+	if start.IsZero() {
+		start = now.Add(-logtree.DefaultSearchPeriod)
+	}
+	if end.IsZero() {
+		end = now
+	}
+	var queryInfo bytes.Buffer
+	fmt.Fprintf(&queryInfo, "query: %s\n", q)
+	fmt.Fprintf(&queryInfo, "period: %s to %s\n", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	fmt.Fprintf(&queryInfo, "generated: %s\n", now.Format(time.RFC3339))
+	var statsTxt bytes.Buffer
+	for _, host := range hosts {
+		stats[host].write(&statsTxt, host)
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	add := func(name string, b []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(b)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	if err := add("QUERY", queryInfo.Bytes()); err != nil {
+		return err
+	}
+	for _, host := range hosts {
+		if err := add(host+".log", logs[host].Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := add("stats.txt", statsTxt.Bytes()); err != nil {
+		return err
+	}
+	if anomalies != nil {
+		if err := add("anomalies.json", anomalies); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/query"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteBundle(t *testing.T) {
+	dir := t.TempDir()
+	const (
+		discover = "rfc3339=2022-08-13T16:20:00Z seq=1 severity=info dhcpd: DHCPDISCOVER\n"
+		ioError  = "rfc3339=2022-08-13T16:21:00Z seq=2 severity=err kernel: sda: I/O error\n"
+		offer    = "rfc3339=2022-08-13T16:22:00Z seq=3 severity=info dhcpd: DHCPOFFER\n"
+		tooLate  = "rfc3339=2022-08-13T18:00:00Z seq=4 severity=info dhcpd: DHCPACK\n"
+	)
+	for rel, contents := range map[string]string{
+		"dr/2022-08-13.log":         discover + ioError + offer + tooLate,
+		"scan2drive/2022-08-13.log": discover,
+	} {
+		fn := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := query.Parse("host:dr since:2022-08-13T16:00:00Z until:2022-08-13T17:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, time.August, 13, 19, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := writeBundle(context.Background(), &buf, dir, q, now, []byte("[]\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	got := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = string(b)
+	}
+	want := map[string]string{
+		"QUERY": "query: host:dr since:2022-08-13T16:00:00Z until:2022-08-13T17:00:00Z\n" +
+			"period: 2022-08-13T16:00:00Z to 2022-08-13T17:00:00Z\n" +
+			"generated: 2022-08-13T19:00:00Z\n",
+		"dr.log": discover + ioError + offer,
+		"stats.txt": "dr: 3 lines, 1 errors, from 2022-08-13T16:20:00Z to 2022-08-13T16:22:00Z\n" +
+			"         2 dhcpd\n" +
+			"         1 kernel\n",
+		"anomalies.json": "[]\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected bundle contents: diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// tokenFileFlag defines the -token_file flag of verbs which call the admin
+// endpoints of gokr-syslogd.
+func tokenFileFlag(fset *flag.FlagSet) *string {
+	return fset.String("token_file",
+		"",
+		"path to a file containing the token of gokr-syslogd -admin_token_file, if set")
+}
+
+// authorize sets the token in tokenFile (if non-empty) as bearer token of req.
+func authorize(req *http.Request, tokenFile string) error {
+	if tokenFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	return nil
+}
+
+func flushCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("flush", flag.ExitOnError)
+	syslogdURL := fset.String("syslogd_url",
+		"",
+		"base URL of the gokr-syslogd HTTP server (see its -http_listen flag), e.g. http://localhost:5515")
+	fset.Parse(args)
+	if *syslogdURL == "" {
+		return fmt.Errorf("syntax: gokr-syslogctl flush -syslogd_url=<url>")
+	}
+	return flush(ctx, *syslogdURL)
+}
+
+func rotateCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("rotate", flag.ExitOnError)
+	var (
+		syslogdURL = fset.String("syslogd_url",
+			"",
+			"base URL of the gokr-syslogd HTTP server (see its -http_listen flag), e.g. http://localhost:5515")
+
+		host = fset.String("host",
+			"",
+			"host whose current log files to rotate, e.g. before copying them for analysis")
+
+		tenant = fset.String("tenant",
+			"",
+			"tenant of the host (see gokr-syslogd -tenant), empty for the main log directory")
+
+		tokenFile = tokenFileFlag(fset)
+	)
+	fset.Parse(args)
+	if *syslogdURL == "" || *host == "" {
+		return fmt.Errorf("syntax: gokr-syslogctl rotate -syslogd_url=<url> -host=<host>")
+	}
+	v := url.Values{"host": []string{*host}}
+	if *tenant != "" {
+		v.Set("tenant", *tenant)
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(*syslogdURL, "/")+"/rotate?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := authorize(req, *tokenFile); err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("rotating %s: unexpected HTTP response: %v: %s", *host, resp.Status, strings.TrimSpace(string(b)))
+	}
+	// The paths of the rotated files.
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+func annotateCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("annotate", flag.ExitOnError)
+	var (
+		syslogdURL = fset.String("syslogd_url",
+			"",
+			"base URL of the gokr-syslogd HTTP server (see its -http_listen flag), e.g. http://localhost:5515")
+
+		host = fset.String("host",
+			"",
+			"host to file the annotation under, e.g. the upgraded device (empty for _annotations)")
+
+		author = fset.String("author",
+			os.Getenv("USER"),
+			"who made the change")
+
+		at = fset.String("at",
+			"",
+			"when the change was made, as RFC 3339 or time of day (e.g. 14:02), empty for now")
+
+		tenant = fset.String("tenant",
+			"",
+			"tenant of the host (see gokr-syslogd -tenant), empty for the main log directory")
+
+		tokenFile = tokenFileFlag(fset)
+	)
+	fset.Parse(args)
+	text := strings.Join(fset.Args(), " ")
+	if *syslogdURL == "" || text == "" {
+		return fmt.Errorf("syntax: gokr-syslogctl annotate -syslogd_url=<url> <text>")
+	}
+	v := url.Values{"text": []string{text}}
+	for key, value := range map[string]string{
+		"host":   *host,
+		"author": *author,
+		"time":   *at,
+		"tenant": *tenant,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(*syslogdURL, "/")+"/annotate?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := authorize(req, *tokenFile); err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("annotating: unexpected HTTP response: %v: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
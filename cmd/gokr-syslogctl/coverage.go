@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/coverage"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+)
+
+func coverageCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("coverage", flag.ExitOnError)
+	var (
+		syslogdDir = fset.String("syslogd_dir",
+			"/perm/syslogd",
+			"directory containing the log files written by gokr-syslogd")
+
+		days = fset.Int("days",
+			coverage.DefaultDays,
+			"number of days up to today to report")
+
+		hostAliases = fset.String("host_aliases",
+			"",
+			"comma-separated list of old=new hostname pairs (e.g. raspberrypi=dr) for renamed hosts, like gokr-syslogd -host_aliases")
+	)
+	fset.Parse(args)
+	if *days < 1 {
+		return fmt.Errorf("-days must be at least 1")
+	}
+	aliases, err := hostalias.Parse(*hostAliases)
+	if err != nil {
+		return fmt.Errorf("-host_aliases: %v", err)
+	}
+	now := time.Now()
+	hosts, err := coverage.Report(*syslogdDir, aliases, now, *days)
+	if err != nil {
+		return err
+	}
+	return coverage.Write(os.Stdout, hosts, now, *days)
+}
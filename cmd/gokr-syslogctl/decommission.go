@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/retired"
+)
+
+func decommissionCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("decommission", flag.ExitOnError)
+	var (
+		syslogdDir = fset.String("syslogd_dir",
+			"/perm/syslogd",
+			"directory containing the log files written by gokr-syslogd")
+
+		host = fset.String("host",
+			"",
+			"host to decommission, e.g. raspberrypi")
+
+		messages = fset.String("messages",
+			retired.MessagesDrop,
+			"what gokr-syslogd does with further messages from the host: "+retired.MessagesDrop+" or "+retired.MessagesQuarantine+" (store in its -quarantine_dir)")
+
+		retention = fset.String("retention",
+			retired.RetentionFreeze,
+			"what happens to the host’s log files: "+retired.RetentionFreeze+" (keep all of them), "+retired.RetentionKeep+" (delete them as usual once they expire) or "+retired.RetentionArchive+" (move them into a tar file in -archive_dir)")
+
+		archiveDir = fset.String("archive_dir",
+			"",
+			"directory in which to create <host>-<date>.tar with -retention="+retired.RetentionArchive)
+
+		undo = fset.Bool("undo",
+			false,
+			"recommission the host: accept its messages and apply retention again")
+	)
+	fset.Parse(args)
+	if *host == "" || *host != filepath.Base(*host) || strings.HasPrefix(*host, ".") {
+		return fmt.Errorf("syntax: gokr-syslogctl decommission -host=<host> [-messages=…] [-retention=…]")
+	}
+	hostDir := filepath.Join(*syslogdDir, *host)
+	if *undo {
+		return recommission(hostDir)
+	}
+	m := retired.Marker{
+		Time:      time.Now(),
+		Messages:  *messages,
+		Retention: *retention,
+	}
+	if m.Retention == retired.RetentionArchive {
+		if *archiveDir == "" {
+			return fmt.Errorf("-retention=%s requires -archive_dir", retired.RetentionArchive)
+		}
+		m.Archive = filepath.Join(*archiveDir, *host+"-"+m.Time.Format("2006-01-02")+".tar")
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	return decommission(hostDir, m)
+}
+
+// decommission writes the marker m into hostDir. With
+// retired.RetentionArchive, the log files of the host are then moved into
+// m.Archive, unless gokr-syslogd might still be writing to them: it picks up
+// the marker within a minute (and closes files after 10 minutes without
+// writes), so that running decommission again later succeeds.
+func decommission(hostDir string, m retired.Marker) error {
+	if _, err := os.Stat(hostDir); err != nil {
+		return err
+	}
+	if prev, ok, err := retired.ReadFile(hostDir); err != nil {
+		return err
+	} else if ok && prev.Archive != "" {
+		m.Archive = prev.Archive // retrying an interrupted archive
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(filepath.Join(hostDir, retired.FileName), &buf); err != nil {
+		return err
+	}
+	log.Printf("marked %s as retired (messages: %s, retention: %s)", hostDir, m.Messages, m.Retention)
+	if m.Retention != retired.RetentionArchive {
+		return nil
+	}
+
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == retired.FileName || !entry.Type().IsRegular() {
+			continue
+		}
+		if logtree.IsLogFile(name) && !strings.HasSuffix(name, ".zst") {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if m.Time.Sub(info.ModTime()) < inUseAge {
+				return fmt.Errorf("%s was modified %v ago and might still be in use, run decommission again later", filepath.Join(hostDir, name), m.Time.Sub(info.ModTime()).Round(time.Second))
+			}
+		}
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		return nil // already archived
+	}
+	if _, err := os.Stat(m.Archive); err == nil {
+		return fmt.Errorf("%s already exists, not overwriting", m.Archive)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.Archive), 0755); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, hostDir, files))
+	}()
+	if err := writeFileAtomically(m.Archive, pr); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(hostDir, name)); err != nil {
+			return err
+		}
+	}
+	log.Printf("archived %d files into %s", len(files), m.Archive)
+	return nil
+}
+
+// writeTar writes the files (names within dir) as a tar archive to w, in the
+// directory named like dir.
+func writeTar(w io.Writer, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range files {
+		if err := addToTar(tw, filepath.Join(dir, name), filepath.Base(dir)+"/"+name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addToTar(tw *tar.Writer, fn, name string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(st, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// recommission removes the marker from hostDir.
+func recommission(hostDir string) error {
+	m, ok, err := retired.ReadFile(hostDir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not retired", hostDir)
+	}
+	if err := os.Remove(filepath.Join(hostDir, retired.FileName)); err != nil {
+		return err
+	}
+	if m.Archive != "" {
+		log.Printf("recommissioned %s; its previous log files remain in %s", hostDir, m.Archive)
+	} else {
+		log.Printf("recommissioned %s", hostDir)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDecommissionArchive(t *testing.T) {
+	dir := t.TempDir()
+	hostDir := filepath.Join(dir, "raspberrypi")
+	now := time.Date(2022, time.August, 14, 16, 20, 0, 0, time.UTC)
+	if err := os.Mkdir(hostDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"2022-08-13.log.zst": "compressed",
+		"2022-08-14.log":     "rfc3339=2022-08-14T16:19:00Z seq=1 ntpd: synchronized\n",
+		"boots":              "2022-08-12T08:00:00Z aaaa\n",
+	} {
+		fn := filepath.Join(hostDir, name)
+		if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fn, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := retired.Marker{
+		Time:      now,
+		Messages:  retired.MessagesDrop,
+		Retention: retired.RetentionArchive,
+		Archive:   filepath.Join(dir, "archive", "raspberrypi-2022-08-14.tar"),
+	}
+	if err := decommission(hostDir, m); err == nil {
+		t.Fatalf("decommission(recently written file) = nil error, want error")
+	}
+	if _, ok, err := retired.ReadFile(hostDir); !ok || err != nil {
+		t.Fatalf("retired.ReadFile = %v, %v, want marker", ok, err)
+	}
+
+	m.Time = now.Add(inUseAge)
+	if err := decommission(hostDir, m); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != retired.FileName {
+		t.Errorf("host directory contains %v, want only %s", entries, retired.FileName)
+	}
+	f, err := os.Open(m.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{
+		"raspberrypi/2022-08-13.log.zst",
+		"raspberrypi/2022-08-14.log",
+		"raspberrypi/boots",
+	}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("archive: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if err := recommission(hostDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := retired.ReadFile(hostDir); ok {
+		t.Errorf("host still retired after recommission")
+	}
+}
@@ -0,0 +1,61 @@
+// Binary gokr-syslogctl performs administrative tasks on the log files which
+// gokr-syslogd writes, like creating backups or incident bundles.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// verbs maps each verb to its implementation, which parses its own flags from
+// args.
+var verbs = map[string]func(ctx context.Context, args []string) error{
+	"annotate":     annotateCmd,
+	"backup":       backupCmd,
+	"bundle":       bundleCmd,
+	"coverage":     coverageCmd,
+	"decommission": decommissionCmd,
+	"flush":        flushCmd,
+	"merge-host":   mergeHostCmd,
+	"rotate":       rotateCmd,
+}
+
+func syslogctl(ctx context.Context) error {
+	names := make([]string, 0, len(verbs))
+	for name := range verbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "syntax: gokr-syslogctl <verb> [flags]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "verbs: %s\n", strings.Join(names, ", "))
+		fmt.Fprintf(flag.CommandLine.Output(), "run gokr-syslogctl <verb> -help for the flags of a verb\n")
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		return fmt.Errorf("no verb specified")
+	}
+	verb := flag.Arg(0)
+	fn, ok := verbs[verb]
+	if !ok {
+		flag.Usage()
+		return fmt.Errorf("unknown verb %q", verb)
+	}
+	return fn(ctx, flag.Args()[1:])
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := syslogctl(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/errindex"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/klauspost/compress/zstd"
+)
+
+func mergeHostCmd(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("merge-host", flag.ExitOnError)
+	var (
+		syslogdDir = fset.String("syslogd_dir",
+			"/perm/syslogd",
+			"directory containing the log files written by gokr-syslogd")
+
+		from = fset.String("from",
+			"",
+			"old name of the host, e.g. raspberrypi")
+
+		to = fset.String("to",
+			"",
+			"current name of the host, e.g. dr")
+	)
+	fset.Parse(args)
+	for _, name := range []string{*from, *to} {
+		if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return fmt.Errorf("syntax: gokr-syslogctl merge-host -from=<old host> -to=<current host>")
+		}
+	}
+	if *from == *to {
+		return fmt.Errorf("-from and -to must differ")
+	}
+	skipped, err := mergeHost(*syslogdDir, *from, *to, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("%d files were not merged (see above), run merge-host again later: %v", len(skipped), skipped)
+	}
+	log.Printf("merged %s into %s", *from, *to)
+	return nil
+}
+
+// inUseAge is how recently an uncompressed log file must have been modified
+// to be considered in use: gokr-syslogd closes files after 10 minutes without
+// writes.
+const inUseAge = 15 * time.Minute
+
+// mergeHost moves the files of host directory from into host directory to,
+// merging files which exist in both (log files line by line in timestamp
+// order, error indexes and boot lists entry by entry). Log files which
+// gokr-syslogd might still be writing to are skipped and returned, so that
+// merging is safe while gokr-syslogd runs (with -host_aliases, so that no new
+// files appear in from). The from directory is removed once empty.
+func mergeHost(dir, from, to string, now time.Time) (skipped []string, _ error) {
+	src := filepath.Join(dir, from)
+	dst := filepath.Join(dir, to)
+	if _, err := os.Stat(src); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		log.Printf("%s does not exist, renaming %s", dst, src)
+		return nil, os.Rename(src, dst)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifest.ReadFile(filepath.Join(dst, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	exists := func(fn string) (os.FileInfo, bool) {
+		st, err := os.Stat(fn)
+		return st, err == nil
+	}
+	inUse := func(fn string) bool {
+		st, ok := exists(fn)
+		return ok && now.Sub(st.ModTime()) < inUseAge
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		srcFn := filepath.Join(src, name)
+		dstFn := filepath.Join(dst, name)
+		switch {
+		case name == manifest.FileName:
+			continue // entries are recomputed below, removed at the end
+
+		case name == errindex.FileName:
+			if err := mergeErrorIndex(srcFn, dstFn); err != nil {
+				return nil, err
+			}
+
+		case name == entityindex.FileName:
+			if err := mergeEntityIndex(srcFn, dstFn); err != nil {
+				return nil, err
+			}
+
+		case name == bindings.FileName:
+			if err := mergeBindings(srcFn, dstFn); err != nil {
+				return nil, err
+			}
+
+		case name == bootlog.FileName:
+			if err := mergeBoots(srcFn, dstFn); err != nil {
+				return nil, err
+			}
+
+		case logtree.IsLogFile(name) && !strings.HasPrefix(name, "."):
+			// A day’s file might exist in either version (and in both
+			// while being compressed).
+			day := strings.TrimSuffix(name, ".zst")
+			if name != day {
+				if _, ok := exists(filepath.Join(src, day)); ok {
+					continue // handled with the uncompressed version
+				}
+			}
+			_, srcBoth := exists(filepath.Join(src, day+".zst"))
+			srcBoth = srcBoth && name == day
+			_, dstPlain := exists(filepath.Join(dst, day))
+			_, dstCompressed := exists(filepath.Join(dst, day+".zst"))
+			if srcBoth || (dstPlain && dstCompressed) ||
+				inUse(filepath.Join(src, day)) || inUse(filepath.Join(dst, day)) {
+				log.Printf("skipping %s: in use by gokr-syslogd", srcFn)
+				skipped = append(skipped, srcFn)
+				continue
+			}
+			target := dstFn
+			switch {
+			case dstPlain:
+				target = filepath.Join(dst, day)
+			case dstCompressed:
+				target = filepath.Join(dst, day+".zst")
+			}
+			if !dstPlain && !dstCompressed {
+				log.Printf("moving %s to %s", srcFn, dst)
+				if err := os.Rename(srcFn, dstFn); err != nil {
+					return nil, err
+				}
+			} else {
+				log.Printf("merging %s into %s", srcFn, target)
+				if err := mergeLogFiles(srcFn, target); err != nil {
+					return nil, err
+				}
+				if err := os.Remove(srcFn); err != nil {
+					return nil, err
+				}
+			}
+			if strings.HasSuffix(target, ".zst") {
+				e, err := manifest.Hash(target)
+				if err != nil {
+					return nil, err
+				}
+				m.Set(e)
+			}
+			continue
+
+		default:
+			log.Printf("skipping %s: unknown file", srcFn)
+			skipped = append(skipped, srcFn)
+			continue
+		}
+		if err := os.Remove(srcFn); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomically(filepath.Join(dst, manifest.FileName), &buf); err != nil {
+		return nil, err
+	}
+	if len(skipped) > 0 {
+		return skipped, nil
+	}
+	if err := os.Remove(filepath.Join(src, manifest.FileName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return nil, os.Remove(src)
+}
+
+// readLogFile returns the lines of the (optionally compressed) log file fn.
+func readLogFile(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := io.Reader(f)
+	if strings.HasSuffix(fn, ".zst") {
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		rd = dec
+	}
+	var lines []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// mergeLogFiles merges the lines of the log file src into the log file dest
+// in timestamp order, compressing them if dest is compressed.
+func mergeLogFiles(src, dest string) error {
+	srcLines, err := readLogFile(src)
+	if err != nil {
+		return err
+	}
+	lines, err := readLogFile(dest)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, srcLines...)
+	timestamp := func(line string) time.Time {
+		v, _ := logline.Field(line, "rfc3339")
+		t, _ := time.Parse(time.RFC3339Nano, v)
+		return t
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return timestamp(lines[i]).Before(timestamp(lines[j]))
+	})
+	var buf bytes.Buffer
+	w := io.Writer(&buf)
+	var enc *zstd.Encoder
+	if strings.HasSuffix(dest, ".zst") {
+		enc, err = zstd.NewWriter(&buf)
+		if err != nil {
+			return err
+		}
+		w = enc
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomically(dest, &buf)
+}
+
+func mergeErrorIndex(src, dest string) error {
+	old, err := errindex.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	idx, err := errindex.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	idx.Merge(old)
+	var buf bytes.Buffer
+	if err := idx.Write(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomically(dest, &buf)
+}
+
+func mergeEntityIndex(src, dest string) error {
+	old, err := entityindex.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	idx, err := entityindex.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	idx.Merge(old)
+	var buf bytes.Buffer
+	if err := idx.Write(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomically(dest, &buf)
+}
+
+func mergeBindings(src, dest string) error {
+	old, err := bindings.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	t, err := bindings.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	t.Merge(old)
+	var buf bytes.Buffer
+	if err := t.Write(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomically(dest, &buf)
+}
+
+func mergeBoots(src, dest string) error {
+	old, err := bootlog.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	boots, err := bootlog.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	boots = append(boots, old...)
+	sort.SliceStable(boots, func(i, j int) bool {
+		return boots[i].Start.Before(boots[j].Start)
+	})
+	var buf bytes.Buffer
+	for _, b := range boots {
+		fmt.Fprintln(&buf, b)
+	}
+	return writeFileAtomically(dest, &buf)
+}
+
+// writeFileAtomically replaces dest with the contents of r, keeping the
+// permissions of dest (if it exists).
+func writeFileAtomically(dest string, r io.Reader) error {
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(dest); err == nil {
+		mode = st.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
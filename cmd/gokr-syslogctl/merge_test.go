@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressed(t *testing.T, contents string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write([]byte(contents))
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMergeHost(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, time.August, 14, 16, 20, 0, 0, time.UTC)
+	const (
+		old1 = "rfc3339=2022-08-13T10:00:00Z seq=1 ntpd: synchronized\n"
+		new1 = "rfc3339=2022-08-13T09:00:00Z seq=1 dhcpd: DHCPDISCOVER\n"
+		new2 = "rfc3339=2022-08-13T11:00:00Z seq=2 dhcpd: DHCPOFFER\n"
+	)
+	for rel, contents := range map[string]string{
+		"raspberrypi/2022-08-12.log.zst": compressed(t, "moved\n"),
+		"raspberrypi/2022-08-13.log":     old1,
+		"raspberrypi/2022-08-14.log":     "in use\n",
+		"raspberrypi/boots":              "2022-08-12T08:00:00Z aaaa\n",
+		"dr/2022-08-13.log.zst":          compressed(t, new1+new2),
+		"dr/boots":                       "2022-08-13T08:00:00Z bbbb\n",
+	} {
+		fn := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(filepath.Base(rel), "2022-08-14") {
+			if err := os.Chtimes(fn, now.Add(-24*time.Hour), now.Add(-24*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Chtimes(filepath.Join(dir, "raspberrypi", "2022-08-14.log"), now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := mergeHost(dir, "raspberrypi", "dr", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{filepath.Join(dir, "raspberrypi", "2022-08-14.log")}, skipped); diff != "" {
+		t.Errorf("mergeHost: unexpected skipped diff (-want +got):\n%s", diff)
+	}
+	for rel, want := range map[string]string{
+		"dr/2022-08-12.log.zst": "moved\n",
+		"dr/2022-08-13.log.zst": new1 + old1 + new2,
+	} {
+		lines, err := readLogFile(filepath.Join(dir, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, strings.Join(lines, "\n")+"\n"); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", rel, diff)
+		}
+		m, err := manifest.ReadFile(filepath.Join(dir, "dr", manifest.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, ok := m.Lookup(filepath.Base(rel))
+		if !ok {
+			t.Errorf("%s: no manifest entry", rel)
+		} else if err := e.Verify(filepath.Join(dir, rel)); err != nil {
+			t.Error(err)
+		}
+	}
+	boots, err := bootlog.ReadFile(filepath.Join(dir, "dr", bootlog.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(boots), 2; got != want || boots[0].ID != "aaaa" {
+		t.Errorf("merged boots = %v, want 2 boots, aaaa first", boots)
+	}
+
+	// Once gokr-syslogd no longer uses the file, merging completes.
+	later := now.Add(1 * time.Hour)
+	skipped, err = mergeHost(dir, "raspberrypi", "dr", later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) > 0 {
+		t.Errorf("mergeHost: skipped %v, want none", skipped)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raspberrypi")); !os.IsNotExist(err) {
+		t.Errorf("raspberrypi directory still exists after merging (err = %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dr", "2022-08-14.log")); err != nil {
+		t.Error(err)
+	}
+}
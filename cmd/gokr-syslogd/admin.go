@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errWriteLoopTimeout = errors.New("timeout waiting for the write loop")
+
+// flush asks the write loop of s to write all buffered lines to disk.
+func (s *server) flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case s.flushRequests <- reply:
+	case <-time.After(10 * time.Second):
+		return errWriteLoopTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-reply
+}
+
+// onWriteLoop calls fn on the write loop of s (see run), which owns the open
+// log files, and returns once fn returned. While fn runs, no messages are
+// written.
+func (s *server) onWriteLoop(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	select {
+	case s.loopRequests <- func() {
+		defer close(done)
+		fn()
+	}:
+	case <-time.After(10 * time.Second):
+		return errWriteLoopTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// flushHandler writes all buffered lines of all servers (one per tenant) to
+// disk before responding, e.g. so that gokr-syslogctl backup captures all
+// messages received so far. Messages held back by -reorder_window are not yet
+// written.
+func flushHandler(servers []*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		for _, s := range servers {
+			if err := s.flush(r.Context()); err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				code := http.StatusInternalServerError
+				if err == errWriteLoopTimeout {
+					code = http.StatusServiceUnavailable
+				}
+				http.Error(w, fmt.Sprintf("%s: %v", s.dir, err), code)
+				return
+			}
+		}
+		fmt.Fprintf(w, "flushed\n")
+	}
+}
+
+var errExternalRotation = errors.New("log files are rotated externally (-rotation=" + rotationExternal + ")")
+
+// rotatedName returns the first numbered name for the log file fn which is not
+// taken, e.g. 2022-08-13.1.log for 2022-08-13.log.
+func rotatedName(fn string) string {
+	base := strings.TrimSuffix(fn, ".log")
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s.%d.log", base, n)
+		_, err := os.Stat(candidate)
+		_, zstErr := os.Stat(candidate + ".zst")
+		if os.IsNotExist(err) && os.IsNotExist(zstErr) {
+			return candidate
+		}
+	}
+}
+
+// rotateHost flushes and closes the open log files of hostname and renames
+// them (and the file of today) to numbered names (see rotatedName), so that
+// they are complete and no longer written to: the next message of the host
+// creates a new file. Files are only renamed once all of them were flushed. It
+// must be called on the write loop (see onWriteLoop) and returns the new
+// paths.
+func (s *server) rotateHost(hostname string, now time.Time) ([]string, error) {
+	if s.externalRotation {
+		return nil, errExternalRotation
+	}
+	basenames := map[string]bool{now.Format(basenameFormat): true}
+	for key, of := range s.files {
+		if key.hostname != hostname || key.quarantine {
+			continue
+		}
+		if err := of.flush(); err != nil {
+			writeErrors.Add(1)
+			return nil, fmt.Errorf("flushing log file for key=%v: %v", key, err)
+		}
+		basenames[key.basename] = true
+	}
+	sorted := make([]string, 0, len(basenames))
+	for basename := range basenames {
+		sorted = append(sorted, basename)
+	}
+	sort.Strings(sorted)
+	dir := filepath.Join(s.dir, hostDirName(hostname))
+	var rotated []string
+	for _, basename := range sorted {
+		key := fileKey{hostname: hostname, basename: basename}
+		if of, ok := s.files[key]; ok {
+			s.closeFile(key, of)
+		}
+		fn := filepath.Join(dir, basename)
+		if _, err := os.Stat(fn); os.IsNotExist(err) {
+			continue
+		}
+		dest := rotatedName(fn)
+		if err := os.Rename(fn, dest); err != nil {
+			return rotated, err
+		}
+		log.Printf("rotated %s to %s", fn, dest)
+		rotated = append(rotated, dest)
+	}
+	if len(rotated) > 0 {
+		if err := syncDir(dir); err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// rotateHandler rotates the current log files of the host= parameter (see
+// rotateHost) in the log directory of the tenant= parameter, e.g. before
+// copying them for analysis, and responds with the new paths. If token is
+// non-empty, requests need to carry it (see checkToken).
+func rotateHandler(servers map[string]*server, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkToken(w, r, token) {
+			return
+		}
+		s, ok := servers[r.FormValue("tenant")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown tenant %q", r.FormValue("tenant")), http.StatusNotFound)
+			return
+		}
+		host := r.FormValue("host")
+		if !validHostname(host) {
+			http.Error(w, fmt.Sprintf("invalid host= parameter %q", host), http.StatusBadRequest)
+			return
+		}
+		var (
+			rotated   []string
+			rotateErr error
+		)
+		err := s.onWriteLoop(r.Context(), func() {
+			rotated, rotateErr = s.rotateHost(host, time.Now())
+		})
+		if err == nil {
+			err = rotateErr
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			code := http.StatusInternalServerError
+			switch err {
+			case errWriteLoopTimeout:
+				code = http.StatusServiceUnavailable
+			case errExternalRotation:
+				code = http.StatusConflict
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if len(rotated) == 0 {
+			http.Error(w, fmt.Sprintf("no current log file for host %q", host), http.StatusNotFound)
+			return
+		}
+		for _, fn := range rotated {
+			fmt.Fprintln(w, fn)
+		}
+	}
+}
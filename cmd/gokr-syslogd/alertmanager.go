@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// alertmanagerPath is the path of alertmanagerHandler.
+	alertmanagerPath = "/ingest/alertmanager"
+
+	// alertmanagerTag is the tag of the messages of alertmanagerHandler.
+	alertmanagerTag = "alertmanager"
+
+	// maxAlertStates bounds the alerts whose last stored state is tracked
+	// (see alertStates).
+	maxAlertStates = 10000
+)
+
+// alert is an alert of an Alertmanager webhook notification, see
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type alert struct {
+	Status      string            `json:"status"` // firing or resolved
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// key identifies the alert across notifications.
+func (a *alert) key() string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, a.Labels[name])
+	}
+	return b.String()
+}
+
+// state is what a stored message records about the alert: its status since
+// when. Notifications which repeat it (Alertmanager re-sends all alerts of a
+// group every repeat_interval) are not stored again.
+func (a *alert) state() string {
+	return a.Status + "@" + a.StartsAt.Format(time.RFC3339Nano)
+}
+
+// alertSeverities maps common values of the severity label to syslog
+// severities.
+var alertSeverities = map[string]int{
+	"critical": 2,
+	"crit":     2,
+	"error":    3,
+	"err":      3,
+	"warning":  4,
+	"warn":     4,
+	"info":     6,
+}
+
+// alertContent returns the content of the message for a: the status, the
+// labels (alertname first) and the annotations as key=value pairs.
+func alertContent(a *alert) string {
+	pairs := []string{"status=" + flattenValue(a.Status)}
+	if name, ok := a.Labels["alertname"]; ok {
+		pairs = append(pairs, "alertname="+flattenValue(name))
+	}
+	sorted := func(m map[string]string) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	for _, k := range sorted(a.Labels) {
+		if k != "alertname" {
+			pairs = append(pairs, flattenKey(k)+"="+flattenValue(a.Labels[k]))
+		}
+	}
+	for _, k := range sorted(a.Annotations) {
+		if _, ok := a.Labels[k]; !ok {
+			pairs = append(pairs, flattenKey(k)+"="+flattenValue(a.Annotations[k]))
+		}
+	}
+	if a.Status == "resolved" && !a.StartsAt.IsZero() {
+		pairs = append(pairs, "since="+a.StartsAt.Format(time.RFC3339))
+	}
+	if a.Fingerprint != "" {
+		pairs = append(pairs, "fingerprint="+flattenValue(a.Fingerprint))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// alertMessage returns the message of host for a, timestamped when it started
+// firing or was resolved (or at now, if that is not known or too old to be
+// accepted, see clock_drift).
+func alertMessage(host string, a *alert, now time.Time) messageParts {
+	ts := a.StartsAt
+	severity := 4 // warning
+	if s, ok := alertSeverities[strings.ToLower(a.Labels["severity"])]; ok {
+		severity = s
+	}
+	if a.Status == "resolved" {
+		ts = a.EndsAt
+		severity = 6 // info
+	}
+	if ts.IsZero() || now.Sub(ts) > 23*time.Hour || ts.After(now) {
+		ts = now
+	}
+	return messageParts{
+		"hostname":  host,
+		"tag":       alertmanagerTag,
+		"content":   alertContent(a),
+		"timestamp": ts.Local(), // files are named by local day
+		"severity":  severity,
+		"facility":  1, // user
+		"ingested":  true,
+	}
+}
+
+// alertStates records the state of each alert which was stored last, per
+// tenant, so that repeated notifications are not stored again.
+type alertStates struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+// stored reports whether the state of a in tenant was stored last.
+func (as *alertStates) stored(tenant string, a *alert) bool {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.states[tenant+"\x00"+a.key()] == a.state()
+}
+
+// record records that the state of a in tenant was stored.
+func (as *alertStates) record(tenant string, a *alert) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if len(as.states) >= maxAlertStates {
+		// Forgetting states only stores repeated notifications again.
+		as.states = nil
+	}
+	if as.states == nil {
+		as.states = make(map[string]string)
+	}
+	as.states[tenant+"\x00"+a.key()] = a.state()
+}
+
+// alertmanagerHandler accepts Alertmanager webhook notifications POSTed to
+// /ingest/alertmanager and passes one message of host per alert which started
+// firing or was resolved to the write loop of the tenant= parameter, so that
+// alerts show up in timelines between the messages of the devices. Requests
+// need to carry token if it is non-empty (see ingestTarget).
+func alertmanagerHandler(servers map[string]*server, host, token string) http.HandlerFunc {
+	var states alertStates
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ingestTarget(w, r, servers, token)
+		if !ok {
+			return
+		}
+		body, ok := readIngestBody(w, r)
+		if !ok {
+			return
+		}
+		var notification struct {
+			Alerts []*alert `json:"alerts"`
+		}
+		if err := json.Unmarshal(body, &notification); err != nil {
+			http.Error(w, fmt.Sprintf("invalid Alertmanager notification: %v", err), http.StatusBadRequest)
+			return
+		}
+		tenant := r.URL.Query().Get("tenant")
+		now := time.Now()
+		var (
+			alerts []*alert
+			msgs   []messageParts
+		)
+		for _, a := range notification.Alerts {
+			if a.Status != "firing" && a.Status != "resolved" {
+				continue
+			}
+			if states.stored(tenant, a) {
+				continue
+			}
+			alerts = append(alerts, a)
+			msgs = append(msgs, alertMessage(host, a, now))
+		}
+		if !sendIngested(w, r, s, msgs) {
+			return
+		}
+		for _, a := range alerts {
+			states.record(tenant, a)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "ingested %d of %d alerts\n", len(msgs), len(notification.Alerts))
+	}
+}
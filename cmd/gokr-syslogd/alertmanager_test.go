@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAlertMessage(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		desc  string
+		alert alert
+		want  messageParts
+	}{
+		{
+			desc: "firing",
+			alert: alert{
+				Status:      "firing",
+				Labels:      map[string]string{"alertname": "HighLoad", "instance": "dr:9100", "severity": "critical"},
+				Annotations: map[string]string{"summary": "load above 4", "severity": "ignored"},
+				StartsAt:    now.Add(-time.Minute),
+				Fingerprint: "4f2a",
+			},
+			want: messageParts{
+				"hostname":  "_alerts",
+				"tag":       "alertmanager",
+				"content":   `status=firing alertname=HighLoad instance=dr:9100 severity=critical summary="load above 4" fingerprint=4f2a`,
+				"timestamp": now.Add(-time.Minute),
+				"severity":  2,
+				"facility":  1,
+				"ingested":  true,
+			},
+		},
+		{
+			desc: "resolved",
+			alert: alert{
+				Status:   "resolved",
+				Labels:   map[string]string{"alertname": "DiskFull"},
+				StartsAt: now.Add(-48 * time.Hour),
+				EndsAt:   now.Add(-time.Second),
+			},
+			want: messageParts{
+				"hostname":  "_alerts",
+				"tag":       "alertmanager",
+				"content":   `status=resolved alertname=DiskFull since=2022-08-11T16:20:00Z`,
+				"timestamp": now.Add(-time.Second),
+				"severity":  6,
+				"facility":  1,
+				"ingested":  true,
+			},
+		},
+		{
+			desc: "firing for days",
+			alert: alert{
+				Status:   "firing",
+				Labels:   map[string]string{"alertname": "DiskFull"},
+				StartsAt: now.Add(-48 * time.Hour),
+			},
+			want: messageParts{
+				"hostname":  "_alerts",
+				"tag":       "alertmanager",
+				"content":   `status=firing alertname=DiskFull`,
+				"timestamp": now,
+				"severity":  4,
+				"facility":  1,
+				"ingested":  true,
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, alertMessage("_alerts", &tt.alert, now)); diff != "" {
+				t.Errorf("alertMessage: unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAlertmanagerHandler(t *testing.T) {
+	srv := &server{
+		dir:          t.TempDir(),
+		files:        make(map[fileKey]*openFile),
+		flushIdle:    1 * time.Millisecond,
+		bufferLimit:  1 << 20,
+		retentionNow: make(chan struct{}, 1),
+		ingested:     make(chan messageParts),
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	handler := alertmanagerHandler(map[string]*server{"": srv}, "_alerts", "t0ken")
+	post := func(body string) (int, string) {
+		req := httptest.NewRequest("POST", "/ingest/alertmanager", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer t0ken")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+	startsAt := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	firing := `{"version":"4","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad"},"startsAt":"` + startsAt + `","fingerprint":"4f2a"}]}`
+	resolved := `{"version":"4","status":"resolved","alerts":[{"status":"resolved","labels":{"alertname":"HighLoad"},"startsAt":"` + startsAt + `","endsAt":"` + startsAt + `","fingerprint":"4f2a"}]}`
+	for _, tt := range []struct {
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{firing, http.StatusAccepted, "ingested 1 of 1 alerts\n"},
+		{firing, http.StatusAccepted, "ingested 0 of 1 alerts\n"}, // repeat_interval
+		{resolved, http.StatusAccepted, "ingested 1 of 1 alerts\n"},
+		{`{"alerts":`, http.StatusBadRequest, ""},
+	} {
+		status, body := post(tt.body)
+		if status != tt.wantStatus {
+			t.Errorf("POST %s: status = %d, want %d", tt.body, status, tt.wantStatus)
+		}
+		if tt.wantBody != "" && body != tt.wantBody {
+			t.Errorf("POST %s: body = %q, want %q", tt.body, body, tt.wantBody)
+		}
+	}
+	close(channel)
+	<-done
+
+	ts, err := time.Parse(time.RFC3339, startsAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(srv.dir, "_alerts", ts.Local().Format(basenameFormat)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		got = append(got, logline.Strip(line))
+	}
+	want := []string{
+		"alertmanager: status=firing alertname=HighLoad fingerprint=4f2a",
+		"alertmanager: status=resolved alertname=HighLoad since=" + startsAt + " fingerprint=4f2a",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("stored alerts: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// annotationTag is the tag of the messages of annotateHandler, which
+	// gokr-syslogweb renders distinctly.
+	annotationTag = "annotation"
+
+	// defaultAnnotationHost is the host of annotations without host=.
+	defaultAnnotationHost = "_annotations"
+
+	// maxAnnotationAge is how far back annotations can be dated, short of
+	// what clock_drift rejects.
+	maxAnnotationAge = 23 * time.Hour
+)
+
+// annotationContent returns the content of the message for an annotation.
+func annotationContent(author, text string) string {
+	var pairs []string
+	if author != "" {
+		pairs = append(pairs, "author="+flattenValue(author))
+	}
+	return strings.Join(append(pairs, "text="+flattenValue(text)), " ")
+}
+
+// parseAnnotationTime parses the time= parameter of annotateHandler: either
+// RFC 3339, or a time of day (e.g. 14:02) at or before now.
+func parseAnnotationTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	tod, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected RFC 3339 or 15:04)", value)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), tod.Hour(), tod.Minute(), 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t, nil
+}
+
+// annotationMessage returns the message of host for an annotation made by
+// author at ts.
+func annotationMessage(host, author, text string, ts time.Time) messageParts {
+	return messageParts{
+		"hostname":  host,
+		"tag":       annotationTag,
+		"content":   annotationContent(author, text),
+		"timestamp": ts.Local(), // files are named by local day
+		"severity":  5,          // notice
+		"facility":  1,          // user
+		"ingested":  true,
+	}
+}
+
+// annotateHandler stores the text= parameter POSTed to /annotate as a message
+// of host= (default _annotations) with tag annotation, e.g. “upgraded router7
+// to v2.3”, so that operator actions show up in timelines between the messages
+// of the devices. author= is stored along with it, time= dates the annotation
+// (see parseAnnotationTime). If token is non-empty, requests need to carry it
+// (see ingestTarget).
+func annotateHandler(servers map[string]*server, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ingestTarget(w, r, servers, token)
+		if !ok {
+			return
+		}
+		text := strings.TrimSpace(r.FormValue("text"))
+		if text == "" {
+			http.Error(w, "missing text= parameter", http.StatusBadRequest)
+			return
+		}
+		host := r.FormValue("host")
+		if host == "" {
+			host = defaultAnnotationHost
+		}
+		if !validHostname(host) {
+			http.Error(w, fmt.Sprintf("invalid host= parameter %q", host), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		ts, err := parseAnnotationTime(r.FormValue("time"), now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if now.Sub(ts) > maxAnnotationAge || ts.Sub(now) > time.Minute {
+			http.Error(w, fmt.Sprintf("time %s not within the last %v", ts.Format(time.RFC3339), maxAnnotationAge), http.StatusBadRequest)
+			return
+		}
+		msg := annotationMessage(host, r.FormValue("author"), text, ts)
+		if !sendIngested(w, r, s, []messageParts{msg}) {
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "annotated %s at %s\n", host, ts.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAnnotationTime(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		value string
+		want  time.Time
+	}{
+		{"", now},
+		{"2022-08-13T14:02:00Z", time.Date(2022, time.August, 13, 14, 2, 0, 0, time.UTC)},
+		{"14:02", time.Date(2022, time.August, 13, 14, 2, 0, 0, time.UTC)},
+		{"23:50", time.Date(2022, time.August, 12, 23, 50, 0, 0, time.UTC)},
+	} {
+		got, err := parseAnnotationTime(tt.value, now)
+		if err != nil {
+			t.Fatalf("parseAnnotationTime(%q): %v", tt.value, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseAnnotationTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	if _, err := parseAnnotationTime("2pm", now); err == nil {
+		t.Errorf("parseAnnotationTime(2pm) unexpectedly succeeded")
+	}
+}
+
+func TestAnnotateHandler(t *testing.T) {
+	srv := &server{
+		dir:          t.TempDir(),
+		files:        make(map[fileKey]*openFile),
+		flushIdle:    1 * time.Millisecond,
+		bufferLimit:  1 << 20,
+		retentionNow: make(chan struct{}, 1),
+		ingested:     make(chan messageParts),
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	handler := annotateHandler(map[string]*server{"": srv}, "t0ken")
+	ts := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for _, tt := range []struct {
+		params     url.Values
+		token      string
+		wantStatus int
+	}{
+		{url.Values{"text": {"upgraded router7 to v2.3"}, "author": {"michael"}, "time": {ts.Format(time.RFC3339)}}, "t0ken", http.StatusAccepted},
+		{url.Values{"text": {"rebooted"}, "host": {"router7"}, "time": {ts.Format(time.RFC3339)}}, "t0ken", http.StatusAccepted},
+		{url.Values{"text": {"fake"}, "host": {"router7"}}, "", http.StatusUnauthorized},
+		{url.Values{"text": {"fake"}, "host": {"router7"}}, "wrong", http.StatusUnauthorized},
+		{url.Values{"text": {" "}}, "t0ken", http.StatusBadRequest},
+		{url.Values{"text": {"x"}, "host": {"../etc"}}, "t0ken", http.StatusBadRequest},
+		{url.Values{"text": {"x"}, "time": {ts.Add(-48 * time.Hour).Format(time.RFC3339)}}, "t0ken", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/annotate?"+tt.params.Encode(), nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("POST %v: status = %d, want %d (%s)", tt.params, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+	}
+	close(channel)
+	<-done
+
+	for host, want := range map[string]string{
+		"_annotations": `annotation: author=michael text="upgraded router7 to v2.3"`,
+		"router7":      `annotation: text=rebooted`,
+	} {
+		b, err := os.ReadFile(filepath.Join(srv.dir, host, ts.Format(basenameFormat)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, logline.Strip(strings.TrimSpace(string(b)))); diff != "" {
+			t.Errorf("annotation of %s: unexpected diff (-want +got):\n%s", host, diff)
+		}
+	}
+}
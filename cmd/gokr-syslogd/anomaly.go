@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of anomalies reported by anomalyDetector.
+const (
+	anomalySpike      = "spike"       // many more messages than usual
+	anomalyErrorSpike = "error_spike" // many more error messages than usual
+	anomalySilence    = "silence"     // no messages from a usually busy source
+)
+
+const (
+	// anomalyAlpha is the weight of the most recent window in the baseline
+	// (an exponentially weighted moving average), i.e. the baseline roughly
+	// covers the last 1/anomalyAlpha windows.
+	anomalyAlpha = 0.1
+
+	// anomalyWarmup is the number of windows a source needs to be observed
+	// before deviations from its baseline are reported.
+	anomalyWarmup = 6
+
+	// anomalyMinSpike is the minimum number of messages per window for a
+	// spike, so that going from 0 to 10 messages is not reported.
+	anomalyMinSpike = 100
+
+	// anomalyMinSilence is the minimum baseline (messages per window) of a
+	// source whose silence is reported.
+	anomalyMinSilence = 10
+
+	// anomalyMaxSources bounds the memory used for tracking baselines.
+	anomalyMaxSources = 10000
+)
+
+// errorSeverity is the highest (least severe) syslog severity counted as an
+// error: LOG_ERR.
+const errorSeverity = 3
+
+type rateKey struct {
+	hostname, tag string
+}
+
+type rateStats struct {
+	messages, errors         int     // in the current window
+	baseline, errorsBaseline float64 // per window
+	windows                  int     // completed windows
+	last                     int     // messages in the last completed window
+}
+
+// anomaly is a deviation from the baseline of a source, as reported at
+// /anomalies.
+type anomaly struct {
+	Hostname string    `json:"hostname"`
+	Tag      string    `json:"tag"`
+	Kind     string    `json:"kind"`
+	Since    time.Time `json:"since"`
+	Messages int       `json:"messages"` // in the most recent window
+	Baseline float64   `json:"baseline"` // messages per window
+}
+
+// anomalyDetector tracks per-host, per-tag message and error rates and reports
+// deviations from their baselines, e.g. a device stuck in a crash loop
+// (spike) or a device which stopped logging (silence).
+type anomalyDetector struct {
+	window      time.Duration
+	spikeFactor float64
+
+	mu      sync.Mutex
+	sources map[rateKey]*rateStats
+	active  map[rateKey]map[string]time.Time // kind → since
+}
+
+func newAnomalyDetector(window time.Duration, spikeFactor float64) *anomalyDetector {
+	return &anomalyDetector{
+		window:      window,
+		spikeFactor: spikeFactor,
+		sources:     make(map[rateKey]*rateStats),
+		active:      make(map[rateKey]map[string]time.Time),
+	}
+}
+
+// observe counts msg towards the current window.
+func (d *anomalyDetector) observe(msg message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := rateKey{msg.hostname, msg.tag}
+	st, ok := d.sources[key]
+	if !ok {
+		if len(d.sources) >= anomalyMaxSources {
+			return
+		}
+		st = &rateStats{}
+		d.sources[key] = st
+	}
+	st.messages++
+	if msg.severity >= 0 && msg.severity <= errorSeverity {
+		st.errors++
+	}
+}
+
+// spike reports whether n is a spike compared to baseline.
+func (d *anomalyDetector) spike(n int, baseline float64) bool {
+	if baseline < 1 {
+		baseline = 1
+	}
+	return n >= anomalyMinSpike && float64(n) >= d.spikeFactor*baseline
+}
+
+// tick completes the current window, updates the baselines and returns the
+// anomalies which started in this window.
+func (d *anomalyDetector) tick(now time.Time) []anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var started []anomaly
+	for key, st := range d.sources {
+		var kinds []string
+		if st.windows >= anomalyWarmup {
+			if d.spike(st.messages, st.baseline) {
+				kinds = append(kinds, anomalySpike)
+			}
+			if d.spike(st.errors, st.errorsBaseline) {
+				kinds = append(kinds, anomalyErrorSpike)
+			}
+			if st.messages == 0 && st.baseline >= anomalyMinSilence {
+				kinds = append(kinds, anomalySilence)
+			}
+		}
+		prev := d.active[key]
+		cur := make(map[string]time.Time, len(kinds))
+		for _, kind := range kinds {
+			since, ok := prev[kind]
+			if !ok {
+				since = now
+				started = append(started, anomaly{
+					Hostname: key.hostname,
+					Tag:      key.tag,
+					Kind:     kind,
+					Since:    since,
+					Messages: st.messages,
+					Baseline: st.baseline,
+				})
+			}
+			cur[kind] = since
+		}
+		if len(cur) > 0 {
+			d.active[key] = cur
+		} else {
+			delete(d.active, key)
+		}
+
+		// Spikes are not folded into the baseline, so that a crash loop
+		// keeps being reported instead of becoming the new normal. Silence
+		// is, so that a decommissioned device is eventually forgotten.
+		if len(kinds) == 0 || kinds[0] == anomalySilence {
+			if st.windows == 0 {
+				st.baseline = float64(st.messages)
+				st.errorsBaseline = float64(st.errors)
+			} else {
+				st.baseline += anomalyAlpha * (float64(st.messages) - st.baseline)
+				st.errorsBaseline += anomalyAlpha * (float64(st.errors) - st.errorsBaseline)
+			}
+		}
+		st.windows++
+		st.last = st.messages
+		st.messages = 0
+		st.errors = 0
+		if st.windows > anomalyWarmup && st.baseline < 0.01 {
+			delete(d.sources, key)
+			delete(d.active, key)
+		}
+	}
+	sort.Slice(started, func(i, j int) bool {
+		a, b := started[i], started[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.Kind < b.Kind
+	})
+	return started
+}
+
+// anomalies returns the currently active anomalies.
+func (d *anomalyDetector) anomalies() []anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]anomaly, 0, len(d.active))
+	for key, kinds := range d.active {
+		st := d.sources[key]
+		for kind, since := range kinds {
+			result = append(result, anomaly{
+				Hostname: key.hostname,
+				Tag:      key.tag,
+				Kind:     kind,
+				Since:    since,
+				Messages: st.last,
+				Baseline: st.baseline,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.Kind < b.Kind
+	})
+	return result
+}
+
+// loop completes a window every d.window and logs newly started anomalies.
+func (d *anomalyDetector) loop() {
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, a := range d.tick(now) {
+			selfLog.Printf("anomaly", "anomaly: %s: host %q, tag %q: %d messages in the last %v, baseline %.1f",
+				a.Kind, a.Hostname, a.Tag, a.Messages, d.window, a.Baseline)
+		}
+	}
+}
+
+// anomaliesHandler serves the currently active anomalies as JSON.
+func (d *anomalyDetector) anomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.MarshalIndent(d.anomalies(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(append(b, '\n'))
+}
+
+// labelValueEscaper escapes a Prometheus label value.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeAnomalyMetrics writes the currently active anomalies in the Prometheus
+// text exposition format.
+func (d *anomalyDetector) writeAnomalyMetrics(w http.ResponseWriter) {
+	fmt.Fprintf(w, "# HELP syslogd_anomaly Sources whose message rate deviates from their baseline, by kind (spike, error_spike, silence).\n")
+	fmt.Fprintf(w, "# TYPE syslogd_anomaly gauge\n")
+	for _, a := range d.anomalies() {
+		fmt.Fprintf(w, "syslogd_anomaly{hostname=\"%s\",tag=\"%s\",kind=\"%s\"} 1\n",
+			labelValueEscaper.Replace(a.Hostname),
+			labelValueEscaper.Replace(a.Tag),
+			a.Kind)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	d := newAnomalyDetector(10*time.Minute, 10)
+	now := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	send := func(hostname, tag string, severity, n int) {
+		for i := 0; i < n; i++ {
+			d.observe(message{hostname: hostname, tag: tag, severity: severity})
+		}
+	}
+	tick := func() []anomaly {
+		now = now.Add(d.window)
+		return d.tick(now)
+	}
+
+	// Establish baselines: scan2drive logs 50 messages per window, grafana
+	// logs 20 and fails about once per window.
+	for i := 0; i < anomalyWarmup; i++ {
+		send("scan2drive", "scan2drive", 6, 50)
+		send("dr", "grafana", 6, 20)
+		send("dr", "grafana", 3, 1)
+		if got := tick(); len(got) > 0 {
+			t.Fatalf("anomalies during warm-up: %+v", got)
+		}
+	}
+
+	// Normal fluctuation is not reported.
+	send("scan2drive", "scan2drive", 6, 120)
+	send("dr", "grafana", 6, 20)
+	send("dr", "grafana", 3, 1)
+	if got := tick(); len(got) > 0 {
+		t.Fatalf("unexpected anomalies: %+v", got)
+	}
+
+	// scan2drive is stuck in a crash loop, grafana goes silent.
+	send("scan2drive", "scan2drive", 6, 1000)
+	send("scan2drive", "scan2drive", 2, 500)
+	spikeSince := now.Add(d.window)
+	want := []anomaly{
+		{
+			Hostname: "dr",
+			Tag:      "grafana",
+			Kind:     anomalySilence,
+			Since:    spikeSince,
+			Baseline: 21,
+		},
+		{
+			Hostname: "scan2drive",
+			Tag:      "scan2drive",
+			Kind:     anomalyErrorSpike,
+			Since:    spikeSince,
+			Messages: 1500,
+			Baseline: 57,
+		},
+		{
+			Hostname: "scan2drive",
+			Tag:      "scan2drive",
+			Kind:     anomalySpike,
+			Since:    spikeSince,
+			Messages: 1500,
+			Baseline: 57,
+		},
+	}
+	if diff := cmp.Diff(want, tick()); diff != "" {
+		t.Fatalf("tick: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// The crash loop continues: it is still active, but not newly reported,
+	// and has not become the new baseline.
+	send("scan2drive", "scan2drive", 6, 1000)
+	send("dr", "grafana", 6, 20)
+	if got := tick(); len(got) > 0 {
+		t.Fatalf("anomalies unexpectedly reported again: %+v", got)
+	}
+	active := d.anomalies()
+	if len(active) != 1 || active[0].Kind != anomalySpike || !active[0].Since.Equal(spikeSince) {
+		t.Fatalf("anomalies() = %+v, want one spike since %v", active, spikeSince)
+	}
+
+	// Recovery clears the anomaly.
+	send("scan2drive", "scan2drive", 6, 50)
+	send("dr", "grafana", 6, 20)
+	tick()
+	if got := d.anomalies(); len(got) > 0 {
+		t.Fatalf("anomalies() after recovery = %+v, want none", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+)
+
+// observeBinding records the IP address to hostname binding of msg, if it is
+// a message of a DHCP or DNS server (see bindings.Parse), in the binding table
+// of its host (see -learn_bindings).
+func (s *server) observeBinding(msg message) {
+	if s.bindingTables == nil {
+		return
+	}
+	addr, name, ok := bindings.Parse(msg.tag, msg.content)
+	if !ok {
+		return
+	}
+	t, ok := s.bindingTables[msg.hostname]
+	if !ok {
+		fn := filepath.Join(s.dir, hostDirName(msg.hostname), bindings.FileName)
+		var err error
+		t, err = bindings.ReadFile(fn)
+		if err != nil {
+			selfLog.Printf("bindings", "reading %s: %v (starting a new table)", fn, err)
+			t = bindings.New()
+		}
+		s.bindingTables[msg.hostname] = t
+	}
+	t.Observe(addr, name, msg.timestamp)
+}
+
+// writeBindingTables writes the binding tables which changed since they were
+// last written.
+func (s *server) writeBindingTables() {
+	for hostname, t := range s.bindingTables {
+		if !t.Dirty() {
+			continue
+		}
+		if err := s.writeBindingTable(hostname, t); err != nil {
+			selfLog.Printf("bindings", "writing binding table for %s: %v", hostname, err)
+		}
+	}
+}
+
+func (s *server) writeBindingTable(hostname string, t *bindings.Table) error {
+	dir := filepath.Join(s.dir, hostDirName(hostname))
+	if err := s.mkdirAll(dir); err != nil {
+		return err
+	}
+	fn := filepath.Join(dir, bindings.FileName)
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := newPendingFile(fn, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if err := t.Write(f); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	return s.chown(fn)
+}
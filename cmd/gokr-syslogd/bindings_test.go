@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+)
+
+func TestBindings(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		bindingTables: make(map[string]*bindings.Table),
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, msg := range []message{
+		{tag: "dnsmasq-dhcp", content: "DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff phone"},
+		{tag: "dnsmasq-dhcp", content: "DHCPREQUEST(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff"},
+		{tag: "sshd", content: "Accepted publickey from 192.168.1.57"},
+	} {
+		msg.hostname = "router7"
+		msg.timestamp = ts
+		msg.received = ts
+		srv.observeBinding(msg)
+	}
+	srv.writeBindingTables()
+
+	// A restarted server continues the table.
+	srv.bindingTables = make(map[string]*bindings.Table)
+	later := ts.Add(24 * time.Hour)
+	srv.observeBinding(message{hostname: "router7", tag: "dnsmasq-dhcp", content: "DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:01 laptop", timestamp: later})
+	srv.writeBindingTables()
+
+	table, err := bindings.ReadFile(filepath.Join(srv.dir, "router7", bindings.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		at   time.Time
+		want string
+	}{
+		{ts.Add(time.Hour), "phone"},
+		{later.Add(time.Hour), "laptop"},
+	} {
+		if got, _ := table.NameAt("192.168.1.57", c.at); got != c.want {
+			t.Errorf("NameAt(%v) = %q, want %q", c.at, got, c.want)
+		}
+	}
+	if got := len(table.Addresses()); got != 1 {
+		t.Errorf("table has %d addresses, want 1", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/gokrazy/syslogd/internal/bootlog"
+)
+
+// defaultBootMarker matches the first message of the Linux kernel.
+const defaultBootMarker = `^kernel: Linux version `
+
+// kernelUptime matches the uptime with which the kernel prefixes its messages
+// (CONFIG_PRINTK_TIME), e.g. [   12.345678].
+var kernelUptime = regexp.MustCompile(`^\[\s*([0-9]+\.[0-9]+)\]`)
+
+// bootState is the current boot session of a host.
+type bootState struct {
+	id         string  // empty until the first reboot is detected
+	lastUptime float64 // latest kernel uptime, in seconds
+}
+
+// bootFor returns the ID of the boot session of msg's host, starting a new
+// session when msg indicates that the host rebooted: msg matches
+// s.bootMarker, or the kernel uptime went backwards.
+func (s *server) bootFor(msg message) string {
+	st, ok := s.boots[msg.hostname]
+	if !ok {
+		st = &bootState{}
+		fn := filepath.Join(s.dir, hostDirName(msg.hostname), bootlog.FileName)
+		boots, err := bootlog.ReadFile(fn)
+		if err != nil {
+			selfLog.Printf("boot", "reading %s: %v", fn, err)
+		}
+		if len(boots) > 0 {
+			st.id = boots[len(boots)-1].ID
+		}
+		s.boots[msg.hostname] = st
+	}
+
+	rebooted := s.bootMarker != nil && s.bootMarker.MatchString(msg.tag+": "+msg.content)
+	if m := kernelUptime.FindStringSubmatch(msg.content); m != nil && msg.tag == "kernel" {
+		if uptime, err := strconv.ParseFloat(m[1], 64); err == nil {
+			// Tolerate slightly out-of-order messages.
+			if uptime < st.lastUptime-1 {
+				rebooted = true
+			}
+			st.lastUptime = uptime
+		}
+	}
+	if !rebooted {
+		return st.id
+	}
+
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		selfLog.Printf("boot", "generating boot ID: %v", err)
+		return st.id
+	}
+	b := bootlog.Boot{Start: msg.received, ID: hex.EncodeToString(buf[:])}
+	if err := s.appendBoot(msg.hostname, b); err != nil {
+		selfLog.Printf("boot", "recording boot of %s: %v", msg.hostname, err)
+	}
+	selfLog.Printf("boot", "%s rebooted, boot=%s", msg.hostname, b.ID)
+	st.id = b.ID
+	return st.id
+}
+
+// appendBoot appends b to the boot list of hostname.
+func (s *server) appendBoot(hostname string, b bootlog.Boot) error {
+	fn := filepath.Join(s.dir, hostDirName(hostname), bootlog.FileName)
+	_, err := os.Stat(fn)
+	created := os.IsNotExist(err)
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String() + "\n"); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if created {
+		if err := s.chown(fn); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBootSessions(t *testing.T) {
+	newServer := func(dir string) *server {
+		return &server{
+			dir:         dir,
+			files:       make(map[fileKey]*openFile),
+			bufferLimit: 1 << 20,
+			boots:       make(map[string]*bootState),
+			bootMarker:  regexp.MustCompile(defaultBootMarker),
+		}
+	}
+	dir := t.TempDir()
+	srv := newServer(dir)
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	write := func(srv *server, tag, content string) {
+		srv.write(message{hostname: "dr", timestamp: ts, received: ts, tag: tag, content: content})
+		ts = ts.Add(time.Minute)
+	}
+	write(srv, "dhcpd", "before the first detected boot")
+	write(srv, "kernel", "Linux version 5.19.1 (gokrazy)")
+	write(srv, "kernel", "[ 120.000000] eth0: link up")
+	write(srv, "kernel", "[ 119.500000] eth0: slightly out of order")
+	write(srv, "kernel", "[   1.000000] eth0: link up") // no marker: rebooted
+	srv.flushFiles()
+
+	// A restarted server continues the current boot session.
+	srv = newServer(dir)
+	write(srv, "dhcpd", "after restarting syslogd")
+	srv.flushFiles()
+
+	boots, err := bootlog.ReadFile(filepath.Join(dir, "dr", bootlog.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(boots), 2; got != want {
+		t.Fatalf("got %d boots, want %d", got, want)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "dr", "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		boot, _ := logline.Field(line, "boot")
+		got = append(got, boot)
+	}
+	first, second := boots[0].ID, boots[1].ID
+	want := []string{"", first, first, first, second, second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected boot= fields: diff (-want +got):\n%s", diff)
+	}
+	if got, want := boots[0].Start, time.Date(2022, time.August, 13, 16, 21, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("first boot started at %v, want %v", got, want)
+	}
+}
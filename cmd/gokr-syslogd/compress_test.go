@@ -32,7 +32,7 @@ func TestColdLogFileNames(t *testing.T) {
 		}
 	}
 	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
-	cold, err := srv.coldLogFileNames(now)
+	cold, err := srv.logFileNamesInState(now, stateCold)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,7 +44,7 @@ func TestColdLogFileNames(t *testing.T) {
 		filepath.Join(srv.dir, "router7", "2022-08-10.log"),
 	}
 	if diff := cmp.Diff(want, cold); diff != "" {
-		t.Errorf("coldLogFileNames(): unexpected diff (-want +got):\n%s", diff)
+		t.Errorf("logFileNamesInState(stateCold): unexpected diff (-want +got):\n%s", diff)
 	}
 }
 
@@ -65,13 +65,13 @@ func TestColdLogFileNamesSingle(t *testing.T) {
 		}
 	}
 	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
-	cold, err := srv.coldLogFileNames(now)
+	cold, err := srv.logFileNamesInState(now, stateCold)
 	if err != nil {
 		t.Fatal(err)
 	}
 	var want []string
 	if diff := cmp.Diff(want, cold); diff != "" {
-		t.Errorf("coldLogFileNames(): unexpected diff (-want +got):\n%s", diff)
+		t.Errorf("logFileNamesInState(stateCold): unexpected diff (-want +got):\n%s", diff)
 	}
 }
 
@@ -81,7 +81,7 @@ func TestCompressFile(t *testing.T) {
 	if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
 		t.Fatal(err)
 	}
-	if err := compressFile(fn); err != nil {
+	if err := compressFile(fn, 0); err != nil {
 		t.Fatal(err)
 	}
 	var buf bytes.Buffer
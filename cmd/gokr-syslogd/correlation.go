@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileCorrelationID compiles the -correlation_id regexp, which needs at
+// least one capture group.
+func compileCorrelationID(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("%q has no capture group", expr)
+	}
+	return re, nil
+}
+
+// correlationIDOf returns the correlation ID in content: the first non-empty
+// group of the -correlation_id match. IDs which cannot be stored as a field
+// value (with spaces or =) are ignored.
+func (s *server) correlationIDOf(content string) string {
+	if s.correlationID == nil {
+		return ""
+	}
+	m := s.correlationID.FindStringSubmatch(content)
+	if m == nil {
+		return ""
+	}
+	for _, id := range m[1:] {
+		if id == "" {
+			continue
+		}
+		if strings.ContainsAny(id, " =") {
+			return ""
+		}
+		return id
+	}
+	return ""
+}
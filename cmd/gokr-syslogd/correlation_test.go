@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCorrelationID(t *testing.T) {
+	if _, err := compileCorrelationID("request_id=[0-9a-f]+"); err == nil {
+		t.Errorf("compileCorrelationID without group unexpectedly succeeded")
+	}
+	re, err := compileCorrelationID(`request_id=([0-9a-f-]+)|trace=(\S+)|"(.*)"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{correlationID: re}
+	for _, tt := range []struct {
+		content string
+		want    string
+	}{
+		{"GET /api request_id=4f2a-91c0 200", "4f2a-91c0"},
+		{"upstream call trace=abc.def", "abc.def"},
+		{"no ID here", ""},
+		{`quoted "two words"`, ""},
+		{`quoted "a=b"`, ""},
+	} {
+		if got := srv.correlationIDOf(tt.content); got != tt.want {
+			t.Errorf("correlationIDOf(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
@@ -11,8 +11,9 @@ import (
 
 func TestToDeleteLogFileNames(t *testing.T) {
 	srv := server{
-		dir:   t.TempDir(),
-		files: make(map[fileKey]*openFile),
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		retentionDays: 7,
 	}
 	for _, rel := range []string{
 		"dr/2022-08-10.log.zst",
@@ -37,7 +38,7 @@ func TestToDeleteLogFileNames(t *testing.T) {
 		}
 	}
 	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
-	cold, err := srv.toDeleteLogFileNames(now)
+	cold, err := srv.logFileNamesInState(now, stateExpired)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -46,6 +47,54 @@ func TestToDeleteLogFileNames(t *testing.T) {
 		filepath.Join(srv.dir, "router7", "2022-08-10.log.zst"),
 	}
 	if diff := cmp.Diff(want, cold); diff != "" {
-		t.Errorf("toDeleteLogFileNames(): unexpected diff (-want +got):\n%s", diff)
+		t.Errorf("logFileNamesInState(stateExpired): unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRetentionDays(t *testing.T) {
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	for _, tt := range []struct {
+		retentionDays int
+		want          []string
+	}{
+		{
+			retentionDays: 2,
+			want:          []string{"2022-05-01.log.zst", "2022-08-10.log.zst", "2022-08-15.log.zst"},
+		},
+		{
+			retentionDays: 90,
+			want:          []string{"2022-05-01.log.zst"},
+		},
+	} {
+		srv := server{
+			dir:           t.TempDir(),
+			files:         make(map[fileKey]*openFile),
+			retentionDays: tt.retentionDays,
+		}
+		for _, name := range []string{
+			"2022-05-01.log.zst",
+			"2022-08-10.log.zst",
+			"2022-08-15.log.zst",
+			"2022-08-16.log.zst",
+		} {
+			fn := filepath.Join(srv.dir, "dr", name)
+			if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fn, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expired, err := srv.logFileNamesInState(now, stateExpired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, fn := range expired {
+			got = append(got, filepath.Base(fn))
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("retentionDays=%d: unexpected diff (-want +got):\n%s", tt.retentionDays, diff)
+		}
 	}
 }
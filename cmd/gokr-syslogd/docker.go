@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var validDockerTagField = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// parseDockerTag parses the field names of the slash-separated components of
+// Docker tags, e.g. image/container for containers logging with
+// --log-opt tag={{.ImageName}}/{{.Name}}.
+func parseDockerTag(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	names := strings.Split(spec, "/")
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !validDockerTagField.MatchString(name) {
+			return nil, fmt.Errorf("invalid field name %q (must match %s)", name, validDockerTagField)
+		}
+		if name == "tag" || name == "message" {
+			// Used for the tag and content by logline.JSON.
+			return nil, fmt.Errorf("reserved field name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field name %q", name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
+
+// splitDockerTag stores the components of msg’s tag as fields named by
+// s.dockerTag, if the tag has as many components.
+func (s *server) splitDockerTag(msg *message) {
+	if len(s.dockerTag) == 0 {
+		return
+	}
+	parts := strings.Split(msg.tag, "/")
+	if len(parts) != len(s.dockerTag) {
+		return
+	}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		msg.labels = append(msg.labels, s.dockerTag[i]+"="+part)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDocker(t *testing.T) {
+	dockerTag, err := parseDockerTag("image/container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []string{"image/Container", "image/image", "image//container", "image/tag"} {
+		if _, err := parseDockerTag(spec); err == nil {
+			t.Errorf("parseDockerTag(%q) unexpectedly succeeded", spec)
+		}
+	}
+	srv := server{dockerTag: dockerTag}
+
+	type result struct {
+		Hostname string
+		Tag      string
+		Content  string
+		Labels   []string
+	}
+	now := time.Now()
+	stamp := now.Format(time.Stamp)
+	for _, tt := range []struct {
+		raw  string
+		want result
+	}{
+		{
+			// Docker’s default format, without hostname.
+			raw: "<30>" + stamp + " nginx/web-1[1234]: GET / HTTP/1.1",
+			want: result{
+				Hostname: "10.0.0.16",
+				Tag:      "nginx/web-1",
+				Content:  "GET / HTTP/1.1",
+				Labels:   []string{"image=nginx", "container=web-1"},
+			},
+		},
+		{
+			// --log-opt syslog-format=rfc3164
+			raw: "<30>" + stamp + " dockerhost nginx/web-1[1234]: GET / HTTP/1.1",
+			want: result{
+				Hostname: "dockerhost",
+				Tag:      "nginx/web-1",
+				Content:  "GET / HTTP/1.1",
+				Labels:   []string{"image=nginx", "container=web-1"},
+			},
+		},
+		{
+			// Default tag (container ID), not split.
+			raw: "<30>" + stamp + " c0ffee123456[1234]: ready",
+			want: result{
+				Hostname: "10.0.0.16",
+				Tag:      "c0ffee123456",
+				Content:  "ready",
+			},
+		},
+	} {
+		logParts, _ := rawFormat{}.parse([]byte(tt.raw))
+		logParts["client"] = "10.0.0.16:58045"
+		msg, ok := srv.parse(logParts, now)
+		if !ok {
+			t.Errorf("parse(%q) unexpectedly failed", tt.raw)
+			continue
+		}
+		got := result{msg.hostname, msg.tag, msg.content, msg.labels}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("parse(%q): unexpected diff (-want +got):\n%s", tt.raw, diff)
+		}
+	}
+}
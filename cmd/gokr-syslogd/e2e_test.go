@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+// e2e runs a server receiving on an ephemeral UDP port, and a gokr-syslogweb
+// process serving the server’s directory on an ephemeral TCP port.
+type e2e struct {
+	t      *testing.T
+	srv    *server
+	conn   net.Conn // to the server’s UDP port
+	webURL string
+}
+
+// freePort returns a localhost address with a port which was free a moment
+// ago. server.listen returns no listen addresses, so the server cannot listen
+// on port 0 directly.
+func freePort(t *testing.T, network string) string {
+	t.Helper()
+	var addr string
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = pc.LocalAddr().String()
+		pc.Close()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr().String()
+		ln.Close()
+	}
+	return addr
+}
+
+// startE2E starts srv, whose configuration fields are set by the caller, and
+// gokr-syslogweb, which is built for the test.
+func startE2E(t *testing.T, srv *server) *e2e {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("building gokr-syslogweb requires the go tool: %v", err)
+	}
+
+	srv.dir = t.TempDir()
+	srv.files = make(map[fileKey]*openFile)
+	srv.flushIdle = 1 * time.Millisecond
+	srv.flushMaxDelay = 5 * time.Millisecond
+	srv.bufferLimit = 8 << 20
+	srv.flushRequests = make(chan chan error)
+	srv.retentionNow = make(chan struct{}, 1)
+	srv.retired = newRetiredHosts(srv.dir)
+	if srv.retentionDays == 0 {
+		srv.retentionDays = defaultRetentionDays
+	}
+	listenAddr := freePort(t, "udp")
+	syslogsrv, channel, err := srv.listen(listenAddr, streamListeners{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like start, but without the retention loop: the test runs retention
+	// passes with a fake clock instead.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+	t.Cleanup(func() {
+		syslogsrv.close()
+		syslogsrv.wait()
+		close(channel)
+		<-done
+	})
+	conn, err := net.Dial("udp", listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	web := filepath.Join(t.TempDir(), "gokr-syslogweb")
+	build := exec.Command(goTool, "build", "-o", web, "github.com/gokrazy/syslogd/cmd/gokr-syslogweb")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building gokr-syslogweb: %v\n%s", err, out)
+	}
+	webAddr := freePort(t, "tcp")
+	cmd := exec.Command(web, "-syslogd_dir", srv.dir, "-listen", webAddr)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	e := &e2e{t: t, srv: srv, conn: conn, webURL: "http://" + webAddr}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(e.webURL + "/")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("gokr-syslogweb not serving on %s: %v", webAddr, err)
+		}
+	}
+	return e
+}
+
+// send sends the datagrams to the server and waits until the write loop has
+// accepted and flushed them all.
+func (e *e2e) send(raws ...string) {
+	e.t.Helper()
+	before := atomic.LoadUint64(&e.srv.accepted)
+	for _, raw := range raws {
+		if _, err := e.conn.Write([]byte(raw)); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	want := before + uint64(len(raws))
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadUint64(&e.srv.accepted) < want; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			e.t.Fatalf("accepted %d of %d messages (dropped: %v)", atomic.LoadUint64(&e.srv.accepted)-before, len(raws), droppedMessages)
+		}
+	}
+	if err := e.srv.flush(context.Background()); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// get returns the body of a GET request to gokr-syslogweb.
+func (e *e2e) get(path string) string {
+	e.t.Helper()
+	resp, err := http.Get(e.webURL + path)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		e.t.Fatalf("GET %s: %s: %s", path, resp.Status, b)
+	}
+	return string(b)
+}
+
+// search returns the results of q on /search as “host tag: content”, sorted.
+func (e *e2e) search(q string) []string {
+	e.t.Helper()
+	var results []string
+	for _, line := range strings.Split(strings.TrimSpace(e.get("/search?q="+url.QueryEscape(q))), "\n") {
+		if host, line, ok := strings.Cut(line, " "); ok {
+			results = append(results, host+" "+logline.Strip(line))
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+// TestEndToEnd sends messages in all formats which gokr-syslogd parses over
+// UDP, queries them through gokr-syslogweb, and then ages them through
+// compression and deletion with a fake clock.
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds gokr-syslogweb")
+	}
+	parseFailures.Lock()
+	parseFailures.stats = make(map[parseFailureKey]*parseFailureStats)
+	parseFailures.Unlock()
+
+	key := []byte("secret")
+	e := startE2E(t, &server{
+		hmacKey:   key,
+		dockerTag: []string{"image", "container"},
+	})
+
+	now := time.Now()
+	stamp := now.Format(time.Stamp)
+	e.send(
+		// RFC3164, e.g. busybox syslogd
+		"<30>"+stamp+" dr dhcpd[123]: DHCPDISCOVER from 00:0d:b9:4a:7e:20",
+		// RFC3164 with an RFC3339 timestamp, e.g. Go’s log/syslog
+		"<30>"+now.Format("2006-01-02T15:04:05-07:00")+" dr ntpd[7]: clock synchronized",
+		// gokr-kmsg
+		"<6>"+stamp+" dr kernel: [    1.234567] Booting Linux on physical CPU 0x0",
+		// gokr-winlogfwd
+		"<12>"+stamp+" winbox Service_Control_Manager: channel=System event_id=7036 The Windows Update service entered the stopped state.",
+		// Docker, without hostname
+		"<30>"+stamp+" nginx/web-1[1234]: GET / HTTP/1.1",
+		// signed, see -hmac_key_file
+		"<14>"+stamp+" dr backup: hmac="+signHMAC(key, "dr", "backup", "backup completed")+" backup completed",
+	)
+	if entries, _ := parseFailureEntries(); len(entries) > 0 {
+		t.Errorf("parse failures recorded for supported formats: %+v", entries)
+	}
+
+	all := map[string][]string{
+		"host:dr": {
+			"dr backup: backup completed",
+			"dr dhcpd: DHCPDISCOVER from 00:0d:b9:4a:7e:20",
+			"dr kernel: [    1.234567] Booting Linux on physical CPU 0x0",
+			"dr ntpd: clock synchronized",
+		},
+		"container=web-1":  {"127.0.0.1 nginx/web-1: GET / HTTP/1.1"},
+		"hmac=ok":          {"dr backup: backup completed"},
+		`"Windows Update"`: {"winbox Service_Control_Manager: channel=System event_id=7036 The Windows Update service entered the stopped state."},
+		"tag:kernel since:1h Booting": {
+			"dr kernel: [    1.234567] Booting Linux on physical CPU 0x0",
+		},
+	}
+	checkSearch := func(stage string, want map[string][]string) {
+		t.Helper()
+		for q, want := range want {
+			if diff := cmp.Diff(want, e.search(q)); diff != "" {
+				t.Errorf("%s: /search?q=%s: unexpected diff (-want +got):\n%s", stage, q, diff)
+			}
+		}
+	}
+	checkSearch("written", all)
+	if got := e.get("/grep/dr?q=DHCP"); !strings.Contains(got, "dhcpd: DHCPDISCOVER") {
+		t.Errorf("/grep/dr?q=DHCP = %q, want the dhcpd line", got)
+	}
+	index := e.get("/")
+	for _, host := range []string{"dr", "winbox", "127.0.0.1"} {
+		if !strings.Contains(index, host) {
+			t.Errorf("index does not list host %q", host)
+		}
+	}
+
+	fn := filepath.Join(e.srv.dir, "dr", now.Format(basenameFormat))
+	exists := func(fn string) bool {
+		_, err := os.Stat(fn)
+		return err == nil
+	}
+
+	// Two days later, the files are compressed, and still searchable.
+	e.srv.retentionPass(now.AddDate(0, 0, 2), false)
+	if exists(fn) || !exists(fn+".zst") {
+		t.Errorf("%s not compressed after two days", fn)
+	}
+	checkSearch("compressed", all)
+
+	// Beyond the retention period, the files are deleted.
+	e.srv.retentionPass(now.AddDate(0, 0, e.srv.retentionDays+2), false)
+	if exists(fn + ".zst") {
+		t.Errorf("%s.zst not deleted after the retention period", fn)
+	}
+	none := make(map[string][]string)
+	for q := range all {
+		none[q] = nil
+	}
+	checkSearch("expired", none)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gokrazy/syslogd/internal/entityindex"
+)
+
+// observeEntities records the IP and MAC addresses in msg, whose line starts
+// at offset of the log file basename, in the entity index of its host (see
+// -entity_index).
+func (s *server) observeEntities(msg message, basename string, offset int64) {
+	if s.entityIndexes == nil {
+		return
+	}
+	entities := entityindex.Extract(msg.content)
+	if len(entities) == 0 {
+		return
+	}
+	idx, ok := s.entityIndexes[msg.hostname]
+	if !ok {
+		fn := filepath.Join(s.dir, hostDirName(msg.hostname), entityindex.FileName)
+		var err error
+		idx, err = entityindex.ReadFile(fn)
+		if err != nil {
+			selfLog.Printf("entity_index", "reading %s: %v (starting a new index)", fn, err)
+			idx = entityindex.New()
+		}
+		s.entityIndexes[msg.hostname] = idx
+	}
+	for _, e := range entities {
+		idx.Observe(e, basename, offset, msg.received)
+	}
+}
+
+// writeEntityIndexes writes the entity indexes which changed since they were
+// last written, without the references to log files which retention deleted.
+func (s *server) writeEntityIndexes() {
+	for hostname, idx := range s.entityIndexes {
+		dir := filepath.Join(s.dir, hostDirName(hostname))
+		idx.Prune(func(file string) bool {
+			for _, fn := range []string{file, file + ".zst"} {
+				if _, err := os.Stat(filepath.Join(dir, fn)); err == nil {
+					return true
+				}
+			}
+			return false
+		})
+		if !idx.Dirty() {
+			continue
+		}
+		if err := s.writeEntityIndex(dir, idx); err != nil {
+			selfLog.Printf("entity_index", "writing entity index for %s: %v", hostname, err)
+		}
+	}
+}
+
+func (s *server) writeEntityIndex(dir string, idx *entityindex.Index) error {
+	if err := s.mkdirAll(dir); err != nil {
+		return err
+	}
+	fn := filepath.Join(dir, entityindex.FileName)
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := newPendingFile(fn, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if err := idx.Write(f); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	return s.chown(fn)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/entityindex"
+)
+
+func TestEntityIndex(t *testing.T) {
+	newServer := func(dir string) *server {
+		return &server{
+			dir:           dir,
+			files:         make(map[fileKey]*openFile),
+			bufferLimit:   1 << 20,
+			entityIndexes: make(map[string]*entityindex.Index),
+		}
+	}
+	dir := t.TempDir()
+	srv := newServer(dir)
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	write := func(srv *server, tag, content string) {
+		srv.write(message{hostname: "router7", timestamp: ts, received: ts, tag: tag, content: content})
+		ts = ts.Add(time.Minute)
+	}
+	write(srv, "dhcp4d", "DHCPACK 192.168.1.57 to aa:bb:cc:dd:ee:ff")
+	write(srv, "dnsd", "query example.com from 192.168.1.60")
+	srv.flushFiles()
+	srv.writeEntityIndexes()
+
+	// A restarted server continues the index, at the current file offset.
+	srv = newServer(dir)
+	write(srv, "dnsd", "query example.com from 192.168.1.57:5353")
+	srv.flushFiles()
+	srv.writeEntityIndexes()
+
+	idx, err := entityindex.ReadFile(filepath.Join(dir, "router7", entityindex.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "router7", "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := idx.Lookup("192.168.1.57")
+	if !ok {
+		t.Fatalf("192.168.1.57 not indexed")
+	}
+	if got, want := len(e.Files), 1; got != want {
+		t.Fatalf("192.168.1.57 in %d files, want %d", got, want)
+	}
+	ref := e.Files[0]
+	if got, want := len(ref.Offsets), 2; got != want {
+		t.Fatalf("192.168.1.57 at %d offsets, want %d", got, want)
+	}
+	for _, offset := range ref.Offsets {
+		line, _, _ := strings.Cut(string(b[offset:]), "\n")
+		if !strings.HasPrefix(line, "rfc3339=") || !strings.Contains(line, "192.168.1.57") {
+			t.Errorf("line at offset %d = %q, want a line mentioning 192.168.1.57", offset, line)
+		}
+	}
+	if _, ok := idx.Lookup("aa:bb:cc:dd:ee:ff"); !ok {
+		t.Errorf("aa:bb:cc:dd:ee:ff not indexed")
+	}
+
+	// References to deleted files are pruned.
+	if err := os.Remove(filepath.Join(dir, "router7", "2022-08-13.log")); err != nil {
+		t.Fatal(err)
+	}
+	srv.writeEntityIndexes()
+	idx, err = entityindex.ReadFile(filepath.Join(dir, "router7", entityindex.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.Entries(); len(got) != 0 {
+		t.Errorf("entries after deleting the log file = %v, want none", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/gokrazy/syslogd/internal/errindex"
+)
+
+// observeError records msg in the error index of its host (see -error_index)
+// if it is an error (severity err or more severe).
+func (s *server) observeError(msg message) {
+	if s.errorIndexes == nil || msg.severity < 0 || msg.severity > 3 {
+		return
+	}
+	idx, ok := s.errorIndexes[msg.hostname]
+	if !ok {
+		fn := filepath.Join(s.dir, hostDirName(msg.hostname), errindex.FileName)
+		var err error
+		idx, err = errindex.ReadFile(fn)
+		if err != nil {
+			selfLog.Printf("error_index", "reading %s: %v (starting a new index)", fn, err)
+			idx = errindex.New()
+		}
+		s.errorIndexes[msg.hostname] = idx
+	}
+	if idx.Observe(msg.tag, msg.content, msg.received) {
+		selfLog.Printf("error_index", "new error from %s: %s: %s", msg.hostname, msg.tag, msg.content)
+	}
+}
+
+// writeErrorIndexes writes the error indexes which changed since they were last
+// written.
+func (s *server) writeErrorIndexes() {
+	for hostname, idx := range s.errorIndexes {
+		if !idx.Dirty() {
+			continue
+		}
+		if err := s.writeErrorIndex(hostname, idx); err != nil {
+			selfLog.Printf("error_index", "writing error index for %s: %v", hostname, err)
+		}
+	}
+}
+
+func (s *server) writeErrorIndex(hostname string, idx *errindex.Index) error {
+	dir := filepath.Join(s.dir, hostDirName(hostname))
+	if err := s.mkdirAll(dir); err != nil {
+		return err
+	}
+	fn := filepath.Join(dir, errindex.FileName)
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := newPendingFile(fn, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if err := idx.Write(f); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	return s.chown(fn)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/errindex"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestErrorIndex(t *testing.T) {
+	srv := server{
+		dir:          t.TempDir(),
+		errorIndexes: make(map[string]*errindex.Index),
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, msg := range []message{
+		{severity: 3, tag: "kernel", content: "sda: I/O error, sector 1234"},
+		{severity: 6, tag: "dhcpd", content: "DHCPDISCOVER"},
+		{severity: 3, tag: "kernel", content: "sda: I/O error, sector 5678"},
+	} {
+		msg.hostname = "dr"
+		msg.timestamp = ts
+		msg.received = ts
+		srv.observeError(msg)
+	}
+	srv.writeErrorIndexes()
+
+	// A restarted server continues the index.
+	srv.errorIndexes = make(map[string]*errindex.Index)
+	later := ts.Add(24 * time.Hour)
+	srv.observeError(message{hostname: "dr", severity: 2, tag: "kernel", content: "sda: I/O error, sector 9", received: later})
+	srv.writeErrorIndexes()
+
+	idx, err := errindex.ReadFile(filepath.Join(srv.dir, "dr", errindex.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []errindex.Entry{
+		{
+			Template:  "kernel: sda: I/O error, sector <*>",
+			Example:   "kernel: sda: I/O error, sector 1234",
+			FirstSeen: ts,
+			LastSeen:  later,
+			Count:     3,
+		},
+	}
+	if diff := cmp.Diff(want, idx.Entries()); diff != "" {
+		t.Errorf("unexpected entries: diff (-want +got):\n%s", diff)
+	}
+}
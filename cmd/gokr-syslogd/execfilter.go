@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// filterMessage is the JSON representation of a message exchanged with the
+// -exec_filter command.
+type filterMessage struct {
+	Hostname  string   `json:"hostname"`
+	Timestamp string   `json:"timestamp"` // RFC3339Nano
+	Tag       string   `json:"tag"`
+	Content   string   `json:"content"`
+	Severity  int      `json:"severity"` // -1 if unknown
+	Facility  int      `json:"facility"` // -1 if unknown
+	Labels    []string `json:"labels,omitempty"`
+	Client    string   `json:"client,omitempty"` // ignored in responses
+}
+
+// execFilter passes messages through a pool of long-running -exec_filter
+// processes, which read one JSON message (see filterMessage) per line from
+// stdin and respond with one line on stdout: the message, possibly modified,
+// or null to drop it. The pool is shared by the write loops of all tenants.
+type execFilter struct {
+	args    []string
+	timeout time.Duration
+
+	// idle holds the processes which are not busy, nil for processes which
+	// are not running (yet, or after they failed).
+	idle chan *filterProc
+}
+
+func newExecFilter(cmd string, workers int, timeout time.Duration) (*execFilter, error) {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("at least one worker required")
+	}
+	f := &execFilter{
+		args:    args,
+		timeout: timeout,
+		idle:    make(chan *filterProc, workers),
+	}
+	for i := 0; i < workers; i++ {
+		f.idle <- nil
+	}
+	return f, nil
+}
+
+// filterProc is a running -exec_filter process.
+type filterProc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte   // closed when stdout is closed
+	done  chan struct{} // closed by kill
+}
+
+func (f *execFilter) start() (*filterProc, error) {
+	cmd := exec.Command(f.args[0], f.args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &filterProc{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan []byte),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(p.lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			select {
+			case p.lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+func (p *filterProc) kill() {
+	close(p.done)
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	go p.cmd.Wait()
+}
+
+// exchange sends req to p and returns its response.
+func (p *filterProc) exchange(req []byte) ([]byte, error) {
+	if _, err := p.stdin.Write(req); err != nil {
+		return nil, err
+	}
+	line, ok := <-p.lines
+	if !ok {
+		return nil, errors.New("exited")
+	}
+	return line, nil
+}
+
+// filter passes msg through a filter process. It returns false if the message
+// is to be dropped. When the process fails (or does not respond within the
+// timeout), it is restarted with the next message, and msg is kept unchanged.
+func (f *execFilter) filter(msg *message) (bool, error) {
+	timer := time.NewTimer(f.timeout)
+	defer timer.Stop()
+	var p *filterProc
+	select {
+	case p = <-f.idle:
+	case <-timer.C:
+		return true, fmt.Errorf("all %d processes busy for %v", cap(f.idle), f.timeout)
+	}
+	if p == nil {
+		var err error
+		if p, err = f.start(); err != nil {
+			f.idle <- nil
+			return true, err
+		}
+	}
+	req, err := json.Marshal(msg.filterMessage())
+	if err != nil {
+		f.idle <- p
+		return true, err
+	}
+	type result struct {
+		line []byte
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		line, err := p.exchange(append(req, '\n'))
+		resc <- result{line, err}
+	}()
+	var res result
+	select {
+	case res = <-resc:
+	case <-timer.C:
+		res.err = fmt.Errorf("no response within %v", f.timeout)
+	}
+	if res.err != nil {
+		p.kill()
+		f.idle <- nil
+		return true, res.err
+	}
+	f.idle <- p
+	if string(res.line) == "null" {
+		return false, nil
+	}
+	var resp filterMessage
+	if err := json.Unmarshal(res.line, &resp); err != nil {
+		return true, fmt.Errorf("invalid response: %v", err)
+	}
+	return true, msg.applyFilter(resp)
+}
+
+func (msg *message) filterMessage() filterMessage {
+	fm := filterMessage{
+		Hostname:  msg.hostname,
+		Timestamp: msg.timestamp.Format(time.RFC3339Nano),
+		Tag:       msg.tag,
+		Content:   msg.content,
+		Severity:  msg.severity,
+		Facility:  msg.facility,
+		Labels:    msg.labels,
+	}
+	if msg.client.IsValid() {
+		fm.Client = msg.clientString()
+	}
+	return fm
+}
+
+// applyFilter updates msg with the response of a filter process, unless the
+// response is invalid.
+func (msg *message) applyFilter(fm filterMessage) error {
+	if !validHostname(fm.Hostname) || fm.Hostname == "" {
+		return fmt.Errorf("invalid response: invalid hostname %q", fm.Hostname)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, fm.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	if fm.Tag == "" {
+		return fmt.Errorf("invalid response: empty tag")
+	}
+	if fm.Severity < -1 || fm.Severity >= len(severity.Names) {
+		return fmt.Errorf("invalid response: invalid severity %d", fm.Severity)
+	}
+	if fm.Facility < -1 || fm.Facility > 23 {
+		return fmt.Errorf("invalid response: invalid facility %d", fm.Facility)
+	}
+	for _, label := range fm.Labels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" || strings.ContainsAny(label, " \n") || sanitize(value) != value {
+			return fmt.Errorf("invalid response: invalid label %q", label)
+		}
+	}
+	msg.hostname = fm.Hostname
+	msg.timestamp = timestamp
+	msg.tag = sanitizeTag(fm.Tag)
+	msg.content = fm.Content
+	msg.severity = fm.Severity
+	msg.facility = fm.Facility
+	msg.labels = fm.Labels
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testFilterScript = `while IFS= read -r line; do
+	case "$line" in
+	*'"tag":"noisy"'*) echo null ;;
+	*'"tag":"slow"'*) sleep 2; echo "$line" ;;
+	*'"tag":"broken"'*) echo '{"hostname":"../etc"}' ;;
+	*) echo "$line" | sed 's/password=[^ "]*/password=REDACTED/' ;;
+	esac
+done
+`
+
+func TestExecFilter(t *testing.T) {
+	for _, name := range []string{"sh", "sed", "sleep"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skip(err)
+		}
+	}
+	script := filepath.Join(t.TempDir(), "filter.sh")
+	if err := os.WriteFile(script, []byte(testFilterScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newExecFilter("sh "+script, 1, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	newMsg := func(tag, content string) message {
+		return message{
+			hostname:  "dr",
+			timestamp: ts,
+			tag:       tag,
+			content:   content,
+			severity:  6,
+			facility:  -1,
+		}
+	}
+	for _, tt := range []struct {
+		name     string
+		msg      message
+		wantKeep bool
+		wantErr  bool
+		want     message
+	}{
+		{
+			name:     "modified",
+			msg:      newMsg("login", "user=michael password=hunter2"),
+			wantKeep: true,
+			want:     newMsg("login", "user=michael password=REDACTED"),
+		},
+		{
+			name: "dropped",
+			msg:  newMsg("noisy", "spam"),
+			want: newMsg("noisy", "spam"),
+		},
+		{
+			name:     "invalid",
+			msg:      newMsg("broken", "hello"),
+			wantKeep: true,
+			wantErr:  true,
+			want:     newMsg("broken", "hello"),
+		},
+		{
+			name:     "timeout",
+			msg:      newMsg("slow", "hello"),
+			wantKeep: true,
+			wantErr:  true,
+			want:     newMsg("slow", "hello"),
+		},
+		{
+			name:     "restarted",
+			msg:      newMsg("login", "password=x"),
+			wantKeep: true,
+			want:     newMsg("login", "password=REDACTED"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			keep, err := f.filter(&msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filter = %v, want error %v", err, tt.wantErr)
+			}
+			if keep != tt.wantKeep {
+				t.Errorf("filter = %v, want %v", keep, tt.wantKeep)
+			}
+			if diff := cmp.Diff(tt.want.filterMessage(), msg.filterMessage()); diff != "" {
+				t.Errorf("filter: unexpected message: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
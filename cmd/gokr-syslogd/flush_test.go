@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushDelay(t *testing.T) {
+	srv := server{
+		flushIdle:     250 * time.Millisecond,
+		flushMaxDelay: 5 * time.Second,
+	}
+	start := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	for _, tt := range []struct {
+		desc       string
+		firstWrite time.Time
+		lastWrite  time.Time
+		now        time.Time
+		want       time.Duration
+	}{
+		{
+			desc:       "single message, not yet idle",
+			firstWrite: start,
+			lastWrite:  start,
+			now:        start.Add(100 * time.Millisecond),
+			want:       150 * time.Millisecond,
+		},
+		{
+			desc:       "single message, idle",
+			firstWrite: start,
+			lastWrite:  start,
+			now:        start.Add(250 * time.Millisecond),
+			want:       0,
+		},
+		{
+			desc:       "sustained load",
+			firstWrite: start,
+			lastWrite:  start.Add(4900 * time.Millisecond),
+			now:        start.Add(4900 * time.Millisecond),
+			want:       100 * time.Millisecond,
+		},
+		{
+			desc:       "sustained load, max delay reached",
+			firstWrite: start,
+			lastWrite:  start.Add(5 * time.Second),
+			now:        start.Add(5 * time.Second),
+			want:       0,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := srv.flushDelay(tt.firstWrite, tt.lastWrite, tt.now)
+			if got != tt.want {
+				t.Errorf("flushDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/google/renameio/v2"
+)
+
+// pendingFile is a temporary file which atomically replaces its destination
+// once complete (see renameio.PendingFile).
+type pendingFile interface {
+	io.Writer
+	CloseAtomicallyReplace() error
+	Cleanup() error
+}
+
+// newPendingFile returns a pendingFile for path with permissions perm
+// (regardless of the umask).
+func newPendingFile(path string, perm os.FileMode) (pendingFile, error) {
+	return renameio.NewPendingFile(path, renameio.WithStaticPermissions(perm))
+}
+
+// hostDirName returns the name of the directory for the log files of
+// hostname.
+func hostDirName(hostname string) string {
+	return hostname
+}
+
+// syncDir fsyncs the directory dir, which makes the creation, rename or removal
+// of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// invalidNameChars replaces the characters which Windows does not allow in
+// file names, e.g. the colons of IPv6 addresses, which are used as hostnames
+// of messages without hostname.
+var invalidNameChars = strings.NewReplacer(
+	"<", "_",
+	">", "_",
+	":", "_",
+	`"`, "_",
+	"/", "_",
+	`\`, "_",
+	"|", "_",
+	"?", "_",
+	"*", "_",
+)
+
+// hostDirName returns the name of the directory for the log files of
+// hostname.
+func hostDirName(hostname string) string {
+	return invalidNameChars.Replace(hostname)
+}
+
+// pendingFile is a temporary file which replaces its destination once
+// complete. renameio does not support Windows, where the replacement is not
+// guaranteed to be atomic, but os.Rename replaces existing files.
+type pendingFile interface {
+	io.Writer
+	CloseAtomicallyReplace() error
+	Cleanup() error
+}
+
+type windowsPendingFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+func newPendingFile(path string, perm os.FileMode) (pendingFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &windowsPendingFile{File: f, path: path}, nil
+}
+
+func (p *windowsPendingFile) CloseAtomicallyReplace() error {
+	if err := p.Sync(); err != nil {
+		return err
+	}
+	if err := p.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(p.Name(), p.path); err != nil {
+		return err
+	}
+	p.done = true
+	return nil
+}
+
+func (p *windowsPendingFile) Cleanup() error {
+	if p.done {
+		return nil
+	}
+	p.Close()
+	return os.Remove(p.Name())
+}
+
+// syncDir is a no-op: Windows cannot open directories for syncing, and NTFS
+// journals metadata changes like renames.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// strayTempAge is how old a temporary file (see isPendingFileName) needs to be
+// for checkTree to consider it left behind by a crash, not still being
+// written, e.g. by gokr-syslogctl.
+const strayTempAge = time.Hour
+
+// treeProblem is an anomaly found by checkTree.
+type treeProblem struct {
+	path    string
+	problem string
+	action  string // empty if only reported
+}
+
+// isPendingFileName reports whether name is that of a temporary file of
+// newPendingFile (a dot, the destination name and random digits) or
+// checkWritable.
+func isPendingFileName(name string) bool {
+	if !strings.HasPrefix(name, ".") {
+		return false
+	}
+	base := strings.TrimRight(name, "0123456789")
+	return len(base) < len(name) && len(base) > 1
+}
+
+// errCorrupt is returned by compareCompressed if the compressed file cannot be
+// decoded.
+var errCorrupt = errors.New("corrupt compressed file")
+
+// compareCompressed reports whether the decompressed content of the log file
+// zstPath is a prefix of the log file logPath, and whether both are equal.
+func compareCompressed(zstPath, logPath string) (prefix, equal bool, _ error) {
+	zf, err := os.Open(zstPath)
+	if err != nil {
+		return false, false, err
+	}
+	defer zf.Close()
+	dec, err := zstd.NewReader(zf)
+	if err != nil {
+		return false, false, err
+	}
+	defer dec.Close()
+	lf, err := os.Open(logPath)
+	if err != nil {
+		return false, false, err
+	}
+	defer lf.Close()
+	zbuf := make([]byte, 64*1024)
+	lbuf := make([]byte, len(zbuf))
+	for {
+		n, err := io.ReadFull(dec, zbuf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, false, fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		done := err != nil
+		m, err := io.ReadFull(lf, lbuf[:n])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, false, err
+		}
+		if m < n || !bytes.Equal(zbuf[:n], lbuf[:n]) {
+			return false, false, nil
+		}
+		if done {
+			m, err := lf.Read(lbuf[:1])
+			if err != nil && err != io.EOF {
+				return false, false, err
+			}
+			return true, m == 0, nil
+		}
+	}
+}
+
+// rotatedCompressedName returns the first numbered name for the compressed
+// log file fn which is not taken, e.g. 2022-08-13.1.log.zst for
+// 2022-08-13.log.zst (see rotatedName).
+func rotatedCompressedName(fn string) string {
+	return rotatedName(strings.TrimSuffix(fn, ".zst")) + ".zst"
+}
+
+// checkLogPair checks a log file fn of which a compressed copy exists as well,
+// which compressFile leaves behind when gokr-syslogd crashes before removing
+// fn, or when lines were added to fn after compressing it. The next
+// compression would replace the compressed copy with fn.
+func checkLogPair(fn string) (problem treeProblem, repair func() error, _ error) {
+	zst := fn + ".zst"
+	problem = treeProblem{path: fn, problem: "both " + filepath.Base(fn) + " and " + filepath.Base(zst) + " exist"}
+	prefix, equal, err := compareCompressed(zst, fn)
+	switch {
+	case errors.Is(err, errCorrupt):
+		problem.problem += ", " + err.Error()
+		problem.action = "removed " + filepath.Base(zst)
+		return problem, func() error { return os.Remove(zst) }, nil
+	case err != nil:
+		return problem, nil, err
+	case equal:
+		problem.problem += " with the same content"
+		problem.action = "removed " + filepath.Base(fn)
+		return problem, func() error { return os.Remove(fn) }, nil
+	case prefix:
+		problem.problem += ", lines were added after compressing"
+		problem.action = "removed " + filepath.Base(zst)
+		return problem, func() error { return os.Remove(zst) }, nil
+	}
+	// Keep both instead of losing the lines of the compressed copy when fn
+	// is compressed next.
+	renamed := rotatedCompressedName(zst)
+	problem.problem += " with different content"
+	problem.action = "renamed " + filepath.Base(zst) + " to " + filepath.Base(renamed)
+	return problem, func() error { return os.Rename(zst, renamed) }, nil
+}
+
+// checkTree scans the log directory of s for anomalies which would trip up
+// rotation and retention later: log files which exist both compressed and
+// uncompressed, empty log files, log files dated in the future and temporary
+// files left behind by a crash. Unless repair is false (in read-only mode), it
+// repairs them where that is safe. Future-dated files are only reported.
+func (s *server) checkTree(now time.Time, repair bool) ([]treeProblem, error) {
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+	var problems []treeProblem
+	fix := func(p treeProblem, repairFn func() error) {
+		if !repair {
+			p.action = ""
+		} else if err := repairFn(); err != nil {
+			p.action = fmt.Sprintf("repair failed: %v", err)
+		}
+		problems = append(problems, p)
+	}
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // removed by a repair
+			}
+			return err
+		}
+		if isPendingFileName(d.Name()) {
+			if now.Sub(info.ModTime()) >= strayTempAge {
+				fix(treeProblem{
+					path:    path,
+					problem: "temporary file left behind",
+					action:  "removed",
+				}, func() error { return os.Remove(path) })
+			}
+			return nil
+		}
+		// Log files are only in host directories.
+		if filepath.Dir(filepath.Dir(path)) != filepath.Clean(s.dir) {
+			return nil
+		}
+		day, compressed, ok := parseLogFileName(d.Name())
+		if !ok {
+			return nil
+		}
+		if info.Size() == 0 {
+			fix(treeProblem{
+				path:    path,
+				problem: "empty log file",
+				action:  "removed",
+			}, func() error { return os.Remove(path) })
+			return nil
+		}
+		if day.After(tomorrow) {
+			problems = append(problems, treeProblem{
+				path:    path,
+				problem: "dated in the future, it is not compressed or deleted until " + day.Format("2006-01-02"),
+			})
+		}
+		if compressed {
+			return nil
+		}
+		if st, err := os.Stat(path + ".zst"); err != nil || st.Size() == 0 {
+			return nil // empty: removed on its own
+		}
+		p, repairFn, err := checkLogPair(path)
+		if err != nil {
+			return err
+		}
+		fix(p, repairFn)
+		return nil
+	})
+	return problems, err
+}
+
+// logTreeProblems logs the problems which checkTree finds in the log
+// directory of s at startup.
+func (s *server) logTreeProblems(now time.Time, repair bool) {
+	problems, err := s.checkTree(now, repair)
+	for _, p := range problems {
+		if p.action == "" {
+			log.Printf("checking %s: %s: %s", s.dir, p.path, p.problem)
+			continue
+		}
+		log.Printf("checking %s: %s: %s: %s", s.dir, p.path, p.problem, p.action)
+	}
+	if err != nil {
+		log.Printf("checking %s: %v", s.dir, err)
+	}
+}
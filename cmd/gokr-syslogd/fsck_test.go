@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCheckTree(t *testing.T) {
+	srv := server{dir: t.TempDir()}
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	compressed := func(s string) string {
+		return string(enc.EncodeAll([]byte(s), nil))
+	}
+	const lines = "rfc3339=2022-08-10T10:00:00Z seq=1 sshd: one\nrfc3339=2022-08-10T11:00:00Z seq=2 sshd: two\n"
+	files := map[string]string{
+		"dr/2022-08-08.log":         "",
+		"dr/2022-08-09.log":         lines,
+		"dr/2022-08-09.log.zst":     "not zstd",
+		"dr/2022-08-10.log":         lines,
+		"dr/2022-08-10.log.zst":     compressed(lines),
+		"dr/2022-08-11.log":         lines + "rfc3339=2022-08-11T00:00:01Z seq=3 sshd: three\n",
+		"dr/2022-08-11.log.zst":     compressed(lines),
+		"dr/2022-08-12.log":         lines,
+		"dr/2022-08-12.log.zst":     compressed("rfc3339=2022-08-12T10:00:00Z seq=1 sshd: other\n"),
+		"dr/2022-09-30.log":         lines,
+		"dr/notes.txt":              "",
+		"dr/.2022-08-13.log.zst123": "partial",
+		".holds.json456":            "[]",
+	}
+	for rel, content := range files {
+		fn := filepath.Join(srv.dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The temporary log file was left behind by a crash, the one of the holds
+	// is still being written.
+	if err := os.Chtimes(filepath.Join(srv.dir, "dr/.2022-08-13.log.zst123"), now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(srv.dir, ".holds.json456"), now.Add(-time.Minute), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := func() []string {
+		var rels []string
+		err := filepath.Walk(srv.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(srv.dir, path)
+			rels = append(rels, filepath.ToSlash(rel))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(rels)
+		return rels
+	}
+	problemsOf := func(problems []treeProblem) []string {
+		var got []string
+		for _, p := range problems {
+			rel, err := filepath.Rel(srv.dir, p.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, filepath.ToSlash(rel)+": "+p.action)
+		}
+		return got
+	}
+	before := remaining()
+
+	// Without repairing, problems are only reported.
+	problems, err := srv.checkTree(now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"dr/.2022-08-13.log.zst123: ",
+		"dr/2022-08-08.log: ",
+		"dr/2022-08-09.log: ",
+		"dr/2022-08-10.log: ",
+		"dr/2022-08-11.log: ",
+		"dr/2022-08-12.log: ",
+		"dr/2022-09-30.log: ",
+	}
+	if diff := cmp.Diff(want, problemsOf(problems)); diff != "" {
+		t.Errorf("checkTree(repair=false): unexpected diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(before, remaining()); diff != "" {
+		t.Errorf("checkTree(repair=false) changed files: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	problems, err = srv.checkTree(now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"dr/.2022-08-13.log.zst123: removed",
+		"dr/2022-08-08.log: removed",
+		"dr/2022-08-09.log: removed 2022-08-09.log.zst",
+		"dr/2022-08-10.log: removed 2022-08-10.log",
+		"dr/2022-08-11.log: removed 2022-08-11.log.zst",
+		"dr/2022-08-12.log: renamed 2022-08-12.log.zst to 2022-08-12.1.log.zst",
+		"dr/2022-09-30.log: ",
+	}
+	if diff := cmp.Diff(want, problemsOf(problems)); diff != "" {
+		t.Errorf("checkTree(repair=true): unexpected diff (-want +got):\n%s", diff)
+	}
+	wantFiles := []string{
+		".holds.json456",
+		"dr/2022-08-09.log",
+		"dr/2022-08-10.log.zst",
+		"dr/2022-08-11.log",
+		"dr/2022-08-12.1.log.zst",
+		"dr/2022-08-12.log",
+		"dr/2022-09-30.log",
+		"dr/notes.txt",
+	}
+	if diff := cmp.Diff(wantFiles, remaining()); diff != "" {
+		t.Errorf("files after checkTree: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Once repaired, only the future-dated file is left to report.
+	problems, err = srv.checkTree(now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"dr/2022-09-30.log: "}, problemsOf(problems)); diff != "" {
+		t.Errorf("checkTree(repair=true) again: unexpected diff (-want +got):\n%s", diff)
+	}
+}
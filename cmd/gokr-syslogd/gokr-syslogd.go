@@ -1,68 +1,403 @@
 // Binary gokr-syslogd is a remote syslog server that writes all received
 // messages into files on local disk. Files that are no longer in use (no new
-// messages will be written to them) will be compressed and deleted after 7
-// days.
+// messages will be written to them) are compressed, and deleted once they are
+// older than -retention (7 days by default), unless a legal hold or the
+// retention of a retired host keeps them longer.
 package main
 
 import (
+	"bytes"
+	"container/heap"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/google/renameio/v2"
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/errindex"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/gokrazy/syslogd/internal/mdns"
+	"github.com/gokrazy/syslogd/internal/rollup"
+	"github.com/gokrazy/syslogd/senderconfig"
 	"github.com/klauspost/compress/zstd"
-	"gopkg.in/mcuadros/go-syslog.v2"
 )
 
 const basenameFormat = "2006-01-02.log"
 
-// logRateLimited throttles printing error message. This is particularly
-// important when the gokr-syslogd output itself is sent to gokr-syslogd, which
-// could cause infinite log message loops without rate limiting.
-//
-// When the value is 0, a log message can be printed. A background goroutine
-// resets the value to 0 once a second.
-var logRateLimited uint32
+// maxBatchSize is how many bytes of lines are buffered at most before they are
+// flushed to disk regardless of flushDelay.
+const maxBatchSize = 64 * 1024
 
-func init() {
-	go func() {
-		for range time.Tick(1 * time.Second) {
-			atomic.StoreUint32(&logRateLimited, 0)
-		}
-	}()
-}
+// defaultRetentionDays is how many days compressed log files are kept by
+// default (see -retention).
+const defaultRetentionDays = 7
 
 type fileKey struct {
 	hostname string
 	basename string
+
+	// quarantine files are stored in server.quarantineDir instead of
+	// server.dir.
+	quarantine bool
 }
 
 type openFile struct {
 	f       *os.File
+	buf     bytes.Buffer // lines which were not yet written to f
 	lastUse time.Time
+	seq     uint64 // sequence number of the last line written
+	size    int64  // offset at which the next buffered line starts
+	synced  bool   // whether all written lines were fsynced
+}
+
+// flush writes all buffered lines to the file. In case of an error (e.g. the
+// disk is full), the lines which were not written remain buffered.
+func (of *openFile) flush() error {
+	for of.buf.Len() > 0 {
+		n, err := of.f.Write(of.buf.Bytes())
+		of.buf.Next(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type server struct {
-	dir   string
+	dir string
+
+	// files are the open log files. They are owned by the write loop (see
+	// run): other goroutines must not access them, but can send functions
+	// to the write loop (see onWriteLoop).
 	files map[fileKey]*openFile
+
+	// flushIdle is how long no new message must have arrived before buffered
+	// lines are written to disk.
+	flushIdle time.Duration
+
+	// flushMaxDelay is how long lines can stay buffered at most, even when
+	// messages keep arriving.
+	flushMaxDelay time.Duration
+
+	// dayRule is one of dayRuleEvent or dayRuleReceive.
+	dayRule string
+
+	// maxFutureSkew is how far in the future (relative to the local clock)
+	// timestamps are accepted. Zero accepts any timestamp.
+	maxFutureSkew time.Duration
+
+	// futureAction is one of futureReject or futureClamp.
+	futureAction string
+
+	// externalRotation writes one file per host, which is rotated by an
+	// external tool, see rotationExternal.
+	externalRotation bool
+
+	// annotateDay enables the event_day= field for lines which are filed
+	// into a different day than their timestamp.
+	annotateDay bool
+
+	// reorderWindow is how long messages are held back so that they can be
+	// written in timestamp order. Zero disables reordering.
+	reorderWindow time.Duration
+
+	// acceptTagless stores messages without a tag using placeholderTag
+	// instead of dropping them.
+	acceptTagless bool
+
+	// acceptEmpty stores messages with empty content instead of dropping
+	// them.
+	acceptEmpty bool
+
+	// annotateReceived enables the received= field, which records when
+	// gokr-syslogd received the message according to the local clock.
+	annotateReceived bool
+
+	// storeClient enables the client= field, which records the source
+	// address of the message.
+	storeClient bool
+
+	// keepRaw enables the raw= field, which holds the original
+	// (base64-encoded) content of messages modified by sanitize.
+	keepRaw bool
+
+	// bufferLimit is how many bytes of lines can be buffered in memory in
+	// total, e.g. while the disk is full.
+	bufferLimit int
+
+	// hostSources restricts the source addresses of hostnames, if non-nil.
+	hostSources *hostSources
+
+	// zones label messages by source address (see -zones).
+	zones []zone
+
+	// hostAliases map old hostnames to the current ones (see -host_aliases).
+	hostAliases hostalias.Map
+
+	// retired holds the decommissioned hosts (see retiredHosts), if non-nil.
+	retired *retiredHosts
+
+	// holds exempt log files from retention (see legalHolds), if non-nil.
+	holds *legalHolds
+
+	// routes send messages of particular facilities into dedicated files.
+	routes []route
+
+	// fileMode and dirMode are the permissions of newly created log files and
+	// directories (0 means 0644 and 0755, respectively).
+	fileMode, dirMode os.FileMode
+
+	// owner is the owner of newly created log files and directories, if
+	// non-nil.
+	owner *owner
+
+	// mirror receives a copy of all written lines (see -stdout), if non-nil.
+	mirror    io.Writer
+	mirrorBuf bytes.Buffer
+
+	// spoofedAction is one of spoofedFlag, spoofedQuarantine or spoofedDrop.
+	spoofedAction string
+
+	// quarantineDir is where quarantined messages are written to.
+	quarantineDir string
+
+	// pcap receives rejected and captured datagrams (see -debug_pcap), if
+	// non-nil. It is shared by all tenants.
+	pcap *pcapWriter
+
+	// listenPort is the UDP port on which s receives messages.
+	listenPort uint16
+
+	// spoolDir is where datagrams are spooled during overload (see spool),
+	// if non-empty.
+	spoolDir string
+
+	// format restricts parsing to formatRFC3164 or formatRFC5424 (see
+	// rawFormat). Empty means detecting the format per message.
+	format string
+
+	// quarantineRejected writes rejected messages into quarantineDir.
+	quarantineRejected bool
+
+	// quarantineRetentionDays is after how many days files in quarantineDir
+	// are deleted.
+	quarantineRetentionDays int
+
+	// hmacKey is the shared secret for verifying signed messages, if non-nil.
+	hmacKey []byte
+
+	// requireHMAC drops messages without signature.
+	requireHMAC bool
+
+	// lineBuf is re-used for formatting lines.
+	lineBuf []byte
+
+	// flushRequests are sent by the /flush handler. The run loop flushes all
+	// files and replies with the result.
+	flushRequests chan chan error
+
+	// loopRequests are functions to call on the write loop, see
+	// onWriteLoop.
+	loopRequests chan func()
+
+	// ingested are messages received via HTTP (see -webhook_ingest and
+	// /annotate), which the write loop accepts like those received via
+	// syslog. nil without -http_listen.
+	ingested chan messageParts
+
+	// retentionNow requests a compression/deletion pass ahead of schedule.
+	retentionNow chan struct{}
+
+	// watchdogTimeout is how long the write loop may be unresponsive (see
+	// watchdog), and how long writes may fail before the log files are
+	// reopened. Zero disables the watchdog.
+	watchdogTimeout time.Duration
+
+	// ping is sent to by the watchdog, see watchdog.
+	ping chan struct{}
+
+	// hangup requests closing all log files, see notifyHangup.
+	hangup chan struct{}
+
+	// retentionInterval is how often old log files are compressed and
+	// deleted (0 means hourly).
+	retentionInterval time.Duration
+
+	// compressWindow restricts compression to a time of day, if non-nil.
+	compressWindow *timeWindow
+
+	// compressPauseRate is the ingest rate (messages per second) above which
+	// compression is postponed. Zero disables pausing.
+	compressPauseRate float64
+
+	// compressIOLimit is the rate (bytes per second) at which compression
+	// reads log files. Zero means unlimited.
+	compressIOLimit int64
+
+	// accepted counts the messages accepted by the write loop. Accessed
+	// atomically.
+	accepted uint64
+
+	// severityTiers keep more severe lines for longer, if non-empty. Lines are
+	// then written with a severity= field.
+	severityTiers []severityTier
+
+	// preDeleteCmd is run before retention deletes a log file, see preDelete.
+	preDeleteCmd string
+
+	// retentionWebhooks are notified of compressed and deleted log files.
+	retentionWebhooks []string
+
+	// webhookOutboxes queue the events for retentionWebhooks (same order) with
+	// -output_spool_dir, nil otherwise.
+	webhookOutboxes []*outbox
+
+	// webhookTemplate renders the requests to retentionWebhooks (see
+	// -webhook_template), if non-nil.
+	webhookTemplate *webhookTemplate
+
+	// outputRules scope -stdout and retentionWebhooks (see -output_rules), if
+	// non-nil.
+	outputRules *outputRules
+
+	// storeSeverity writes lines with a severity= field.
+	storeSeverity bool
+
+	// rollups enables the rollup files (see rollupOldLogs), which are
+	// deleted after rollupRetentionDays (0 means never).
+	rollups             bool
+	rollupRetentionDays int
+
+	// dockerTag names the slash-separated components of tags, which are
+	// stored as fields (see -docker_tag), if non-empty.
+	dockerTag []string
+
+	// correlationID extracts the correlation ID of messages from their
+	// content (see -correlation_id), if non-nil.
+	correlationID *regexp.Regexp
+
+	// boots are the current boot sessions by hostname (see -boot_sessions), if
+	// non-nil. Owned by the run loop.
+	boots map[string]*bootState
+
+	// bootMarker matches messages (tag: content) which a host sends when it
+	// boots, if non-nil.
+	bootMarker *regexp.Regexp
+
+	// services maps tags to gokrazy packages (see -services), if non-nil.
+	// Shared across tenants.
+	services *serviceMap
+
+	// tagFilters drop messages by host and tag (see -tag_filters), if
+	// non-nil.
+	tagFilters *tagFilters
+
+	// rules drop, route and alert on messages by condition (see -rules), if
+	// non-nil. Shared across tenants.
+	rules *rules
+
+	// execFilter passes messages through -exec_filter processes, if
+	// non-nil. Shared across tenants.
+	execFilter *execFilter
+
+	// manifest maintains a checksum manifest per host directory, see
+	// updateManifest.
+	manifest bool
+
+	// errorIndexes are the error indexes by hostname (see -error_index), if
+	// non-nil. Owned by the run loop.
+	errorIndexes map[string]*errindex.Index
+
+	// entityIndexes are the entity indexes by hostname (see -entity_index),
+	// if non-nil.
+	entityIndexes map[string]*entityindex.Index
+
+	// bindingTables are the IP address to hostname binding tables by
+	// hostname (see -learn_bindings), if enabled.
+	bindingTables map[string]*bindings.Table
+
+	// matrix counts messages per host and minute for /matrix, if non-nil.
+	// Shared across tenants.
+	matrix *matrix
+
+	// hostMetrics tracks per-host freshness and counts for /metrics, if
+	// non-nil. Shared across tenants.
+	hostMetrics *hostMetrics
+
+	// anomalies tracks message rates per source, if non-nil.
+	anomalies *anomalyDetector
+
+	// retentionDays is the number of days after which compressed log files
+	// are deleted.
+	retentionDays int
+
+	// maxDiskUsage is how many bytes the log files may use before the oldest
+	// compressed ones are deleted regardless of their age (0 means no limit).
+	maxDiskUsage int64
+}
+
+// bufferedBytes returns how many bytes are buffered across all files.
+func (s *server) bufferedBytes() int {
+	var n int
+	for _, of := range s.files {
+		n += of.buf.Len()
+	}
+	return n
+}
+
+// dirFor returns the base directory for the log file identified by key.
+func (s *server) dirFor(key fileKey) string {
+	if key.quarantine {
+		return s.quarantineDir
+	}
+	return s.dir
 }
 
 func (s *server) openFile(key fileKey) (*os.File, error) {
-	fn := filepath.Join(s.dir, key.hostname, key.basename)
-	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+	fn := filepath.Join(s.dirFor(key), hostDirName(key.hostname), key.basename)
+	if err := s.mkdirAll(s.dirFor(key)); err != nil {
+		return nil, err
+	}
+	if err := s.mkdirAll(filepath.Dir(fn)); err != nil {
 		return nil, err
 	}
-	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	_, err := os.Stat(fn)
+	created := os.IsNotExist(err)
+	mode := s.fileModeFor(key)
+	flags := os.O_RDWR | os.O_CREATE
+	if s.externalRotation {
+		// The file might be truncated underneath us (logrotate copytruncate).
+		flags |= os.O_APPEND
+	}
+	f, err := os.OpenFile(fn, flags, mode)
 	if err != nil {
 		return nil, err
 	}
+	if created {
+		// Apply mode regardless of the umask.
+		if err := f.Chmod(mode); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := s.chown(fn); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
 	// os.O_APPEND results in the kernel seeking to the end of the file on
 	// *every write*, which is unnecessary for our use-case. Instead, we seek to
 	// the end once when opening a file, which is a no-op for newly created
@@ -73,98 +408,83 @@ func (s *server) openFile(key fileKey) (*os.File, error) {
 	return f, nil
 }
 
-func (s *server) toDeleteLogFileNames(now time.Time) ([]string, error) {
-	oldestToKeep := now.Add(-7 * 24 * time.Hour).Format(basenameFormat)
-
-	var toDeleteLogFileNames []string
-
-	hostDirs, err := os.ReadDir(s.dir)
-	if err != nil {
-		return nil, err
+// checkWritable returns an error if no files can be created in dir, e.g.
+// because it is on a read-only file system.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
-	for _, hostDir := range hostDirs {
-		dir := filepath.Join(s.dir, hostDir.Name())
-		logFiles, err := os.ReadDir(dir)
-		if err != nil {
-			return nil, err
-		}
-		logFileNames := make([]string, 0, len(logFiles))
-		for _, logFile := range logFiles {
-			if !strings.HasSuffix(logFile.Name(), ".log.zst") {
-				continue // skip already compressed file
-			}
-			logFileNames = append(logFileNames, filepath.Join(dir, logFile.Name()))
-		}
-		// Exclude all log files that might still be in use
-		toDelete := make([]string, 0, len(logFileNames))
-		for _, fn := range logFileNames {
-			if strings.Compare(filepath.Join(dir, oldestToKeep), fn) > 0 {
-				toDelete = append(toDelete, fn)
-			}
-		}
-		toDeleteLogFileNames = append(toDeleteLogFileNames, toDelete...)
+	f, err := os.CreateTemp(dir, ".writable")
+	if err != nil {
+		return err
 	}
-	return toDeleteLogFileNames, nil
+	f.Close()
+	return os.Remove(f.Name())
 }
 
-func (s *server) coldLogFileNames(now time.Time) ([]string, error) {
-	// We accept log messages for up to 24 hours earlier
-	earliestInUse := now.Add(-24 * time.Hour).Format(basenameFormat)
-	currentlyInUse := now.Format(basenameFormat)
-
-	var coldLogFileNames []string
-
-	hostDirs, err := os.ReadDir(s.dir)
+// lastSeq returns the sequence number of the last line in f, which must be
+// positioned at the end of the file. This way, sequence numbers keep
+// increasing when a log file is re-opened.
+func lastSeq(f *os.File) (uint64, error) {
+	end, err := f.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	for _, hostDir := range hostDirs {
-		dir := filepath.Join(s.dir, hostDir.Name())
-		logFiles, err := os.ReadDir(dir)
-		if err != nil {
-			return nil, err
-		}
-		logFileNames := make([]string, 0, len(logFiles))
-		for _, logFile := range logFiles {
-			if !strings.HasSuffix(logFile.Name(), ".log") {
-				continue // skip already compressed file
-			}
-			logFileNames = append(logFileNames, filepath.Join(dir, logFile.Name()))
-		}
-		// Exclude all log files that might still be in use
-		cold := logFileNames
-		for _, earliest := range []string{
-			filepath.Join(dir, earliestInUse),
-			filepath.Join(dir, currentlyInUse),
-		} {
-			i, found := sort.Find(len(cold), func(i int) int {
-				return strings.Compare(earliest, cold[i])
-			})
-			if found {
-				cold = cold[:i]
-			}
+	const tailSize = 64 * 1024 // much longer than any line
+	off := end - tailSize
+	if off < 0 {
+		off = 0
+	}
+	tail := make([]byte, end-off)
+	if _, err := f.ReadAt(tail, off); err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(tail), "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if v, ok := logline.Field(lines[i], "seq"); ok {
+			return strconv.ParseUint(v, 10, 64)
 		}
-		coldLogFileNames = append(coldLogFileNames, cold...)
 	}
-	return coldLogFileNames, nil
+	return 0, nil // no line with sequence number yet
 }
 
-func compressFile(fn string) error {
-	src, err := os.Open(fn)
+// compressFile replaces fn with a zstd-compressed copy. If limit is positive,
+// fn is read at no more than limit bytes per second, and compressed on a
+// single core.
+func compressFile(fn string, limit int64) error {
+	// Opened for writing because Windows cannot sync read-only handles.
+	src, err := os.OpenFile(fn, os.O_RDWR, 0)
 	if err != nil {
 		return err
 	}
 	defer src.Close()
-	dst, err := renameio.TempFile("", fn+".zst")
+	// The log file might have been closed without fsync, e.g. when
+	// gokr-syslogd crashed. Make sure we compress what is actually on disk.
+	if err := src.Sync(); err != nil {
+		return err
+	}
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := newPendingFile(fn+".zst", st.Mode().Perm())
 	if err != nil {
 		return err
 	}
 	defer dst.Cleanup()
-	wr, err := zstd.NewWriter(dst)
+	var (
+		r    io.Reader = src
+		opts []zstd.EOption
+	)
+	if limit > 0 {
+		r = newThrottledReader(src, limit)
+		opts = append(opts, zstd.WithEncoderConcurrency(1))
+	}
+	wr, err := zstd.NewWriter(dst, opts...)
 	if err != nil {
 		return err
 	}
-	if _, err := io.Copy(wr, src); err != nil {
+	if _, err := io.Copy(wr, r); err != nil {
 		return err
 	}
 	if err := wr.Close(); err != nil {
@@ -173,28 +493,62 @@ func compressFile(fn string) error {
 	if err := dst.CloseAtomicallyReplace(); err != nil {
 		return err
 	}
+	// Persist the rename before removing the uncompressed file, so that a
+	// crash cannot lose both.
+	if err := syncDir(filepath.Dir(fn)); err != nil {
+		return err
+	}
 	return os.Remove(fn)
 }
 
-func (s *server) compressOldLogs() error {
-	cold, err := s.coldLogFileNames(time.Now())
+// compressOldLogs compresses all log files which are cold at now. Unless urgent
+// is set (to free up disk space), compression stops early while more than
+// s.compressPauseRate messages per second are received, and reads at most
+// s.compressIOLimit bytes per second.
+func (s *server) compressOldLogs(now time.Time, urgent bool) error {
+	cold, err := s.logFileNamesInState(now, stateCold)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // no log files written yet
 		}
 		return err
 	}
-	for _, fn := range cold {
+	for i, fn := range cold {
+		if !urgent && s.compressPauseRate > 0 {
+			if rate := s.ingestRate(); rate > s.compressPauseRate {
+				log.Printf("receiving %.0f messages/s, postponing compression of %d files", rate, len(cold)-i)
+				return nil
+			}
+		}
 		log.Printf("compressing %s to %s.zst", fn, fn)
-		if err := compressFile(fn); err != nil {
+		var size int64
+		if st, err := os.Stat(fn); err == nil {
+			size = st.Size()
+		}
+		var limit int64
+		if !urgent {
+			limit = s.compressIOLimit
+		}
+		if err := compressFile(fn, limit); err != nil {
+			log.Printf("compressing %s: %v", fn, err)
+			continue
+		}
+		if err := s.chown(fn + ".zst"); err != nil {
 			log.Printf("compressing %s: %v", fn, err)
 		}
+		s.updateManifest(fn + ".zst")
+		ev := retentionEvent{Event: retentionCompressed, File: fn + ".zst", Size: size}
+		if st, err := os.Stat(fn + ".zst"); err == nil {
+			ev.CompressedSize = st.Size()
+		}
+		s.notifyRetention(ev)
 	}
 	return nil
 }
 
-func (s *server) deleteOldLogs() error {
-	toDelete, err := s.toDeleteLogFileNames(time.Now())
+// deleteOldLogs deletes all log files which are expired at now.
+func (s *server) deleteOldLogs(now time.Time) error {
+	toDelete, err := s.logFileNamesInState(now, stateExpired)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // no log files written yet
@@ -202,159 +556,1300 @@ func (s *server) deleteOldLogs() error {
 		return err
 	}
 	for _, fn := range toDelete {
-		log.Printf("deleting log file older than 7 days: %s", fn)
-		if err := os.Remove(fn); err != nil {
-			log.Printf("deleting %s: %v", fn, err)
-		}
+		log.Printf("deleting log file older than %d days: %s", s.retentionDays, fn)
+		s.deleteLogFile(fn)
 	}
 	return nil
 }
 
-func gokrsyslogd() error {
-	var (
-		outdir = flag.String("outdir",
-			"/perm/syslogd",
-			"directory to which to write syslog to")
-
-		listenAddr = flag.String("listen",
-			"127.0.0.1:5514",
-			"[host]:port listen address")
-	)
-	flag.Parse()
+// deleteLogFile deletes the log file fn (unless -pre_delete_cmd fails) and
+// reports whether it was deleted.
+func (s *server) deleteLogFile(fn string) bool {
+	var size int64
+	if st, err := os.Stat(fn); err == nil {
+		size = st.Size()
+	}
+	if err := s.preDelete(fn); err != nil {
+		log.Printf("not deleting %s: -pre_delete_cmd failed: %v", fn, err)
+		return false
+	}
+	if err := os.Remove(fn); err != nil {
+		log.Printf("deleting %s: %v", fn, err)
+		return false
+	}
+	s.updateManifest(fn)
+	s.notifyRetention(retentionEvent{Event: retentionDeleted, File: fn, Size: size})
+	return true
+}
 
-	srv := server{
-		dir:   *outdir,
-		files: make(map[fileKey]*openFile),
+// flushDelay returns how much longer buffered lines can stay in memory, given
+// the time of the first and the last buffered write. A flush is due once the
+// write loop was idle for flushIdle, or once the oldest buffered line was
+// written flushMaxDelay ago. This means that lines are flushed promptly for
+// low-traffic deployments, while under load lines are batched into fewer,
+// larger writes.
+func (s *server) flushDelay(firstWrite, lastWrite, now time.Time) time.Duration {
+	delay := lastWrite.Add(s.flushIdle).Sub(now)
+	if maxDelay := firstWrite.Add(s.flushMaxDelay).Sub(now); maxDelay < delay {
+		delay = maxDelay
 	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
 
-	// Start periodic log compression/deletion in the background, not blocking
-	// server startup.
-	go func() {
-		for ; ; time.Sleep(1 * time.Hour) {
-			if err := srv.compressOldLogs(); err != nil {
-				log.Printf("compressing old logs: %v", err)
-			}
-			if err := srv.deleteOldLogs(); err != nil {
-				log.Printf("deleting old logs: %v", err)
+// flushFiles writes the buffered lines of all files to disk. It returns the
+// first error encountered, but tries to flush all files regardless.
+func (s *server) flushFiles() error {
+	if s.mirror != nil {
+		s.flushMirror()
+	}
+	var firstErr error
+	for key, of := range s.files {
+		if err := of.flush(); err != nil {
+			writeErrors.Add(1)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("flushing log file for key=%v: %v", key, err)
 			}
 		}
-	}()
+	}
+	bufferedBytesVar.Set(int64(s.bufferedBytes()))
+	setWriteError(firstErr)
+	return firstErr
+}
 
-	// TODO: how does flow control work? this is a blocking channel, where does
-	// backpressure go?
-	channel := make(syslog.LogPartsChannel)
-	syslogsrv := syslog.NewServer()
-	// RFC3164 seems to be what Go’s standard library log/syslog package uses.
-	// The other two available formats (RFC6587, RFC5424) result in garbage.
-	syslogsrv.SetFormat(syslog.RFC3164)
-	if err := syslogsrv.ListenUDP(*listenAddr); err != nil {
-		return err
+// closeUnusedFiles closes all log files which have not been written to for 10
+// minutes. Files whose buffered lines cannot be flushed remain open.
+func (s *server) closeUnusedFiles(now time.Time) {
+	for key, of := range s.files {
+		if now.Sub(of.lastUse) < 10*time.Minute {
+			continue
+		}
+		if of.buf.Len() > 0 {
+			continue // not yet flushed, e.g. due to a write error
+		}
+		log.Printf("closing unused log file for key=%v", key)
+		s.closeFile(key, of)
 	}
-	syslogsrv.SetHandler(syslog.NewChannelHandler(channel))
-	if err := syslogsrv.Boot(); err != nil {
-		return err
+}
+
+// syncFile makes the lines written to of durable.
+func (s *server) syncFile(key fileKey, of *openFile) {
+	if err := of.f.Sync(); err != nil {
+		selfLog.Printf("sync", "error syncing log file for key=%v: %v", key, err)
+	}
+	of.synced = true
+}
+
+// closeFile syncs and closes of, which must be flushed. The directory is
+// synced as well, in case the file was newly created. Once closed, a file is
+// eligible for compression, which must not operate on data that only exists
+// in the page cache.
+func (s *server) closeFile(key fileKey, of *openFile) {
+	s.syncFile(key, of)
+	if err := of.f.Close(); err != nil {
+		selfLog.Printf("close", "error closing log file: %v", err)
+	}
+	if err := syncDir(filepath.Join(s.dirFor(key), hostDirName(key.hostname))); err != nil {
+		selfLog.Printf("sync", "error syncing log directory: %v", err)
+	}
+	delete(s.files, key)
+}
+
+// syncFinishedFiles syncs the files of hostname for days before basename: once
+// a host’s messages roll over into a new day, the previous day’s file is
+// finished, apart from stragglers.
+func (s *server) syncFinishedFiles(hostname, basename string) {
+	for key, of := range s.files {
+		if !s.hasDay(key) {
+			continue
+		}
+		// Compare only the days, not the routes (see route).
+		if key.hostname != hostname || key.basename[:len("2006-01-02")] >= basename[:len("2006-01-02")] || of.synced {
+			continue
+		}
+		if err := of.flush(); err != nil {
+			continue // will be retried by the run loop
+		}
+		s.syncFile(key, of)
+	}
+}
+
+// triggerRetention requests a compression/deletion pass ahead of schedule,
+// which might free up disk space.
+func (s *server) triggerRetention() {
+	select {
+	case s.retentionNow <- struct{}{}:
+	default:
+		// a pass is already pending
+	}
+}
+
+// stopTimer stops t and drains its channel, so that t can be reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// run writes all messages received on channel to log files, flushes buffered
+// lines in the background (see flushDelay) and closes unused log files once a
+// minute. All access to s.files happens in the run goroutine, including that
+// of other goroutines (see onWriteLoop).
+//
+// Because a single goroutine writes all messages, messages from the same host
+// are written in the order in which they arrived on channel (unless
+// -reorder_window is set, which orders by timestamp instead).
+//
+// When flushing fails (e.g. because the disk is full), lines remain buffered
+// in memory (up to s.bufferLimit) and flushing is retried with exponential
+// backoff. Each failure triggers an early retention pass.
+func (s *server) run(channel chan messageParts) {
+	janitor := time.NewTicker(1 * time.Minute)
+	defer janitor.Stop()
+
+	var lastBeat atomic.Int64
+	lastBeat.Store(time.Now().UnixNano())
+	if s.watchdogTimeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go s.watchdog(s.watchdogTimeout, &lastBeat, done)
 	}
-	log.Printf("writing to %s all remote syslog received on %s", *outdir, *listenAddr)
-
-	// Every 100 syslog messages, look through currently open files to close
-	// unused ones.
-	const closeFrequency = 100
-	stride := closeFrequency
-	go func(channel syslog.LogPartsChannel) {
-		for logParts := range channel {
-			// This is an example logParts value: map[
-			//   client:10.0.0.16:58045
-			//   content:Try `iptables -h' or 'iptables --help' for more information.
-			//   facility:0
-			//   hostname:gokrazy
-			//   priority:6 // gokrazy sends all messages at LOG_INFO
-			//   severity:6
-			//   tag:iptables // gokrazy sends the basename of the binary
-			//   timestamp:2022-08-13 14:41:30 +0200 +0200
-			// tls_peer:]
-			var (
-				hostname  string
-				timestamp time.Time
-				tag       string
-				content   string
-			)
-			if v, ok := logParts["hostname"]; ok {
-				hostname = v.(string)
+
+	flushTimer := time.NewTimer(s.flushIdle)
+	flushTimer.Stop()
+	var (
+		flushC     <-chan time.Time // nil while no lines are buffered
+		firstWrite time.Time
+		lastWrite  time.Time
+		retryDelay time.Duration // non-zero while flushing fails
+		failSince  time.Time     // when flushing started failing
+		reopened   time.Time     // when the files were last reopened
+	)
+	flush := func() error {
+		err := s.flushFiles()
+		if err != nil {
+			now := time.Now()
+			if retryDelay == 0 {
+				failSince = now
+			}
+			if s.watchdogTimeout > 0 &&
+				now.Sub(failSince) > s.watchdogTimeout &&
+				now.Sub(reopened) > s.watchdogTimeout {
+				selfLog.Printf("flush", "writes failing since %v, reopening log files", failSince.Format(time.RFC3339))
+				s.reopenFiles()
+				reopened = now
 			}
-			if v, ok := logParts["content"]; ok {
-				content = v.(string)
+			retryDelay *= 2
+			if retryDelay == 0 {
+				retryDelay = 1 * time.Second
+			} else if retryDelay > 1*time.Minute {
+				retryDelay = 1 * time.Minute
 			}
-			if v, ok := logParts["timestamp"]; ok {
-				timestamp = v.(time.Time)
+			selfLog.Printf("flush", "%v (%d bytes buffered, retrying in %v)", err, s.bufferedBytes(), retryDelay)
+			s.triggerRetention()
+			if flushC != nil {
+				stopTimer(flushTimer) // in case a size-triggered flush failed
+			}
+			flushTimer.Reset(retryDelay)
+			flushC = flushTimer.C
+			return err
+		}
+		retryDelay = 0
+		if flushC != nil {
+			stopTimer(flushTimer)
+			flushC = nil
+		}
+		return nil
+	}
+	write := func(msg message) {
+		if !s.write(msg) {
+			return
+		}
+		s.observeError(msg)
+		s.observeBinding(msg)
+		lastWrite = time.Now()
+		if retryDelay > 0 {
+			return // the retry timer is already armed
+		}
+		if s.bufferedBytes() >= maxBatchSize {
+			flush()
+			return
+		}
+		if flushC == nil {
+			firstWrite = lastWrite
+			flushTimer.Reset(s.flushIdle)
+			flushC = flushTimer.C
+		}
+	}
+
+	reorderTimer := time.NewTimer(s.reorderWindow)
+	reorderTimer.Stop()
+	var (
+		reorderC <-chan time.Time // nil while no messages are queued
+		pending  reorderQueue
+	)
+	accept := func(logParts messageParts) {
+		received, ok := logParts["received"].(time.Time) // set by spool.replay
+		if !ok {
+			received = time.Now()
+		}
+		msg, ok := s.parse(logParts, received)
+		if !ok {
+			return
+		}
+		atomic.AddUint64(&s.accepted, 1)
+		if s.anomalies != nil {
+			s.anomalies.observe(msg)
+		}
+		if s.matrix != nil {
+			s.matrix.observe(msg)
+		}
+		if s.hostMetrics != nil {
+			s.hostMetrics.observe(msg)
+		}
+		if s.reorderWindow == 0 {
+			write(msg)
+			return
+		}
+		heap.Push(&pending, msg)
+		if reorderC == nil {
+			reorderTimer.Reset(s.reorderWindow)
+			reorderC = reorderTimer.C
+		}
+	}
+	for {
+		select {
+		case logParts, ok := <-channel:
+			if !ok {
+				for _, msg := range pending.release(time.Now(), 0) {
+					write(msg)
+				}
+				if err := s.flushFiles(); err != nil {
+					log.Print(err)
+				}
+				for key, of := range s.files {
+					if of.buf.Len() == 0 {
+						s.closeFile(key, of)
+					}
+				}
+				s.writeErrorIndexes()
+				s.writeEntityIndexes()
+				s.writeBindingTables()
+				return
 			}
-			if v, ok := logParts["tag"]; ok {
-				tag = v.(string)
+			accept(logParts)
+
+		case logParts := <-s.ingested:
+			accept(logParts)
+
+		case now := <-reorderC:
+			for _, msg := range pending.release(now, s.reorderWindow) {
+				write(msg)
 			}
-			if hostname == "" ||
-				tag == "" ||
-				content == "" ||
-				timestamp.IsZero() {
+			if pending.Len() == 0 {
+				reorderC = nil
 				continue
 			}
+			reorderTimer.Reset(pending[0].received.Add(s.reorderWindow).Sub(now))
 
-			// Reject too old timestamps to avoid tampering and to make it safe
-			// to compress/rotate old files.
-			if time.Since(timestamp) > 24*time.Hour {
-				if atomic.SwapUint32(&logRateLimited, 1) == 0 {
-					log.Printf("dropping message with timestamp with too large clock drift: timestamp %v", timestamp)
+		case now := <-flushC:
+			flushC = nil // the timer fired
+			if retryDelay == 0 {
+				if delay := s.flushDelay(firstWrite, lastWrite, now); delay > 0 {
+					flushTimer.Reset(delay)
+					flushC = flushTimer.C
+					continue
 				}
-				continue
 			}
+			flush()
 
-			basename := timestamp.Format(basenameFormat)
-			key := fileKey{
-				hostname: hostname,
-				basename: basename,
+		case now := <-janitor.C:
+			s.closeUnusedFiles(now)
+			if s.externalRotation {
+				s.reopenRotatedFiles()
 			}
-			of, ok := srv.files[key]
-			if !ok {
-				f, err := srv.openFile(key)
-				if err != nil {
-					if atomic.SwapUint32(&logRateLimited, 1) == 0 {
-						log.Printf("error opening log file: %v", err)
-					}
-					continue
+			if s.services != nil {
+				if err := s.services.reload(); err != nil {
+					selfLog.Printf("services", "reloading service map: %v", err)
 				}
-				of = &openFile{
-					f: f,
+			}
+			if s.tagFilters != nil {
+				if err := s.tagFilters.reload(); err != nil {
+					selfLog.Printf("tag_filters", "reloading tag filters: %v", err)
 				}
-				srv.files[key] = of
 			}
-			of.lastUse = time.Now()
-			fmt.Fprintf(of.f, "rfc3339=%s %s: %s\n",
-				timestamp.Format(time.RFC3339),
-				tag,
-				content)
-
-			stride--
-			if stride <= 0 {
-				stride = closeFrequency
-				for key, of := range srv.files {
-					if time.Since(of.lastUse) < 10*time.Minute {
-						continue
-					}
-					log.Printf("closing unused log file for key=%v", key)
-					// close old log file
-					if err := of.f.Close(); err != nil {
-						if atomic.SwapUint32(&logRateLimited, 1) == 0 {
-							log.Printf("error opening log file: %v", err)
-						}
-					}
-					delete(srv.files, key)
+			if s.rules != nil {
+				if err := s.rules.reload(); err != nil {
+					selfLog.Printf("rules", "reloading rules: %v", err)
+				}
+			}
+			if s.outputRules != nil {
+				if err := s.outputRules.reload(); err != nil {
+					selfLog.Printf("output_rules", "reloading output rules: %v", err)
 				}
 			}
+			s.writeErrorIndexes()
+			s.writeEntityIndexes()
+			s.writeBindingTables()
+			if s.retired != nil {
+				s.retired.reload()
+			}
+
+		case reply := <-s.flushRequests:
+			reply <- flush()
+
+		case fn := <-s.loopRequests:
+			fn()
+
+		case <-s.ping:
+			lastBeat.Store(time.Now().UnixNano())
+
+		case <-s.hangup:
+			log.Printf("SIGHUP received, closing log files")
+			flush()
+			s.closeFlushedFiles()
 		}
-	}(channel)
+	}
+}
+
+func gokrsyslogd() error {
+	var (
+		outdir = flag.String("outdir",
+			"/perm/syslogd",
+			"directory to which to write syslog to")
 
-	syslogsrv.Wait()
-	log.Printf("srv.Wait() returned, last error: %v", syslogsrv.GetLastError())
+		listenAddr = flag.String("listen",
+			"127.0.0.1:5514",
+			"[host]:port listen address")
 
-	return nil
+		listenTCP = flag.String("listen_tcp",
+			"",
+			"if non-empty, [host]:port TCP listen address for syslog over TCP, with octet-counted or newline-terminated framing (RFC 6587), e.g. for rsyslog with @@host")
+
+		listenTLS = flag.String("listen_tls",
+			"",
+			"if non-empty, [host]:port TCP listen address for syslog over TLS (RFC 5425), e.g. :6514, with the certificate of -tls_cert and -tls_key")
+
+		tlsCert = flag.String("tls_cert",
+			"",
+			"path to the PEM certificate (chain) of -listen_tls, reloaded when it changes")
+
+		tlsKey = flag.String("tls_key",
+			"",
+			"path to the PEM private key of -tls_cert")
+
+		flushIdle = flag.Duration("flush_idle",
+			250*time.Millisecond,
+			"flush buffered log lines to disk once no message arrived for this long")
+
+		flushMaxDelay = flag.Duration("flush_max_delay",
+			5*time.Second,
+			"flush buffered log lines to disk at least this often under sustained load")
+
+		dayRule = flag.String("day_rule",
+			dayRuleEvent,
+			"which day to file messages into: "+dayRuleEvent+" (sender timestamp) or "+dayRuleReceive+" (local receive time)")
+
+		maxFutureSkew = flag.Duration("max_future_skew",
+			24*time.Hour,
+			"how far in the future a message timestamp may be (e.g. due to sender clock drift or time zone confusion), see -future_timestamps. Timestamps older than 24 hours are always rejected. 0 accepts any future timestamp")
+
+		futureAction = flag.String("future_timestamps",
+			futureReject,
+			"what to do with messages whose timestamp is beyond -max_future_skew: "+futureReject+" (with reason future_timestamp) or "+futureClamp+" (store with the receive time as timestamp and the claimed one in a clamped_from= field)")
+
+		rotation = flag.String("rotation",
+			rotationDaily,
+			"how log files are rotated: "+rotationDaily+" (one file per host and day, compressed and deleted by gokr-syslogd) or "+rotationExternal+" (one file per host, rotated by e.g. logrotate: renamed files are detected and SIGHUP closes all files)")
+
+		annotateDay = flag.Bool("annotate_day",
+			false,
+			"mark lines which are filed into a different day than their timestamp with an event_day= field")
+
+		reorderWindow = flag.Duration("reorder_window",
+			0,
+			"hold messages back for this long to write them in timestamp order (0 disables reordering)")
+
+		acceptTagless = flag.Bool("accept_tagless",
+			false,
+			"store messages without a tag using the placeholder tag "+placeholderTag+" instead of dropping them")
+
+		acceptEmpty = flag.Bool("accept_empty",
+			false,
+			"store messages with empty content instead of dropping them")
+
+		annotateReceived = flag.Bool("annotate_received",
+			false,
+			"record the local receive time of each message in a received= field, next to the sender timestamp (useful to debug sender clock drift)")
+
+		storeClient = flag.Bool("store_client",
+			false,
+			"record the source address of each message in a client= field (IPv6 addresses with their zone, e.g. client=fe80::1%eth0), which the client: search term of gokr-syslogweb filters on")
+
+		keepRaw = flag.Bool("keep_raw",
+			false,
+			"for messages whose content contains control characters or invalid UTF-8, store the original content base64-encoded in a raw= field")
+
+		bufferLimit = flag.Int("buffer_limit",
+			8<<20,
+			"how many bytes of log lines to buffer in memory at most, e.g. while the disk is full")
+
+		hostSourcesSpec = flag.String("host_sources",
+			"",
+			"comma-separated list of hostname=address pairs (e.g. router7=10.0.0.1): messages claiming a listed hostname are only trusted from the listed addresses")
+
+		hostAliases = flag.String("host_aliases",
+			"",
+			"comma-separated list of old=new hostname pairs (e.g. raspberrypi=dr) for renamed hosts: messages claiming an old hostname are filed under the new name. Spoofing checks (-host_sources) apply to the hostname the message claims. Use gokr-syslogctl merge-host to merge the old directory.")
+
+		zonesSpec = flag.String("zones",
+			"",
+			"comma-separated list of zone=cidr pairs (e.g. home=10.0.0.0/24,office=10.8.0.0/16): messages from a source address within a zone are stored with a zone= field (the most specific zone wins)")
+
+		learnHostSources = flag.Bool("learn_host_sources",
+			false,
+			"bind each hostname not listed in -host_sources to the first source address seen for it")
+
+		spoofedAction = flag.String("spoofed_action",
+			spoofedFlag,
+			"what to do with messages from an unexpected source address: "+spoofedFlag+" (store with a spoofed_from= field), "+spoofedQuarantine+" (store in -quarantine_dir) or "+spoofedDrop)
+
+		quarantineDir = flag.String("quarantine_dir",
+			"/perm/syslogd-quarantine",
+			"directory to which to write quarantined messages to")
+
+		debugPcap = flag.String("debug_pcap",
+			"",
+			"if non-empty, path of a pcap file to which to append all datagrams which are rejected (e.g. because they cannot be parsed), and, while a capture is active (see /debug/capture on -http_listen), all datagrams from the captured source address")
+
+		messageFormat = flag.String("format",
+			formatAuto,
+			"format of received messages: "+formatAuto+" (detected per message), "+formatRFC3164+" (BSD syslog) or "+formatRFC5424)
+
+		spoolDir = flag.String("spool_dir",
+			"",
+			fmt.Sprintf("if non-empty, datagrams which arrive while %d messages wait to be written are appended unparsed to files in this directory, and parsed and written once gokr-syslogd catches up, so that bursts are limited by disk speed instead of parser speed", spoolQueue))
+
+		quarantineRejected = flag.Bool("quarantine_rejected",
+			false,
+			"instead of only counting rejected messages (e.g. unparseable or too old), write them, along with client address and reason, into per-day files in -quarantine_dir")
+
+		quarantineRetentionDays = flag.Int("quarantine_retention_days",
+			3,
+			"delete files in -quarantine_dir after this many days")
+
+		hmacKeyFile = flag.String("hmac_key_file",
+			"",
+			"path to a file containing a shared secret: messages whose content starts with hmac=<signature> are verified and dropped if the signature is invalid")
+
+		requireHMAC = flag.Bool("require_hmac",
+			false,
+			"drop messages without a valid signature (requires -hmac_key_file)")
+
+		logRateLimit = flag.Duration("log_rate_limit",
+			1*time.Second,
+			"print at most one error message of the same kind per interval; suppressed messages are counted and summarized")
+
+		watchdogTimeout = flag.Duration("watchdog_timeout",
+			1*time.Minute,
+			"exit (to be restarted by the supervisor) when the write loop does not make progress for this long, and reopen the log files when writes fail for this long (0 disables the watchdog)")
+
+		retention = flag.String("retention",
+			strconv.Itoa(defaultRetentionDays)+"d",
+			"how long to keep compressed log files before deleting them: a number of days (e.g. 90 or 90d) or a duration, rounded up to whole days (e.g. 48h)")
+
+		maxDiskUsage = flag.String("max_disk_usage",
+			"",
+			"if non-empty, delete the oldest compressed log files (across all hosts, regardless of -retention) while the log files use more than this, e.g. 2GiB or 500MB")
+
+		retentionInterval = flag.Duration("retention_interval",
+			1*time.Hour,
+			"how often to compress and delete old log files")
+
+		compressWindowSpec = flag.String("compress_window",
+			"",
+			"only compress log files during this local time of day, e.g. 03:00-05:00 (empty means any time). Deletion is not restricted, and compression runs regardless of this window when writes fail.")
+
+		compressPauseRate = flag.Float64("compress_pause_rate",
+			0,
+			"postpone compression while receiving more than this many messages per second (0 disables)")
+
+		compressIOLimit = flag.Int64("compress_io_limit",
+			0,
+			"read log files for compression at no more than this many bytes per second, on a single core (0 disables)")
+
+		dockerTag = flag.String("docker_tag",
+			"",
+			"slash-separated field names for the components of tags sent by Docker’s syslog log driver, e.g. image/container for --log-opt tag={{.ImageName}}/{{.Name}}: lines of tags with as many components are stored with these fields (e.g. container=web-1)")
+
+		correlationID = flag.String("correlation_id",
+			"",
+			`Go regexp with a capture group which extracts a correlation (request, trace) ID from message contents, e.g. "request_id=([0-9a-f-]+)": lines are stored with a correlation_id= field, which gokr-syslogweb’s /trace/<id> gathers across hosts. With several groups, the first one which matched is used`)
+
+		bootSessions = flag.Bool("boot_sessions",
+			false,
+			"detect sender reboots (see -boot_marker, or the kernel uptime going backwards) and store lines with a boot= field identifying the boot session. Boots are listed in <host>/"+bootlog.FileName)
+
+		bootMarker = flag.String("boot_marker",
+			defaultBootMarker,
+			"Go regexp matching a message (tag: content) which senders log when booting (empty disables)")
+
+		servicesPath = flag.String("services",
+			"",
+			"path to a file listing the deployed gokrazy packages, one package[@version] [tag] per line: lines are stored with a service= field for messages of the tag (defaults to the package basename). The file is re-read when it changes.")
+
+		tagFiltersPath = flag.String("tag_filters",
+			"",
+			"path to a file of tag filters, one <host or *> allow|deny <tag>… or <host or *> floor <severity> [<tag>…] rule per line: messages with a denied tag, without an allowed tag if allow rules exist for the host, or less severe than the floor, are dropped before anything is written. The file is re-read when it changes.")
+
+		manifestFlag = flag.Bool("manifest",
+			true,
+			"maintain a per-host list of the size and SHA-256 of each compressed log file in <host>/"+manifest.FileName+", which the integrity verification job (see -verify_interval) and gokr-syslogctl backup check files against")
+
+		errorIndex = flag.Bool("error_index",
+			true,
+			"maintain a per-host index of distinct error messages (severity err or more severe) in <host>/"+errindex.FileName+", recording when each was first and last seen")
+
+		rulesPath = flag.String("rules",
+			"",
+			"path to a file of rules, one per line: drop <condition>, route <route> <condition> (overriding the facility routes of -route) or alert <name> <condition> (logged and counted in syslogd_alerts_total), where conditions are expressions like tag == sshd && content =~ \"Failed password\". The file is re-read when it changes.")
+
+		execFilterCmd = flag.String("exec_filter",
+			"",
+			"if non-empty, a command (split at whitespace) through which every accepted message is passed: it reads one JSON message per line from stdin and writes the message (possibly modified) or null (to drop it) as one line to stdout. Messages are kept unchanged when the command fails")
+
+		execFilterWorkers = flag.Int("exec_filter_workers",
+			1,
+			"number of -exec_filter processes to run concurrently, shared by the write loops of all tenants")
+
+		execFilterTimeout = flag.Duration("exec_filter_timeout",
+			1*time.Second,
+			"how long to wait for an -exec_filter process to respond before restarting it")
+
+		entityIndex = flag.Bool("entity_index",
+			false,
+			"maintain a per-host index of the IP and MAC addresses mentioned in messages in <host>/"+entityindex.FileName+", with the lines mentioning them, which gokr-syslogweb’s /entity/<address> uses")
+
+		learnBindings = flag.Bool("learn_bindings",
+			false,
+			"learn which hostname held which IP address when from the messages of DHCP and DNS servers (dnsmasq, ISC dhcpd), in <host>/"+bindings.FileName+", with which gokr-syslogweb annotates addresses in results (names=1)")
+
+		webhookIngest = flag.Bool("webhook_ingest",
+			false,
+			"accept JSON payloads POSTed to "+webhookPrefix+"<source> of -http_listen (e.g. by GitHub or Shelly devices) as messages of -webhook_host with tag <source>, flattened into key=value pairs")
+
+		webhookHost = flag.String("webhook_host",
+			"webhooks",
+			"hostname under which -webhook_ingest stores the ingested messages")
+
+		webhookTokenFile = flag.String("webhook_token_file",
+			"",
+			"path to a file containing a token which -webhook_ingest and -alertmanager_ingest requests need to carry in the token= parameter or as bearer token")
+
+		adminTokenFile = flag.String("admin_token_file",
+			"",
+			"path to a file containing a token which requests to the admin endpoints of -http_listen (/annotate, /rotate, /debug/capture, POST or DELETE /holds) need to carry in the token= parameter or as bearer token")
+
+		alertmanagerIngest = flag.Bool("alertmanager_ingest",
+			false,
+			"accept Alertmanager webhook notifications POSTed to "+alertmanagerPath+" of -http_listen and store each alert which started firing or was resolved as a message of -alertmanager_host with tag "+alertmanagerTag+" (requests need to carry the token of -webhook_token_file, if set)")
+
+		alertmanagerHost = flag.String("alertmanager_host",
+			"_alerts",
+			"hostname under which -alertmanager_ingest stores alerts")
+
+		retentionDryRun = flag.Bool("retention_dry_run",
+			false,
+			"print which log files the next retention pass would compress, filter and delete with the given flags (and how much space that reclaims), then exit without changing anything. The /retention endpoint of -http_listen reports the same for the running configuration")
+
+		preDeleteCmd = flag.String("pre_delete_cmd",
+			"",
+			"if non-empty, a command (split at whitespace) which is run with the log file name as last argument before retention deletes the file. The file is kept (and the command retried with the next retention run) if the command fails, e.g. to guarantee that files were archived elsewhere")
+
+		retentionWebhook = flag.String("retention_webhook",
+			"",
+			"comma-separated list of URLs to POST a JSON event to whenever a log file was compressed or deleted, e.g. for inventory or backup systems")
+
+		webhookTemplatePath = flag.String("webhook_template",
+			"",
+			"if non-empty, a file of Go text/template definitions (body, and optionally method, url and \"header <name>\") from which the requests to -retention_webhook URLs are built instead of posting the JSON event, e.g. for PagerDuty or Gotify")
+
+		outputRulesPath = flag.String("output_rules",
+			"",
+			"if non-empty, a file of rules scoping the outputs (-stdout, -retention_webhook URLs) to messages by host=, tag= and sev>=, one output and its conditions per line, e.g. \"stdout host=router7\". Outputs without rules receive everything. Re-read when it changes.")
+
+		outputSpoolDir = flag.String("output_spool_dir",
+			"",
+			"if non-empty, a directory in which to queue everything sent to external outputs (-retention_webhook) until it was delivered, retrying with exponential backoff while an output fails, so that outages and restarts do not lose events")
+
+		outputSpoolMaxBytes = flag.Int64("output_spool_max_bytes",
+			64<<20,
+			"how many bytes to queue in -output_spool_dir per output at most; further events are dropped (dropped_messages reason output_spool_full) until the output recovers")
+
+		storeSeverity = flag.Bool("store_severity",
+			false,
+			"store lines with a severity= field, e.g. for sev>= queries of gokr-syslogweb (implied by -severity_retention)")
+
+		severityRetention = flag.String("severity_retention",
+			"",
+			"comma-separated list of severity=days pairs, e.g. warning=90,err=365: lines of that severity or more severe are kept for that many days. Compressed files are rewritten as their lines expire. Lines are stored with a severity= field.")
+
+		rollups = flag.Bool("rollups",
+			false,
+			"write per-host rollup files (<host>/"+rollup.DirName+"/<day>.json) with hourly message and error counts per tag for each day, which outlive the log files for long-term trends")
+
+		rollupRetentionDays = flag.Int("rollup_retention_days",
+			3650,
+			"how many days to keep rollup files for (0 keeps them forever)")
+
+		verifyInterval = flag.Duration("verify_interval",
+			24*time.Hour,
+			"re-read all compressed log files once per interval to detect corruption (0 disables verification)")
+
+		anomalyWindow = flag.Duration("anomaly_window",
+			10*time.Minute,
+			"compare the number of messages per host and tag in each window against their baseline and report spikes and silence (0 disables anomaly detection)")
+
+		anomalySpikeFactor = flag.Float64("anomaly_spike_factor",
+			10,
+			"report a spike when a host and tag sends this many times more messages (or error messages) than its baseline")
+
+		readOnly = flag.Bool("read_only",
+			false,
+			"do not accept messages and do not compress or delete files, e.g. when -outdir is a copy of the log tree. Without -read_only, gokr-syslogd exits if -outdir is not writable.")
+
+		checkLogTree = flag.Bool("check_tree",
+			true,
+			"on startup, check -outdir for log files which exist both compressed and uncompressed, empty log files, log files dated in the future and temporary files left behind by a crash, and repair them (in -read_only mode: only report them)")
+
+		fileModeSpec = flag.String("file_mode",
+			"0644",
+			"permissions of newly created log files (see also the mode= key of -route)")
+
+		dirModeSpec = flag.String("dir_mode",
+			"0755",
+			"permissions of newly created directories")
+
+		ownerSpec = flag.String("owner",
+			"",
+			"user[:group] (names or numeric IDs) to chown newly created log files and directories to, e.g. syslog:adm (requires running as root)")
+
+		mirrorStdout = flag.Bool("stdout",
+			false,
+			"mirror all written lines, prefixed with the hostname, to stdout, e.g. for docker logs or kubectl logs when running in a container. The log files remain the durable store.")
+
+		runAsUser = flag.String("user",
+			"",
+			"user (name or numeric ID) to switch to after binding the listen addresses, e.g. to bind port 514 as root and then run unprivileged. Also the default for -owner.")
+
+		runAsGroup = flag.String("group",
+			"",
+			"group (name or numeric ID) to switch to after binding the listen addresses (defaults to the primary group of -user)")
+
+		httpListen = flag.String("http_listen",
+			"",
+			"[host]:port listen address for the HTTP server serving /health, /metrics, /debug/vars and the admin endpoints like /flush (empty disables the HTTP server)")
+
+		mdnsAdvertise = flag.Bool("mdns",
+			false,
+			"advertise the -listen port on the local network via mDNS/DNS-SD (as _syslog._udp), so that senders started with -target=mdns find gokr-syslogd. Requires a -listen address which is reachable from the network, e.g. :514")
+
+		senderAddress = flag.String("sender_address",
+			"",
+			"host:port which senders are told to send to in the sender configuration served at "+senderconfig.Path+" on -http_listen (empty means the host name the configuration was requested from, with the -listen port)")
+	)
+	var routes routeFlag
+	flag.Var(&routes, "route",
+		"send messages of the specified facilities into dedicated files, e.g. auth,facilities=auth+authpriv[,mode=0600][,retention_days=90] writes them into <host>/<day>.auth.log. Can be specified multiple times.")
+
+	var tenants tenantFlag
+	flag.Var(&tenants, "tenant",
+		"additional tenant, which receives messages on its own listen address and stores them in its own directories, e.g. friend,listen=:5515[,outdir=/perm/syslogd-friend][,quarantine_dir=/perm/syslogd-quarantine-friend][,retention_days=14]. Can be specified multiple times.")
+	flag.Parse()
+
+	selfLog.interval = *logRateLimit
+	go selfLog.summarizeLoop()
+
+	if *rotation != rotationDaily && *rotation != rotationExternal {
+		return fmt.Errorf("invalid -rotation=%q: expected one of %s or %s", *rotation, rotationDaily, rotationExternal)
+	}
+	if *dayRule != dayRuleEvent && *dayRule != dayRuleReceive {
+		return fmt.Errorf("invalid -day_rule=%q: expected one of %s or %s", *dayRule, dayRuleEvent, dayRuleReceive)
+	}
+
+	if *messageFormat != formatAuto && *messageFormat != formatRFC3164 && *messageFormat != formatRFC5424 {
+		return fmt.Errorf("invalid -format=%q: expected one of %s, %s or %s", *messageFormat, formatAuto, formatRFC3164, formatRFC5424)
+	}
+	onlyFormat := *messageFormat
+	if onlyFormat == formatAuto {
+		onlyFormat = ""
+	}
+
+	if *futureAction != futureReject && *futureAction != futureClamp {
+		return fmt.Errorf("invalid -future_timestamps=%q: expected one of %s or %s", *futureAction, futureReject, futureClamp)
+	}
+	switch *spoofedAction {
+	case spoofedFlag, spoofedQuarantine, spoofedDrop:
+	default:
+		return fmt.Errorf("invalid -spoofed_action=%q: expected one of %s, %s or %s", *spoofedAction, spoofedFlag, spoofedQuarantine, spoofedDrop)
+	}
+	// Each tenant gets its own hostSources, so that learned bindings are not
+	// shared.
+	newHostSources := func() (*hostSources, error) {
+		if *hostSourcesSpec == "" && !*learnHostSources {
+			return nil, nil
+		}
+		allowed, err := parseHostSources(*hostSourcesSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -host_sources: %v", err)
+		}
+		return &hostSources{
+			learn:   *learnHostSources,
+			allowed: allowed,
+		}, nil
+	}
+	hs, err := newHostSources()
+	if err != nil {
+		return err
+	}
+	fileMode, err := parseMode(*fileModeSpec)
+	if err != nil {
+		return fmt.Errorf("-file_mode: %v", err)
+	}
+	dirMode, err := parseMode(*dirModeSpec)
+	if err != nil {
+		return fmt.Errorf("-dir_mode: %v", err)
+	}
+	var fileOwner *owner
+	if *ownerSpec != "" {
+		if fileOwner, err = parseOwner(*ownerSpec); err != nil {
+			return fmt.Errorf("-owner: %v", err)
+		}
+	}
+	var privileges *owner
+	if *runAsUser != "" {
+		spec := *runAsUser
+		if *runAsGroup != "" {
+			spec += ":" + *runAsGroup
+		}
+		if privileges, err = parseOwner(spec); err != nil {
+			return fmt.Errorf("-user/-group: %v", err)
+		}
+		if privileges.gid == -1 {
+			return fmt.Errorf("-user=%s is numeric, -group must be specified as well", *runAsUser)
+		}
+		if fileOwner == nil {
+			// Directories created before dropping privileges (e.g. -outdir)
+			// must be writable afterwards.
+			fileOwner = privileges
+		}
+	} else if *runAsGroup != "" {
+		return fmt.Errorf("-group requires -user")
+	}
+	var compressWindow *timeWindow
+	if *compressWindowSpec != "" {
+		if compressWindow, err = parseTimeWindow(*compressWindowSpec); err != nil {
+			return fmt.Errorf("-compress_window: %v", err)
+		}
+	}
+	retentionDays, err := parseRetention(*retention)
+	if err != nil {
+		return fmt.Errorf("invalid -retention=%q: %v", *retention, err)
+	}
+	var maxDiskUsageBytes int64
+	if *maxDiskUsage != "" {
+		if maxDiskUsageBytes, err = parseByteSize(*maxDiskUsage); err != nil {
+			return fmt.Errorf("invalid -max_disk_usage=%q: %v", *maxDiskUsage, err)
+		}
+	}
+	if *retentionInterval <= 0 {
+		return fmt.Errorf("-retention_interval must be positive")
+	}
+	dockerTagFields, err := parseDockerTag(*dockerTag)
+	if err != nil {
+		return fmt.Errorf("-docker_tag: %v", err)
+	}
+	severityTiers, err := parseSeverityTiers(*severityRetention)
+	if err != nil {
+		return fmt.Errorf("-severity_retention: %v", err)
+	}
+	zones, err := parseZones(*zonesSpec)
+	if err != nil {
+		return fmt.Errorf("invalid -zones: %v", err)
+	}
+	aliases, err := hostalias.Parse(*hostAliases)
+	if err != nil {
+		return fmt.Errorf("invalid -host_aliases: %v", err)
+	}
+
+	var hmacKey []byte
+	if *hmacKeyFile != "" {
+		b, err := os.ReadFile(*hmacKeyFile)
+		if err != nil {
+			return err
+		}
+		hmacKey = bytes.TrimSpace(b)
+		if len(hmacKey) == 0 {
+			return fmt.Errorf("-hmac_key_file=%s is empty", *hmacKeyFile)
+		}
+	}
+	var webhookToken string
+	if *webhookTokenFile != "" {
+		b, err := os.ReadFile(*webhookTokenFile)
+		if err != nil {
+			return err
+		}
+		webhookToken = string(bytes.TrimSpace(b))
+		if webhookToken == "" {
+			return fmt.Errorf("-webhook_token_file=%s is empty", *webhookTokenFile)
+		}
+	}
+	var adminToken string
+	if *adminTokenFile != "" {
+		b, err := os.ReadFile(*adminTokenFile)
+		if err != nil {
+			return err
+		}
+		adminToken = string(bytes.TrimSpace(b))
+		if adminToken == "" {
+			return fmt.Errorf("-admin_token_file=%s is empty", *adminTokenFile)
+		}
+	}
+	if *webhookIngest && *httpListen == "" {
+		return fmt.Errorf("-webhook_ingest requires -http_listen")
+	}
+	if *webhookIngest && !validHostname(*webhookHost) {
+		return fmt.Errorf("-webhook_host=%q cannot be used as a directory name", *webhookHost)
+	}
+	if *alertmanagerIngest && *httpListen == "" {
+		return fmt.Errorf("-alertmanager_ingest requires -http_listen")
+	}
+	if *alertmanagerIngest && !validHostname(*alertmanagerHost) {
+		return fmt.Errorf("-alertmanager_host=%q cannot be used as a directory name", *alertmanagerHost)
+	}
+	if *requireHMAC && hmacKey == nil {
+		return fmt.Errorf("-require_hmac requires -hmac_key_file")
+	}
+	mainStreams := streamListeners{tcpAddr: *listenTCP, tlsAddr: *listenTLS}
+	if *listenTLS != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			return fmt.Errorf("-listen_tls requires -tls_cert and -tls_key")
+		}
+		certs, err := newCertLoader(*tlsCert, *tlsKey)
+		if err != nil {
+			return fmt.Errorf("-tls_cert: %v", err)
+		}
+		mainStreams.tlsConfig = certs.tlsConfig()
+	}
+	if *anomalySpikeFactor <= 1 {
+		return fmt.Errorf("-anomaly_spike_factor must be larger than 1")
+	}
+
+	srv := &server{
+		dir:                     *outdir,
+		files:                   make(map[fileKey]*openFile),
+		flushIdle:               *flushIdle,
+		flushMaxDelay:           *flushMaxDelay,
+		dayRule:                 *dayRule,
+		maxFutureSkew:           *maxFutureSkew,
+		futureAction:            *futureAction,
+		annotateDay:             *annotateDay,
+		externalRotation:        *rotation == rotationExternal,
+		reorderWindow:           *reorderWindow,
+		acceptTagless:           *acceptTagless,
+		acceptEmpty:             *acceptEmpty,
+		annotateReceived:        *annotateReceived,
+		storeClient:             *storeClient,
+		keepRaw:                 *keepRaw,
+		bufferLimit:             *bufferLimit,
+		hostSources:             hs,
+		zones:                   zones,
+		hostAliases:             aliases,
+		routes:                  routes,
+		fileMode:                fileMode,
+		dirMode:                 dirMode,
+		owner:                   fileOwner,
+		spoofedAction:           *spoofedAction,
+		quarantineDir:           *quarantineDir,
+		spoolDir:                *spoolDir,
+		format:                  onlyFormat,
+		quarantineRejected:      *quarantineRejected,
+		quarantineRetentionDays: *quarantineRetentionDays,
+		hmacKey:                 hmacKey,
+		requireHMAC:             *requireHMAC,
+		flushRequests:           make(chan chan error),
+		loopRequests:            make(chan func()),
+		retentionNow:            make(chan struct{}, 1),
+		retentionDays:           retentionDays,
+		maxDiskUsage:            maxDiskUsageBytes,
+		watchdogTimeout:         *watchdogTimeout,
+		retentionInterval:       *retentionInterval,
+		compressWindow:          compressWindow,
+		compressPauseRate:       *compressPauseRate,
+		compressIOLimit:         *compressIOLimit,
+		severityTiers:           severityTiers,
+		storeSeverity:           *storeSeverity,
+		rollups:                 *rollups,
+		rollupRetentionDays:     *rollupRetentionDays,
+		manifest:                *manifestFlag,
+		preDeleteCmd:            *preDeleteCmd,
+		retentionWebhooks:       parseWebhooks(*retentionWebhook),
+		dockerTag:               dockerTagFields,
+		ping:                    make(chan struct{}, 1),
+		hangup:                  make(chan struct{}, 1),
+	}
+	if *mirrorStdout {
+		srv.mirror = os.Stdout
+	}
+	if *webhookTemplatePath != "" {
+		srv.webhookTemplate, err = parseWebhookTemplate(*webhookTemplatePath)
+		if err != nil {
+			return fmt.Errorf("-webhook_template: %v", err)
+		}
+	}
+	if *outputSpoolDir != "" {
+		for _, u := range srv.retentionWebhooks {
+			u := u // copy
+			o, err := newOutbox(*outputSpoolDir, u, *outputSpoolMaxBytes, func(b []byte) error {
+				return postWebhook(srv.webhookTemplate, u, b)
+			})
+			if err != nil {
+				return fmt.Errorf("-output_spool_dir: %v", err)
+			}
+			go o.run(context.Background())
+			srv.webhookOutboxes = append(srv.webhookOutboxes, o)
+		}
+	}
+	if *correlationID != "" {
+		srv.correlationID, err = compileCorrelationID(*correlationID)
+		if err != nil {
+			return fmt.Errorf("-correlation_id: %v", err)
+		}
+	}
+	if *bootSessions {
+		srv.boots = make(map[string]*bootState)
+		if *bootMarker != "" {
+			srv.bootMarker, err = regexp.Compile(*bootMarker)
+			if err != nil {
+				return fmt.Errorf("-boot_marker: %v", err)
+			}
+		}
+	}
+	if *servicesPath != "" {
+		srv.services, err = newServiceMap(*servicesPath)
+		if err != nil {
+			return fmt.Errorf("-services: %v", err)
+		}
+	}
+	if *outputRulesPath != "" {
+		outputs := append([]string{outputStdout}, srv.retentionWebhooks...)
+		srv.outputRules, err = newOutputRules(*outputRulesPath, outputs)
+		if err != nil {
+			return fmt.Errorf("-output_rules: %v", err)
+		}
+	}
+	if *tagFiltersPath != "" {
+		srv.tagFilters, err = newTagFilters(*tagFiltersPath)
+		if err != nil {
+			return fmt.Errorf("-tag_filters: %v", err)
+		}
+	}
+	if *rulesPath != "" {
+		srv.rules, err = newRules(*rulesPath, routes)
+		if err != nil {
+			return fmt.Errorf("-rules: %v", err)
+		}
+	}
+	if *execFilterCmd != "" {
+		srv.execFilter, err = newExecFilter(*execFilterCmd, *execFilterWorkers, *execFilterTimeout)
+		if err != nil {
+			return fmt.Errorf("-exec_filter: %v", err)
+		}
+	}
+	if *errorIndex {
+		srv.errorIndexes = make(map[string]*errindex.Index)
+	}
+	if *entityIndex {
+		srv.entityIndexes = make(map[string]*entityindex.Index)
+	}
+	if *learnBindings {
+		srv.bindingTables = make(map[string]*bindings.Table)
+	}
+	if *httpListen != "" {
+		// for -webhook_ingest, -alertmanager_ingest and /annotate
+		srv.ingested = make(chan messageParts)
+	}
+	if *anomalyWindow > 0 {
+		srv.anomalies = newAnomalyDetector(*anomalyWindow, *anomalySpikeFactor)
+	}
+	if *httpListen != "" {
+		srv.matrix = newMatrix()
+		srv.hostMetrics = newHostMetrics()
+	}
+	if *debugPcap != "" {
+		srv.pcap, err = newPcapWriter(*debugPcap)
+		if err != nil {
+			return fmt.Errorf("-debug_pcap: %v", err)
+		}
+	}
+	servers := []*server{srv}
+	serversByTenant := map[string]*server{"": srv}
+	listenAddrs := []string{*listenAddr}
+	for _, t := range tenants {
+		t = t.resolve(*outdir, *quarantineDir, srv.retentionDays)
+		hs, err := newHostSources()
+		if err != nil {
+			return err
+		}
+		ts := srv.forTenant(t, hs)
+		servers = append(servers, ts)
+		serversByTenant[t.name] = ts
+		listenAddrs = append(listenAddrs, t.listen)
+	}
+	for _, s := range servers {
+		if s.holds, err = newLegalHolds(s.dir); err != nil {
+			return err
+		}
+		s.retired = newRetiredHosts(s.dir)
+	}
+	if *retentionDryRun {
+		for _, s := range servers {
+			if err := s.writeRetentionPlan(os.Stdout, time.Now()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if srv.hostMetrics != nil {
+		for _, s := range servers {
+			if err := s.hostMetrics.seed(s.dir); err != nil && !os.IsNotExist(err) {
+				log.Printf("seeding per-host metrics from %s: %v", s.dir, err)
+			}
+		}
+	}
+
+	if *httpListen != "" {
+		ln, err := net.Listen("tcp", *httpListen)
+		if err != nil {
+			return err
+		}
+		http.HandleFunc("/health", healthHandler)
+		http.HandleFunc("/metrics", metricsHandler(servers))
+		if srv.anomalies != nil {
+			http.HandleFunc("/anomalies", srv.anomalies.anomaliesHandler)
+		}
+		http.HandleFunc("/matrix", srv.matrix.pageHandler)
+		http.HandleFunc("/matrix/events", matrixEventsHandler(serversByTenant))
+		http.HandleFunc("/flush", flushHandler(servers))
+		http.HandleFunc("/rotate", rotateHandler(serversByTenant, adminToken))
+		http.HandleFunc("/holds", holdsHandler(serversByTenant, adminToken))
+		http.HandleFunc("/annotate", annotateHandler(serversByTenant, adminToken))
+		http.HandleFunc("/retention", retentionPlanHandler(serversByTenant))
+		http.HandleFunc("/hosts", hostStatsHandler(serversByTenant))
+		http.HandleFunc("/hosts/", hostStatsHandler(serversByTenant))
+		http.HandleFunc("/debug/capture", captureHandler(srv.pcap, adminToken))
+		http.HandleFunc("/parse_failures", parseFailuresHandler)
+		http.HandleFunc(senderconfig.Path, senderConfigHandler(*listenAddr, *senderAddress, *requireHMAC))
+		if *webhookIngest {
+			http.HandleFunc(webhookPrefix, ingestHandler(serversByTenant, *webhookHost, webhookToken))
+		}
+		if *alertmanagerIngest {
+			http.HandleFunc(alertmanagerPath, alertmanagerHandler(serversByTenant, *alertmanagerHost, webhookToken))
+		}
+		go func() {
+			log.Printf("serving HTTP on %s", ln.Addr())
+			if err := http.Serve(ln, nil); err != nil {
+				log.Printf("serving HTTP: %v", err)
+			}
+		}()
+	}
+
+	for _, s := range servers {
+		if *readOnly {
+			break
+		}
+		// Fail instead of serving read-only, so that the supervisor retries,
+		// e.g. while the file system is not mounted yet.
+		if err := s.mkdirAll(s.dir); err != nil {
+			return fmt.Errorf("%s cannot be created (pass -read_only to serve a read-only copy): %v", s.dir, err)
+		}
+		if s.quarantineRejected || s.spoofedAction == spoofedQuarantine {
+			// Created lazily otherwise, possibly after dropping privileges.
+			if err := s.mkdirAll(s.quarantineDir); err != nil {
+				log.Printf("creating %s: %v", s.quarantineDir, err)
+			}
+		}
+	}
+	if *readOnly {
+		setReadOnly()
+		log.Printf("read-only mode: not accepting messages, not compressing or deleting log files")
+		if privileges != nil {
+			if err := dropPrivileges(privileges); err != nil {
+				return fmt.Errorf("dropping privileges: %v", err)
+			}
+		}
+		if *checkLogTree {
+			for _, s := range servers {
+				s.logTreeProblems(time.Now(), false)
+			}
+		}
+		// Keep running (instead of failing and being restarted over and
+		// over), serving HTTP if enabled.
+		select {}
+	}
+
+	// Bind all listeners before dropping privileges, so that privileged
+	// ports like 514 can be used.
+	syslogsrvs := make([]*syslogServer, len(servers))
+	channels := make([]chan messageParts, len(servers))
+	for i, s := range servers {
+		var streams streamListeners
+		if i == 0 {
+			streams = mainStreams
+		}
+		syslogsrvs[i], channels[i], err = s.listen(listenAddrs[i], streams)
+		if err != nil {
+			return err
+		}
+	}
+	if privileges != nil {
+		if err := dropPrivileges(privileges); err != nil {
+			return fmt.Errorf("dropping privileges: %v", err)
+		}
+		log.Printf("dropped privileges to uid %d, gid %d", privileges.uid, privileges.gid)
+	}
+	// Checked as the user which writes the files: a directory owned by root
+	// passes the check before dropping privileges, but every write would fail
+	// afterwards.
+	for _, s := range servers {
+		if err := checkWritable(s.dir); err != nil {
+			return fmt.Errorf("%s is not writable by the user of gokr-syslogd (see -user; pass -read_only to serve a read-only copy): %v", s.dir, err)
+		}
+	}
+	if *checkLogTree {
+		for _, s := range servers {
+			s.logTreeProblems(time.Now(), true)
+		}
+	}
+
+	if *mdnsAdvertise {
+		if err := advertise(*listenAddr); err != nil {
+			return fmt.Errorf("-mdns: %v", err)
+		}
+	}
+	if srv.anomalies != nil {
+		go srv.anomalies.loop()
+	}
+	if srv.externalRotation {
+		notifyHangup(servers)
+	}
+	for i, s := range servers {
+		s.start(channels[i], *verifyInterval)
+	}
+	for _, syslogsrv := range syslogsrvs[1:] {
+		syslogsrv := syslogsrv // copy
+		go func() {
+			syslogsrv.wait()
+			log.Printf("tenant server wait() returned, last error: %v", syslogsrv.lastError())
+		}()
+	}
+	syslogsrv := syslogsrvs[0]
+	syslogsrv.wait()
+	log.Printf("srv.wait() returned, last error: %v", syslogsrv.lastError())
+
+	return nil
+}
+
+// advertise advertises listenAddr via mDNS in the background.
+func advertise(listenAddr string) error {
+	_, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := mdns.Advertise(context.Background(), hostname, []mdns.Service{{
+			Instance: "gokr-syslogd on " + hostname,
+			Service:  mdns.SyslogService,
+			Port:     port,
+		}})
+		log.Printf("mDNS advertisement stopped: %v", err)
+	}()
+	return nil
+}
+
+// listen starts a syslog server listening on UDP listenAddr (and on streams),
+// which passes the received messages to the returned channel.
+func (s *server) listen(listenAddr string, streams streamListeners) (*syslogServer, chan messageParts, error) {
+	// TODO: how does flow control work? this is a blocking channel, where does
+	// backpressure go?
+	channel := make(chan messageParts)
+	var f messageFormat = rawFormat{only: s.format}
+	handler := func(logParts messageParts) { channel <- logParts }
+	if s.spoolDir != "" {
+		if err := s.mkdirAll(s.spoolDir); err != nil {
+			return nil, nil, err
+		}
+		channel = make(chan messageParts, spoolQueue)
+		sp := newSpool(s.spoolDir, channel, rawFormat{only: s.format})
+		f = spoolFormat{messageFormat: f, spool: sp}
+		handler = sp.handle
+		go sp.catchUp()
+	}
+	syslogsrv := newSyslogServer(f, handler)
+	if err := syslogsrv.listenUDP(listenAddr); err != nil {
+		return nil, nil, err
+	}
+	if _, port, err := net.SplitHostPort(listenAddr); err == nil {
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			s.listenPort = uint16(p)
+		}
+	}
+	if streams.tcpAddr != "" {
+		if err := syslogsrv.listenTCP(streams.tcpAddr); err != nil {
+			return nil, nil, err
+		}
+	}
+	if streams.tlsAddr != "" {
+		if err := syslogsrv.listenTLS(streams.tlsAddr, streams.tlsConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	syslogsrv.serve()
+	log.Printf("writing to %s all remote syslog received on %s", s.dir, listenAddr)
+	if streams.tcpAddr != "" {
+		log.Printf("writing to %s all remote syslog received on TCP %s", s.dir, streams.tcpAddr)
+	}
+	if streams.tlsAddr != "" {
+		log.Printf("writing to %s all remote syslog received on TLS %s", s.dir, streams.tlsAddr)
+	}
+	return syslogsrv, channel, nil
+}
+
+// start starts the background jobs of s (retention and verification) and the
+// write loop, which reads messages from channel.
+func (s *server) start(channel chan messageParts, verifyInterval time.Duration) {
+	// Start periodic log compression/deletion in the background, not blocking
+	// server startup.
+	go s.retentionLoop()
+
+	if verifyInterval > 0 {
+		go s.verifyLoop(verifyInterval)
+	}
+
+	go s.run(channel)
 }
 
 func main() {
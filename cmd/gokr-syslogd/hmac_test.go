@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestVerifyHMAC(t *testing.T) {
+	key := []byte("secret")
+	sig := signHMAC(key, "dr", "dhcpd", "DHCPDISCOVER")
+	for _, tt := range []struct {
+		desc        string
+		msg         message
+		wantSigned  bool
+		wantValid   bool
+		wantContent string
+	}{
+		{
+			desc:        "unsigned",
+			msg:         message{hostname: "dr", tag: "dhcpd", content: "DHCPDISCOVER"},
+			wantContent: "DHCPDISCOVER",
+		},
+		{
+			desc:        "valid",
+			msg:         message{hostname: "dr", tag: "dhcpd", content: "hmac=" + sig + " DHCPDISCOVER"},
+			wantSigned:  true,
+			wantValid:   true,
+			wantContent: "DHCPDISCOVER",
+		},
+		{
+			desc:        "forged hostname",
+			msg:         message{hostname: "router7", tag: "dhcpd", content: "hmac=" + sig + " DHCPDISCOVER"},
+			wantSigned:  true,
+			wantContent: "hmac=" + sig + " DHCPDISCOVER",
+		},
+		{
+			desc:        "modified content",
+			msg:         message{hostname: "dr", tag: "dhcpd", content: "hmac=" + sig + " DHCPOFFER"},
+			wantSigned:  true,
+			wantContent: "hmac=" + sig + " DHCPOFFER",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			msg := tt.msg
+			signed, valid := verifyHMAC(key, &msg)
+			if signed != tt.wantSigned || valid != tt.wantValid {
+				t.Errorf("verifyHMAC() = %v, %v, want %v, %v", signed, valid, tt.wantSigned, tt.wantValid)
+			}
+			if msg.content != tt.wantContent {
+				t.Errorf("content = %q, want %q", msg.content, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// holdsFileName is the name of the file in which legal holds are stored,
+// within the log directory (hidden, so that it is not taken for a host).
+const holdsFileName = ".holds.json"
+
+const holdDayFormat = "2006-01-02"
+
+// hold exempts the log files of a host (or of all hosts) for a range of days
+// from deletion and from -severity_retention, until it is released.
+type hold struct {
+	ID string `json:"id"`
+	// Host is the host directory, or empty for all hosts.
+	Host string `json:"host,omitempty"`
+	// From and Until are the first and last day (inclusive) in the
+	// 2006-01-02 format. Empty means unbounded.
+	From    string    `json:"from,omitempty"`
+	Until   string    `json:"until,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+func (h hold) covers(hostDir string, day time.Time) bool {
+	if h.Host != "" && h.Host != hostDir {
+		return false
+	}
+	d := day.Format(holdDayFormat)
+	return (h.From == "" || d >= h.From) && (h.Until == "" || d <= h.Until)
+}
+
+// legalHolds are the holds of a log directory, which are placed and released
+// via the /holds admin endpoint.
+type legalHolds struct {
+	path string
+
+	mu    sync.RWMutex
+	holds []hold
+}
+
+func newLegalHolds(dir string) (*legalHolds, error) {
+	l := &legalHolds{path: filepath.Join(dir, holdsFileName)}
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &l.holds); err != nil {
+		return nil, fmt.Errorf("%s: %v", l.path, err)
+	}
+	return l, nil
+}
+
+// held reports whether a hold covers the log files of hostDir for day. The
+// nil *legalHolds holds nothing.
+func (l *legalHolds) held(hostDir string, day time.Time) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, h := range l.holds {
+		if h.covers(hostDir, day) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *legalHolds) list() []hold {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]hold{}, l.holds...)
+}
+
+// save writes holds to disk (atomically) before l uses them, so that holds
+// are never lost to a crash. l.mu must be held.
+func (l *legalHolds) save(holds []hold) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(holds, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := newPendingFile(l.path, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	l.holds = holds
+	return nil
+}
+
+func (l *legalHolds) place(h hold) (hold, error) {
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return hold{}, err
+	}
+	h.ID = hex.EncodeToString(id[:])
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	holds := append(append([]hold{}, l.holds...), h)
+	return h, l.save(holds)
+}
+
+// release removes the hold with the specified id, reporting whether it
+// existed.
+func (l *legalHolds) release(id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var holds []hold
+	for _, h := range l.holds {
+		if h.ID != id {
+			holds = append(holds, h)
+		}
+	}
+	if len(holds) == len(l.holds) {
+		return false, nil
+	}
+	return true, l.save(holds)
+}
+
+// holdsHandler lists (GET), places (POST with host=, from=, until= and
+// reason=) and releases (DELETE with id=) the legal holds of the log
+// directory of the tenant= parameter (empty for the main directory). If token
+// is non-empty, placing and releasing holds requires it (see checkToken), as
+// holds override retention.
+func holdsHandler(servers map[string]*server, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := servers[r.FormValue("tenant")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown tenant %q", r.FormValue("tenant")), http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet && !checkToken(w, r, token) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.holds.list())
+
+		case http.MethodPost:
+			h := hold{
+				From:    r.FormValue("from"),
+				Until:   r.FormValue("until"),
+				Reason:  r.FormValue("reason"),
+				Created: time.Now().UTC(),
+			}
+			if host := r.FormValue("host"); host != "" {
+				if !validHostname(host) {
+					http.Error(w, fmt.Sprintf("invalid host= parameter %q", host), http.StatusBadRequest)
+					return
+				}
+				h.Host = hostDirName(host)
+			}
+			for _, v := range []string{h.From, h.Until} {
+				if _, err := time.Parse(holdDayFormat, v); v != "" && err != nil {
+					http.Error(w, fmt.Sprintf("invalid day %q (expected %s)", v, holdDayFormat), http.StatusBadRequest)
+					return
+				}
+			}
+			if h.From != "" && h.Until != "" && h.From > h.Until {
+				http.Error(w, "from= is after until=", http.StatusBadRequest)
+				return
+			}
+			h, err := s.holds.place(h)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("placed legal hold %s on host=%q from=%q until=%q (%s)", h.ID, h.Host, h.From, h.Until, h.Reason)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(h)
+
+		case http.MethodDelete:
+			id := r.FormValue("id")
+			ok, err := s.holds.release(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, fmt.Sprintf("hold %q not found", id), http.StatusNotFound)
+				return
+			}
+			log.Printf("released legal hold %s", id)
+			fmt.Fprintf(w, "released\n")
+
+		default:
+			http.Error(w, "method not allowed (use GET, POST or DELETE)", http.StatusMethodNotAllowed)
+		}
+	}
+}
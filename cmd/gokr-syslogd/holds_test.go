@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLegalHolds(t *testing.T) {
+	dir := t.TempDir()
+	holds, err := newLegalHolds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{dir: dir, retentionDays: 7, holds: holds}
+	hdl := holdsHandler(map[string]*server{"": srv}, "t0ken")
+	now := time.Date(2022, time.September, 1, 12, 0, 0, 0, time.Local)
+	day := func(d int) time.Time { return time.Date(2022, time.August, d, 0, 0, 0, 0, time.Local) }
+	expired := func(host string, d int) bool {
+		return srv.state(logFile{hostname: host, day: day(d), compressed: true}, now) == stateExpired
+	}
+
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("POST", "/holds?"+url.Values{
+		"host":   {"dr"},
+		"from":   {"2022-08-12"},
+		"until":  {"2022-08-13"},
+		"reason": {"incident 42"},
+		"token":  {"t0ken"},
+	}.Encode(), nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /holds: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var h hold
+	if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host string
+		day  int
+		want bool
+	}{
+		{"dr", 11, true},
+		{"dr", 12, false},
+		{"dr", 13, false},
+		{"dr", 14, true},
+		{"scan2drive", 13, true},
+	} {
+		if got := expired(tt.host, tt.day); got != tt.want {
+			t.Errorf("%s 2022-08-%d: expired = %v, want %v", tt.host, tt.day, got, tt.want)
+		}
+	}
+
+	// Holds survive restarts.
+	reloaded, err := newLegalHolds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.held("dr", day(12)) {
+		t.Errorf("hold not persisted")
+	}
+
+	for _, req := range []string{
+		"/holds?from=2022-08-13&until=2022-08-12&token=t0ken",
+		"/holds?from=13.08.2022&token=t0ken",
+		"/holds?host=..&token=t0ken",
+		"/holds?tenant=unknown&token=t0ken",
+		"/holds?host=dr",
+		"/holds?host=dr&token=wrong",
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("POST", req, nil))
+		if rec.Code == http.StatusCreated {
+			t.Errorf("POST %s unexpectedly succeeded", req)
+		}
+	}
+
+	// Without the token, holds cannot be released.
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("DELETE", "/holds?id="+h.ID, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE /holds without token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if !srv.holds.held("dr", day(12)) {
+		t.Errorf("hold released without token")
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/holds?id="+h.ID, nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	hdl.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE /holds: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !expired("dr", 12) {
+		t.Errorf("dr 2022-08-12 not expired after the hold was released")
+	}
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("DELETE", "/holds?token=t0ken&id="+h.ID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a released hold: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
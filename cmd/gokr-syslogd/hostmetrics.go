@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// hostMetricsMaxHosts bounds the memory used by the per-host metrics.
+const hostMetricsMaxHosts = 1000
+
+// hostMetricsMaxTags bounds the tags tracked per host. The messages of
+// further tags are counted under otherTags.
+const hostMetricsMaxTags = 200
+
+// otherTags is the tag under which the messages of untracked tags are counted.
+const otherTags = "(other tags)"
+
+// tagVolume is the number of messages of a tag, and their size (of tag and
+// content).
+type tagVolume struct {
+	messages, bytes uint64
+}
+
+type hostCounts struct {
+	last time.Time
+	// bySeverity counts messages by severity code (see severity.Names), with
+	// unknown severities counted last.
+	bySeverity [8 + 1]uint64
+
+	// tags and dropped are only reported at /hosts/<host> (see
+	// hostStatsHandler), not in /metrics: both are unbounded in number.
+	tags    map[string]*tagVolume
+	dropped map[string]uint64 // by reject reason
+}
+
+// volume returns the total of the tag volumes of c.
+func (c *hostCounts) volume() tagVolume {
+	var total tagVolume
+	for _, v := range c.tags {
+		total.messages += v.messages
+		total.bytes += v.bytes
+	}
+	return total
+}
+
+// hostMetrics tracks when each host last sent a message and how many it sent
+// by severity, for Prometheus alerts like “no logs from router7 for 15
+// minutes” (see /metrics).
+type hostMetrics struct {
+	started time.Time
+	tenant  string // labels the metrics of tenants (see -tenant)
+
+	mu    sync.Mutex
+	hosts map[string]*hostCounts
+}
+
+func newHostMetrics() *hostMetrics {
+	return &hostMetrics{
+		started: time.Now(),
+		hosts:   make(map[string]*hostCounts),
+	}
+}
+
+// seed initializes the last message time of each host in dir (except for
+// retired hosts) with the modification time of its newest log file, so that
+// freshness alerts keep working across restarts.
+func (h *hostMetrics) seed(dir string) error {
+	hosts, err := logtree.ListHosts(dir)
+	if err != nil {
+		return err
+	}
+	retiredHosts, err := retired.Hosts(dir)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, host := range hosts {
+		if _, ok := retiredHosts[host]; ok {
+			continue
+		}
+		fis, err := os.ReadDir(filepath.Join(dir, host))
+		if err != nil {
+			return err
+		}
+		var last time.Time
+		for _, fi := range fis {
+			if !logtree.IsLogFile(fi.Name()) {
+				continue
+			}
+			info, err := fi.Info()
+			if err != nil {
+				continue // deleted in the meantime
+			}
+			if info.ModTime().After(last) {
+				last = info.ModTime()
+			}
+		}
+		if last.IsZero() || len(h.hosts) >= hostMetricsMaxHosts {
+			continue
+		}
+		if _, ok := h.hosts[host]; !ok {
+			h.hosts[host] = &hostCounts{last: last}
+		}
+	}
+	return nil
+}
+
+// counts returns the counts of hostname, or nil if too many hosts are tracked
+// already. h.mu must be held.
+func (h *hostMetrics) counts(hostname string) *hostCounts {
+	c, ok := h.hosts[hostname]
+	if !ok {
+		if len(h.hosts) >= hostMetricsMaxHosts {
+			selfLog.Printf("host_metrics", "not tracking %q: tracking %d hosts already", hostname, hostMetricsMaxHosts)
+			return nil
+		}
+		c = &hostCounts{}
+		h.hosts[hostname] = c
+	}
+	return c
+}
+
+// observe counts msg, which was accepted.
+func (h *hostMetrics) observe(msg message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.counts(msg.hostname)
+	if c == nil {
+		return
+	}
+	if msg.received.After(c.last) {
+		c.last = msg.received
+	}
+	code := msg.severity
+	if code < 0 || code >= len(severity.Names) {
+		code = len(severity.Names)
+	}
+	c.bySeverity[code]++
+	if c.tags == nil {
+		c.tags = make(map[string]*tagVolume)
+	}
+	v, ok := c.tags[msg.tag]
+	if !ok {
+		if len(c.tags) >= hostMetricsMaxTags {
+			msg.tag = otherTags
+		}
+		if v, ok = c.tags[msg.tag]; !ok {
+			v = &tagVolume{}
+			c.tags[msg.tag] = v
+		}
+	}
+	v.messages++
+	v.bytes += uint64(len(msg.tag) + len(msg.content))
+}
+
+// observeDrop counts a message claiming hostname which was rejected for the
+// specified reason.
+func (h *hostMetrics) observeDrop(hostname, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.counts(hostname)
+	if c == nil {
+		return
+	}
+	if c.dropped == nil {
+		c.dropped = make(map[string]uint64)
+	}
+	c.dropped[reason]++
+}
+
+// labels returns the Prometheus labels of host, including the tenant.
+func (h *hostMetrics) labels(host string) string {
+	if h.tenant != "" {
+		return fmt.Sprintf("tenant=%q,host=%q", h.tenant, host)
+	}
+	return fmt.Sprintf("host=%q", host)
+}
+
+// sortedHosts returns the hosts of h, sorted. h.mu must be held.
+func (h *hostMetrics) sortedHosts() []string {
+	hosts := make([]string, 0, len(h.hosts))
+	for host := range h.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// writeHostMetrics writes the per-host metrics of all tenants in the
+// Prometheus text format.
+func writeHostMetrics(w io.Writer, all []*hostMetrics) {
+	fmt.Fprintf(w, "# HELP syslogd_last_message_timestamp_seconds When the most recent message of each host was received, as a Unix timestamp.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_last_message_timestamp_seconds gauge\n")
+	for _, h := range all {
+		h.mu.Lock()
+		for _, host := range h.sortedHosts() {
+			if h.hosts[host].last.IsZero() {
+				continue // only rejected messages
+			}
+			fmt.Fprintf(w, "syslogd_last_message_timestamp_seconds{%s} %.3f\n", h.labels(host), float64(h.hosts[host].last.UnixNano())/1e9)
+		}
+		h.mu.Unlock()
+	}
+	fmt.Fprintf(w, "# HELP syslogd_messages_total Messages accepted, by host and severity.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_messages_total counter\n")
+	for _, h := range all {
+		h.mu.Lock()
+		for _, host := range h.sortedHosts() {
+			for code, n := range h.hosts[host].bySeverity {
+				if n == 0 {
+					continue
+				}
+				name := "unknown"
+				if code < len(severity.Names) {
+					name = severity.Names[code]
+				}
+				fmt.Fprintf(w, "syslogd_messages_total{%s,severity=%q} %d\n", h.labels(host), name, n)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
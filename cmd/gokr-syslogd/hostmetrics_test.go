@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHostMetrics(t *testing.T) {
+	dir := t.TempDir()
+	seeded := time.Date(2022, time.August, 13, 12, 0, 0, 0, time.UTC)
+	for fn, content := range map[string]string{
+		"scan2drive/2022-08-13.log": "",
+		"old/2022-08-13.log":        "",
+		"old/" + retired.FileName:   `{"messages":"drop","retention":"keep"}`,
+	} {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fn, seeded, seeded); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := newHostMetrics()
+	if err := h.seed(dir); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 500e6, time.UTC)
+	for _, msg := range []message{
+		{hostname: "dr", severity: 6, received: now.Add(-time.Minute)},
+		{hostname: "dr", severity: 3, received: now},
+		{hostname: "dr", severity: 6, received: now.Add(-2 * time.Minute)}, // reordered
+		{hostname: "apu", severity: -1, received: now},
+	} {
+		h.observe(msg)
+	}
+	// A tenant with a host of the same name is counted separately.
+	friend := newHostMetrics()
+	friend.tenant = "friend"
+	friend.observe(message{hostname: "dr", severity: 6, received: now})
+	var b strings.Builder
+	writeHostMetrics(&b, []*hostMetrics{h, friend})
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		`syslogd_last_message_timestamp_seconds{host="apu"} 1660407630.500`,
+		`syslogd_last_message_timestamp_seconds{host="dr"} 1660407630.500`,
+		`syslogd_last_message_timestamp_seconds{host="scan2drive"} 1660392000.000`,
+		`syslogd_last_message_timestamp_seconds{tenant="friend",host="dr"} 1660407630.500`,
+		`syslogd_messages_total{host="apu",severity="unknown"} 1`,
+		`syslogd_messages_total{host="dr",severity="err"} 1`,
+		`syslogd_messages_total{host="dr",severity="info"} 2`,
+		`syslogd_messages_total{tenant="friend",host="dr",severity="info"} 1`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metrics: unexpected diff (-want +got):\n%s", diff)
+	}
+}
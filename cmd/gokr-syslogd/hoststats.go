@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// hostStatsTopTags is the number of tags listed at /hosts/<host>.
+	hostStatsTopTags = 20
+
+	// hostStatsBarWidth is the width of the bars of the bytes per day trend.
+	hostStatsBarWidth = 40
+)
+
+// hostStats is a copy of the counts of a host since startup.
+type hostStats struct {
+	host    string
+	last    time.Time
+	tags    []string // by bytes, most first
+	volume  map[string]tagVolume
+	total   tagVolume
+	dropped map[string]uint64 // by reject or filter reason
+}
+
+// droppedTotal returns the sum of the drop counts of st.
+func (st *hostStats) droppedTotal() uint64 {
+	var n uint64
+	for _, d := range st.dropped {
+		n += d
+	}
+	return n
+}
+
+// stats returns the counts of all hosts.
+func (h *hostMetrics) stats() map[string]*hostStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := make(map[string]*hostStats, len(h.hosts))
+	for host, c := range h.hosts {
+		st := &hostStats{
+			host:    host,
+			last:    c.last,
+			volume:  make(map[string]tagVolume, len(c.tags)),
+			total:   c.volume(),
+			dropped: make(map[string]uint64, len(c.dropped)),
+		}
+		for tag, v := range c.tags {
+			st.tags = append(st.tags, tag)
+			st.volume[tag] = *v
+		}
+		sort.Slice(st.tags, func(i, j int) bool {
+			vi, vj := st.volume[st.tags[i]], st.volume[st.tags[j]]
+			if vi.bytes != vj.bytes {
+				return vi.bytes > vj.bytes
+			}
+			return st.tags[i] < st.tags[j]
+		})
+		for reason, n := range c.dropped {
+			st.dropped[reason] = n
+		}
+		all[host] = st
+	}
+	return all
+}
+
+// storedDay is the size of the log files of a host for one day.
+type storedDay struct {
+	day        string
+	size       int64
+	compressed bool // at least one of the files is compressed
+}
+
+// storedDays returns the sizes of the log files of host in s.dir per day,
+// newest first.
+func (s *server) storedDays(host string) ([]storedDay, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, hostDirName(host)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	byDay := make(map[string]*storedDay)
+	for _, entry := range entries {
+		day, compressed, ok := parseLogFileName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted in the meantime
+		}
+		key := day.Format("2006-01-02")
+		d, ok := byDay[key]
+		if !ok {
+			d = &storedDay{day: key}
+			byDay[key] = d
+		}
+		d.size += info.Size()
+		d.compressed = d.compressed || compressed
+	}
+	days := make([]storedDay, 0, len(byDay))
+	for _, d := range byDay {
+		days = append(days, *d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].day > days[j].day })
+	return days, nil
+}
+
+// writeHostIndex writes one line per host with its message rate, volume and
+// drops, busiest host (in the last hour) first.
+func (s *server) writeHostIndex(w io.Writer, now time.Time) error {
+	type row struct {
+		st             *hostStats
+		lastFive, hour int
+	}
+	var rows []row
+	for _, st := range s.hostMetrics.stats() {
+		rows = append(rows, row{
+			st:       st,
+			lastFive: s.matrix.recent(st.host, now, 5),
+			hour:     s.matrix.recent(st.host, now, 60),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].hour != rows[j].hour {
+			return rows[i].hour > rows[j].hour
+		}
+		return rows[i].st.host < rows[j].st.host
+	})
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# messages per host as of %s, busiest in the last hour first; counts since %s\n",
+		now.Format(time.RFC3339), s.hostMetrics.started.Format(time.RFC3339))
+	fmt.Fprintf(bw, "%-30s %10s %10s %12s %12s %10s\n", "host", "per minute", "last hour", "messages", "bytes", "dropped")
+	for _, r := range rows {
+		fmt.Fprintf(bw, "%-30s %10.1f %10d %12d %12s %10d\n",
+			r.st.host, float64(r.lastFive)/5, r.hour, r.st.total.messages, formatBytes(int64(r.st.total.bytes)), r.st.droppedTotal())
+	}
+	return bw.Flush()
+}
+
+// writeHostStats writes the ingestion statistics of host: its message rate,
+// the bytes per day stored for it, its tags by volume and its drops.
+func (s *server) writeHostStats(w io.Writer, host string, now time.Time) error {
+	st, ok := s.hostMetrics.stats()[host]
+	if !ok {
+		st = &hostStats{host: host}
+	}
+	days, err := s.storedDays(host)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s as of %s; counts since %s\n", host, now.Format(time.RFC3339), s.hostMetrics.started.Format(time.RFC3339))
+	if !st.last.IsZero() {
+		fmt.Fprintf(bw, "last message: %s (%v ago)\n", st.last.Format(time.RFC3339), now.Sub(st.last).Round(time.Second))
+	}
+	fmt.Fprintf(bw, "rate: %.1f messages per minute over the last 5 minutes, %d in the last hour\n",
+		float64(s.matrix.recent(host, now, 5))/5, s.matrix.recent(host, now, 60))
+	fmt.Fprintf(bw, "total: %d messages, %s\n", st.total.messages, formatBytes(int64(st.total.bytes)))
+
+	fmt.Fprintf(bw, "\n# bytes stored per day (compressed files marked with *)\n")
+	var largest int64
+	for _, d := range days {
+		if d.size > largest {
+			largest = d.size
+		}
+	}
+	for _, d := range days {
+		mark := " "
+		if d.compressed {
+			mark = "*"
+		}
+		bar := 0
+		if largest > 0 {
+			bar = int(d.size * hostStatsBarWidth / largest)
+		}
+		fmt.Fprintf(bw, "%s %10s%s %s\n", d.day, formatBytes(d.size), mark, strings.Repeat("#", bar))
+	}
+
+	fmt.Fprintf(bw, "\n# top tags by volume\n")
+	fmt.Fprintf(bw, "%-30s %12s %12s %6s\n", "tag", "messages", "bytes", "share")
+	for i, tag := range st.tags {
+		if i == hostStatsTopTags {
+			fmt.Fprintf(bw, "(%d more tags)\n", len(st.tags)-i)
+			break
+		}
+		v := st.volume[tag]
+		share := 0.0
+		if st.total.bytes > 0 {
+			share = 100 * float64(v.bytes) / float64(st.total.bytes)
+		}
+		fmt.Fprintf(bw, "%-30s %12d %12s %5.1f%%\n", tag, v.messages, formatBytes(int64(v.bytes)), share)
+	}
+
+	fmt.Fprintf(bw, "\n# dropped messages by reason\n")
+	reasons := make([]string, 0, len(st.dropped))
+	for reason := range st.dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if st.dropped[reasons[i]] != st.dropped[reasons[j]] {
+			return st.dropped[reasons[i]] > st.dropped[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	for _, reason := range reasons {
+		fmt.Fprintf(bw, "%-30s %12d\n", reason, st.dropped[reason])
+	}
+	return bw.Flush()
+}
+
+// hostStatsHandler serves the ingestion statistics of all hosts at /hosts and
+// of one host at /hosts/<host>, e.g. to find the device to quiet down. Bytes
+// per day are those in the log directory of the tenant= parameter.
+func hostStatsHandler(servers map[string]*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := servers[r.FormValue("tenant")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown tenant %q", r.FormValue("tenant")), http.StatusNotFound)
+			return
+		}
+		now := time.Now()
+		host := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/hosts"), "/")
+		if host == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := s.writeHostIndex(w, now); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if !validHostname(host) {
+			http.Error(w, fmt.Sprintf("invalid host %q", host), http.StatusNotFound)
+			return
+		}
+		if _, ok := s.hostMetrics.stats()[host]; !ok {
+			if _, err := os.Stat(filepath.Join(s.dir, hostDirName(host))); err != nil {
+				http.Error(w, fmt.Sprintf("unknown host %q", host), http.StatusNotFound)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := s.writeHostStats(w, host, now); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
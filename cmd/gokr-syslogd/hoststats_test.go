@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHostStats(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 0, time.UTC)
+	srv := server{
+		dir:         t.TempDir(),
+		hostMetrics: newHostMetrics(),
+		matrix:      newMatrix(),
+	}
+	srv.hostMetrics.started = now.Add(-time.Hour)
+	for fn, size := range map[string]int{
+		"dr/2022-08-13.log":       4096,
+		"dr/2022-08-12.log":       2048,
+		"dr/2022-08-11.log.zst":   1024,
+		"dr/2022-08-11.1.log.zst": 1024,
+		"dr/notes.txt":            100,
+		"apu/2022-08-13.log":      10,
+	} {
+		fn = filepath.Join(srv.dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	observe := func(msg message) {
+		srv.matrix.observe(msg)
+		srv.hostMetrics.observe(msg)
+	}
+	for i := 0; i < 10; i++ {
+		observe(message{hostname: "dr", tag: "dhcpd", content: "DHCPDISCOVER", severity: 6, received: now.Add(-time.Duration(i) * time.Minute)})
+	}
+	observe(message{hostname: "dr", tag: "kernel", content: "eth0: link up", severity: 6, received: now.Add(-30 * time.Minute)})
+	observe(message{hostname: "apu", tag: "sshd", content: "accepted", severity: 6, received: now.Add(-2 * time.Hour)})
+	srv.reject(messageParts{"hostname": "dr"}, now, "clock_drift")
+	srv.reject(messageParts{"hostname": "dr"}, now, "clock_drift")
+	srv.reject(messageParts{"hostname": "../etc"}, now, "invalid_hostname")
+
+	var b strings.Builder
+	if err := srv.writeHostStats(&b, "dr", now); err != nil {
+		t.Fatal(err)
+	}
+	want := `# dr as of 2022-08-13T16:20:30Z; counts since 2022-08-13T15:20:30Z
+last message: 2022-08-13T16:20:30Z (0s ago)
+rate: 1.0 messages per minute over the last 5 minutes, 11 in the last hour
+total: 11 messages, 189 B
+
+# bytes stored per day (compressed files marked with *)
+2022-08-13    4.0 KiB  ########################################
+2022-08-12    2.0 KiB  ####################
+2022-08-11    2.0 KiB* ####################
+
+# top tags by volume
+tag                                messages        bytes  share
+dhcpd                                    10        170 B  89.9%
+kernel                                    1         19 B  10.1%
+
+# dropped messages by reason
+clock_drift                               2
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("writeHostStats: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	b.Reset()
+	if err := srv.writeHostIndex(&b, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	var hosts []string
+	for _, line := range lines[2:] {
+		hosts = append(hosts, strings.Fields(line)[0])
+	}
+	if diff := cmp.Diff([]string{"dr", "apu"}, hosts); diff != "" {
+		t.Errorf("writeHostIndex: unexpected hosts (-want +got):\n%s", diff)
+	}
+}
+
+func TestHostStatsTenants(t *testing.T) {
+	srv := &server{
+		dir:         t.TempDir(),
+		hostMetrics: newHostMetrics(),
+		matrix:      newMatrix(),
+	}
+	friend := srv.forTenant(tenant{name: "friend", outdir: t.TempDir()}, nil)
+	now := time.Now()
+	for _, s := range []*server{srv, friend} {
+		msg := message{hostname: "router7", tag: "dhcpd", content: "DHCPACK", severity: 6, received: now}
+		s.matrix.observe(msg)
+		s.hostMetrics.observe(msg)
+	}
+	srv.hostMetrics.observe(message{hostname: "dr", tag: "sshd", content: "accepted", severity: 6, received: now})
+
+	handler := hostStatsHandler(map[string]*server{"": srv, "friend": friend})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/hosts?tenant=friend", nil))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if got, want := len(lines), 3; got != want {
+		t.Fatalf("/hosts?tenant=friend: got %d lines, want %d:\n%s", got, want, rec.Body.String())
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "router7" || fields[3] != "1" {
+		t.Errorf("/hosts?tenant=friend: got %q, want router7 with 1 message", lines[2])
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/hosts/dr?tenant=friend", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/hosts/dr?tenant=friend: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
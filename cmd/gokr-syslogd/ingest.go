@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWebhookBody is the size of a webhook payload accepted by ingestHandler.
+const maxWebhookBody = 1 << 20
+
+// maxWebhookFields bounds the key=value pairs of one ingested message: the
+// remaining pairs are dropped (see flattenJSON).
+const maxWebhookFields = 500
+
+// webhookPrefix is the path of ingestHandler, followed by the source.
+const webhookPrefix = "/ingest/webhook/"
+
+// validSource reports whether source can be used as the tag of ingested
+// messages.
+func validSource(source string) bool {
+	if source == "" || len(source) > 64 {
+		return false
+	}
+	for _, r := range source {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenKey makes a JSON object key usable in a key=value pair.
+func flattenKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '=' || r == '"' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// flattenValue formats a JSON string for a key=value pair, quoting it unless
+// it is a single non-empty word.
+func flattenValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// flattenJSON appends the leaves of v (as decoded with json.Decoder.UseNumber)
+// to pairs as key=value, with nested keys joined by dots and array elements
+// numbered, e.g. repository.name=syslogd commits.0.id=4f2a, in key order.
+func flattenJSON(pairs []string, key string, v interface{}) []string {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+	leafKey := key
+	if leafKey == "" {
+		leafKey = "value"
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return append(pairs, leafKey+"={}")
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			pairs = flattenJSON(pairs, join(flattenKey(k)), v[k])
+		}
+		return pairs
+	case []interface{}:
+		if len(v) == 0 {
+			return append(pairs, leafKey+"=[]")
+		}
+		for i, elem := range v {
+			pairs = flattenJSON(pairs, join(strconv.Itoa(i)), elem)
+		}
+		return pairs
+	case string:
+		return append(pairs, leafKey+"="+flattenValue(v))
+	case json.Number:
+		return append(pairs, leafKey+"="+v.String())
+	case bool:
+		return append(pairs, leafKey+"="+strconv.FormatBool(v))
+	default: // nil
+		return append(pairs, leafKey+"=null")
+	}
+}
+
+// webhookContent returns the content of the message for one payload value.
+func webhookContent(v interface{}) string {
+	pairs := flattenJSON(nil, "", v)
+	if len(pairs) > maxWebhookFields {
+		n := len(pairs) - maxWebhookFields
+		pairs = append(pairs[:maxWebhookFields], fmt.Sprintf("truncated=%d", n))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// webhookPayload returns the JSON of the request body: the payload= field for
+// form-encoded bodies (as GitHub sends them optionally), the body otherwise.
+func webhookPayload(r *http.Request, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return body
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return body
+	}
+	return []byte(form.Get("payload"))
+}
+
+// webhookMessages returns the messages of a webhook payload from source: one
+// per JSON value, or per element of a top-level array.
+func webhookMessages(host, source string, payload []byte, now time.Time) ([]messageParts, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var values []interface{}
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if elems, ok := v.([]interface{}); ok && len(elems) > 0 {
+			values = append(values, elems...)
+			continue
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+	msgs := make([]messageParts, 0, len(values))
+	for _, v := range values {
+		msgs = append(msgs, messageParts{
+			"hostname":  host,
+			"tag":       source,
+			"content":   webhookContent(v),
+			"timestamp": now,
+			"severity":  6, // info
+			"facility":  1, // user
+			"ingested":  true,
+		})
+	}
+	return msgs, nil
+}
+
+// checkToken reports whether r carries token in the token= parameter or as
+// bearer token, responding with an error otherwise. An empty token admits all
+// requests.
+func checkToken(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// ingestTarget checks the method and token of an ingest request and returns
+// the server of its tenant= parameter, or responds with an error and returns
+// false. If token is non-empty, requests need to carry it (see checkToken).
+func ingestTarget(w http.ResponseWriter, r *http.Request, servers map[string]*server, token string) (*server, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed (use POST)", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !checkToken(w, r, token) {
+		return nil, false
+	}
+	params := r.URL.Query()
+	s, ok := servers[params.Get("tenant")]
+	if !ok || s.ingested == nil {
+		http.Error(w, fmt.Sprintf("unknown tenant %q", params.Get("tenant")), http.StatusNotFound)
+		return nil, false
+	}
+	return s, true
+}
+
+// readIngestBody returns the body of an ingest request of at most
+// maxWebhookBody bytes, or responds with an error and returns false.
+func readIngestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		return nil, false
+	}
+	if len(body) > maxWebhookBody {
+		http.Error(w, fmt.Sprintf("payload larger than %d bytes", maxWebhookBody), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return body, true
+}
+
+// sendIngested passes msgs to the write loop of s and reports whether all of
+// them were accepted, responding with an error otherwise.
+func sendIngested(w http.ResponseWriter, r *http.Request, s *server, msgs []messageParts) bool {
+	timeout := time.After(10 * time.Second)
+	for _, msg := range msgs {
+		select {
+		case s.ingested <- msg:
+		case <-timeout:
+			http.Error(w, errWriteLoopTimeout.Error(), http.StatusServiceUnavailable)
+			return false
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	return true
+}
+
+// ingestHandler accepts JSON payloads POSTed to /ingest/webhook/<source>, e.g.
+// by GitHub, UniFi or Shelly devices, and passes them to the write loop of the
+// tenant= parameter as messages of host with tag <source>, flattened into
+// key=value pairs (see flattenJSON). If token is non-empty, requests need to
+// carry it (see ingestTarget).
+func ingestHandler(servers map[string]*server, host, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ingestTarget(w, r, servers, token)
+		if !ok {
+			return
+		}
+		source := strings.TrimPrefix(r.URL.Path, webhookPrefix)
+		if !validSource(source) {
+			http.Error(w, fmt.Sprintf("invalid source %q (expected letters, digits, -, _ or .)", source), http.StatusNotFound)
+			return
+		}
+		body, ok := readIngestBody(w, r)
+		if !ok {
+			return
+		}
+		msgs, err := webhookMessages(host, source, webhookPayload(r, body), time.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !sendIngested(w, r, s, msgs) {
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "ingested %d messages\n", len(msgs))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWebhookMessages(t *testing.T) {
+	for _, tt := range []struct {
+		payload string
+		want    []string
+	}{
+		{
+			payload: `{"action":"opened","repository":{"full_name":"gokrazy/syslogd","stars":42},"commits":[{"id":"4f2a"},{"id":"91c0"}],"draft":false,"label":null}`,
+			want:    []string{`action=opened commits.0.id=4f2a commits.1.id=91c0 draft=false label=null repository.full_name=gokrazy/syslogd repository.stars=42`},
+		},
+		{
+			payload: `{"msg":"door opened","tags":[],"meta":{},"odd key=":"a\nb"}`,
+			want:    []string{`meta={} msg="door opened" odd_key_="a\nb" tags=[]`},
+		},
+		{
+			payload: `[{"temp":21.5},{"temp":22}]` + "\n" + `{"temp":1e3}` + "\n" + `"boot"`,
+			want:    []string{`temp=21.5`, `temp=22`, `temp=1e3`, `value=boot`},
+		},
+	} {
+		msgs, err := webhookMessages("webhooks", "shelly", []byte(tt.payload), time.Now())
+		if err != nil {
+			t.Fatalf("webhookMessages(%s): %v", tt.payload, err)
+		}
+		var got []string
+		for _, msg := range msgs {
+			got = append(got, msg["content"].(string))
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("webhookMessages(%s): unexpected diff (-want +got):\n%s", tt.payload, diff)
+		}
+	}
+	if _, err := webhookMessages("webhooks", "shelly", []byte(`{"unterminated"`), time.Now()); err == nil {
+		t.Errorf("webhookMessages(invalid JSON) succeeded unexpectedly")
+	}
+}
+
+func TestIngestHandler(t *testing.T) {
+	srv := &server{
+		dir:          t.TempDir(),
+		files:        make(map[fileKey]*openFile),
+		flushIdle:    1 * time.Millisecond,
+		bufferLimit:  1 << 20,
+		retentionNow: make(chan struct{}, 1),
+		ingested:     make(chan messageParts),
+		// Webhook messages are not subject to the sender checks of syslog.
+		hmacKey:     []byte("secret"),
+		requireHMAC: true,
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	ingest := ingestHandler(map[string]*server{"": srv}, "webhooks", "t0ken")
+	post := func(path, contentType, body string, header http.Header) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		ingest.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, tt := range []struct {
+		desc, path, contentType, body string
+		header                        http.Header
+		want                          int
+	}{
+		{"json", "/ingest/webhook/github?token=t0ken", "application/json", `{"action":"opened"}`, nil, http.StatusAccepted},
+		{"form", "/ingest/webhook/github", "application/x-www-form-urlencoded", "payload=" + url.QueryEscape(`{"action":"closed"}`), http.Header{"Authorization": {"Bearer t0ken"}}, http.StatusAccepted},
+		{"missing token", "/ingest/webhook/github", "application/json", `{"action":"spoofed"}`, nil, http.StatusUnauthorized},
+		{"invalid source", "/ingest/webhook/../etc?token=t0ken", "application/json", `{}`, nil, http.StatusNotFound},
+		{"invalid JSON", "/ingest/webhook/github?token=t0ken", "application/json", `{`, nil, http.StatusBadRequest},
+	} {
+		if got := post(tt.path, tt.contentType, tt.body, tt.header); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.desc, got, tt.want)
+		}
+	}
+	close(channel)
+	<-done
+
+	b, err := os.ReadFile(filepath.Join(srv.dir, "webhooks", time.Now().Format(basenameFormat)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		got = append(got, logline.Strip(line))
+	}
+	want := []string{"github: action=opened", "github: action=closed"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ingested messages: unexpected diff (-want +got):\n%s", diff)
+	}
+}
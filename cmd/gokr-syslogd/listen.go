@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// messageParts are the fields of a message on its way to the write loop:
+// hostname, tag, content, timestamp, facility, severity, raw, parse_error and
+// labels (see rawFormat), client (see syslogServer), ingested
+// (see ingestHandler), and received and spool (see spool).
+type messageParts map[string]interface{}
+
+// messageFormat parses a received message into its messageParts, which carry
+// the parse error (if any) as parse_error.
+type messageFormat interface {
+	parse(raw []byte) (messageParts, error)
+}
+
+// datagramReadBufferSize is the socket receive buffer size of UDP listeners.
+const datagramReadBufferSize = 64 * 1024
+
+const (
+	// streamHandshakeTimeout bounds the TLS handshake of stream connections.
+	streamHandshakeTimeout = 10 * time.Second
+
+	// streamIdleTimeout is how long stream connections may stay silent before
+	// they are closed. Senders like rsyslog reconnect when they have messages
+	// again.
+	streamIdleTimeout = 15 * time.Minute
+
+	// maxStreamConns caps the open stream connections of a syslogServer;
+	// further connections are closed right away.
+	maxStreamConns = 1024
+)
+
+// syslogServer receives syslog messages on UDP and stream (TCP or TLS)
+// listeners, parses them with format and passes them to handler, with the
+// address of the sender as client.
+type syslogServer struct {
+	format  messageFormat
+	handler func(messageParts)
+
+	packetConns []net.PacketConn
+	listeners   []net.Listener
+	wg          sync.WaitGroup
+
+	handshakeTimeout time.Duration // streamHandshakeTimeout
+	idleTimeout      time.Duration // streamIdleTimeout
+	maxConns         int           // maxStreamConns
+
+	mu      sync.Mutex
+	conns   map[net.Conn]bool // open stream connections
+	closed  bool
+	lastErr error
+}
+
+func newSyslogServer(f messageFormat, handler func(messageParts)) *syslogServer {
+	return &syslogServer{
+		format:           f,
+		handler:          handler,
+		handshakeTimeout: streamHandshakeTimeout,
+		idleTimeout:      streamIdleTimeout,
+		maxConns:         maxStreamConns,
+		conns:            make(map[net.Conn]bool),
+	}
+}
+
+// listenUDP listens for datagrams on addr.
+func (s *syslogServer) listenUDP(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	conn.SetReadBuffer(datagramReadBufferSize)
+	s.packetConns = append(s.packetConns, conn)
+	return nil
+}
+
+// listenTCP listens for streams (see splitFrames) on addr.
+func (s *syslogServer) listenTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listeners = append(s.listeners, ln)
+	return nil
+}
+
+// listenTLS listens for TLS streams (see splitFrames) on addr.
+func (s *syslogServer) listenTLS(addr string, config *tls.Config) error {
+	ln, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	s.listeners = append(s.listeners, ln)
+	return nil
+}
+
+// serve starts receiving messages on all listeners in the background.
+func (s *syslogServer) serve() {
+	for _, conn := range s.packetConns {
+		s.wg.Add(1)
+		go s.receiveDatagrams(conn)
+	}
+	for _, ln := range s.listeners {
+		s.wg.Add(1)
+		go s.accept(ln)
+	}
+}
+
+// receive parses raw and passes it to the handler.
+func (s *syslogServer) receive(raw []byte, client string) {
+	logParts, err := s.format.parse(raw)
+	if err != nil {
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+	}
+	logParts["client"] = client
+	s.handler(logParts)
+}
+
+func (s *syslogServer) receiveDatagrams(conn net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// E.g. a transient error while the interface goes down.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		// Ignore trailing control characters and NUL bytes.
+		for n > 0 && buf[n-1] < ' ' {
+			n--
+		}
+		if n == 0 {
+			continue
+		}
+		var client string
+		if addr != nil {
+			client = addr.String()
+		}
+		s.receive(buf[:n], client)
+	}
+}
+
+func (s *syslogServer) accept(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		tracked, closed := s.track(conn)
+		if !tracked {
+			conn.Close()
+			if closed {
+				return
+			}
+			selfLog.Printf("stream_conns", "rejecting connection from %s: already %d open connections", conn.RemoteAddr(), s.maxConns)
+			continue
+		}
+		s.wg.Add(1)
+		go s.serveStream(conn)
+	}
+}
+
+// track adds conn to the open connections, unless s is closed or already has
+// maxConns open connections.
+func (s *syslogServer) track(conn net.Conn) (tracked, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, true
+	}
+	if len(s.conns) >= s.maxConns {
+		return false, false
+	}
+	s.conns[conn] = true
+	return true, false
+}
+
+// idleReader reads from conn, failing once no data arrived for timeout.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
+
+// serveStream receives the messages of conn until it is closed or idle for
+// idleTimeout.
+func (s *syslogServer) serveStream(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Handshake now, so that slow clients cannot hold the connection
+		// without ever sending a message.
+		tlsConn.SetDeadline(time.Now().Add(s.handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+	client := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(&idleReader{conn: conn, timeout: s.idleTimeout})
+	scanner.Split(splitFrames)
+	for scanner.Scan() {
+		s.receive(scanner.Bytes(), client)
+	}
+}
+
+// close closes all listeners and open stream connections.
+func (s *syslogServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+}
+
+// wait waits until s is closed and all its connections are done.
+func (s *syslogServer) wait() {
+	s.wg.Wait()
+}
+
+// lastError returns the last parse error.
+func (s *syslogServer) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
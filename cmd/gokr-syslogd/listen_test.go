@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyslogServerUDP(t *testing.T) {
+	received := make(chan messageParts)
+	syslogsrv := newSyslogServer(rawFormat{}, func(logParts messageParts) { received <- logParts })
+	addr := freePort(t, "udp")
+	if err := syslogsrv.listenUDP(addr); err != nil {
+		t.Fatal(err)
+	}
+	syslogsrv.serve()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Datagrams are not split like streams: each datagram is one message,
+	// without trailing control characters.
+	const raw = "<14>Aug 13 16:20:00 dr sshd: first\nsecond"
+	if _, err := conn.Write([]byte(raw + "\n\x00")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case logParts := <-received:
+		if diff := cmp.Diff(raw, logParts["raw"]); diff != "" {
+			t.Errorf("raw: unexpected diff (-want +got):\n%s", diff)
+		}
+		if got, want := logParts["client"], conn.LocalAddr().String(); got != want {
+			t.Errorf("client = %v, want %v", got, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	syslogsrv.close()
+	syslogsrv.wait()
+}
+
+func TestSyslogServerStreamLimits(t *testing.T) {
+	syslogsrv := newSyslogServer(rawFormat{}, func(messageParts) {})
+	syslogsrv.idleTimeout = 100 * time.Millisecond
+	syslogsrv.maxConns = 1
+	addr := freePort(t, "tcp")
+	if err := syslogsrv.listenTCP(addr); err != nil {
+		t.Fatal(err)
+	}
+	syslogsrv.serve()
+	defer func() {
+		syslogsrv.close()
+		syslogsrv.wait()
+	}()
+
+	// closedBy returns whether gokr-syslogd closes conn within timeout.
+	closedBy := func(conn net.Conn, timeout time.Duration) bool {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, err := conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return false
+		}
+		return true
+	}
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err := first.Write([]byte("<14>Aug 13 16:20:00 dr sshd: hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Wait until the first connection is tracked.
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		syslogsrv.mu.Lock()
+		n := len(syslogsrv.conns)
+		syslogsrv.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first connection not tracked")
+		}
+	}
+
+	// Connections beyond maxConns are closed right away.
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if !closedBy(second, 10*time.Second) {
+		t.Errorf("connection beyond maxConns not closed")
+	}
+
+	// Idle connections are closed after idleTimeout.
+	if !closedBy(first, 10*time.Second) {
+		t.Errorf("idle connection not closed")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// With -rotation=external, each host’s messages are written into a single
+// file (plus one file per route), which is rotated by an external tool such
+// as logrotate instead of by gokr-syslogd:
+//
+//   - Files which were renamed or removed are closed (see reopenRotatedFiles)
+//     and re-created with the next message.
+//   - SIGHUP closes all files, as logrotate’s postrotate scripts expect.
+//   - Files are opened with O_APPEND, so that writes continue at the start of
+//     files truncated by logrotate’s copytruncate option.
+//   - Log files are neither compressed nor deleted (quarantine files still
+//     are).
+const (
+	rotationDaily    = "daily"
+	rotationExternal = "external"
+)
+
+// externalBasename is the log file of each host with -rotation=external.
+const externalBasename = "syslog.log"
+
+// hasDay returns whether the name of the log file identified by key starts
+// with a day, which is not the case with -rotation=external.
+func (s *server) hasDay(key fileKey) bool {
+	return key.quarantine || !s.externalRotation
+}
+
+// notifyHangup requests that all servers close their log files on SIGHUP.
+func notifyHangup(servers []*server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			for _, s := range servers {
+				select {
+				case s.hangup <- struct{}{}:
+				default:
+					// already pending
+				}
+			}
+		}
+	}()
+}
+
+// closeFlushedFiles closes all log files without buffered lines. Files
+// which cannot be flushed remain open.
+func (s *server) closeFlushedFiles() {
+	for key, of := range s.files {
+		if of.buf.Len() > 0 {
+			continue // not yet flushed, e.g. due to a write error
+		}
+		s.closeFile(key, of)
+	}
+}
+
+// reopenRotatedFiles closes the log files which were renamed or removed since
+// they were opened, so that the next message re-creates them. Buffered lines
+// are flushed first, ending up in the rotated file.
+func (s *server) reopenRotatedFiles() {
+	for key, of := range s.files {
+		if !s.rotatedAway(key, of) {
+			continue
+		}
+		if err := of.flush(); err != nil {
+			continue // will be retried by the run loop
+		}
+		log.Printf("log file for key=%v was rotated, reopening", key)
+		s.closeFile(key, of)
+	}
+}
+
+// rotatedAway returns whether the path of the log file identified by key no
+// longer refers to of.
+func (s *server) rotatedAway(key fileKey, of *openFile) bool {
+	st, err := os.Stat(filepath.Join(s.dirFor(key), hostDirName(key.hostname), key.basename))
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	ost, err := of.f.Stat()
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(st, ost)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExternalRotation(t *testing.T) {
+	srv := server{
+		dir:              t.TempDir(),
+		files:            make(map[fileKey]*openFile),
+		bufferLimit:      1 << 20,
+		retentionDays:    7,
+		externalRotation: true,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	write := func(content string) {
+		srv.write(message{
+			hostname:  "dr",
+			timestamp: ts,
+			received:  ts,
+			facility:  -1,
+			tag:       "dhcpd",
+			content:   content,
+		})
+		if err := srv.flushFiles(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fn := filepath.Join(srv.dir, "dr", externalBasename)
+
+	// logrotate’s default: rename, then create a new file.
+	write("before rename")
+	if err := os.Rename(fn, fn+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write("after rename")
+	srv.reopenRotatedFiles()
+	write("after reopen")
+
+	// logrotate’s copytruncate option.
+	if err := os.Truncate(fn, 0); err != nil {
+		t.Fatal(err)
+	}
+	srv.reopenRotatedFiles() // no-op: the file was not renamed
+	write("after truncate")
+
+	for basename, want := range map[string]string{
+		externalBasename + ".1": "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: before rename\n" +
+			"rfc3339=2022-08-13T16:20:00Z seq=2 dhcpd: after rename\n",
+		externalBasename: "rfc3339=2022-08-13T16:20:00Z seq=2 dhcpd: after truncate\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(srv.dir, "dr", basename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(b)); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", basename, diff)
+		}
+	}
+}
+
+func TestRotateHost(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		bufferLimit:   1 << 20,
+		retentionDays: 7,
+		retentionNow:  make(chan struct{}, 1),
+		loopRequests:  make(chan func()),
+	}
+	ts := time.Now().Truncate(time.Second)
+	write := func(hostname, content string) {
+		srv.write(message{
+			hostname:  hostname,
+			timestamp: ts,
+			received:  ts,
+			facility:  -1,
+			tag:       "dhcpd",
+			content:   content,
+		})
+	}
+	basename := ts.Format(basenameFormat)
+	day := ts.Format("2006-01-02")
+	write("dr", "before rotation") // buffered, flushed by rotateHost
+	write("scan2drive", "other host")
+	rotated, err := srv.rotateHost("dr", ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := filepath.Join(srv.dir, "dr", day+".1.log")
+	if diff := cmp.Diff([]string{first}, rotated); diff != "" {
+		t.Fatalf("rotateHost: unexpected diff (-want +got):\n%s", diff)
+	}
+	if _, ok := srv.files[fileKey{hostname: "scan2drive", basename: basename}]; !ok {
+		t.Errorf("rotateHost(dr) closed the log file of scan2drive")
+	}
+	write("dr", "after rotation")
+	if err := srv.flushFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second rotation goes through the handler, on the write loop.
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+	hdl := rotateHandler(map[string]*server{"": &srv}, "t0ken")
+	for _, tt := range []struct {
+		method, url string
+		wantCode    int
+	}{
+		{"GET", "/rotate?host=dr&token=t0ken", http.StatusMethodNotAllowed},
+		{"POST", "/rotate?host=dr", http.StatusUnauthorized},
+		{"POST", "/rotate?host=dr&token=wrong", http.StatusUnauthorized},
+		{"POST", "/rotate?host=dr&tenant=nope&token=t0ken", http.StatusNotFound},
+		{"POST", "/rotate?host=" + url.QueryEscape("../etc") + "&token=t0ken", http.StatusBadRequest},
+		{"POST", "/rotate?host=unknown&token=t0ken", http.StatusNotFound},
+		{"POST", "/rotate?host=dr&token=t0ken", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s %s: got HTTP %d (%s), want %d", tt.method, tt.url, rec.Code, rec.Body.String(), tt.wantCode)
+		}
+	}
+	close(channel)
+	<-done
+
+	for basename, want := range map[string]string{
+		day + ".1.log": "rfc3339=" + ts.Format(time.RFC3339) + " seq=1 dhcpd: before rotation\n",
+		day + ".2.log": "rfc3339=" + ts.Format(time.RFC3339) + " seq=1 dhcpd: after rotation\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(srv.dir, "dr", basename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(b)); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", basename, diff)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(srv.dir, "dr", basename)); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after rotation", basename)
+	}
+
+	srv.externalRotation = true
+	if _, err := srv.rotateHost("dr", ts); err != errExternalRotation {
+		t.Errorf("rotateHost with external rotation: got %v, want %v", err, errExternalRotation)
+	}
+}
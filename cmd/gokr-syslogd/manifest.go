@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gokrazy/syslogd/internal/manifest"
+)
+
+// updateManifest records the size and SHA-256 of the finalized log file fn in
+// the manifest of its host directory (see -manifest), or removes fn from the
+// manifest if fn no longer exists. Only the retention goroutine modifies
+// finalized files, so it is the only caller.
+func (s *server) updateManifest(fn string) {
+	if !s.manifest {
+		return
+	}
+	if err := s.writeManifest(fn); err != nil {
+		selfLog.Printf("manifest", "updating manifest for %s: %v", fn, err)
+	}
+}
+
+func (s *server) writeManifest(fn string) error {
+	mfn := filepath.Join(filepath.Dir(fn), manifest.FileName)
+	m, err := manifest.ReadFile(mfn)
+	if err != nil {
+		return err
+	}
+	e, err := manifest.Hash(fn)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if _, ok := m.Lookup(filepath.Base(fn)); !ok {
+			return nil // nothing to remove
+		}
+		m.Remove(filepath.Base(fn))
+	} else {
+		m.Set(e)
+	}
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := newPendingFile(mfn, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if err := m.Write(f); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	return s.chown(mfn)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/manifest"
+)
+
+func TestManifest(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		retentionDays: 7,
+		manifest:      true,
+	}
+	fn := filepath.Join(srv.dir, "dr", "2022-08-10.log")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte("rfc3339=2022-08-10T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mfn := filepath.Join(srv.dir, "dr", manifest.FileName)
+
+	if err := srv.compressOldLogs(time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.ReadFile(mfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Lookup("2022-08-10.log.zst"); !ok {
+		t.Fatalf("%s: no entry for 2022-08-10.log.zst after compression", mfn)
+	}
+	if err := verifyManifest(fn+".zst", make(map[string]*manifest.Manifest)); err != nil {
+		t.Errorf("verifyManifest(intact file): %v", err)
+	}
+	if err := os.WriteFile(fn+".zst", []byte("rotten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyManifest(fn+".zst", make(map[string]*manifest.Manifest)); err == nil {
+		t.Errorf("verifyManifest(modified file) unexpectedly succeeded")
+	}
+
+	if err := srv.deleteOldLogs(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	m, err = manifest.ReadFile(mfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.Entries()); got != 0 {
+		t.Errorf("%s: %d entries after deletion, want 0", mfn, got)
+	}
+}
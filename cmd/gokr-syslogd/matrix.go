@@ -0,0 +1,189 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// matrixMinutes is the number of minutes the matrix covers.
+	matrixMinutes = 60
+
+	// matrixMaxHosts bounds the memory used by the matrix.
+	matrixMaxHosts = 1000
+
+	// matrixInterval is how often /matrix/events sends an update.
+	matrixInterval = 5 * time.Second
+)
+
+type matrixCell struct {
+	messages, errors int
+}
+
+type matrixRow struct {
+	minutes [matrixMinutes]int64 // unix minute of each cell
+	cells   [matrixMinutes]matrixCell
+}
+
+// matrix counts the messages and errors of each host per minute, for the live
+// fleet view at /matrix.
+type matrix struct {
+	mu    sync.Mutex
+	hosts map[string]*matrixRow
+}
+
+func newMatrix() *matrix {
+	return &matrix{hosts: make(map[string]*matrixRow)}
+}
+
+// observe counts msg in the minute it was received.
+func (m *matrix) observe(msg message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row, ok := m.hosts[msg.hostname]
+	if !ok {
+		if len(m.hosts) >= matrixMaxHosts {
+			selfLog.Printf("matrix", "not tracking %q: tracking %d hosts already", msg.hostname, matrixMaxHosts)
+			return
+		}
+		row = &matrixRow{}
+		m.hosts[msg.hostname] = row
+	}
+	minute := msg.received.Unix() / 60
+	idx := minute % matrixMinutes
+	if row.minutes[idx] > minute {
+		return // older than the matrix
+	}
+	if row.minutes[idx] != minute {
+		row.minutes[idx] = minute
+		row.cells[idx] = matrixCell{}
+	}
+	row.cells[idx].messages++
+	if msg.severity >= 0 && msg.severity <= errorSeverity {
+		row.cells[idx].errors++
+	}
+}
+
+// recent returns the number of messages of host within the last minutes
+// (at most matrixMinutes) at now, including the current minute.
+func (m *matrix) recent(host string, now time.Time, minutes int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row, ok := m.hosts[host]
+	if !ok {
+		return 0
+	}
+	last := now.Unix() / 60
+	n := 0
+	for minute := last - int64(minutes) + 1; minute <= last; minute++ {
+		if idx := minute % matrixMinutes; row.minutes[idx] == minute {
+			n += row.cells[idx].messages
+		}
+	}
+	return n
+}
+
+// matrixSnapshot is the JSON representation of the matrix.
+type matrixSnapshot struct {
+	Minutes []time.Time         `json:"minutes"` // oldest first
+	Hosts   []matrixSnapshotRow `json:"hosts"`
+}
+
+type matrixSnapshotRow struct {
+	Host     string `json:"host"`
+	Messages []int  `json:"messages"` // per minute
+	Errors   []int  `json:"errors"`   // per minute
+}
+
+// snapshot returns the counts of the last matrixMinutes minutes at now, with
+// hosts sorted by name.
+func (m *matrix) snapshot(now time.Time) matrixSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := now.Unix() / 60
+	first := last - matrixMinutes + 1
+	var snap matrixSnapshot
+	for minute := first; minute <= last; minute++ {
+		snap.Minutes = append(snap.Minutes, time.Unix(minute*60, 0))
+	}
+	for host, row := range m.hosts {
+		r := matrixSnapshotRow{
+			Host:     host,
+			Messages: make([]int, matrixMinutes),
+			Errors:   make([]int, matrixMinutes),
+		}
+		active := false
+		for i := range r.Messages {
+			minute := first + int64(i)
+			idx := minute % matrixMinutes
+			if row.minutes[idx] != minute {
+				continue
+			}
+			r.Messages[i] = row.cells[idx].messages
+			r.Errors[i] = row.cells[idx].errors
+			active = true
+		}
+		if !active {
+			delete(m.hosts, host) // silent for matrixMinutes
+			continue
+		}
+		snap.Hosts = append(snap.Hosts, r)
+	}
+	sort.Slice(snap.Hosts, func(i, j int) bool { return snap.Hosts[i].Host < snap.Hosts[j].Host })
+	return snap
+}
+
+//go:embed matrix.html
+var matrixPage []byte
+
+func (m *matrix) pageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(matrixPage)
+}
+
+// eventsHandler streams a snapshot of the matrix every matrixInterval as
+// server-sent events.
+func (m *matrix) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ticker := time.NewTicker(matrixInterval)
+	defer ticker.Stop()
+	for {
+		b, err := json.Marshal(m.snapshot(time.Now()))
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// matrixEventsHandler serves the eventsHandler of the matrix of the tenant=
+// parameter (empty for the main log directory).
+func matrixEventsHandler(servers map[string]*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := servers[r.FormValue("tenant")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown tenant %q", r.FormValue("tenant")), http.StatusNotFound)
+			return
+		}
+		s.matrix.eventsHandler(w, r)
+	}
+}
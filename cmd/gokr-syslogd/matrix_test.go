@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatrix(t *testing.T) {
+	m := newMatrix()
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 0, time.UTC)
+	for _, msg := range []message{
+		{hostname: "dr", severity: 6, received: now},
+		{hostname: "dr", severity: 3, received: now},
+		{hostname: "dr", severity: 6, received: now.Add(-2 * time.Minute)},
+		{hostname: "dr", severity: 6, received: now.Add(-matrixMinutes * time.Minute)}, // too old
+		{hostname: "scan2drive", severity: -1, received: now.Add(-matrixMinutes * time.Minute)},
+		{hostname: "apu", severity: 2, received: now.Add(-1 * time.Minute)},
+	} {
+		m.observe(msg)
+	}
+	snap := m.snapshot(now)
+	if got, want := len(snap.Minutes), matrixMinutes; got != want {
+		t.Fatalf("len(Minutes) = %d, want %d", got, want)
+	}
+	if got, want := snap.Minutes[matrixMinutes-1], now.Truncate(time.Minute); !got.Equal(want) {
+		t.Errorf("last minute = %v, want %v", got, want)
+	}
+	type cell struct{ Host, Messages, Errors string }
+	format := func(counts []int) string {
+		var b []byte
+		for _, c := range counts[matrixMinutes-3:] {
+			b = append(b, byte('0'+c))
+		}
+		return string(b)
+	}
+	var got []cell
+	for _, h := range snap.Hosts {
+		got = append(got, cell{h.Host, format(h.Messages), format(h.Errors)})
+	}
+	// scan2drive was silent for the last hour and is dropped.
+	want := []cell{
+		{"apu", "010", "010"},
+		{"dr", "102", "001"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected snapshot: diff (-want +got):\n%s", diff)
+	}
+}
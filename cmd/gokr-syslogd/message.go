@@ -0,0 +1,447 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// Log messages are filed into one file per host and day. Which day a message
+// belongs to is determined by the -day_rule flag:
+//
+//   - dayRuleEvent (the default) files a message into the day of the
+//     timestamp the sender claims. Messages which arrive around midnight
+//     might hence be written into the previous day’s file.
+//   - dayRuleReceive files a message into the day on which gokr-syslogd
+//     received it, according to the local clock. Files are then written
+//     strictly one after the other, and a message can end up in a different
+//     day than its timestamp. With -annotate_day, such lines are marked with
+//     an event_day= field.
+const (
+	dayRuleEvent   = "event"
+	dayRuleReceive = "receive"
+)
+
+// Actions for messages whose timestamp lies further in the future than
+// -max_future_skew, selected by -future_timestamps.
+const (
+	futureReject = "reject"
+	futureClamp  = "clamp" // store with the receive time, see clampedFrom
+)
+
+// placeholderTag is stored for messages without a tag when -accept_tagless is
+// set. Some BusyBox tools do not send a tag.
+const placeholderTag = "-"
+
+// message is a syslog message which passed validation.
+type message struct {
+	hostname  string
+	timestamp time.Time // as claimed by the sender
+	received  time.Time // local clock
+	tag       string
+	content   string
+
+	// severity is the syslog severity, from 0 (emergency) to 7 (debug), or
+	// -1 if unknown.
+	severity int
+
+	// facility is the syslog facility (see facilities), or -1 if unknown.
+	facility int
+
+	// client is the source address of the message, if known, without IPv6
+	// zone.
+	client netip.Addr
+
+	// clientZone is the IPv6 zone of client, e.g. eth0 for a link-local
+	// address.
+	clientZone string
+
+	// zone is the name of the zone containing client, if any.
+	zone string
+
+	// labels are key=value fields split from the tag (see -docker_tag).
+	labels []string
+
+	// spoofed is set when the source address of the message is not bound to
+	// its hostname (see hostSources).
+	spoofed bool
+
+	// retired is set when the message claims the hostname of a host which
+	// was decommissioned with retired.MessagesQuarantine.
+	retired bool
+
+	// signed is set when the message carried a valid signature (see
+	// hmacPrefix).
+	signed bool
+
+	// raw is the original content if sanitize modified it and -keep_raw
+	// is set.
+	raw string
+
+	// route is the name of the route set by a -rules route rule, if any.
+	route string
+
+	// correlationID is the ID extracted from content by -correlation_id,
+	// if any.
+	correlationID string
+
+	// clampedFrom is the timestamp claimed by the sender if it was replaced
+	// with the receive time (see futureClamp).
+	clampedFrom time.Time
+}
+
+// clientString formats the source address of msg, including its IPv6 zone.
+func (msg message) clientString() string {
+	if msg.clientZone != "" {
+		return msg.client.WithZone(msg.clientZone).String()
+	}
+	return msg.client.String()
+}
+
+// parse validates the message contained in logParts.
+func (s *server) parse(logParts messageParts, received time.Time) (message, bool) {
+	// This is an example logParts value: map[
+	//   client:10.0.0.16:58045
+	//   content:Try `iptables -h' or 'iptables --help' for more information.
+	//   facility:0
+	//   hostname:gokrazy
+	//   priority:6 // gokrazy sends all messages at LOG_INFO
+	//   severity:6
+	//   tag:iptables // gokrazy sends the basename of the binary
+	//   timestamp:2022-08-13 14:41:30 +0200 +0200]
+	s.capture(logParts, received, false)
+	msg := message{
+		received: received,
+		severity: -1,
+		facility: -1,
+	}
+	if v, ok := logParts["hostname"]; ok {
+		msg.hostname = v.(string)
+	}
+	if v, ok := logParts["content"]; ok {
+		msg.content = v.(string)
+	}
+	if v, ok := logParts["timestamp"]; ok {
+		msg.timestamp = v.(time.Time)
+	}
+	if v, ok := logParts["tag"]; ok {
+		msg.tag = v.(string)
+	}
+	if v, ok := logParts["severity"]; ok {
+		msg.severity = v.(int)
+	}
+	if v, ok := logParts["facility"]; ok {
+		msg.facility = v.(int)
+	}
+	if v, ok := logParts["labels"]; ok {
+		msg.labels = v.([]string) // RFC5424 structured data
+	}
+	if v, ok := logParts["client"]; ok {
+		client := v.(string)
+		msg.client = clientAddr(client)
+		msg.clientZone = clientZone(client)
+		msg.zone = zoneFor(s.zones, msg.client)
+		if msg.hostname == "" {
+			// E.g. glibc’s syslog(3) and Docker’s syslog log driver
+			// omit the hostname.
+			if host, _, err := net.SplitHostPort(client); err == nil {
+				msg.hostname = host
+			} else {
+				msg.hostname = client
+			}
+		}
+	}
+	if msg.hostname == "" {
+		return s.reject(logParts, received, "no_hostname")
+	}
+	if !validHostname(msg.hostname) {
+		selfLog.Printf("hostname", "dropping message with hostname %q, which cannot be used as a directory name", msg.hostname)
+		return s.reject(logParts, received, "invalid_hostname")
+	}
+	// Ingested messages (see -webhook_ingest) are authenticated by the HTTP
+	// request, not by source address or signature.
+	_, ingested := logParts["ingested"]
+	if s.hostSources != nil && !ingested && !s.hostSources.check(msg.hostname, msg.client) {
+		selfLog.Printf("spoofed", "message claiming hostname %q from unexpected source %s", msg.hostname, msg.clientString())
+		if s.spoofedAction == spoofedDrop {
+			return s.reject(logParts, received, "spoofed")
+		}
+		msg.spoofed = true
+	}
+	if msg.timestamp.IsZero() {
+		return s.reject(logParts, received, "no_timestamp")
+	}
+	if msg.tag == "" {
+		if !s.acceptTagless {
+			return s.reject(logParts, received, "no_tag")
+		}
+		msg.tag = placeholderTag
+	}
+	if msg.content == "" && !s.acceptEmpty {
+		return s.reject(logParts, received, "empty_content")
+	}
+
+	if s.hmacKey != nil && !ingested {
+		signed, valid := verifyHMAC(s.hmacKey, &msg)
+		if signed && !valid {
+			selfLog.Printf("hmac", "dropping message claiming hostname %q with invalid signature", msg.hostname)
+			return s.reject(logParts, received, "hmac_invalid")
+		}
+		if !signed && s.requireHMAC {
+			return s.reject(logParts, received, "hmac_missing")
+		}
+		msg.signed = signed
+	}
+	msg.hostname = s.hostAliases.Resolve(msg.hostname)
+	if m, ok := s.retired.lookup(hostDirName(msg.hostname)); ok {
+		if m.Messages != retired.MessagesQuarantine {
+			return s.reject(logParts, received, "retired")
+		}
+		msg.retired = true
+	}
+
+	msg.tag = sanitizeTag(msg.tag)
+	s.splitDockerTag(&msg)
+	if s.tagFilters != nil {
+		if reason := s.tagFilters.filter(msg.hostname, msg.tag, msg.severity); reason != "" {
+			// Not quarantined: filtered messages are unwanted by definition.
+			s.filtered(reason, msg.hostname)
+			return message{}, false
+		}
+	}
+	if s.rules != nil && !s.rules.apply(&msg) {
+		s.filtered("rule_dropped", msg.hostname)
+		return message{}, false
+	}
+	if s.execFilter != nil {
+		keep, err := s.execFilter.filter(&msg)
+		if err != nil {
+			execFilterErrors.Add(1)
+			selfLog.Printf("exec_filter", "-exec_filter: %v", err)
+		}
+		if !keep {
+			s.filtered("exec_filtered", msg.hostname)
+			return message{}, false
+		}
+	}
+	if content := sanitize(msg.content); content != msg.content {
+		if s.keepRaw {
+			msg.raw = msg.content
+		}
+		msg.content = content
+	}
+	msg.correlationID = s.correlationIDOf(msg.content)
+
+	// Reject too old timestamps to avoid tampering and to make it safe
+	// to compress/rotate old files.
+	if received.Sub(msg.timestamp) > 24*time.Hour {
+		selfLog.Printf("clock_drift", "dropping message with timestamp with too large clock drift: timestamp %v", msg.timestamp)
+		return s.reject(logParts, received, "clock_drift")
+	}
+	// Likewise, files of future days would stay active until that day.
+	if s.maxFutureSkew > 0 && msg.timestamp.Sub(received) > s.maxFutureSkew {
+		if s.futureAction != futureClamp {
+			selfLog.Printf("future_timestamp", "dropping message with timestamp too far in the future: timestamp %v", msg.timestamp)
+			return s.reject(logParts, received, "future_timestamp")
+		}
+		clampedTimestamps.Add(1)
+		msg.clampedFrom = msg.timestamp
+		msg.timestamp = received
+	}
+
+	if raw, ok := logParts["raw"].(string); ok {
+		parseError, _ := logParts["parse_error"].(string)
+		observeMisparsed(msg, raw, parseError)
+	}
+	return msg, true
+}
+
+// validHostname reports whether hostname can be used as the name of its
+// directory: the hostname is taken from the message verbatim, so it must not
+// escape the directory (e.g. "..") or contain control characters.
+func validHostname(hostname string) bool {
+	if hostname == "." || hostname == ".." || strings.ContainsAny(hostname, "/\\") {
+		return false
+	}
+	return sanitize(hostname) == hostname
+}
+
+// day returns the time whose date determines the log file msg is written to.
+func (s *server) day(msg message) time.Time {
+	if s.dayRule == dayRuleReceive {
+		return msg.received
+	}
+	return msg.timestamp
+}
+
+// write writes msg into the corresponding log file. It returns whether a line
+// was written.
+func (s *server) write(msg message) bool {
+	day := s.day(msg)
+	basename := day.Format(basenameFormat)
+	if r := s.routeFor(msg); r != nil {
+		basename = day.Format("2006-01-02") + "." + r.name + ".log"
+	}
+	key := fileKey{
+		hostname:   msg.hostname,
+		basename:   basename,
+		quarantine: (msg.spoofed && s.spoofedAction == spoofedQuarantine) || msg.retired,
+	}
+	if s.externalRotation && !key.quarantine {
+		key.basename = externalBasename
+		if r := s.routeFor(msg); r != nil {
+			key.basename = r.name + ".log"
+		}
+	}
+	of, ok := s.file(key)
+	if !ok {
+		return false
+	}
+	// RFC3339Nano omits the fractional second when it is zero, e.g. for
+	// RFC3164 messages, whose timestamps have only second precision.
+	line := fmt.Appendf(s.lineBuf[:0], "rfc3339=%s seq=%d ", msg.timestamp.Format(time.RFC3339Nano), of.seq+1)
+	if s.annotateReceived {
+		line = fmt.Appendf(line, "received=%s ", msg.received.Format(time.RFC3339Nano))
+	}
+	if s.storeClient && msg.client.IsValid() {
+		line = fmt.Appendf(line, "client=%s ", msg.clientString())
+	}
+	if s.annotateDay {
+		if eventDay := msg.timestamp.Format("2006-01-02"); eventDay != day.Format("2006-01-02") {
+			line = fmt.Appendf(line, "event_day=%s ", eventDay)
+		}
+	}
+	if msg.zone != "" {
+		line = fmt.Appendf(line, "zone=%s ", msg.zone)
+	}
+	for _, label := range msg.labels {
+		line = fmt.Appendf(line, "%s ", label)
+	}
+	if (s.storeSeverity || len(s.severityTiers) > 0) && msg.severity >= 0 && msg.severity < len(severity.Names) {
+		line = fmt.Appendf(line, "severity=%s ", severity.Names[msg.severity])
+	}
+	if s.boots != nil && !msg.spoofed {
+		if id := s.bootFor(msg); id != "" {
+			line = fmt.Appendf(line, "boot=%s ", id)
+		}
+	}
+	if s.services != nil {
+		if svc := s.services.lookup(msg.tag); svc != "" {
+			line = fmt.Appendf(line, "service=%s ", svc)
+		}
+	}
+	if msg.correlationID != "" {
+		line = fmt.Appendf(line, "correlation_id=%s ", msg.correlationID)
+	}
+	if msg.spoofed {
+		line = fmt.Appendf(line, "spoofed_from=%s ", msg.clientString())
+	}
+	if !msg.clampedFrom.IsZero() {
+		line = fmt.Appendf(line, "clamped_from=%s ", msg.clampedFrom.Format(time.RFC3339Nano))
+	}
+	if msg.signed {
+		line = append(line, "hmac=ok "...)
+	}
+	if msg.raw != "" {
+		line = fmt.Appendf(line, "raw=%s ", base64.StdEncoding.EncodeToString([]byte(msg.raw)))
+	}
+	line = fmt.Appendf(line, "%s: %s\n", msg.tag, msg.content)
+	s.lineBuf = line
+	offset := of.size
+	if !s.buffer(of, line) {
+		return false
+	}
+	if !key.quarantine {
+		s.observeEntities(msg, key.basename, offset)
+	}
+	if s.mirror != nil && s.outputRules.matches(outputStdout, msg.hostname, msg.tag, msg.severity) {
+		s.mirrorLine(msg)
+	}
+	return true
+}
+
+// file returns the open log file identified by key, opening it if needed.
+func (s *server) file(key fileKey) (*openFile, bool) {
+	if of, ok := s.files[key]; ok {
+		return of, true
+	}
+	f, err := s.openFile(key)
+	if err != nil {
+		selfLog.Printf("open", "error opening log file: %v", err)
+		drop("open_failed")
+		return nil, false
+	}
+	seq, err := lastSeq(f)
+	if err != nil {
+		f.Close()
+		selfLog.Printf("open", "error reading sequence number from log file: %v", err)
+		drop("open_failed")
+		return nil, false
+	}
+	var size int64
+	if st, err := f.Stat(); err == nil {
+		size = st.Size()
+	}
+	of := &openFile{
+		f:    f,
+		seq:  seq,
+		size: size,
+	}
+	s.files[key] = of
+	if s.hasDay(key) {
+		s.syncFinishedFiles(key.hostname, key.basename)
+	}
+	return of, true
+}
+
+// buffer appends line, which must carry sequence number of.seq+1, to the
+// buffered lines of of. It returns false if too many bytes are buffered.
+func (s *server) buffer(of *openFile, line []byte) bool {
+	if s.bufferedBytes()+len(line) > s.bufferLimit {
+		selfLog.Printf("buffer_full", "dropping message: %d bytes already buffered in memory", s.bufferedBytes())
+		drop("buffer_full")
+		return false
+	}
+	of.buf.Write(line)
+	of.size += int64(len(line))
+	of.lastUse = time.Now()
+	of.seq++
+	of.synced = false
+	return true
+}
+
+// reorderQueue holds messages for the duration of the -reorder_window so that
+// messages which arrive slightly out of order are written in timestamp order.
+// It implements heap.Interface, ordered by timestamp.
+type reorderQueue []message
+
+func (q reorderQueue) Len() int           { return len(q) }
+func (q reorderQueue) Less(i, j int) bool { return q[i].timestamp.Before(q[j].timestamp) }
+func (q reorderQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *reorderQueue) Push(x any) { *q = append(*q, x.(message)) }
+
+func (q *reorderQueue) Pop() any {
+	old := *q
+	n := len(old)
+	msg := old[n-1]
+	*q = old[:n-1]
+	return msg
+}
+
+// release pops all messages (in timestamp order) which were received at least
+// window ago.
+func (q *reorderQueue) release(now time.Time, window time.Duration) []message {
+	var released []message
+	for q.Len() > 0 && now.Sub((*q)[0].received) >= window {
+		released = append(released, heap.Pop(q).(message))
+	}
+	return released
+}
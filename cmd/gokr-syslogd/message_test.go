@@ -0,0 +1,468 @@
+package main
+
+import (
+	"container/heap"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReorderQueue(t *testing.T) {
+	start := time.Date(2022, time.August, 13, 23, 59, 59, 0, time.Local)
+	var q reorderQueue
+	for _, msg := range []message{
+		{tag: "b", timestamp: start.Add(2 * time.Second), received: start},
+		{tag: "a", timestamp: start.Add(1 * time.Second), received: start.Add(100 * time.Millisecond)},
+		{tag: "c", timestamp: start.Add(3 * time.Second), received: start.Add(5 * time.Second)},
+	} {
+		heap.Push(&q, msg)
+	}
+	tags := func(msgs []message) []string {
+		var tags []string
+		for _, msg := range msgs {
+			tags = append(tags, msg.tag)
+		}
+		return tags
+	}
+
+	// a arrived after b, but has an earlier timestamp: within the window, a is
+	// written first.
+	if diff := cmp.Diff([]string{"a", "b"}, tags(q.release(start.Add(2*time.Second), 1*time.Second))); diff != "" {
+		t.Errorf("release(): unexpected diff (-want +got):\n%s", diff)
+	}
+	if got := tags(q.release(start.Add(5*time.Second), 1*time.Second)); got != nil {
+		t.Errorf("release() = %v, want nil (c still within window)", got)
+	}
+	if diff := cmp.Diff([]string{"c"}, tags(q.release(start.Add(6*time.Second), 1*time.Second))); diff != "" {
+		t.Errorf("release(): unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDayRule(t *testing.T) {
+	midnight := time.Date(2022, time.August, 14, 0, 0, 0, 0, time.Local)
+	msg := message{
+		hostname:  "dr",
+		timestamp: midnight.Add(-1 * time.Second),
+		received:  midnight.Add(1 * time.Second),
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	}
+	for _, tt := range []struct {
+		dayRule string
+		want    string
+	}{
+		{dayRule: dayRuleEvent, want: "2022-08-13.log"},
+		{dayRule: dayRuleReceive, want: "2022-08-14.log"},
+	} {
+		srv := server{dayRule: tt.dayRule}
+		if got := srv.day(msg).Format(basenameFormat); got != tt.want {
+			t.Errorf("day_rule=%s: day() = %s, want %s", tt.dayRule, got, tt.want)
+		}
+	}
+}
+
+func TestParseFallbacks(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	for _, tt := range []struct {
+		desc     string
+		srv      server
+		logParts messageParts
+		wantOK   bool
+		wantTag  string
+		wantDrop string
+	}{
+		{
+			desc: "complete",
+			logParts: messageParts{
+				"hostname":  "dr",
+				"tag":       "dhcpd",
+				"content":   "DHCPDISCOVER",
+				"timestamp": now,
+			},
+			wantOK:  true,
+			wantTag: "dhcpd",
+		},
+		{
+			desc: "tagless dropped",
+			logParts: messageParts{
+				"hostname":  "dr",
+				"content":   "DHCPDISCOVER",
+				"timestamp": now,
+			},
+			wantDrop: "no_tag",
+		},
+		{
+			desc: "tagless accepted",
+			srv:  server{acceptTagless: true},
+			logParts: messageParts{
+				"hostname":  "dr",
+				"content":   "DHCPDISCOVER",
+				"timestamp": now,
+			},
+			wantOK:  true,
+			wantTag: placeholderTag,
+		},
+		{
+			desc: "empty dropped",
+			logParts: messageParts{
+				"hostname":  "dr",
+				"tag":       "dhcpd",
+				"timestamp": now,
+			},
+			wantDrop: "empty_content",
+		},
+		{
+			desc: "empty accepted",
+			srv:  server{acceptEmpty: true},
+			logParts: messageParts{
+				"hostname":  "dr",
+				"tag":       "dhcpd",
+				"content":   "",
+				"timestamp": now,
+			},
+			wantOK:  true,
+			wantTag: "dhcpd",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			var before int64
+			if tt.wantDrop != "" {
+				if v, ok := droppedMessages.Get(tt.wantDrop).(*expvar.Int); ok {
+					before = v.Value()
+				}
+			}
+			msg, ok := tt.srv.parse(tt.logParts, now)
+			if ok != tt.wantOK {
+				t.Fatalf("parse() = %v, want %v", ok, tt.wantOK)
+			}
+			if msg.tag != tt.wantTag {
+				t.Errorf("parse(): tag = %q, want %q", msg.tag, tt.wantTag)
+			}
+			if tt.wantDrop != "" {
+				v, _ := droppedMessages.Get(tt.wantDrop).(*expvar.Int)
+				if v == nil || v.Value() != before+1 {
+					t.Errorf("dropped_messages[%s] not incremented", tt.wantDrop)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSequence(t *testing.T) {
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	msg := message{
+		hostname:  "dr",
+		timestamp: ts,
+		received:  ts,
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	}
+	srv.write(msg)
+	msg.timestamp = ts.Add(1500 * time.Millisecond)
+	srv.write(msg)
+	// Closing and re-opening the file must continue the sequence.
+	srv.closeUnusedFiles(time.Now().Add(1 * time.Hour))
+	msg.timestamp = ts.Add(2 * time.Second)
+	srv.write(msg)
+	srv.flushFiles()
+
+	b, err := os.ReadFile(filepath.Join(srv.dir, "dr", "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER
+rfc3339=2022-08-13T16:20:01.5Z seq=2 dhcpd: DHCPDISCOVER
+rfc3339=2022-08-13T16:20:02Z seq=3 dhcpd: DHCPDISCOVER
+`
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteReceived(t *testing.T) {
+	srv := server{
+		dir:              t.TempDir(),
+		files:            make(map[fileKey]*openFile),
+		annotateReceived: true,
+		bufferLimit:      1 << 20,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	srv.write(message{
+		hostname:  "dr",
+		timestamp: ts,
+		received:  ts.Add(90 * time.Second),
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	})
+	srv.flushFiles()
+
+	b, err := os.ReadFile(filepath.Join(srv.dir, "dr", "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rfc3339=2022-08-13T16:20:00Z seq=1 received=2022-08-13T16:21:30Z dhcpd: DHCPDISCOVER\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteClient(t *testing.T) {
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		client string
+		want   string
+	}{
+		{client: "10.0.0.16:58045", want: "client=10.0.0.16"},
+		{client: "[::ffff:10.0.0.16]:58045", want: "client=10.0.0.16"},
+		{client: "[2001:db8::7]:514", want: "client=2001:db8::7"},
+		{client: "[fe80::1%eth0]:514", want: "client=fe80::1%eth0"},
+		{client: "[fe80::1%bad zone]:514", want: "client=fe80::1"},
+	} {
+		t.Run(tt.client, func(t *testing.T) {
+			srv := server{
+				dir:         t.TempDir(),
+				files:       make(map[fileKey]*openFile),
+				storeClient: true,
+				bufferLimit: 1 << 20,
+			}
+			msg, ok := srv.parse(messageParts{
+				"hostname":  "dr",
+				"tag":       "dhcpd",
+				"content":   "DHCPDISCOVER",
+				"timestamp": ts,
+				"client":    tt.client,
+			}, ts)
+			if !ok {
+				t.Fatalf("parse(client=%s) unexpectedly failed", tt.client)
+			}
+			srv.write(msg)
+			srv.flushFiles()
+			b, err := os.ReadFile(filepath.Join(srv.dir, "dr", "2022-08-13.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "rfc3339=2022-08-13T16:20:00Z seq=1 " + tt.want + " dhcpd: DHCPDISCOVER\n"
+			if diff := cmp.Diff(want, string(b)); diff != "" {
+				t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFlushRetainsLinesOnError(t *testing.T) {
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	msg := message{
+		hostname:  "dr",
+		timestamp: ts,
+		received:  ts,
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	}
+	if !srv.write(msg) {
+		t.Fatal("write() = false")
+	}
+	key := fileKey{hostname: "dr", basename: "2022-08-13.log"}
+	of := srv.files[key]
+	fn := of.f.Name()
+
+	// Simulate a write error by swapping in a read-only file.
+	rw := of.f
+	ro, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	of.f = ro
+	if err := srv.flushFiles(); err == nil {
+		t.Fatal("flushFiles() unexpectedly succeeded")
+	}
+	if got, want := srv.bufferedBytes(), of.buf.Len(); got == 0 || got != want {
+		t.Fatalf("bufferedBytes() = %d after failed flush, want %d (> 0)", got, want)
+	}
+
+	of.f = rw
+	if err := srv.flushFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.bufferedBytes(); got != 0 {
+		t.Fatalf("bufferedBytes() = %d after successful flush, want 0", got)
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteBufferLimit(t *testing.T) {
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 100,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	msg := message{
+		hostname:  "dr",
+		timestamp: ts,
+		received:  ts,
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	}
+	if !srv.write(msg) {
+		t.Fatal("write() = false, want true")
+	}
+	if srv.write(msg) {
+		t.Fatal("write() = true, want false (buffer limit exceeded)")
+	}
+}
+
+func TestParseHostAlias(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	srv := server{hostAliases: hostalias.Map{"raspberrypi": "dr"}}
+	for _, tt := range []struct {
+		hostname string
+		want     string
+	}{
+		{hostname: "raspberrypi", want: "dr"},
+		{hostname: "dr", want: "dr"},
+		{hostname: "router7", want: "router7"},
+	} {
+		msg, ok := srv.parse(messageParts{
+			"hostname":  tt.hostname,
+			"tag":       "dhcpd",
+			"content":   "DHCPDISCOVER",
+			"timestamp": now,
+		}, now)
+		if !ok {
+			t.Fatalf("parse(hostname=%s) unexpectedly failed", tt.hostname)
+		}
+		if msg.hostname != tt.want {
+			t.Errorf("parse(hostname=%s): hostname = %q, want %q", tt.hostname, msg.hostname, tt.want)
+		}
+	}
+}
+
+func TestParseFutureTimestamp(t *testing.T) {
+	received := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		action string
+		skew   time.Duration
+		want   string // empty if rejected
+	}{
+		{action: futureReject, skew: 23 * time.Hour, want: "rfc3339=2022-08-14T15:20:00Z seq=1 dhcpd: DHCPDISCOVER\n"},
+		{action: futureReject, skew: 25 * time.Hour},
+		{action: futureClamp, skew: 365 * 24 * time.Hour, want: "rfc3339=2022-08-13T16:20:00Z seq=1 clamped_from=2023-08-13T16:20:00Z dhcpd: DHCPDISCOVER\n"},
+	} {
+		t.Run(fmt.Sprintf("%s/%v", tt.action, tt.skew), func(t *testing.T) {
+			srv := server{
+				dir:           t.TempDir(),
+				files:         make(map[fileKey]*openFile),
+				bufferLimit:   1 << 20,
+				maxFutureSkew: 24 * time.Hour,
+				futureAction:  tt.action,
+			}
+			var before int64
+			if v, ok := droppedMessages.Get("future_timestamp").(*expvar.Int); ok {
+				before = v.Value()
+			}
+			msg, ok := srv.parse(messageParts{
+				"hostname":  "dr",
+				"tag":       "dhcpd",
+				"content":   "DHCPDISCOVER",
+				"timestamp": received.Add(tt.skew),
+			}, received)
+			if ok != (tt.want != "") {
+				t.Fatalf("parse() = %v, want %v", ok, tt.want != "")
+			}
+			if !ok {
+				v, _ := droppedMessages.Get("future_timestamp").(*expvar.Int)
+				if v == nil || v.Value() != before+1 {
+					t.Errorf("dropped_messages[future_timestamp] not incremented")
+				}
+				return
+			}
+			srv.write(msg)
+			srv.flushFiles()
+			fn := filepath.Join(srv.dir, "dr", msg.timestamp.Format(basenameFormat))
+			b, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, string(b)); diff != "" {
+				t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// FuzzParse feeds datagrams through the parser, as a collector listening on an
+// open UDP port would receive them. Accepted messages must result in a stored
+// line which logline reads back, in a file within the host’s directory.
+func FuzzParse(f *testing.F) {
+	for _, raw := range []string{
+		"<30>Aug 13 16:20:00 dr dhcpd[123]: DHCPDISCOVER from 00:0d:b9:4a:7e:20 via eth0",
+		"<30>2022-08-13T16:20:00+02:00 dr ntpd[7]: clock synchronized",
+		"<6>Aug 13 16:20:00 dr kernel: [    1.234567] Booting Linux on physical CPU 0x0",
+		"<12>Aug 13 16:20:00 winbox Service_Control_Manager: channel=System event_id=7036 The Windows Update service entered the stopped state.",
+		"<30>Aug 13 16:20:00 nginx/web-1[1234]: GET / HTTP/1.1",
+		"<14>Aug 13 16:20:00 dr backup: hmac=0123abcd backup completed",
+		"<14>Aug  3 16:20:00 dr : no tag here",
+		"<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER",
+		"57 <14>Aug 13 16:20:00 dr dhcpd: DHCPDISCOVER",
+		`{"level":"info","msg":"DHCPDISCOVER"}`,
+		"<14>Aug 13 16:20:00 dr dhcpd: \x1b[31mred\x1b[0m\nrfc3339=fake seq=1 injected: line",
+		"<191>Aug 13 16:20:00 dr sshd[1]: Invalid user admin from 203.0.113.7 port 22 \xff\xfe",
+	} {
+		f.Add(raw)
+	}
+	srv := server{
+		dockerTag:     []string{"image", "container"},
+		hmacKey:       []byte("secret"),
+		acceptTagless: true,
+	}
+	// Timestamps without year are in the year closest to received, see
+	// rfc3164.Parse.
+	received := time.Date(time.Now().Year(), time.August, 14, 16, 0, 0, 0, time.Local)
+	f.Fuzz(func(t *testing.T, raw string) {
+		logParts, _ := rawFormat{}.parse([]byte(raw))
+		logParts["client"] = "10.0.0.16:58045"
+		detectFormat(raw)
+		msg, ok := srv.parse(logParts, received)
+		if !ok {
+			return
+		}
+		dir := hostDirName(msg.hostname)
+		if dir == "" || dir == "." || dir == ".." || filepath.Base(dir) != dir {
+			t.Fatalf("parse(%q): hostname %q is not a directory name", raw, msg.hostname)
+		}
+		rest := msg.tag + ": " + msg.content
+		if strings.ContainsAny(rest, "\r\n") {
+			t.Fatalf("parse(%q): tag or content contain a line break: %q", raw, rest)
+		}
+		line := "rfc3339=" + msg.timestamp.Format(time.RFC3339Nano) + " seq=1 " + rest
+		if got := logline.Strip(line); got != rest {
+			t.Fatalf("parse(%q): stored line %q reads back as %q, want %q", raw, line, got, rest)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// droppedMessages counts messages which were not written to a log file, keyed
+// by reason. Like all expvar variables, it is available at /debug/vars.
+var droppedMessages = expvar.NewMap("dropped_messages")
+
+// filterReasons are the reasons with which -tag_filters, -rules and
+// -exec_filter drop messages.
+var filterReasons = []string{"tag_filtered", "severity_floor", "rule_dropped", "exec_filtered"}
+
+// filteredMessages breaks down the messages dropped by filters by
+// reason, then by host.
+var filteredMessages = expvar.NewMap("filtered_messages")
+
+func init() {
+	for _, reason := range filterReasons {
+		filteredMessages.Set(reason, new(expvar.Map))
+	}
+}
+
+var (
+	// writeErrors counts failed attempts to flush buffered lines to disk.
+	writeErrors = expvar.NewInt("write_errors")
+
+	// bufferedBytesVar is the number of bytes buffered in memory as of the
+	// last flush attempt.
+	bufferedBytesVar = expvar.NewInt("buffered_bytes")
+
+	// clampedTimestamps counts messages whose timestamp was replaced with the
+	// receive time (see -future_timestamps).
+	clampedTimestamps = expvar.NewInt("clamped_timestamps")
+
+	// execFilterErrors counts messages which -exec_filter failed to process.
+	execFilterErrors = expvar.NewInt("exec_filter_errors")
+)
+
+// writeState records whether writing to disk currently fails, as reported by
+// /health.
+var writeState struct {
+	sync.Mutex
+	err      error     // most recent write error, nil if writes succeed
+	since    time.Time // when writes started failing
+	readOnly bool      // see -read_only
+}
+
+// setReadOnly records that gokr-syslogd does not accept messages.
+func setReadOnly() {
+	writeState.Lock()
+	defer writeState.Unlock()
+	writeState.readOnly = true
+}
+
+// setWriteError updates writeState with the result of the most recent flush.
+func setWriteError(err error) {
+	writeState.Lock()
+	defer writeState.Unlock()
+	if err != nil && writeState.err == nil {
+		writeState.since = time.Now()
+	}
+	writeState.err = err
+}
+
+// healthHandler responds with HTTP 503 while writing to disk fails, so that
+// monitoring notices that log lines are only buffered in memory.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeState.Lock()
+	err, since, readOnly := writeState.err, writeState.since, writeState.readOnly
+	writeState.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if readOnly {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "read-only: not accepting messages\n")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "degraded: writes failing since %v (%d bytes buffered): %v\n",
+			since.Format(time.RFC3339), bufferedBytesVar.Value(), err)
+		return
+	}
+	fmt.Fprintf(w, "ok\n")
+}
+
+// drop records that a message was dropped for the specified reason.
+func drop(reason string) {
+	droppedMessages.Add(reason, 1)
+}
+
+// filtered records that a message of hostname was dropped by -tag_filters for
+// the specified reason (one of filterReasons).
+func (s *server) filtered(reason, hostname string) {
+	drop(reason)
+	filteredMessages.Get(reason).(*expvar.Map).Add(hostname, 1)
+	if s.hostMetrics != nil {
+		s.hostMetrics.observeDrop(hostname, reason)
+	}
+}
+
+// metricsHandler serves the counters in the Prometheus text exposition format.
+// servers are the main server followed by those of the tenants.
+func metricsHandler(servers []*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeMetrics(w, servers)
+	}
+}
+
+// writeMetrics writes the counters of servers (see metricsHandler).
+func writeMetrics(w http.ResponseWriter, servers []*server) {
+	s := servers[0]
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP syslogd_dropped_messages_total Messages which were not written to a log file.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_dropped_messages_total counter\n")
+	droppedMessages.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "syslogd_dropped_messages_total{reason=%q} %s\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "# HELP syslogd_filtered_messages_total Messages which were dropped by -tag_filters, -rules or -exec_filter, by host.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_filtered_messages_total counter\n")
+	for _, reason := range filterReasons {
+		filteredMessages.Get(reason).(*expvar.Map).Do(func(kv expvar.KeyValue) {
+			fmt.Fprintf(w, "syslogd_filtered_messages_total{reason=%q,host=%q} %s\n", reason, kv.Key, kv.Value)
+		})
+	}
+	fmt.Fprintf(w, "# HELP syslogd_suppressed_log_messages_total Log messages of gokr-syslogd itself which were suppressed by rate limiting.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_suppressed_log_messages_total counter\n")
+	suppressedLogMessages.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "syslogd_suppressed_log_messages_total{class=%q} %s\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "# HELP syslogd_spooled_datagrams_total Datagrams which were spooled unparsed during overload (see -spool_dir).\n")
+	fmt.Fprintf(w, "# TYPE syslogd_spooled_datagrams_total counter\n")
+	fmt.Fprintf(w, "syslogd_spooled_datagrams_total %d\n", spooledDatagrams.Value())
+	fmt.Fprintf(w, "# HELP syslogd_replayed_datagrams_total Spooled datagrams which were parsed and passed on to be written.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_replayed_datagrams_total counter\n")
+	fmt.Fprintf(w, "syslogd_replayed_datagrams_total %d\n", replayedDatagrams.Value())
+	fmt.Fprintf(w, "# HELP syslogd_write_errors_total Failed attempts to write buffered log lines to disk.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_write_errors_total counter\n")
+	fmt.Fprintf(w, "syslogd_write_errors_total %d\n", writeErrors.Value())
+	fmt.Fprintf(w, "# HELP syslogd_alerts_total Messages which matched -rules alert rules, by alert.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_alerts_total counter\n")
+	alertMatches.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "syslogd_alerts_total{alert=%q} %s\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "# HELP syslogd_exec_filter_errors_total Messages which -exec_filter failed to process (and which were kept unchanged).\n")
+	fmt.Fprintf(w, "# TYPE syslogd_exec_filter_errors_total counter\n")
+	fmt.Fprintf(w, "syslogd_exec_filter_errors_total %d\n", execFilterErrors.Value())
+	fmt.Fprintf(w, "# HELP syslogd_clamped_timestamps_total Messages whose timestamp was too far in the future and replaced with the receive time.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_clamped_timestamps_total counter\n")
+	fmt.Fprintf(w, "syslogd_clamped_timestamps_total %d\n", clampedTimestamps.Value())
+	fmt.Fprintf(w, "# HELP syslogd_buffered_bytes Bytes of log lines buffered in memory.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_buffered_bytes gauge\n")
+	fmt.Fprintf(w, "syslogd_buffered_bytes %d\n", bufferedBytesVar.Value())
+	writeVerifyMetrics(w)
+	writeParseFailureMetrics(w)
+	if s.anomalies != nil {
+		s.anomalies.writeAnomalyMetrics(w)
+	}
+	if s.hostMetrics != nil {
+		all := make([]*hostMetrics, 0, len(servers))
+		for _, s := range servers {
+			all = append(all, s.hostMetrics)
+		}
+		writeHostMetrics(w, all)
+	}
+}
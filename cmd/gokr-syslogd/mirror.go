@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+// mirrorLine appends a line for msg to the lines which are mirrored to
+// s.mirror (see -stdout) on the next flush. The line is prefixed with the
+// hostname, as the output combines all hosts.
+func (s *server) mirrorLine(msg message) {
+	if s.mirrorBuf.Len() > s.bufferLimit {
+		drop("mirror_full")
+		return
+	}
+	fmt.Fprintf(&s.mirrorBuf, "%s %s: %s\n", msg.hostname, msg.tag, msg.content)
+}
+
+// flushMirror writes the mirrored lines to s.mirror. Lines which cannot be
+// written are discarded: the log files are the durable store.
+func (s *server) flushMirror() {
+	if s.mirrorBuf.Len() == 0 {
+		return
+	}
+	if _, err := s.mirror.Write(s.mirrorBuf.Bytes()); err != nil {
+		selfLog.Printf("mirror", "mirroring lines: %v", err)
+	}
+	s.mirrorBuf.Reset()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMirror(t *testing.T) {
+	var mirror bytes.Buffer
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+		mirror:      &mirror,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, msg := range []message{
+		{hostname: "dr", tag: "dhcpd", content: "DHCPDISCOVER"},
+		{hostname: "scan2drive", tag: "scan2drive", content: "scan complete"},
+	} {
+		msg.timestamp = ts
+		msg.received = ts
+		srv.write(msg)
+	}
+	if mirror.Len() > 0 {
+		t.Errorf("lines mirrored before flush: %q", mirror.String())
+	}
+	if err := srv.flushFiles(); err != nil {
+		t.Fatal(err)
+	}
+	want := "dr dhcpd: DHCPDISCOVER\n" +
+		"scan2drive scan2drive: scan complete\n"
+	if diff := cmp.Diff(want, mirror.String()); diff != "" {
+		t.Errorf("mirror: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+)
+
+func TestPerHostOrdering(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		flushIdle:     1 * time.Millisecond,
+		flushMaxDelay: 5 * time.Millisecond,
+		bufferLimit:   8 << 20,
+		retentionNow:  make(chan struct{}, 1),
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	const (
+		hosts      = 8
+		perHost    = 500
+		timestamps = 3 // messages from the same host share timestamps
+	)
+	now := time.Now().Truncate(time.Second) // all timestamps on the same day
+	var wg sync.WaitGroup
+	for h := 0; h < hosts; h++ {
+		h := h // copy
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perHost; i++ {
+				channel <- messageParts{
+					"hostname":  fmt.Sprintf("host%d", h),
+					"tag":       "hammer",
+					"content":   strconv.Itoa(i),
+					"timestamp": now.Add(time.Duration(i%timestamps) * time.Millisecond),
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(channel)
+	<-done
+
+	for h := 0; h < hosts; h++ {
+		fn := filepath.Join(srv.dir, fmt.Sprintf("host%d", h), now.Format(basenameFormat))
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var want int
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			content := strings.TrimPrefix(logline.Strip(line), "hammer: ")
+			got, err := strconv.Atoi(content)
+			if err != nil {
+				t.Fatalf("%s: malformed line %q", fn, line)
+			}
+			if got != want {
+				t.Fatalf("%s: got message %d, want %d (out of order)", fn, got, want)
+			}
+			if seq, _ := logline.Field(line, "seq"); seq != strconv.Itoa(want+1) {
+				t.Fatalf("%s: seq=%s, want %d", fn, seq, want+1)
+			}
+			want++
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if want != perHost {
+			t.Errorf("%s: got %d messages, want %d", fn, want, perHost)
+		}
+	}
+}
+
+// TestConcurrentIngestion exercises the write loop while other goroutines
+// flush, inspect the open files and close them (SIGHUP), which is only safe
+// because all of them go through the write loop. Run with -race.
+func TestConcurrentIngestion(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		flushIdle:     1 * time.Millisecond,
+		flushMaxDelay: 5 * time.Millisecond,
+		bufferLimit:   8 << 20,
+		retentionNow:  make(chan struct{}, 1),
+		flushRequests: make(chan chan error),
+		loopRequests:  make(chan func()),
+		hangup:        make(chan struct{}, 1),
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	const (
+		hosts   = 4
+		perHost = 300
+	)
+	now := time.Now().Truncate(time.Second)
+	var senders sync.WaitGroup
+	for h := 0; h < hosts; h++ {
+		h := h // copy
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for i := 0; i < perHost; i++ {
+				channel <- messageParts{
+					"hostname":  fmt.Sprintf("host%d", h),
+					"tag":       "hammer",
+					"content":   strconv.Itoa(i),
+					"timestamp": now,
+				}
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	var controllers sync.WaitGroup
+	for _, control := range []func() error{
+		func() error { return srv.flush(context.Background()) },
+		func() error {
+			return srv.onWriteLoop(context.Background(), func() {
+				for _, of := range srv.files {
+					_ = of.buf.Len()
+				}
+			})
+		},
+		func() error {
+			select {
+			case srv.hangup <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+	} {
+		control := control // copy
+		controllers.Add(1)
+		go func() {
+			defer controllers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := control(); err != nil {
+					t.Error(err)
+					return
+				}
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+	senders.Wait()
+	close(stop)
+	controllers.Wait()
+	close(channel)
+	<-done
+
+	for h := 0; h < hosts; h++ {
+		fn := filepath.Join(srv.dir, fmt.Sprintf("host%d", h), now.Format(basenameFormat))
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		if len(lines) != perHost {
+			t.Errorf("%s: got %d lines, want %d", fn, len(lines), perHost)
+		}
+		for i, line := range lines {
+			if got, want := strings.TrimPrefix(logline.Strip(line), "hammer: "), strconv.Itoa(i); got != want {
+				t.Fatalf("%s: line %d is message %s, want %s", fn, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outboxSuffix is the file name suffix of queued payloads.
+const outboxSuffix = ".payload"
+
+const (
+	outboxMinBackoff = 1 * time.Second
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+var (
+	// outboxDelivered counts payloads which were delivered to an output.
+	outboxDelivered = expvar.NewInt("output_spool_delivered")
+
+	// outboxRetries counts failed delivery attempts, which are retried.
+	outboxRetries = expvar.NewInt("output_spool_retries")
+
+	// outboxPendingBytes is the number of bytes queued in all outboxes.
+	outboxPendingBytes = expvar.NewInt("output_spool_pending_bytes")
+)
+
+// outbox delivers payloads to an external output (see -output_spool_dir) at
+// least once: each payload is written to a file in dir before delivery is
+// attempted, and only removed once deliver succeeded. Payloads are delivered
+// in order; while the output fails, delivery is retried with exponential
+// backoff, and the payloads wait on disk (surviving restarts) until maxBytes
+// are queued, after which new payloads are dropped.
+type outbox struct {
+	name     string // for logging, e.g. the URL of the output
+	dir      string
+	maxBytes int64
+	deliver  func([]byte) error
+
+	minBackoff, maxBackoff time.Duration
+
+	wake chan struct{}
+
+	mu    sync.Mutex
+	seq   uint64 // of the most recently queued payload
+	bytes int64  // queued in dir
+}
+
+// newOutbox returns an outbox for the output name, queueing in a
+// subdirectory of dir which is derived from name. Payloads which were queued
+// before a restart are delivered first.
+func newOutbox(dir, name string, maxBytes int64, deliver func([]byte) error) (*outbox, error) {
+	o := &outbox{
+		name:       name,
+		dir:        filepath.Join(dir, fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:16]),
+		maxBytes:   maxBytes,
+		deliver:    deliver,
+		minBackoff: outboxMinBackoff,
+		maxBackoff: outboxMaxBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+	if err := os.MkdirAll(o.dir, 0700); err != nil {
+		return nil, err
+	}
+	// Record the output, so that operators can tell the directories apart.
+	if err := os.WriteFile(filepath.Join(o.dir, "OUTPUT"), []byte(name+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	names, err := o.pending()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		st, err := os.Stat(filepath.Join(o.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		o.bytes += st.Size()
+		if seq, err := strconv.ParseUint(strings.TrimSuffix(name, outboxSuffix), 10, 64); err == nil && seq > o.seq {
+			o.seq = seq
+		}
+	}
+	outboxPendingBytes.Add(o.bytes)
+	return o, nil
+}
+
+// pending returns the names of the queued payloads, oldest first.
+func (o *outbox) pending() ([]string, error) {
+	fis, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".") || !strings.HasSuffix(fi.Name(), outboxSuffix) {
+			continue // e.g. a temporary file of an interrupted enqueue
+		}
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names) // zero-padded sequence numbers
+	return names, nil
+}
+
+// enqueue durably queues payload for delivery.
+func (o *outbox) enqueue(payload []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.bytes+int64(len(payload)) > o.maxBytes {
+		drop("output_spool_full")
+		return fmt.Errorf("%s: spool full (%d bytes queued)", o.name, o.bytes)
+	}
+	o.seq++
+	fn := filepath.Join(o.dir, fmt.Sprintf("%020d%s", o.seq, outboxSuffix))
+	pf, err := newPendingFile(fn, 0600)
+	if err != nil {
+		return err
+	}
+	defer pf.Cleanup()
+	if _, err := pf.Write(payload); err != nil {
+		return err
+	}
+	if err := pf.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	o.bytes += int64(len(payload))
+	outboxPendingBytes.Add(int64(len(payload)))
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deliverPending delivers the queued payloads in order, stopping at the first
+// failure.
+func (o *outbox) deliverPending(ctx context.Context) error {
+	names, err := o.pending()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn := filepath.Join(o.dir, name)
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return err
+		}
+		if err := o.deliver(b); err != nil {
+			return err
+		}
+		if err := os.Remove(fn); err != nil {
+			return err
+		}
+		outboxDelivered.Add(1)
+		o.mu.Lock()
+		o.bytes -= int64(len(b))
+		o.mu.Unlock()
+		outboxPendingBytes.Add(-int64(len(b)))
+	}
+	return nil
+}
+
+// run delivers queued payloads until ctx is done.
+func (o *outbox) run(ctx context.Context) {
+	backoff := o.minBackoff
+	for {
+		err := o.deliverPending(ctx)
+		if err == nil || ctx.Err() != nil {
+			backoff = o.minBackoff
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.wake:
+			}
+			continue
+		}
+		outboxRetries.Add(1)
+		selfLog.Printf("output_spool", "delivering to %s (retrying in %v): %v", o.name, backoff, err)
+		// New payloads do not shorten the backoff.
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
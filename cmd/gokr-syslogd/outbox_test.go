@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		mu        sync.Mutex
+		down      = true
+		delivered []string
+	)
+	deliver := func(b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return fmt.Errorf("connection refused")
+		}
+		delivered = append(delivered, string(b))
+		return nil
+	}
+	o, err := newOutbox(dir, "http://backup/hook", 11, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"one", "two", "three"} {
+		if err := o.enqueue([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.enqueue([]byte("four")); err == nil {
+		t.Errorf("enqueue beyond maxBytes unexpectedly succeeded")
+	}
+	if err := o.deliverPending(context.Background()); err == nil {
+		t.Errorf("deliverPending unexpectedly succeeded while the output is down")
+	}
+
+	// The queue survives a restart, and is delivered in order once the
+	// output recovers.
+	o, err = newOutbox(dir, "http://backup/hook", 11, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.minBackoff = 1 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if err := o.enqueue([]byte("four")); err == nil {
+		t.Errorf("enqueue into the restored full queue unexpectedly succeeded")
+	}
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		if names, _ := o.pending(); len(names) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("payloads not delivered")
+		}
+	}
+	if err := o.enqueue([]byte("four")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("payload enqueued after recovery not delivered")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"one", "two", "three", "four"}, delivered); diff != "" {
+		t.Errorf("delivered: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// outputStdout is the output name of -stdout in -output_rules files.
+const outputStdout = "stdout"
+
+// outputRule matches messages for an output. Empty fields match everything.
+type outputRule struct {
+	host        string
+	tag         string
+	minSeverity int // least severe severity code matched, -1 for all
+}
+
+func (r outputRule) matches(hostname, tag string, severity int) bool {
+	if r.host != "" && r.host != hostname {
+		return false
+	}
+	if r.tag != "" && r.tag != tag {
+		return false
+	}
+	if r.minSeverity >= 0 && (severity < 0 || severity > r.minSeverity) {
+		return false
+	}
+	return true
+}
+
+// outputRules scopes the outputs (-stdout and the -retention_webhook URLs)
+// to the messages matching their rules, read from the file given by
+// -output_rules. Outputs without rules receive everything. The file is
+// re-read when it changes.
+type outputRules struct {
+	path    string
+	outputs map[string]bool // valid output names
+
+	mu      sync.RWMutex
+	modTime time.Time
+	rules   map[string][]outputRule // output → rules
+}
+
+// parseOutputRules parses one rule per line: an output (stdout or one of the
+// -retention_webhook URLs) and the conditions which a message needs to meet,
+// host=, tag= and sev>= (at least as severe), e.g.:
+//
+//	https://alerts.example/hook sev>=err
+//	stdout                      host=router7
+//	stdout                      host=dr tag=dhcpd
+//
+// A message is sent to an output if any of its rules match. Retention events
+// have a host, but neither tag nor severity. Empty lines and lines starting
+// with # are skipped.
+func parseOutputRules(r io.Reader, outputs map[string]bool) (map[string][]outputRule, error) {
+	rules := make(map[string][]outputRule)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected <output> host=|tag=|sev>=…, got %q", lineno, line)
+		}
+		output := fields[0]
+		if !outputs[output] {
+			names := make([]string, 0, len(outputs))
+			for name := range outputs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("line %d: unknown output %q: expected one of %s", lineno, output, strings.Join(names, ", "))
+		}
+		rule := outputRule{minSeverity: -1}
+		for _, cond := range fields[1:] {
+			switch {
+			case strings.HasPrefix(cond, "host="):
+				rule.host = strings.TrimPrefix(cond, "host=")
+			case strings.HasPrefix(cond, "tag="):
+				rule.tag = strings.TrimPrefix(cond, "tag=")
+			case strings.HasPrefix(cond, "sev>="):
+				name := strings.TrimPrefix(cond, "sev>=")
+				code, err := severity.Parse(name)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineno, err)
+				}
+				rule.minSeverity = code
+			default:
+				return nil, fmt.Errorf("line %d: invalid condition %q: expected host=, tag= or sev>=", lineno, cond)
+			}
+		}
+		rules[output] = append(rules[output], rule)
+	}
+	return rules, scanner.Err()
+}
+
+// newOutputRules reads the output rules from path.
+func newOutputRules(path string, outputs []string) (*outputRules, error) {
+	o := &outputRules{path: path, outputs: make(map[string]bool)}
+	for _, output := range outputs {
+		o.outputs[output] = true
+	}
+	if err := o.reload(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// reload re-reads the output rules if their file changed. When the file
+// cannot be read, the previous rules are kept.
+func (o *outputRules) reload() error {
+	file, err := os.Open(o.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	o.mu.RLock()
+	unchanged := st.ModTime().Equal(o.modTime)
+	o.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	rules, err := parseOutputRules(file, o.outputs)
+	if err != nil {
+		return fmt.Errorf("%s: %v", o.path, err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.modTime = st.ModTime()
+	o.rules = rules
+	return nil
+}
+
+// matches reports whether a message of hostname with tag and severity (-1 if
+// unknown) is sent to output. A nil *outputRules sends everything everywhere.
+func (o *outputRules) matches(output, hostname, tag string, severity int) bool {
+	if o == nil {
+		return true
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	rules, ok := o.rules[output]
+	if !ok {
+		return true
+	}
+	for _, r := range rules {
+		if r.matches(hostname, tag, severity) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputRules(t *testing.T) {
+	const hook = "https://alerts.example/hook"
+	outputs := map[string]bool{outputStdout: true, hook: true, "https://inventory.example/hook": true}
+	rules, err := parseOutputRules(strings.NewReader(`# only alerts
+https://alerts.example/hook sev>=err
+stdout host=router7
+stdout host=dr tag=dhcpd
+`), outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		crit = 2
+		info = 6
+	)
+	o := &outputRules{rules: rules}
+	for _, tt := range []struct {
+		output, hostname, tag string
+		severity              int
+		want                  bool
+	}{
+		{hook, "dr", "sshd", crit, true},
+		{hook, "dr", "sshd", info, false},
+		{hook, "dr", "", -1, false}, // retention event
+		{outputStdout, "router7", "dhcp4d", info, true},
+		{outputStdout, "dr", "dhcpd", info, true},
+		{outputStdout, "dr", "ntpd", crit, false},
+		{"https://inventory.example/hook", "dr", "", -1, true}, // no rules
+	} {
+		if got := o.matches(tt.output, tt.hostname, tt.tag, tt.severity); got != tt.want {
+			t.Errorf("matches(%q, %q, %q, %d) = %v, want %v", tt.output, tt.hostname, tt.tag, tt.severity, got, tt.want)
+		}
+	}
+	if !(*outputRules)(nil).matches(hook, "dr", "sshd", info) {
+		t.Errorf("nil outputRules do not match everything")
+	}
+
+	for _, input := range []string{
+		"stdout",
+		"loki host=dr",
+		"stdout hostname=dr",
+		"stdout sev>=loud",
+	} {
+		if _, err := parseOutputRules(strings.NewReader(input), outputs); err == nil {
+			t.Errorf("parseOutputRules(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestOutputRulesReload(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "output_rules")
+	if err := os.WriteFile(fn, []byte("stdout host=router7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o, err := newOutputRules(fn, []string{outputStdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.matches(outputStdout, "dr", "dhcpd", -1) {
+		t.Errorf("dr unexpectedly mirrored to stdout")
+	}
+
+	// Invalid rules keep the previous ones.
+	if err := os.WriteFile(fn, []byte("stdout host=dr tag\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.reload(); err == nil {
+		t.Errorf("reload of invalid rules unexpectedly succeeded")
+	}
+	if o.matches(outputStdout, "dr", "dhcpd", -1) {
+		t.Errorf("dr mirrored to stdout after failed reload")
+	}
+
+	if err := os.WriteFile(fn, []byte("stdout host=dr\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !o.matches(outputStdout, "dr", "dhcpd", -1) {
+		t.Errorf("dr not mirrored to stdout after reload")
+	}
+}
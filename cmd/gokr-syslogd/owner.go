@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// owner is the user and group which log files and directories are chowned to.
+type owner struct {
+	uid, gid int
+}
+
+// parseOwner parses an owner specification like syslog:adm or 1000:1000. If
+// the group is omitted, the primary group of the user is used.
+func parseOwner(spec string) (*owner, error) {
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+	o := &owner{}
+	if uid, err := strconv.Atoi(userSpec); err == nil {
+		o.uid = uid
+		o.gid = -1 // leave unchanged unless specified
+	} else {
+		u, err := user.Lookup(userSpec)
+		if err != nil {
+			return nil, err
+		}
+		if o.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, fmt.Errorf("user %q: non-numeric uid %q", userSpec, u.Uid)
+		}
+		if o.gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("user %q: non-numeric gid %q", userSpec, u.Gid)
+		}
+	}
+	if hasGroup {
+		if gid, err := strconv.Atoi(groupSpec); err == nil {
+			o.gid = gid
+		} else {
+			g, err := user.LookupGroup(groupSpec)
+			if err != nil {
+				return nil, err
+			}
+			if o.gid, err = strconv.Atoi(g.Gid); err != nil {
+				return nil, fmt.Errorf("group %q: non-numeric gid %q", groupSpec, g.Gid)
+			}
+		}
+	}
+	return o, nil
+}
+
+// parseMode parses octal permission bits like 0640.
+func parseMode(spec string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(spec, 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return 0, fmt.Errorf("invalid mode %q: expected octal permission bits like 0640", spec)
+	}
+	return os.FileMode(mode), nil
+}
+
+// fileModeFor returns the permissions of the log file identified by key.
+func (s *server) fileModeFor(key fileKey) os.FileMode {
+	if r := s.routeOf(key.basename); r != nil && r.mode != 0 {
+		return r.mode
+	}
+	if s.fileMode != 0 {
+		return s.fileMode
+	}
+	return 0644
+}
+
+// chown changes the owner of path to s.owner, if set.
+func (s *server) chown(path string) error {
+	if s.owner == nil {
+		return nil
+	}
+	return os.Chown(path, s.owner.uid, s.owner.gid)
+}
+
+// mkdirAll is like os.MkdirAll, but sets the permissions (regardless of the
+// umask) and owner of dir if it needs to be created.
+func (s *server) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	mode := s.dirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+	return s.chown(dir)
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseOwner(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want owner
+	}{
+		{spec: "1000", want: owner{uid: 1000, gid: -1}},
+		{spec: "1000:4", want: owner{uid: 1000, gid: 4}},
+		{spec: "0:0", want: owner{uid: 0, gid: 0}},
+	} {
+		got, err := parseOwner(tt.spec)
+		if err != nil {
+			t.Fatalf("parseOwner(%q): %v", tt.spec, err)
+		}
+		if *got != tt.want {
+			t.Errorf("parseOwner(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+	if _, err := parseOwner("no-such-user-hopefully"); err == nil {
+		t.Errorf("parseOwner(unknown user) unexpectedly succeeded")
+	}
+}
+
+func TestModes(t *testing.T) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	srv := server{
+		dir:         filepath.Join(t.TempDir(), "syslogd"),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+		fileMode:    0640,
+		dirMode:     0750,
+		// chowning to oneself works without privileges
+		owner: &owner{uid: os.Getuid(), gid: os.Getgid()},
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	srv.write(message{
+		hostname:  "dr",
+		timestamp: ts,
+		received:  ts,
+		tag:       "dhcpd",
+		content:   "DHCPDISCOVER",
+	})
+	srv.flushFiles()
+	fn := filepath.Join(srv.dir, "dr", "2022-08-13.log")
+	for path, want := range map[string]os.FileMode{
+		srv.dir:          0750,
+		filepath.Dir(fn): 0750,
+		fn:               0640,
+	} {
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := st.Mode().Perm(); got != want {
+			t.Errorf("%s: mode = %v, want %v", path, got, want)
+		}
+	}
+
+	// Compression retains the mode.
+	srv.closeUnusedFiles(time.Now().Add(1 * time.Hour))
+	if err := compressFile(fn, 0); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(fn + ".zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Errorf("%s.zst: mode = %v, want %v", fn, got, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gokrazy/syslogd/internal/rfc3164"
+)
+
+// Formats recognized by detectFormat. formatRFC3164 and formatRFC5424 are
+// parsed (see -format); the others are only recognized to explain failures.
+const (
+	formatRFC3164      = "rfc3164"
+	formatRFC5424      = "rfc5424"
+	formatOctetCounted = "octet_counted"
+	formatJSON         = "json"
+	formatNoPriority   = "no_priority"
+	formatUnknown      = "unknown"
+)
+
+// formatAuto (-format) detects the format per message: RFC5424 or RFC3164.
+const formatAuto = "auto"
+
+// formatHints explain the detected formats which gokr-syslogd cannot parse.
+var formatHints = map[string]string{
+	formatRFC5424:      "malformed RFC5424, or RFC5424 with -format=rfc3164",
+	formatOctetCounted: "octet-counted framing (RFC6587, meant for TCP): send plain datagrams",
+	formatJSON:         "JSON instead of syslog: use a syslog output",
+	formatNoPriority:   "no <priority> prefix: not a syslog message",
+	formatUnknown:      "the header after <priority> is neither RFC3164 nor RFC5424",
+}
+
+// detectFormat guesses which format raw (a complete datagram) is in, to
+// explain why it could not be parsed.
+func detectFormat(raw string) string {
+	if n := strings.IndexByte(raw, ' '); n > 0 && isDigits(raw[:n]) && strings.HasPrefix(raw[n+1:], "<") {
+		return formatOctetCounted
+	}
+	if strings.HasPrefix(strings.TrimSpace(raw), "{") {
+		return formatJSON
+	}
+	end := strings.IndexByte(raw, '>')
+	if !strings.HasPrefix(raw, "<") || end < 2 || end > 4 || !isDigits(raw[1:end]) {
+		return formatNoPriority
+	}
+	rest := raw[end+1:]
+	if len(rest) >= 2 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		return formatRFC5424 // <PRI>VERSION SP TIMESTAMP …
+	}
+	if _, err := rfc3164.Parse([]byte(raw), time.Now(), time.UTC); err == nil {
+		return formatRFC3164
+	}
+	return formatUnknown
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// parseFailureReasons are the reject reasons which indicate that a message
+// was not parsed as intended.
+var parseFailureReasons = map[string]bool{
+	"no_hostname":      true,
+	"invalid_hostname": true,
+	"no_timestamp":     true,
+	"no_tag":           true,
+}
+
+const (
+	// maxParseFailureKeys bounds the number of source/format combinations
+	// tracked, so that spoofed source addresses cannot exhaust memory.
+	maxParseFailureKeys = 256
+
+	// parseFailureSamples is how many raw payloads are kept per source and
+	// format, each truncated to parseFailureSampleLen bytes.
+	parseFailureSamples   = 3
+	parseFailureSampleLen = 200
+)
+
+type parseFailureKey struct {
+	source netip.Addr
+	format string
+}
+
+type parseFailureStats struct {
+	count     uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+	reasons   map[string]uint64
+	samples   []string
+}
+
+// parseFailures tracks messages which were not parsed as intended, grouped by
+// source address and detected format, so that senders in a format
+// gokr-syslogd does not support (like RFC5424) are easy to spot. It is shared
+// by all tenants.
+var parseFailures = struct {
+	sync.Mutex
+	stats    map[parseFailureKey]*parseFailureStats
+	overflow uint64 // failures not tracked because of maxParseFailureKeys
+}{stats: make(map[parseFailureKey]*parseFailureStats)}
+
+// observeParseFailure records that raw from source was not parsed as intended
+// for the specified reason: one of parseFailureReasons, parse_error, or
+// misparsed for messages in another format which were accepted anyway.
+func observeParseFailure(source netip.Addr, reason, raw string, t time.Time) {
+	key := parseFailureKey{source: source, format: detectFormat(raw)}
+	parseFailures.Lock()
+	defer parseFailures.Unlock()
+	st, ok := parseFailures.stats[key]
+	if !ok {
+		if len(parseFailures.stats) >= maxParseFailureKeys {
+			parseFailures.overflow++
+			return
+		}
+		st = &parseFailureStats{firstSeen: t, reasons: make(map[string]uint64)}
+		parseFailures.stats[key] = st
+	}
+	st.count++
+	st.lastSeen = t
+	st.reasons[reason]++
+	if len(st.samples) < parseFailureSamples {
+		st.samples = append(st.samples, truncateSample(raw))
+	}
+}
+
+// truncateSample shortens raw to parseFailureSampleLen bytes, keeping UTF-8
+// sequences intact.
+func truncateSample(raw string) string {
+	if len(raw) <= parseFailureSampleLen {
+		return raw
+	}
+	n := parseFailureSampleLen
+	for n > 0 && !utf8.RuneStart(raw[n]) {
+		n--
+	}
+	return raw[:n] + "…"
+}
+
+// observeMisparsed records msg, which was accepted, if its raw datagram could
+// not be parsed, in which case its fields are likely garbage (e.g. an RFC5424
+// message with -format=rfc3164 and -accept_tagless).
+func observeMisparsed(msg message, raw, parseError string) {
+	if raw == "" || parseError == "" {
+		return
+	}
+	observeParseFailure(msg.client, "misparsed", raw, msg.received)
+}
+
+type parseFailureEntry struct {
+	parseFailureKey
+	parseFailureStats
+}
+
+// parseFailureEntries returns a copy of the tracked failures, most recently
+// seen first.
+func parseFailureEntries() ([]parseFailureEntry, uint64) {
+	parseFailures.Lock()
+	defer parseFailures.Unlock()
+	entries := make([]parseFailureEntry, 0, len(parseFailures.stats))
+	for key, st := range parseFailures.stats {
+		e := parseFailureEntry{parseFailureKey: key, parseFailureStats: *st}
+		e.reasons = make(map[string]uint64, len(st.reasons))
+		for reason, n := range st.reasons {
+			e.reasons[reason] = n
+		}
+		e.samples = append([]string(nil), st.samples...)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].lastSeen.Equal(entries[j].lastSeen) {
+			return entries[i].lastSeen.After(entries[j].lastSeen)
+		}
+		if entries[i].source != entries[j].source {
+			return entries[i].source.Less(entries[j].source)
+		}
+		return entries[i].format < entries[j].format
+	})
+	return entries, parseFailures.overflow
+}
+
+func (e parseFailureEntry) sourceString() string {
+	if !e.source.IsValid() {
+		return "unknown"
+	}
+	return e.source.String()
+}
+
+func (e parseFailureEntry) reasonsString() string {
+	reasons := make([]string, 0, len(e.reasons))
+	for reason, n := range e.reasons {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ",")
+}
+
+// writeParseFailureMetrics writes the failure counts in the Prometheus text
+// format.
+func writeParseFailureMetrics(w http.ResponseWriter) {
+	entries, _ := parseFailureEntries()
+	fmt.Fprintf(w, "# HELP syslogd_parse_failures_total Messages which were not parsed as intended, by source address and detected format.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_parse_failures_total counter\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "syslogd_parse_failures_total{source=%q,format=%q} %d\n", e.sourceString(), e.format, e.count)
+	}
+}
+
+// parseFailuresHandler lists the tracked parse failures with sample payloads
+// and a hint on how to fix the sender.
+func parseFailuresHandler(w http.ResponseWriter, r *http.Request) {
+	entries, overflow := parseFailureEntries()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "# messages which were not parsed as intended, by source and detected format, most recently seen first\n")
+	if overflow > 0 {
+		fmt.Fprintf(w, "# %d more from untracked sources (more than %d source/format combinations)\n", overflow, maxParseFailureKeys)
+	}
+	for _, e := range entries {
+		fmt.Fprintf(w, "\n%s %s: %d messages (%s), first seen %s, last seen %s\n",
+			e.sourceString(), e.format, e.count, e.reasonsString(),
+			e.firstSeen.Format(time.RFC3339), e.lastSeen.Format(time.RFC3339))
+		if hint, ok := formatHints[e.format]; ok {
+			fmt.Fprintf(w, "  format: %s\n", hint)
+		}
+		for _, sample := range e.samples {
+			fmt.Fprintf(w, "  sample: %q\n", sample)
+		}
+	}
+}
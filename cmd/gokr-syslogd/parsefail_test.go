@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want string
+	}{
+		{"<14>Aug 13 16:20:00 dr dhcpd: DHCPDISCOVER", formatRFC3164},
+		{"<30>Aug  3 16:20:00 nginx/web-1[1234]: GET / HTTP/1.1", formatRFC3164},
+		{"<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER", formatRFC5424},
+		{"<14>2022-08-13T16:20:00+02:00 dr dhcpd: DHCPDISCOVER", formatRFC3164},
+		{"<14>2022-08-13T16:20:00.123+02:00 dr dhcpd: DHCPDISCOVER", formatRFC3164},
+		{"<14>2022-08-13T16:20:00Z dr dhcpd: DHCPDISCOVER", formatRFC3164},
+		{"57 <14>Aug 13 16:20:00 dr dhcpd: DHCPDISCOVER", formatOctetCounted},
+		{`{"level":"info","msg":"DHCPDISCOVER"}`, formatJSON},
+		{"DHCPDISCOVER", formatNoPriority},
+		{"<14>yesterday dr dhcpd: DHCPDISCOVER", formatUnknown},
+	} {
+		if got := detectFormat(tt.raw); got != tt.want {
+			t.Errorf("detectFormat(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseFailures(t *testing.T) {
+	parseFailures.Lock()
+	parseFailures.stats = make(map[parseFailureKey]*parseFailureStats)
+	parseFailures.Unlock()
+
+	srv := server{}
+	received := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		raw, client string
+	}{
+		{"<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER", "10.0.0.16:58045"},
+		{"<14>1 2022-08-13T16:20:01Z dr dhcpd 123 - - DHCPOFFER", "10.0.0.16:58045"},
+		{"<14>Aug 13 16:20:00 router7 dhcp4d: parsed fine", "10.0.0.1:514"},
+	} {
+		logParts, _ := rawFormat{only: formatRFC3164}.parse([]byte(tt.raw))
+		logParts["client"] = tt.client
+		srv.parse(logParts, received)
+	}
+
+	entries, _ := parseFailureEntries()
+	if len(entries) != 1 {
+		t.Fatalf("parseFailureEntries() = %+v, want 1 entry", entries)
+	}
+	if e := entries[0]; e.sourceString() != "10.0.0.16" || e.format != formatRFC5424 || e.count != 2 || e.reasonsString() != "parse_error=2" {
+		t.Errorf("parseFailureEntries()[0] = %s %s %d %s, want 10.0.0.16 rfc5424 2 parse_error=2", e.sourceString(), e.format, e.count, e.reasonsString())
+	}
+
+	rec := httptest.NewRecorder()
+	parseFailuresHandler(rec, httptest.NewRequest("GET", "/parse_failures", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"10.0.0.16 rfc5424: 2 messages (parse_error=2)",
+		formatHints[formatRFC5424],
+		`sample: "<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/parse_failures does not contain %q:\n%s", want, body)
+		}
+	}
+}
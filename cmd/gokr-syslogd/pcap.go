@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxCaptureDuration limits captures started with /debug/capture, so that a
+// forgotten capture does not fill the disk.
+const maxCaptureDuration = 1 * time.Hour
+
+// pcapWriter writes datagrams to the file given by -debug_pcap in the pcap
+// format (with raw IP packets, reconstructed from the source address and
+// listen port), so that they can be inspected with Wireshark or tcpdump -r:
+// all rejected datagrams, and while a capture is active (see captureHandler),
+// all datagrams from its source address.
+type pcapWriter struct {
+	mu sync.Mutex
+	f  *os.File
+
+	source netip.Addr // of the active capture
+	until  time.Time
+}
+
+// pcap file format constants, see
+// https://wiki.wireshark.org/Development/LibpcapFileFormat
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapLinkRaw    = 101 // LINKTYPE_RAW: IPv4 or IPv6 packets
+	pcapSnapLength = 65535 + 40 + 8
+)
+
+// newPcapWriter opens fn for appending, writing the file header if fn is
+// empty.
+func newPcapWriter(fn string) (*pcapWriter, error) {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if st.Size() == 0 {
+		hdr := make([]byte, 24)
+		binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+		binary.LittleEndian.PutUint16(hdr[4:], 2) // version 2.4
+		binary.LittleEndian.PutUint16(hdr[6:], 4)
+		binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLength)
+		binary.LittleEndian.PutUint32(hdr[20:], pcapLinkRaw)
+		if _, err := f.Write(hdr); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &pcapWriter{f: f}, nil
+}
+
+// capturing reports whether a capture of source is active at now.
+func (p *pcapWriter) capturing(source netip.Addr, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return source.IsValid() && source == p.source && now.Before(p.until)
+}
+
+// startCapture captures all datagrams from source until until, replacing a
+// previous capture.
+func (p *pcapWriter) startCapture(source netip.Addr, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.source, p.until = source, until
+}
+
+// writePacket writes a record of a datagram from src to dst, received at t.
+func (p *pcapWriter) writePacket(t time.Time, src, dst netip.AddrPort, payload []byte) error {
+	pkt := udpPacket(src, dst, payload)
+	rec := make([]byte, 16, 16+len(pkt))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+	rec = append(rec, pkt...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.f.Write(rec)
+	return err
+}
+
+// udpPacket returns an IPv4 or IPv6 packet (depending on src) containing a
+// UDP datagram with payload. dst is converted to the address family of src.
+func udpPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	srcIP := src.Addr().Unmap()
+	dstIP := dst.Addr().Unmap()
+	is4 := srcIP.Is4()
+	if is4 && !dstIP.Is4() {
+		dstIP = netip.IPv4Unspecified()
+	} else if !is4 && !dstIP.Is6() {
+		dstIP = netip.IPv6Unspecified()
+	}
+	udpLen := 8 + len(payload)
+	udp := make([]byte, 8, udpLen)
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	udp = append(udp, payload...)
+
+	// The checksum covers a pseudo header of addresses, protocol and length.
+	pseudo := append(srcIP.AsSlice(), dstIP.AsSlice()...)
+	pseudo = append(pseudo, 0, 17, byte(udpLen>>8), byte(udpLen))
+	sum := checksum(append(pseudo, udp...))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	if is4 {
+		ip := make([]byte, 20, 20+udpLen)
+		ip[0] = 0x45 // version 4, 5 × 4 byte header
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+udpLen))
+		ip[6] = 0x40 // don’t fragment
+		ip[8] = 64   // TTL
+		ip[9] = 17   // UDP
+		copy(ip[12:16], srcIP.AsSlice())
+		copy(ip[16:20], dstIP.AsSlice())
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+		return append(ip, udp...)
+	}
+	ip := make([]byte, 40, 40+udpLen)
+	ip[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+	ip[6] = 17 // UDP
+	ip[7] = 64 // hop limit
+	copy(ip[8:24], srcIP.AsSlice())
+	copy(ip[24:40], dstIP.AsSlice())
+	return append(ip, udp...)
+}
+
+// checksum returns the internet checksum (RFC 1071) of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// capture writes the datagram of logParts to -debug_pcap, if enabled:
+// rejected datagrams always, others while their source is being captured. It
+// is called for every datagram and again for rejected ones, and writes each
+// datagram once.
+func (s *server) capture(logParts messageParts, received time.Time, rejected bool) {
+	if s.pcap == nil {
+		return
+	}
+	raw, _ := logParts["raw"].(string)
+	client, _ := logParts["client"].(string)
+	src, err := netip.ParseAddrPort(client)
+	if err != nil {
+		src = netip.AddrPortFrom(netip.IPv4Unspecified(), 0) // e.g. unix socket
+	}
+	if s.pcap.capturing(src.Addr().Unmap(), received) == rejected {
+		return // captured before, or not captured
+	}
+	dst := netip.AddrPortFrom(netip.IPv4Unspecified(), s.listenPort)
+	if err := s.pcap.writePacket(received, src, dst, []byte(raw)); err != nil {
+		selfLog.Printf("pcap", "writing -debug_pcap: %v", err)
+	}
+}
+
+// captureHandler starts capturing all datagrams from the source address given
+// in the source= parameter to -debug_pcap, for the duration= parameter (at
+// most maxCaptureDuration, 5 minutes by default):
+//
+//	curl -X POST 'http://localhost:5515/debug/capture?source=10.0.0.16&duration=10m'
+//
+// If token is non-empty, requests need to carry it (see checkToken).
+func captureHandler(p *pcapWriter, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkToken(w, r, token) {
+			return
+		}
+		if p == nil {
+			http.Error(w, "capturing requires -debug_pcap", http.StatusNotFound)
+			return
+		}
+		source, err := netip.ParseAddr(r.FormValue("source"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid source= parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		duration := 5 * time.Minute
+		if v := r.FormValue("duration"); v != "" {
+			duration, err = time.ParseDuration(v)
+			if err != nil || duration <= 0 || duration > maxCaptureDuration {
+				http.Error(w, fmt.Sprintf("invalid duration= parameter %q: expected a positive duration of at most %v", v, maxCaptureDuration), http.StatusBadRequest)
+				return
+			}
+		}
+		until := time.Now().Add(duration)
+		p.startCapture(source.Unmap(), until)
+		fmt.Fprintf(w, "capturing datagrams from %v until %v\n", source, until.Format(time.RFC3339))
+	}
+}
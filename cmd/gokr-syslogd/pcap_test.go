@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDebugPcap(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "debug.pcap")
+	p, err := newPcapWriter(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+		pcap:        p,
+		listenPort:  514,
+	}
+	received := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	p.startCapture(netip.MustParseAddr("10.0.0.16"), received.Add(time.Minute))
+	for _, tt := range []struct {
+		raw, client string
+	}{
+		{"<14>Aug 13 16:20:00 dr dhcpd: captured", "10.0.0.16:58045"},
+		{"<14>Aug 13 16:20:00 dr : rejected and captured", "10.0.0.16:58045"},
+		{"<14>Aug 13 16:20:00 router7 dhcp4d: not captured", "10.0.0.1:514"},
+		{"<14>Aug 13 16:20:00 router7 : rejected", "[fd00::1]:514"},
+	} {
+		logParts, _ := rawFormat{}.parse([]byte(tt.raw))
+		logParts["client"] = tt.client
+		srv.parse(logParts, received)
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(b); got != pcapMagic {
+		t.Fatalf("magic = %#x, want %#x", got, pcapMagic)
+	}
+	var payloads []string
+	for rec := b[24:]; len(rec) > 0; {
+		n := int(binary.LittleEndian.Uint32(rec[8:]))
+		pkt := rec[16 : 16+n]
+		rec = rec[16+n:]
+		hdrLen := 40
+		if pkt[0]>>4 == 4 {
+			hdrLen = 20
+			if sum := checksum(pkt[:20]); sum != 0 {
+				t.Errorf("invalid IPv4 header checksum (%#x)", sum)
+			}
+		}
+		if got := binary.BigEndian.Uint16(pkt[hdrLen+2:]); got != 514 {
+			t.Errorf("destination port = %d, want 514", got)
+		}
+		payloads = append(payloads, string(pkt[hdrLen+8:]))
+	}
+	want := []string{
+		"<14>Aug 13 16:20:00 dr dhcpd: captured",
+		"<14>Aug 13 16:20:00 dr : rejected and captured",
+		"<14>Aug 13 16:20:00 router7 : rejected",
+	}
+	if diff := cmp.Diff(want, payloads); diff != "" {
+		t.Errorf("pcap payloads: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// Reopening appends without writing a second file header.
+	if _, err := newPcapWriter(fn); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, b) {
+		t.Errorf("reopening modified the pcap file")
+	}
+
+	// Only requests with the admin token start captures.
+	hdl := captureHandler(p, "t0ken")
+	for _, tt := range []struct {
+		url      string
+		wantCode int
+	}{
+		{"/debug/capture?source=10.0.0.1", http.StatusUnauthorized},
+		{"/debug/capture?source=10.0.0.1&token=wrong", http.StatusUnauthorized},
+		{"/debug/capture?source=10.0.0.1&token=t0ken", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("POST", tt.url, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("POST %s: got HTTP %d (%s), want %d", tt.url, rec.Code, rec.Body.String(), tt.wantCode)
+		}
+	}
+	if !p.capturing(netip.MustParseAddr("10.0.0.1"), time.Now()) {
+		t.Errorf("capture of 10.0.0.1 not started")
+	}
+}
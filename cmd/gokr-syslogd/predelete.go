@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// preDelete runs -pre_delete_cmd with fn as last argument. Retention only
+// removes fn if the command succeeded, allowing users to archive files
+// elsewhere before they are gone.
+func (s *server) preDelete(fn string) error {
+	args := strings.Fields(s.preDeleteCmd)
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := exec.Command(args[0], append(args[1:], fn)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %v (output: %q)", cmd.Args, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreDeleteCmd(t *testing.T) {
+	for _, name := range []string{"true", "false"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skip(err)
+		}
+	}
+	for _, tt := range []struct {
+		cmd         string
+		wantDeleted bool
+	}{
+		{cmd: "", wantDeleted: true},
+		{cmd: "true", wantDeleted: true},
+		{cmd: "false", wantDeleted: false},
+	} {
+		t.Run(tt.cmd, func(t *testing.T) {
+			srv := server{
+				dir:           t.TempDir(),
+				files:         make(map[fileKey]*openFile),
+				retentionDays: 7,
+				preDeleteCmd:  tt.cmd,
+			}
+			fn := filepath.Join(srv.dir, "dr", "2022-08-10.log.zst")
+			if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fn, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.deleteOldLogs(time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			_, err := os.Stat(fn)
+			if deleted := os.IsNotExist(err); deleted != tt.wantDeleted {
+				t.Errorf("%s deleted = %v, want %v", fn, deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func dropPrivileges(o *owner) error {
+	return fmt.Errorf("-user is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// dropPrivileges switches the process (all threads, as of Go 1.16) to the
+// user and group of o, dropping all supplementary groups.
+func dropPrivileges(o *owner) error {
+	if err := syscall.Setgroups([]int{o.gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(o.gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(o.uid)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/rfc3164"
+	"github.com/gokrazy/syslogd/internal/rfc5424"
+)
+
+// rawFormat parses messages with internal/rfc3164 or internal/rfc5424, so that
+// the messageParts of each message carry the raw bytes as received
+// (logParts["raw"]) and the parse error, if any (logParts["parse_error"]), next
+// to the parsed fields. Timestamps without zone are in UTC.
+type rawFormat struct {
+	// only restricts parsing to formatRFC3164 or formatRFC5424 (see -format).
+	// Empty means detecting the format per message.
+	only string
+}
+
+func (f rawFormat) parse(raw []byte) (messageParts, error) {
+	now := time.Now()
+	var (
+		msg    rfc3164.Message
+		labels []string // from RFC5424 structured data
+		err    error
+	)
+	if f.only == formatRFC5424 || (f.only == "" && rfc5424.Is(raw)) {
+		msg, labels, err = parseRFC5424(raw, now)
+	} else {
+		msg, err = rfc3164.Parse(raw, now, time.UTC)
+	}
+	logParts := messageParts{
+		"timestamp": msg.Timestamp,
+		"hostname":  msg.Hostname,
+		"tag":       msg.Tag,
+		"content":   msg.Content,
+		"priority":  msg.Facility*8 + msg.Severity,
+		"facility":  msg.Facility,
+		"severity":  msg.Severity,
+		"raw":       string(raw),
+	}
+	if len(labels) > 0 {
+		logParts["labels"] = labels
+	}
+	if err != nil {
+		logParts["parse_error"] = err.Error()
+	}
+	return logParts, err
+}
+
+// reject drops the message contained in logParts for the specified reason.
+// With -quarantine_rejected, the message is written into a per-day file in
+// -quarantine_dir (e.g. /perm/syslogd-quarantine/2022-08-13.log), so that
+// rejected messages can be inspected:
+//
+//	rfc3339=… seq=1 client=10.0.0.16:58045 reason=no_tag rejected: <14>Aug 13 …
+//
+// parse_error is set by rawFormat.
+func (s *server) reject(logParts messageParts, received time.Time, reason string) (message, bool) {
+	drop(reason)
+	if hostname, ok := logParts["hostname"].(string); ok && s.hostMetrics != nil && hostname != "" && validHostname(hostname) {
+		s.hostMetrics.observeDrop(hostname, reason)
+	}
+	s.capture(logParts, received, true)
+	var raw, client, parseError string
+	if v, ok := logParts["raw"].(string); ok {
+		raw = v
+	}
+	if v, ok := logParts["client"].(string); ok {
+		client = v
+	}
+	if v, ok := logParts["parse_error"].(string); ok {
+		parseError = v
+	}
+	if parseError != "" {
+		observeParseFailure(clientAddr(client), "parse_error", raw, received)
+	} else if parseFailureReasons[reason] {
+		observeParseFailure(clientAddr(client), reason, raw, received)
+	}
+	if !s.quarantineRejected {
+		return message{}, false
+	}
+	key := fileKey{
+		basename:   received.Format(basenameFormat),
+		quarantine: true,
+	}
+	of, ok := s.file(key)
+	if !ok {
+		return message{}, false
+	}
+	line := fmt.Appendf(s.lineBuf[:0], "rfc3339=%s seq=%d ", received.Format(time.RFC3339Nano), of.seq+1)
+	if client != "" {
+		line = fmt.Appendf(line, "client=%s ", client)
+	}
+	line = fmt.Appendf(line, "reason=%s ", reason)
+	if clean := sanitize(raw); clean != raw && s.keepRaw {
+		line = fmt.Appendf(line, "raw=%s ", base64.StdEncoding.EncodeToString([]byte(raw)))
+	}
+	line = append(line, "rejected: "...)
+	if parseError != "" {
+		line = fmt.Appendf(line, "[parse error: %s] ", sanitize(parseError))
+	}
+	line = fmt.Appendf(line, "%s\n", sanitize(raw))
+	s.lineBuf = line
+	s.buffer(of, line)
+	return message{}, false
+}
+
+// oldQuarantineFiles returns the files in -quarantine_dir (rejected messages
+// and quarantined hosts) older than -quarantine_retention_days.
+func (s *server) oldQuarantineFiles(now time.Time) ([]string, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	oldestToKeep := today.AddDate(0, 0, -s.quarantineRetentionDays)
+	dirs := []string{s.quarantineDir}
+	entries, err := os.ReadDir(s.quarantineDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // nothing quarantined yet
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(s.quarantineDir, entry.Name()))
+		}
+	}
+	var old []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			day, _, ok := parseLogFileName(entry.Name())
+			if !ok || !entry.Type().IsRegular() || !day.Before(oldestToKeep) {
+				continue
+			}
+			old = append(old, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return old, nil
+}
+
+// deleteOldQuarantine deletes the files of oldQuarantineFiles.
+func (s *server) deleteOldQuarantine(now time.Time) error {
+	old, err := s.oldQuarantineFiles(now)
+	if err != nil {
+		return err
+	}
+	for _, fn := range old {
+		log.Printf("deleting quarantine file older than %d days: %s", s.quarantineRetentionDays, fn)
+		if err := os.Remove(fn); err != nil {
+			log.Printf("deleting %s: %v", fn, err)
+		}
+	}
+	return nil
+}
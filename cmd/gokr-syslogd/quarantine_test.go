@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQuarantineRejected(t *testing.T) {
+	srv := server{
+		files:              make(map[fileKey]*openFile),
+		bufferLimit:        1 << 20,
+		quarantineDir:      t.TempDir(),
+		quarantineRejected: true,
+	}
+	const raw = "<14>Aug 13 16:20:00 dr : no tag here"
+	logParts, _ := rawFormat{}.parse([]byte(raw))
+	logParts["client"] = "10.0.0.16:58045"
+	received := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	if _, ok := srv.parse(logParts, received); ok {
+		t.Fatalf("parse(%q) unexpectedly succeeded", raw)
+	}
+	if err := srv.flushFiles(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(srv.quarantineDir, "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rfc3339=2022-08-13T16:20:00Z seq=1 client=10.0.0.16:58045 reason=no_tag rejected: " + raw + "\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("quarantine file: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeleteOldQuarantine(t *testing.T) {
+	srv := server{
+		quarantineDir:           t.TempDir(),
+		quarantineRetentionDays: 3,
+	}
+	for _, rel := range []string{
+		"2022-08-14.log",
+		"2022-08-15.log",
+		"dr/2022-08-14.log",
+		"dr/2022-08-15.log",
+	} {
+		fn := filepath.Join(srv.quarantineDir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	if err := srv.deleteOldQuarantine(now); err != nil {
+		t.Fatal(err)
+	}
+	var remaining []string
+	err := filepath.Walk(srv.quarantineDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(srv.quarantineDir, path)
+			remaining = append(remaining, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2022-08-15.log",
+		"dr/2022-08-15.log",
+	}
+	if diff := cmp.Diff(want, remaining); diff != "" {
+		t.Errorf("deleteOldQuarantine: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteSizeUnits are the suffixes which parseByteSize accepts.
+var byteSizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	// Longer suffixes first, so that e.g. KiB is not taken for B.
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"B", 1},
+}
+
+// parseByteSize parses a number of bytes with an optional unit, e.g. 2GiB,
+// 500MB or 1048576.
+func parseByteSize(spec string) (int64, error) {
+	num, unit := spec, int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(spec, u.suffix) {
+			num, unit = strings.TrimSuffix(spec, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 2GiB, 500MB or 1048576 (bytes)")
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// overQuotaFiles returns the paths of the compressed log files which need to
+// be deleted, oldest first across all hosts, for the log files of s.dir to use
+// no more than s.maxDiskUsage bytes at now. Files of frozen (retired) or held
+// hosts are never selected.
+func (s *server) overQuotaFiles(now time.Time) ([]string, error) {
+	files, err := s.logFiles()
+	if err != nil {
+		return nil, err
+	}
+	var usage int64
+	var candidates []logFile
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		st, err := os.Stat(f.path)
+		if err != nil {
+			continue // e.g. deleted in the meantime
+		}
+		usage += st.Size()
+		sizes[f.path] = st.Size()
+		if s.state(f, now) == stateCompressed && !s.retired.frozen(f.hostname) && !s.holds.held(f.hostname, f.day) {
+			candidates = append(candidates, f)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].day.Before(candidates[j].day)
+	})
+	var toDelete []string
+	for _, f := range candidates {
+		if usage <= s.maxDiskUsage {
+			break
+		}
+		toDelete = append(toDelete, f.path)
+		usage -= sizes[f.path]
+	}
+	return toDelete, nil
+}
+
+// enforceDiskQuota deletes the oldest compressed log files until the log files
+// use no more than -max_disk_usage, regardless of their age.
+func (s *server) enforceDiskQuota(now time.Time) error {
+	if s.maxDiskUsage <= 0 {
+		return nil
+	}
+	toDelete, err := s.overQuotaFiles(now)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // no log files written yet
+		}
+		return err
+	}
+	for _, fn := range toDelete {
+		log.Printf("deleting log file to stay within -max_disk_usage (%s): %s", formatBytes(s.maxDiskUsage), fn)
+		s.deleteLogFile(fn)
+	}
+	return nil
+}
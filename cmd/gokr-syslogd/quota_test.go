@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, tt := range []struct {
+		spec    string
+		want    int64
+		wantErr bool
+	}{
+		{spec: "1048576", want: 1 << 20},
+		{spec: "2GiB", want: 2 << 30},
+		{spec: "1.5KiB", want: 1536},
+		{spec: "500MB", want: 500e6},
+		{spec: "10B", want: 10},
+		{spec: "2GB", want: 2e9},
+		{spec: "GiB", wantErr: true},
+		{spec: "-1MiB", wantErr: true},
+		{spec: "2 parsecs", wantErr: true},
+	} {
+		got, err := parseByteSize(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q): err = %v, want error: %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestDiskQuota(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		retentionDays: 7,
+		maxDiskUsage:  2500,
+	}
+	for _, rel := range []string{
+		"dr/2022-08-13.log.zst",
+		"dr/2022-08-15.log.zst",
+		"dr/2022-08-18.log", // active: counted, but never deleted
+		"router7/2022-08-14.log.zst",
+		"router7/2022-08-16.log.zst",
+	} {
+		fn := filepath.Join(srv.dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(strings.Repeat("x", 1000)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	if err := srv.enforceDiskQuota(now); err != nil {
+		t.Fatal(err)
+	}
+	files, err := srv.logFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, err := filepath.Rel(srv.dir, f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}
+	// The oldest files across hosts were deleted until 2 files remained.
+	want := []string{
+		"dr/2022-08-18.log",
+		"router7/2022-08-16.log.zst",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("after enforceDiskQuota: unexpected diff (-want +got):\n%s", diff)
+	}
+}
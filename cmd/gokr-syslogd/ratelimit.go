@@ -0,0 +1,88 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// suppressedLogMessages counts log messages which were not printed due to
+// rate limiting, keyed by class.
+var suppressedLogMessages = expvar.NewMap("suppressed_log_messages")
+
+// rateLimitedLog throttles printing error messages. This is particularly
+// important when the gokr-syslogd output itself is sent to gokr-syslogd, which
+// could cause infinite log message loops without rate limiting.
+//
+// Messages are throttled per class (e.g. "open" for errors opening log
+// files), so that a flood of one kind of error does not hide another. The
+// number of suppressed messages is reported with the next message of the
+// class that is printed, or by summarize.
+type rateLimitedLog struct {
+	interval time.Duration // at most one message per class per interval
+
+	mu      sync.Mutex
+	classes map[string]*logClass
+}
+
+type logClass struct {
+	last       time.Time // when a message was last printed
+	suppressed int       // since last
+}
+
+// selfLog is used for all log messages which could be triggered by incoming
+// syslog messages.
+var selfLog = &rateLimitedLog{
+	interval: 1 * time.Second,
+	classes:  make(map[string]*logClass),
+}
+
+// Printf prints a log message of the specified class unless a message of the
+// same class was printed within the interval.
+func (l *rateLimitedLog) Printf(class, format string, v ...any) {
+	now := time.Now()
+	l.mu.Lock()
+	c, ok := l.classes[class]
+	if !ok {
+		c = &logClass{}
+		l.classes[class] = c
+	}
+	if !c.last.IsZero() && now.Sub(c.last) < l.interval {
+		c.suppressed++
+		l.mu.Unlock()
+		suppressedLogMessages.Add(class, 1)
+		return
+	}
+	suppressed := c.suppressed
+	c.last = now
+	c.suppressed = 0
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		log.Printf("[%s] suppressed %d similar messages", class, suppressed)
+	}
+	log.Printf(format, v...)
+}
+
+// summarize reports suppressed messages of classes for which no message was
+// printed for at least the interval, i.e. once an error condition stopped.
+func (l *rateLimitedLog) summarize(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for class, c := range l.classes {
+		if c.suppressed == 0 || now.Sub(c.last) < l.interval {
+			continue
+		}
+		log.Printf("[%s] suppressed %d similar messages", class, c.suppressed)
+		c.suppressed = 0
+		c.last = now
+	}
+}
+
+// summarizeLoop calls summarize once per interval.
+func (l *rateLimitedLog) summarizeLoop() {
+	for now := range time.Tick(l.interval) {
+		l.summarize(now)
+	}
+}
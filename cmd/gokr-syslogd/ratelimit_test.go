@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRateLimitedLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	l := &rateLimitedLog{
+		interval: 1 * time.Hour,
+		classes:  make(map[string]*logClass),
+	}
+	l.Printf("open", "error opening log file: %d", 1)
+	l.Printf("open", "error opening log file: %d", 2)
+	l.Printf("open", "error opening log file: %d", 3)
+	// A different class is not throttled by the open class.
+	l.Printf("drift", "dropping message")
+	l.summarize(time.Now().Add(2 * time.Hour))
+
+	want := `error opening log file: 1
+dropping message
+[open] suppressed 2 similar messages
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("log output: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// estimateSample is how much of a log file estimateCompressed compresses.
+const estimateSample = 1 << 20
+
+// plannedAction is a change which the next retention pass would make.
+type plannedAction struct {
+	action    string // compress, filter or delete
+	path      string
+	size      int64
+	reclaimed int64  // estimated for compress and filter
+	detail    string // e.g. the lines past their retention
+}
+
+// estimateCompressed returns the estimated size of the log file fn of size
+// bytes once compressed, extrapolated from its first estimateSample bytes.
+func estimateCompressed(fn string, size int64) (int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sample, err := io.ReadAll(io.LimitReader(f, estimateSample))
+	if err != nil {
+		return 0, err
+	}
+	if len(sample) == 0 {
+		return 0, nil
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer enc.Close()
+	compressed := enc.EncodeAll(sample, nil)
+	return size * int64(len(compressed)) / int64(len(sample)), nil
+}
+
+// retentionPlan returns what the next retention pass (see retentionPass) at
+// now would do, without doing it. Compression and filtering sizes are
+// estimates; -pre_delete_cmd might still keep files.
+func (s *server) retentionPlan(now time.Time) ([]plannedAction, error) {
+	var plan []plannedAction
+	files, err := s.logFiles()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.externalRotation {
+		files = nil
+	}
+	compressing := s.compressWindow == nil || s.compressWindow.contains(now)
+	for _, f := range files {
+		st, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		switch s.state(f, now) {
+		case stateCold:
+			if !compressing {
+				continue
+			}
+			// Compressed files which are expired already are deleted
+			// within the same pass.
+			compressedFile := f
+			compressedFile.path += ".zst"
+			compressedFile.compressed = true
+			if s.state(compressedFile, now) == stateExpired {
+				plan = append(plan, plannedAction{
+					action:    "delete",
+					path:      f.path,
+					size:      st.Size(),
+					reclaimed: st.Size(),
+					detail:    "after compressing",
+				})
+				continue
+			}
+			estimate, err := estimateCompressed(f.path, st.Size())
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, plannedAction{
+				action:    "compress",
+				path:      f.path,
+				size:      st.Size(),
+				reclaimed: st.Size() - estimate,
+			})
+
+		case stateCompressed:
+			if len(s.severityTiers) == 0 || s.retired.frozen(f.hostname) || s.holds.held(f.hostname, f.day) ||
+				!s.needsFiltering(f, st.ModTime(), now) {
+				continue
+			}
+			fl, err := s.filterLines(f, now)
+			if err != nil {
+				return nil, err
+			}
+			if fl.removed == 0 {
+				continue
+			}
+			a := plannedAction{
+				action:    "filter",
+				path:      f.path,
+				size:      st.Size(),
+				reclaimed: st.Size(),
+				detail:    fmt.Sprintf("%d of %d lines past their retention", fl.removed, fl.total),
+			}
+			if fl.removed == fl.total {
+				a.action = "delete"
+			} else if fl.size > 0 {
+				a.reclaimed = st.Size() * (fl.size - int64(fl.kept.Len())) / fl.size
+			}
+			plan = append(plan, a)
+
+		case stateExpired:
+			plan = append(plan, plannedAction{
+				action:    "delete",
+				path:      f.path,
+				size:      st.Size(),
+				reclaimed: st.Size(),
+			})
+		}
+	}
+	quarantined, err := s.oldQuarantineFiles(now)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range quarantined {
+		st, err := os.Stat(fn)
+		if err != nil {
+			continue
+		}
+		plan = append(plan, plannedAction{
+			action:    "delete",
+			path:      fn,
+			size:      st.Size(),
+			reclaimed: st.Size(),
+			detail:    "quarantine",
+		})
+	}
+	return plan, nil
+}
+
+// formatBytes formats n like 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// writeRetentionPlan writes the retention plan of s at now to w, one action
+// per line, followed by a summary.
+func (s *server) writeRetentionPlan(w io.Writer, now time.Time) error {
+	plan, err := s.retentionPlan(now)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# retention plan for %s as of %s (-retention=%dd)\n", s.dir, now.Format(time.RFC3339), s.retentionDays)
+	if s.externalRotation {
+		fmt.Fprintf(bw, "# log files are rotated externally (-rotation=%s)\n", rotationExternal)
+	} else if s.compressWindow != nil && !s.compressWindow.contains(now) {
+		fmt.Fprintf(bw, "# outside of -compress_window: no files are compressed\n")
+	}
+	counts := make(map[string]int)
+	var reclaimed int64
+	for _, a := range plan {
+		path := a.path
+		if rel, err := filepath.Rel(s.dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		fmt.Fprintf(bw, "%-8s %s size=%d reclaimed=%d", a.action, path, a.size, a.reclaimed)
+		if a.detail != "" {
+			fmt.Fprintf(bw, " (%s)", a.detail)
+		}
+		fmt.Fprintln(bw)
+		counts[a.action]++
+		reclaimed += a.reclaimed
+	}
+	fmt.Fprintf(bw, "# %d to compress, %d to filter, %d to delete: reclaims about %s\n",
+		counts["compress"], counts["filter"], counts["delete"], formatBytes(reclaimed))
+	return bw.Flush()
+}
+
+// retentionPlanHandler responds with what the next retention pass in the log
+// directory of the tenant= parameter would do (see retentionPlan), e.g. to
+// check a configuration change before it deletes files.
+func retentionPlanHandler(servers map[string]*server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := servers[r.FormValue("tenant")]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown tenant %q", r.FormValue("tenant")), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := s.writeRetentionPlan(w, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetentionPlan(t *testing.T) {
+	srv := server{
+		dir:                     t.TempDir(),
+		quarantineDir:           t.TempDir(),
+		files:                   make(map[fileKey]*openFile),
+		retentionDays:           7,
+		quarantineRetentionDays: 3,
+	}
+	line := "rfc3339=2022-08-10T16:20:00Z seq=1 dhcpd: DHCPACK on 10.0.0.16\n"
+	for _, fn := range []string{
+		filepath.Join(srv.dir, "dr", "2022-08-10.log.zst"), // expired
+		filepath.Join(srv.dir, "dr", "2022-08-09.log"),     // cold and expired
+		filepath.Join(srv.dir, "dr", "2022-08-16.log"),     // cold
+		filepath.Join(srv.dir, "dr", "2022-08-18.log"),     // active
+		filepath.Join(srv.quarantineDir, "2022-08-14.log"),
+		filepath.Join(srv.quarantineDir, "2022-08-16.log"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(strings.Repeat(line, 100)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	plan, err := srv.retentionPlan(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type action struct{ Action, Path string }
+	var got []action
+	size := int64(len(line) * 100)
+	for _, a := range plan {
+		got = append(got, action{a.action, a.path})
+		if a.size != size {
+			t.Errorf("%s %s: size = %d, want %d", a.action, a.path, a.size, size)
+		}
+		if a.reclaimed <= 0 || a.reclaimed > a.size {
+			t.Errorf("%s %s: reclaimed = %d, want in (0, %d]", a.action, a.path, a.reclaimed, a.size)
+		}
+	}
+	want := []action{
+		{"delete", filepath.Join(srv.dir, "dr", "2022-08-09.log")},
+		{"delete", filepath.Join(srv.dir, "dr", "2022-08-10.log.zst")},
+		{"compress", filepath.Join(srv.dir, "dr", "2022-08-16.log")},
+		{"delete", filepath.Join(srv.quarantineDir, "2022-08-14.log")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("retentionPlan: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := srv.writeRetentionPlan(&buf, now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "# 1 to compress, 0 to filter, 3 to delete: reclaims about ") {
+		t.Errorf("writeRetentionPlan: summary missing:\n%s", buf.String())
+	}
+	// A dry run changes nothing.
+	if _, err := os.Stat(filepath.Join(srv.dir, "dr", "2022-08-10.log.zst")); err != nil {
+		t.Errorf("expired file removed by dry run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(srv.dir, "dr", "2022-08-16.log.zst")); !os.IsNotExist(err) {
+		t.Errorf("cold file compressed by dry run: %v", err)
+	}
+}
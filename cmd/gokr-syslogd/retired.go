@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gokrazy/syslogd/internal/retired"
+)
+
+// retiredHosts holds the markers of the hosts decommissioned with
+// gokr-syslogctl decommission, by host directory name. The markers are re-read
+// every minute, so that decommissioning does not require a restart.
+type retiredHosts struct {
+	dir string
+
+	mu    sync.RWMutex
+	hosts map[string]retired.Marker
+}
+
+func newRetiredHosts(dir string) *retiredHosts {
+	r := &retiredHosts{dir: dir}
+	r.reload()
+	return r
+}
+
+// reload re-reads the markers. When they cannot be read, the previous ones are
+// kept.
+func (r *retiredHosts) reload() {
+	hosts, err := retired.Hosts(r.dir)
+	if err != nil {
+		log.Printf("reading retired hosts: %v", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts = hosts
+}
+
+// lookup returns the marker of the host directory hostDir, if retired. The nil
+// *retiredHosts has no retired hosts.
+func (r *retiredHosts) lookup(hostDir string) (retired.Marker, bool) {
+	if r == nil {
+		return retired.Marker{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.hosts[hostDir]
+	return m, ok
+}
+
+// frozen reports whether retention is suspended for the host directory
+// hostDir.
+func (r *retiredHosts) frozen(hostDir string) bool {
+	m, ok := r.lookup(hostDir)
+	return ok && m.Retention == retired.RetentionFreeze
+}
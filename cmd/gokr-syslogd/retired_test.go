@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetiredMessages(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	srv := server{
+		dir:           t.TempDir(),
+		quarantineDir: t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		bufferLimit:   1 << 20,
+		retired: &retiredHosts{hosts: map[string]retired.Marker{
+			"raspberrypi": {Messages: retired.MessagesDrop, Retention: retired.RetentionKeep},
+			"router":      {Messages: retired.MessagesQuarantine, Retention: retired.RetentionKeep},
+		}},
+	}
+	for _, hostname := range []string{"raspberrypi", "router", "dr"} {
+		msg, ok := srv.parse(messageParts{
+			"hostname":  hostname,
+			"tag":       "dhcpd",
+			"content":   "DHCPDISCOVER",
+			"timestamp": now,
+		}, now)
+		if got, want := ok, hostname != "raspberrypi"; got != want {
+			t.Fatalf("parse(hostname=%s) = %v, want %v", hostname, got, want)
+		}
+		if ok {
+			srv.write(msg)
+		}
+	}
+	if err := srv.flushFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{
+		filepath.Join(srv.quarantineDir, "router", "2022-08-13.log"),
+		filepath.Join(srv.dir, "dr", "2022-08-13.log"),
+	} {
+		if _, err := os.Stat(fn); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, host := range []string{"raspberrypi", "router"} {
+		if _, err := os.Stat(filepath.Join(srv.dir, host)); !os.IsNotExist(err) {
+			t.Errorf("%s directory unexpectedly written (%v)", host, err)
+		}
+	}
+}
+
+func TestRetiredFrozen(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		retentionDays: 7,
+		retired: &retiredHosts{hosts: map[string]retired.Marker{
+			"raspberrypi": {Messages: retired.MessagesDrop, Retention: retired.RetentionFreeze},
+		}},
+	}
+	for _, rel := range []string{
+		"dr/2022-08-10.log.zst",
+		"raspberrypi/2022-08-10.log.zst",
+	} {
+		fn := filepath.Join(srv.dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	expired, err := srv.logFileNamesInState(now, stateExpired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(srv.dir, "dr", "2022-08-10.log.zst")}
+	if diff := cmp.Diff(want, expired); diff != "" {
+		t.Errorf("logFileNamesInState(stateExpired): unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/rfc3164"
+	"github.com/gokrazy/syslogd/internal/rfc5424"
+)
+
+// parseRFC5424 parses the RFC5424 message raw, received at now, into the
+// fields of an RFC3164 message (APP-NAME becomes the tag), and its MSGID and
+// structured data into labels (see structuredDataLabels). Like rfc3164.Parse,
+// messages which cannot be parsed are returned as content.
+func parseRFC5424(raw []byte, now time.Time) (rfc3164.Message, []string, error) {
+	m, err := rfc5424.Parse(raw)
+	if err != nil {
+		return rfc3164.Message{
+			Facility:  1,
+			Severity:  5,
+			Timestamp: now,
+			Content:   strings.TrimSpace(string(raw)),
+		}, nil, err
+	}
+	msg := rfc3164.Message{
+		Facility:  m.Facility,
+		Severity:  m.Severity,
+		Timestamp: m.Timestamp,
+		Hostname:  m.Hostname,
+		Tag:       m.AppName,
+		Content:   m.Content,
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = now // NILVALUE
+	}
+	return msg, structuredDataLabels(m), nil
+}
+
+// structuredDataLabels returns the MSGID and the parameters of the structured
+// data of m as key=value fields, e.g. msgid=ID47 and sd_examplesdid_32473_iut=3
+// for [exampleSDID@32473 iut="3"]. Keys are lower-cased, with other characters
+// than letters and digits replaced by _, values are escaped like URL path
+// segments (e.g. a space as %20).
+func structuredDataLabels(m rfc5424.Message) []string {
+	var labels []string
+	if m.MsgID != "" {
+		labels = append(labels, "msgid="+url.PathEscape(m.MsgID))
+	}
+	for _, el := range m.StructuredData {
+		for _, param := range el.Params {
+			labels = append(labels, labelKey("sd_"+el.ID+"_"+param.Name)+"="+url.PathEscape(param.Value))
+		}
+	}
+	return labels
+}
+
+// labelKey turns s into a field key, which consists of a-z, 0-9 and _ (see
+// logline.Split).
+func labelKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, s)
+}
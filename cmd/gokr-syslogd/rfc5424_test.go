@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRFC5424(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 0, time.UTC)
+	type result struct {
+		Hostname  string
+		Tag       string
+		Content   string
+		Labels    []string
+		Timestamp time.Time
+	}
+	for _, tt := range []struct {
+		desc   string
+		only   string
+		raw    string
+		want   result
+		wantOK bool
+	}{
+		{
+			desc: "structured data",
+			raw:  `<165>1 2022-08-13T16:20:00.003Z dr evntslog 123 ID47 [exampleSDID@32473 iut="3" eventSource="Application Log"] An application event`,
+			want: result{
+				Hostname:  "dr",
+				Tag:       "evntslog",
+				Content:   "An application event",
+				Labels:    []string{"msgid=ID47", "sd_examplesdid_32473_iut=3", "sd_examplesdid_32473_eventsource=Application%20Log"},
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 3*int(time.Millisecond), time.UTC),
+			},
+			wantOK: true,
+		},
+		{
+			desc: "nil hostname and timestamp",
+			raw:  "<14>1 - - sshd - - - Accepted publickey",
+			want: result{
+				Hostname: "10.0.0.16",
+				Tag:      "sshd",
+				Content:  "Accepted publickey",
+				// Timestamp: time of receipt
+			},
+			wantOK: true,
+		},
+		{
+			desc: "RFC3164 detected",
+			raw:  "<14>2022-08-13T16:20:00Z dr sshd: hello",
+			want: result{
+				Hostname:  "dr",
+				Tag:       "sshd",
+				Content:   "hello",
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+			},
+			wantOK: true,
+		},
+		{
+			desc: "-format=rfc3164",
+			only: formatRFC3164,
+			raw:  "<14>1 2022-08-13T16:20:00Z dr sshd - - - hello",
+		},
+		{
+			desc: "-format=rfc5424",
+			only: formatRFC5424,
+			raw:  "<14>Aug 13 16:20:00 dr sshd: hello",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			logParts, _ := rawFormat{only: tt.only}.parse([]byte(tt.raw))
+			logParts["client"] = "10.0.0.16:58045"
+			srv := server{}
+			msg, ok := srv.parse(logParts, now)
+			if ok != tt.wantOK {
+				t.Fatalf("parse(%q) = %v, want %v", tt.raw, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if msg.timestamp.IsZero() {
+				t.Fatalf("parse(%q): zero timestamp", tt.raw)
+			}
+			got := result{msg.hostname, msg.tag, msg.content, msg.labels, msg.timestamp}
+			if tt.want.Timestamp.IsZero() {
+				got.Timestamp = time.Time{}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parse(%q): unexpected diff (-want +got):\n%s", tt.raw, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/rollup"
+)
+
+// rollupOldLogs writes a rollup file (see package rollup) for each host and
+// day whose log files are no longer written to, unless the rollup file
+// exists already, and deletes rollup files older than s.rollupRetentionDays.
+// It runs before retention filters or deletes log files.
+func (s *server) rollupOldLogs(now time.Time) error {
+	files, err := s.logFiles()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	type hostDay struct {
+		host string
+		day  time.Time
+	}
+	paths := make(map[hostDay]map[string]bool)
+	active := make(map[hostDay]bool)
+	for _, f := range files {
+		hd := hostDay{f.hostname, f.day}
+		if s.state(f, now) == stateActive {
+			active[hd] = true
+		}
+		if paths[hd] == nil {
+			paths[hd] = make(map[string]bool)
+		}
+		// logtree.Scan falls back to the compressed version.
+		paths[hd][strings.TrimSuffix(f.path, ".zst")] = true
+	}
+	for hd, fns := range paths {
+		if active[hd] {
+			continue
+		}
+		fn := filepath.Join(s.dir, hd.host, rollup.DirName, rollup.FileName(hd.day.Format("2006-01-02")))
+		if _, err := os.Stat(fn); err == nil {
+			continue
+		}
+		if err := s.writeRollup(fn, fns); err != nil {
+			log.Printf("writing rollup %s: %v", fn, err)
+		}
+	}
+	return s.deleteOldRollups(now)
+}
+
+// writeRollup writes the rollup file fn for the log files fns.
+func (s *server) writeRollup(fn string, fns map[string]bool) error {
+	sorted := make([]string, 0, len(fns))
+	for path := range fns {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	b := rollup.NewBuilder()
+	for _, path := range sorted {
+		if err := logtree.Scan(context.Background(), path, b.Add); err != nil {
+			return err
+		}
+	}
+	if err := s.mkdirAll(filepath.Dir(fn)); err != nil {
+		return err
+	}
+	mode := s.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := newPendingFile(fn, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	if err := rollup.Write(f, b.Counts()); err != nil {
+		return err
+	}
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	return s.chown(fn)
+}
+
+// deleteOldRollups deletes the rollup files of days before
+// s.rollupRetentionDays (0 keeps them forever).
+func (s *server) deleteOldRollups(now time.Time) error {
+	if s.rollupRetentionDays == 0 {
+		return nil
+	}
+	hosts, err := logtree.ListHosts(s.dir)
+	if err != nil {
+		return err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	cutoff := today.AddDate(0, 0, -s.rollupRetentionDays).Format("2006-01-02")
+	for _, host := range hosts {
+		days, err := rollup.Days(filepath.Join(s.dir, host))
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			if day >= cutoff {
+				break
+			}
+			fn := filepath.Join(s.dir, host, rollup.DirName, rollup.FileName(day))
+			log.Printf("deleting rollup older than %d days: %s", s.rollupRetentionDays, fn)
+			if err := os.Remove(fn); err != nil {
+				log.Printf("deleting %s: %v", fn, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/rollup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRollupOldLogs(t *testing.T) {
+	dir := t.TempDir()
+	for fn, content := range map[string]string{
+		"dr/2022-08-11.log":      "rfc3339=2022-08-11T10:00:00Z seq=1 severity=err dhcpd: no free leases\n",
+		"dr/2022-08-11.auth.log": "rfc3339=2022-08-11T10:30:00Z seq=1 sshd: Accepted publickey\n",
+		"dr/2022-08-13.log":      "rfc3339=2022-08-13T10:00:00Z seq=1 dhcpd: DHCPDISCOVER\n",
+		// Rollups outlive their log files, within their own retention.
+		"dr/rollup/2012-08-13.json": "[]\n",
+		"dr/rollup/2022-01-01.json": "[]\n",
+	} {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &server{dir: dir, retentionDays: 7, rollups: true, rollupRetentionDays: 3650}
+	now := time.Date(2022, time.August, 13, 16, 0, 0, 0, time.Local)
+	if err := srv.rollupOldLogs(now); err != nil {
+		t.Fatal(err)
+	}
+	days, err := rollup.Days(filepath.Join(dir, "dr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2022-08-13 is still written to.
+	if diff := cmp.Diff([]string{"2022-01-01", "2022-08-11"}, days); diff != "" {
+		t.Errorf("rollup days: unexpected diff (-want +got):\n%s", diff)
+	}
+	counts, err := rollup.ReadFile(filepath.Join(dir, "dr", rollup.DirName, rollup.FileName("2022-08-11")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Date(2022, time.August, 11, 10, 0, 0, 0, time.UTC)
+	want := []rollup.Count{
+		{Hour: hour, Tag: "dhcpd", Messages: 1, Errors: 1},
+		{Hour: hour, Tag: "sshd", Messages: 1},
+	}
+	if diff := cmp.Diff(want, counts); diff != "" {
+		t.Errorf("rollup: unexpected diff (-want +got):\n%s", diff)
+	}
+}
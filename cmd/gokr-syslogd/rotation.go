@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lifecycleState is the state of a log file on disk. Log files go through the
+// following states:
+//
+//	active → cold → compressed → expired
+//
+// Time passing moves an active file to cold and a compressed file to expired.
+// compressOldLogs moves cold files to compressed, deleteOldLogs removes expired
+// files.
+type lifecycleState int
+
+const (
+	// stateActive files might still be written to: messages are accepted
+	// for up to 24 hours into the past, so files of today and yesterday (and
+	// of future days, in case of clock drift) are active.
+	stateActive lifecycleState = iota
+
+	// stateCold files are no longer written to, but not yet compressed.
+	stateCold
+
+	// stateCompressed files were compressed with zstd.
+	stateCompressed
+
+	// stateExpired files are compressed files older than the retention
+	// period (see server.fileRetentionDays).
+	stateExpired
+)
+
+func (st lifecycleState) String() string {
+	switch st {
+	case stateActive:
+		return "active"
+	case stateCold:
+		return "cold"
+	case stateCompressed:
+		return "compressed"
+	case stateExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// logFile is a log file on disk.
+type logFile struct {
+	path       string
+	hostname   string
+	day        time.Time // midnight (local time) of the day the file is for
+	compressed bool
+}
+
+// parseLogFileName parses the day from a log file name. In addition to the
+// daily layout (2022-08-13.log), names are accepted which carry a suffix after
+// the date, like hourly (2022-08-13T15.log) or numbered (2022-08-13.1.log)
+// files. Compressed files carry an additional .zst suffix.
+func parseLogFileName(name string) (day time.Time, compressed bool, ok bool) {
+	const dateLayout = "2006-01-02"
+	if len(name) < len(dateLayout) {
+		return time.Time{}, false, false
+	}
+	day, err := time.ParseInLocation(dateLayout, name[:len(dateLayout)], time.Local)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	switch rest := name[len(dateLayout):]; {
+	case strings.HasSuffix(rest, ".log.zst"):
+		return day, true, true
+	case strings.HasSuffix(rest, ".log"):
+		return day, false, true
+	}
+	return time.Time{}, false, false
+}
+
+// parseRetention parses the -retention flag into a number of days: either a
+// number of days (90 or 90d) or a duration (48h), which is rounded up to whole
+// days because log files are deleted a day at a time.
+func parseRetention(spec string) (int, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(spec, "d")); err == nil {
+		if days < 1 {
+			return 0, fmt.Errorf("expected at least one day")
+		}
+		return days, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number of days (e.g. 90d) or a duration (e.g. 48h)")
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expected at least one day")
+	}
+	const day = 24 * time.Hour
+	return int((d + day - 1) / day), nil
+}
+
+// state returns the lifecycle state of f at time now.
+func (s *server) state(f logFile, now time.Time) lifecycleState {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if f.compressed {
+		if f.day.Before(today.AddDate(0, 0, -s.fileRetentionDays(f))) && !s.retired.frozen(f.hostname) && !s.holds.held(f.hostname, f.day) {
+			return stateExpired
+		}
+		return stateCompressed
+	}
+	if f.day.Before(today.AddDate(0, 0, -1)) {
+		return stateCold
+	}
+	return stateActive
+}
+
+// logFiles returns all log files in s.dir, sorted by host and file name.
+// Files which do not look like log files are skipped.
+func (s *server) logFiles() ([]logFile, error) {
+	hostDirs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []logFile
+	for _, hostDir := range hostDirs {
+		if !hostDir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, hostDir.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			day, compressed, ok := parseLogFileName(entry.Name())
+			if !ok || !entry.Type().IsRegular() {
+				continue
+			}
+			files = append(files, logFile{
+				path:       filepath.Join(dir, entry.Name()),
+				hostname:   hostDir.Name(),
+				day:        day,
+				compressed: compressed,
+			})
+		}
+	}
+	return files, nil
+}
+
+// logFileNamesInState returns the paths of all log files which are in state st
+// at time now.
+func (s *server) logFileNamesInState(now time.Time, st lifecycleState) ([]string, error) {
+	files, err := s.logFiles()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if s.state(f, now) == st {
+			names = append(names, f.path)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLifecycleStates(t *testing.T) {
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		retentionDays: 7,
+	}
+	for _, rel := range []string{
+		"dr/2022-08-10.log.zst",
+		"dr/2022-08-11.log.zst",
+		"dr/2022-08-16.log",
+		"dr/2022-08-17.log",
+		"dr/2022-08-18.log",
+		"dr/2022-08-19.log", // sender clock ahead
+		"hourly/2022-08-16T23.log",
+		"hourly/2022-08-17T00.log",
+		"numbered/2022-08-10.1.log.zst",
+		"numbered/2022-08-16.1.log",
+		"numbered/2022-08-16.2.log",
+		// not log files:
+		"dr/.2022-08-16.log.zst123456",
+		"dr/2022-08-16.log.zst.tmp",
+		"dr/README",
+	} {
+		fn := filepath.Join(srv.dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2022, time.August, 18, 16, 20, 0, 0, time.Local)
+	files, err := srv.logFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range files {
+		rel, err := filepath.Rel(srv.dir, f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[filepath.ToSlash(rel)] = srv.state(f, now).String()
+	}
+	want := map[string]string{
+		"dr/2022-08-10.log.zst":         "expired",
+		"dr/2022-08-11.log.zst":         "compressed",
+		"dr/2022-08-16.log":             "cold",
+		"dr/2022-08-17.log":             "active",
+		"dr/2022-08-18.log":             "active",
+		"dr/2022-08-19.log":             "active",
+		"hourly/2022-08-16T23.log":      "cold",
+		"hourly/2022-08-17T00.log":      "active",
+		"numbered/2022-08-10.1.log.zst": "expired",
+		"numbered/2022-08-16.1.log":     "cold",
+		"numbered/2022-08-16.2.log":     "cold",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("lifecycle states: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	for _, tt := range []struct {
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{spec: "7", want: 7},
+		{spec: "90d", want: 90},
+		{spec: "48h", want: 2},
+		{spec: "49h", want: 3},
+		{spec: "1m", want: 1},
+		{spec: "0", wantErr: true},
+		{spec: "-3d", wantErr: true},
+		{spec: "0s", wantErr: true},
+		{spec: "a week", wantErr: true},
+	} {
+		got, err := parseRetention(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRetention(%q): err = %v, want error: %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRetention(%q) = %d, want %d", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// facilities maps the syslog facility names (see syslog(3)) to their codes.
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// route sends the messages of particular facilities into dedicated files next
+// to the daily log file of a host, like /etc/syslog.conf does. For example,
+// the authpriv messages of 2022-08-13 might go into 2022-08-13.auth.log, which
+// is only readable by its owner and kept for longer.
+type route struct {
+	name          string
+	facilities    map[int]bool
+	mode          os.FileMode // 0 means the default mode
+	retentionDays int         // 0 means the retention of the server
+}
+
+// Route names must not be mistaken for the suffixes of hourly (T15) and
+// numbered (1) log files, see parseLogFileName.
+var validRouteName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// routeFlag is the -route flag, which can be specified multiple times.
+type routeFlag []route
+
+func (f *routeFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, r := range *f {
+		names = append(names, r.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Set parses a route specification like
+// auth,facilities=auth+authpriv,mode=0600,retention_days=90.
+func (f *routeFlag) Set(spec string) error {
+	parts := strings.Split(spec, ",")
+	r := route{
+		name:       parts[0],
+		facilities: make(map[int]bool),
+	}
+	if !validRouteName.MatchString(r.name) {
+		return fmt.Errorf("invalid route name %q: expected [a-z][a-z0-9_-]*", r.name)
+	}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid key=value pair %q", part)
+		}
+		switch key {
+		case "facilities":
+			for _, name := range strings.Split(value, "+") {
+				code, ok := facilities[name]
+				if !ok {
+					return fmt.Errorf("unknown facility %q", name)
+				}
+				r.facilities[code] = true
+			}
+		case "mode":
+			mode, err := parseMode(value)
+			if err != nil {
+				return err
+			}
+			r.mode = mode
+		case "retention_days":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 {
+				return fmt.Errorf("invalid retention_days=%q: expected a positive number of days", value)
+			}
+			r.retentionDays = days
+		default:
+			return fmt.Errorf("unknown key %q (expected one of facilities, mode or retention_days)", key)
+		}
+	}
+	if len(r.facilities) == 0 {
+		return fmt.Errorf("route %q: facilities= is required", r.name)
+	}
+	for _, other := range *f {
+		if other.name == r.name {
+			return fmt.Errorf("route %q specified more than once", r.name)
+		}
+		for code := range r.facilities {
+			if other.facilities[code] {
+				return fmt.Errorf("route %q: facility %d already routed by %q", r.name, code, other.name)
+			}
+		}
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// routeFor returns the route for msg, if any: the route set by -rules, or the
+// route of its facility.
+func (s *server) routeFor(msg message) *route {
+	for i := range s.routes {
+		if msg.route != "" && s.routes[i].name == msg.route ||
+			msg.route == "" && s.routes[i].facilities[msg.facility] {
+			return &s.routes[i]
+		}
+	}
+	return nil
+}
+
+// routeOf returns the route whose messages the log file name contains, if any.
+func (s *server) routeOf(name string) *route {
+	const dateLayout = "2006-01-02"
+	if !s.externalRotation {
+		if len(name) < len(dateLayout) {
+			return nil
+		}
+		name = name[len(dateLayout):]
+	}
+	rest := strings.TrimSuffix(name, ".zst")
+	rest = strings.TrimSuffix(rest, ".log")
+	rest = strings.TrimPrefix(rest, ".")
+	for i := range s.routes {
+		if s.routes[i].name == rest {
+			return &s.routes[i]
+		}
+	}
+	return nil
+}
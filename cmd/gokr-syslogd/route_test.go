@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRoutes(t *testing.T) {
+	var routes routeFlag
+	if err := routes.Set("auth,facilities=auth+authpriv,mode=0600,retention_days=90"); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []string{
+		"auth,facilities=kern",          // duplicate name
+		"kernel,facilities=kern+auth",   // duplicate facility
+		"T15,facilities=kern",           // would look like an hourly file
+		"kernel,facilities=kernel",      // unknown facility
+		"kernel",                        // no facilities
+		"kernel,facilities=kern,mode=9", // invalid mode
+	} {
+		if err := routes.Set(spec); err == nil {
+			t.Errorf("Set(%q) unexpectedly succeeded", spec)
+		}
+	}
+
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		bufferLimit:   1 << 20,
+		retentionDays: 7,
+		routes:        routes,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, msg := range []message{
+		{facility: facilities["authpriv"], tag: "sshd", content: "Accepted publickey for michael"},
+		{facility: facilities["daemon"], tag: "dhcpd", content: "DHCPDISCOVER"},
+		{facility: -1, tag: "iptables", content: "unknown facility"},
+	} {
+		msg.hostname = "dr"
+		msg.timestamp = ts
+		msg.received = ts
+		srv.write(msg)
+	}
+	srv.flushFiles()
+
+	for basename, want := range map[string]string{
+		"2022-08-13.auth.log": "rfc3339=2022-08-13T16:20:00Z seq=1 sshd: Accepted publickey for michael\n",
+		"2022-08-13.log": "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n" +
+			"rfc3339=2022-08-13T16:20:00Z seq=2 iptables: unknown facility\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(srv.dir, "dr", basename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(b)); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", basename, diff)
+		}
+	}
+	st, err := os.Stat(filepath.Join(srv.dir, "dr", "2022-08-13.auth.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Errorf("2022-08-13.auth.log: mode = %v, want %v", got, want)
+	}
+
+	// Routed files are kept for the retention of their route.
+	now := time.Date(2022, time.August, 31, 12, 0, 0, 0, time.Local)
+	day := time.Date(2022, time.August, 13, 0, 0, 0, 0, time.Local)
+	for path, want := range map[string]lifecycleState{
+		"dr/2022-08-13.auth.log.zst": stateCompressed,
+		"dr/2022-08-13.log.zst":      stateExpired,
+	} {
+		f := logFile{path: path, hostname: "dr", day: day, compressed: true}
+		if got := srv.state(f, now); got != want {
+			t.Errorf("state(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/msgexpr"
+)
+
+// alertMatches counts the messages which matched alert rules (see -rules), by
+// alert name.
+var alertMatches = expvar.NewMap("alert_matches")
+
+// rule is a line of the -rules file.
+type rule struct {
+	action string // drop, route or alert
+	name   string // of the route or alert
+	cond   *msgexpr.Expr
+}
+
+// rules are conditions (see package msgexpr) on messages with an action, read
+// from the file given by -rules. The file is re-read when it changes.
+type rules struct {
+	path   string
+	routes []route
+
+	mu      sync.RWMutex
+	modTime time.Time
+	rules   []rule
+}
+
+// parseRules parses one rule per line: drop and a condition, route, the name
+// of a -route and a condition, or alert, a name and a condition, e.g.:
+//
+//	drop  tag == wpa_supplicant && content =~ "^CTRL-EVENT-SCAN"
+//	route auth  tag == sudo
+//	alert root-login  tag == sshd && content =~ "Accepted .* for root"
+//
+// Empty lines and lines starting with # are skipped.
+func parseRules(r io.Reader, routes []route) ([]rule, error) {
+	var result []rule
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, rest, _ := strings.Cut(line, " ")
+		ru := rule{action: action}
+		switch action {
+		case "drop":
+		case "route", "alert":
+			ru.name, rest, _ = strings.Cut(strings.TrimSpace(rest), " ")
+			if !validRouteName.MatchString(ru.name) {
+				return nil, fmt.Errorf("line %d: invalid %s name %q: expected [a-z][a-z0-9_-]*", lineno, action, ru.name)
+			}
+			if action == "route" && !hasRoute(routes, ru.name) {
+				return nil, fmt.Errorf("line %d: unknown route %q (see -route)", lineno, ru.name)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: invalid action %q: expected drop, route or alert", lineno, action)
+		}
+		cond, err := msgexpr.Parse(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		ru.cond = cond
+		result = append(result, ru)
+	}
+	return result, scanner.Err()
+}
+
+func hasRoute(routes []route, name string) bool {
+	for _, r := range routes {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newRules reads the rules from path, whose route rules refer to routes.
+func newRules(path string, routes []route) (*rules, error) {
+	r := &rules{path: path, routes: routes}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload re-reads the rules if their file changed. When the file cannot be
+// read, the previous rules are kept.
+func (r *rules) reload() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := st.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	parsed, err := parseRules(file, r.routes)
+	if err != nil {
+		return fmt.Errorf("%s: %v", r.path, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modTime = st.ModTime()
+	r.rules = parsed
+	return nil
+}
+
+// facilityName returns the name of the facility code, or the empty string.
+func facilityName(code int) string {
+	for name, c := range facilities {
+		if c == code {
+			return name
+		}
+	}
+	return ""
+}
+
+// apply evaluates the rules in order against msg. It returns false if a drop
+// rule matched, which ends the evaluation. The first matching route rule sets
+// the route of msg, and every matching alert rule is counted and logged.
+func (r *rules) apply(msg *message) bool {
+	m := &msgexpr.Message{
+		Host:     msg.hostname,
+		Tag:      msg.tag,
+		Content:  msg.content,
+		Zone:     msg.zone,
+		Facility: facilityName(msg.facility),
+		Severity: msg.severity,
+		Labels:   msg.labels,
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, ru := range r.rules {
+		if !ru.cond.Match(m) {
+			continue
+		}
+		switch ru.action {
+		case "drop":
+			return false
+		case "route":
+			if msg.route == "" {
+				msg.route = ru.name
+			}
+		case "alert":
+			alertMatches.Add(ru.name, 1)
+			selfLog.Printf("alert_"+ru.name, "alert %s: host %q, tag %q: %s", ru.name, msg.hostname, msg.tag, msg.content)
+		}
+	}
+	return true
+}
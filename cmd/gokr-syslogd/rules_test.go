@@ -0,0 +1,99 @@
+package main
+
+import (
+	"expvar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	var routes routeFlag
+	if err := routes.Set("auth,facilities=auth"); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(fn, []byte(`# scans are noise
+drop  tag == wpa_supplicant && content =~ "^CTRL-EVENT-SCAN"
+route auth  tag == sudo
+alert root-login  tag == sshd && content =~ "Accepted .* for root"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRules(fn, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{rules: r, routes: routes}
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	parse := func(tag, content string) (message, bool) {
+		return srv.parse(messageParts{
+			"hostname":  "dr",
+			"tag":       tag,
+			"content":   content,
+			"timestamp": now,
+			"facility":  1, // user
+		}, now)
+	}
+
+	if _, ok := parse("wpa_supplicant", "CTRL-EVENT-SCAN-STARTED"); ok {
+		t.Errorf("parse(wpa_supplicant scan) unexpectedly succeeded")
+	}
+	if _, ok := parse("wpa_supplicant", "CTRL-EVENT-CONNECTED"); !ok {
+		t.Errorf("parse(wpa_supplicant connect) unexpectedly failed")
+	}
+
+	msg, ok := parse("sudo", "michael : TTY=pts/0 ; COMMAND=/bin/ls")
+	if !ok {
+		t.Fatalf("parse(sudo) unexpectedly failed")
+	}
+	if r := srv.routeFor(msg); r == nil || r.name != "auth" {
+		t.Errorf("routeFor(sudo message) = %v, want route auth", r)
+	}
+	msg, _ = parse("ntpd", "synchronized")
+	if r := srv.routeFor(msg); r != nil {
+		t.Errorf("routeFor(ntpd message) = %v, want nil", r)
+	}
+
+	before := alertCount("root-login")
+	parse("sshd", "Accepted publickey for root from 10.0.0.16")
+	parse("sshd", "Accepted publickey for michael from 10.0.0.16")
+	if got, want := alertCount("root-login")-before, int64(1); got != want {
+		t.Errorf("root-login alerts = %d, want %d", got, want)
+	}
+
+	// Reload without the drop rule.
+	if err := os.WriteFile(fn, []byte("alert scan tag == wpa_supplicant\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parse("wpa_supplicant", "CTRL-EVENT-SCAN-STARTED"); !ok {
+		t.Errorf("parse(wpa_supplicant scan) failed after reload")
+	}
+
+	for _, input := range []string{
+		"block tag == sshd",
+		"drop tag ==",
+		"route console tag == sudo",
+		"alert Root tag == sshd",
+	} {
+		if _, err := parseRules(strings.NewReader(input), routes); err == nil {
+			t.Errorf("parseRules(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func alertCount(name string) int64 {
+	if v, ok := alertMatches.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// needsEscape reports whether r must not be written to a log file verbatim:
+// control characters (other than tab) would break terminals and line-based
+// parsers, e.g. ANSI escape sequences or newlines injecting fake lines.
+func needsEscape(r rune) bool {
+	return (r < 0x20 && r != '\t') ||
+		r == 0x7f ||
+		(r >= 0x80 && r < 0xa0)
+}
+
+// sanitize escapes control characters in s (\n, \r or \xNN/\uNNNN) and
+// replaces invalid UTF-8 with the Unicode replacement character.
+func sanitize(s string) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || needsEscape(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x80 && needsEscape(r):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case needsEscape(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			// Invalid UTF-8 is decoded as utf8.RuneError by range.
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sanitizeTag is like sanitize, but also escapes '=', so that a tag like
+// "level=info" is not mistaken for a key=value field when reading the stored
+// line (see logline.Split).
+func sanitizeTag(tag string) string {
+	return strings.ReplaceAll(sanitize(tag), "=", `\x3d`)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{in: "plain ascii", want: "plain ascii"},
+		{in: "tab\tseparated", want: "tab\tseparated"},
+		{in: "grüße", want: "grüße"},
+		{in: "line one\nrfc3339=fake", want: `line one\nrfc3339=fake`},
+		{in: "\x1b[31mred\x1b[0m", want: `\x1b[31mred\x1b[0m`},
+		{in: "nul\x00byte", want: `nul\x00byte`},
+		{in: "next line\u0085", want: `next line\u0085`},
+		{in: "invalid \xff\xfe utf-8", want: "invalid �� utf-8"},
+	} {
+		if got := sanitize(tt.in); got != tt.want {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{in: "dhcpd", want: "dhcpd"},
+		{in: "nginx/web-1", want: "nginx/web-1"},
+		{in: "level=info", want: `level\x3dinfo`},
+		{in: "tag\nseq=1", want: `tag\nseq\x3d1`},
+	} {
+		if got := sanitizeTag(tt.in); got != tt.want {
+			t.Errorf("sanitizeTag(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// timeWindow is a daily time-of-day window like 03:00-05:00, which may wrap
+// around midnight (e.g. 23:00-01:00).
+type timeWindow struct {
+	start, end time.Duration // since midnight
+}
+
+// parseTimeWindow parses a window like 03:00-05:00.
+func parseTimeWindow(spec string) (*timeWindow, error) {
+	var startH, startM, endH, endM int
+	if _, err := fmt.Sscanf(spec, "%d:%d-%d:%d", &startH, &startM, &endH, &endM); err != nil {
+		return nil, fmt.Errorf("invalid time window %q: expected e.g. 03:00-05:00", spec)
+	}
+	for _, v := range []struct{ h, m int }{{startH, startM}, {endH, endM}} {
+		if v.h < 0 || v.h > 24 || v.m < 0 || v.m > 59 || (v.h == 24 && v.m > 0) {
+			return nil, fmt.Errorf("invalid time window %q: %02d:%02d is not a time of day", spec, v.h, v.m)
+		}
+	}
+	w := &timeWindow{
+		start: time.Duration(startH)*time.Hour + time.Duration(startM)*time.Minute,
+		end:   time.Duration(endH)*time.Hour + time.Duration(endM)*time.Minute,
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid time window %q: empty", spec)
+	}
+	return w, nil
+}
+
+func sinceMidnight(t time.Time) (time.Time, time.Duration) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return midnight, t.Sub(midnight)
+}
+
+// contains reports whether t (in its location) is within w.
+func (w *timeWindow) contains(t time.Time) bool {
+	_, tod := sinceMidnight(t)
+	if w.start < w.end {
+		return tod >= w.start && tod < w.end
+	}
+	return tod >= w.start || tod < w.end
+}
+
+// next returns the next start of w after t.
+func (w *timeWindow) next(t time.Time) time.Time {
+	midnight, tod := sinceMidnight(t)
+	if tod < w.start {
+		return midnight.Add(w.start)
+	}
+	return midnight.AddDate(0, 0, 1).Add(w.start)
+}
+
+// ingestRate returns the number of messages per second accepted by the write
+// loop, measured over one second.
+func (s *server) ingestRate() float64 {
+	before := atomic.LoadUint64(&s.accepted)
+	time.Sleep(1 * time.Second)
+	return float64(atomic.LoadUint64(&s.accepted) - before)
+}
+
+// retentionPass compresses, filters and deletes the log files (unless they are
+// rotated externally) and deletes old quarantine files, as of now.
+func (s *server) retentionPass(now time.Time, emergency bool) {
+	// With -rotation=external, log files are rotated externally.
+	if !s.externalRotation {
+		if s.rollups {
+			if err := s.rollupOldLogs(now); err != nil {
+				log.Printf("writing rollups: %v", err)
+			}
+		}
+		if emergency || s.compressWindow == nil || s.compressWindow.contains(now) {
+			if err := s.compressOldLogs(now, emergency); err != nil {
+				log.Printf("compressing old logs: %v", err)
+			}
+		}
+		if err := s.filterOldLogs(now); err != nil {
+			log.Printf("filtering old logs: %v", err)
+		}
+		if err := s.deleteOldLogs(now); err != nil {
+			log.Printf("deleting old logs: %v", err)
+		}
+		if err := s.enforceDiskQuota(now); err != nil {
+			log.Printf("enforcing -max_disk_usage: %v", err)
+		}
+	}
+	if err := s.deleteOldQuarantine(now); err != nil {
+		log.Printf("deleting old quarantine files: %v", err)
+	}
+}
+
+// retentionLoop compresses and deletes old log files every
+// s.retentionInterval, compressing only within s.compressWindow (if set). The
+// loop runs early (and regardless of the window) when writes fail, see
+// triggerRetention.
+func (s *server) retentionLoop() {
+	interval := s.retentionInterval
+	if interval == 0 {
+		interval = 1 * time.Hour
+	}
+	emergency := false
+	for {
+		now := time.Now()
+		s.retentionPass(now, emergency)
+		wait := interval
+		if s.compressWindow != nil && !s.compressWindow.contains(now) {
+			if untilWindow := time.Until(s.compressWindow.next(now)); untilWindow < wait {
+				wait = untilWindow
+			}
+		}
+		select {
+		case <-time.After(wait):
+			emergency = false
+		case <-s.retentionNow:
+			log.Printf("running retention pass early to free up disk space")
+			emergency = true
+		}
+	}
+}
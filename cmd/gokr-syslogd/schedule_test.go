@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, time.August, 13, hour, min, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		spec     string
+		t        time.Time
+		contains bool
+		next     time.Time
+	}{
+		{spec: "03:00-05:00", t: at(4, 0), contains: true, next: at(3, 0).AddDate(0, 0, 1)},
+		{spec: "03:00-05:00", t: at(5, 0), contains: false, next: at(3, 0).AddDate(0, 0, 1)},
+		{spec: "03:00-05:00", t: at(1, 30), contains: false, next: at(3, 0)},
+		{spec: "23:30-01:00", t: at(0, 15), contains: true, next: at(23, 30)},
+		{spec: "23:30-01:00", t: at(23, 45), contains: true, next: at(23, 30).AddDate(0, 0, 1)},
+		{spec: "23:30-01:00", t: at(12, 0), contains: false, next: at(23, 30)},
+	} {
+		w, err := parseTimeWindow(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.contains(tt.t); got != tt.contains {
+			t.Errorf("%s: contains(%v) = %v, want %v", tt.spec, tt.t, got, tt.contains)
+		}
+		if got := w.next(tt.t); !got.Equal(tt.next) {
+			t.Errorf("%s: next(%v) = %v, want %v", tt.spec, tt.t, got, tt.next)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"04:00",
+		"25:00-26:00",
+		"03:60-04:00",
+		"04:00-04:00",
+	} {
+		if _, err := parseTimeWindow(spec); err == nil {
+			t.Errorf("parseTimeWindow(%q) unexpectedly succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gokrazy/syslogd/senderconfig"
+)
+
+// senderConfigHandler serves the sender configuration (see package
+// senderconfig). Unless address is set explicitly, senders are pointed at the
+// host they sent the HTTP request to, with the port of listenAddr, so that
+// moving the collector only requires changing the DNS record of that host.
+func senderConfigHandler(listenAddr, address string, requireHMAC bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := senderconfig.Config{
+			Address:     address,
+			Protocol:    "udp",
+			RequireHMAC: requireHMAC,
+		}
+		if cfg.Address == "" {
+			_, port, err := net.SplitHostPort(listenAddr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			cfg.Address = net.JoinHostPort(host, port)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(cfg)
+	}
+}
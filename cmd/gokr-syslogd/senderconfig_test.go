@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gokrazy/syslogd/senderconfig"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSenderConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(senderconfig.Path, senderConfigHandler(":514", "", true))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	got, err := senderconfig.Fetch(ctx, strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &senderconfig.Config{
+		Address:     "127.0.0.1:514",
+		Protocol:    "udp",
+		RequireHMAC: true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sender config: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	mux.HandleFunc("/explicit", senderConfigHandler(":514", "syslog.lan:5514", false))
+	addr, err := senderconfig.ResolveTarget(ctx, srv.URL+"/explicit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "syslog.lan:5514" {
+		t.Errorf("ResolveTarget = %q, want syslog.lan:5514", addr)
+	}
+
+	if _, err := senderconfig.Fetch(ctx, srv.URL+"/missing"); err == nil {
+		t.Errorf("Fetch of a missing configuration unexpectedly succeeded")
+	}
+}
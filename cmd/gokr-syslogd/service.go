@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceMap maps tags to the gokrazy packages (and their versions) which log
+// with that tag, read from the file given by -services. The file is re-read
+// when it changes, e.g. after an update deployed new package versions.
+type serviceMap struct {
+	path string
+
+	mu       sync.RWMutex
+	modTime  time.Time
+	services map[string]string // tag → package[@version]
+}
+
+// parseServices parses one package per line, optionally with its version
+// (github.com/rtr7/router7/cmd/dhcp4d@v0.0.0-20220813), followed by the tag if
+// it differs from the basename of the package (the name gokrazy runs the
+// binary as). Empty lines and lines starting with # are skipped.
+func parseServices(r io.Reader) (map[string]string, error) {
+	services := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected package[@version] [tag], got %q", lineno, line)
+		}
+		pkg, _, _ := strings.Cut(fields[0], "@")
+		tag := path.Base(pkg)
+		if len(fields) == 2 {
+			tag = fields[1]
+		}
+		if _, ok := services[tag]; ok {
+			return nil, fmt.Errorf("line %d: duplicate tag %q", lineno, tag)
+		}
+		services[tag] = fields[0]
+	}
+	return services, scanner.Err()
+}
+
+// newServiceMap reads the service map from path.
+func newServiceMap(path string) (*serviceMap, error) {
+	m := &serviceMap{path: path}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// reload re-reads the service map if its file changed. When the file cannot
+// be read, the previous mapping is kept.
+func (m *serviceMap) reload() error {
+	f, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	unchanged := st.ModTime().Equal(m.modTime)
+	m.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	services, err := parseServices(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", m.path, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modTime = st.ModTime()
+	m.services = services
+	return nil
+}
+
+// lookup returns the package[@version] which logs with tag, or the empty
+// string if tag is not mapped.
+func (m *serviceMap) lookup(tag string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.services[tag]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseServices(t *testing.T) {
+	services, err := parseServices(strings.NewReader(`# deployed to router7
+github.com/rtr7/router7/cmd/dhcp4d@v0.0.0-20220813
+github.com/gokrazy/breakglass
+
+github.com/gokrazy/syslogd/cmd/gokr-syslogd@v1.2.3 syslogd
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"dhcp4d":     "github.com/rtr7/router7/cmd/dhcp4d@v0.0.0-20220813",
+		"breakglass": "github.com/gokrazy/breakglass",
+		"syslogd":    "github.com/gokrazy/syslogd/cmd/gokr-syslogd@v1.2.3",
+	}
+	if diff := cmp.Diff(want, services); diff != "" {
+		t.Errorf("parseServices: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, input := range []string{
+		"github.com/rtr7/router7/cmd/dhcp4d dhcp4d extra",
+		"github.com/rtr7/router7/cmd/dhcp4d\ngithub.com/other/cmd/dhcp4d",
+	} {
+		if _, err := parseServices(strings.NewReader(input)); err == nil {
+			t.Errorf("parseServices(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestWriteService(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "services.txt")
+	if err := os.WriteFile(fn, []byte("github.com/rtr7/router7/cmd/dhcp4d@v1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	services, err := newServiceMap(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+		services:    services,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	write := func(tag, content string) {
+		srv.write(message{hostname: "router7", timestamp: ts, received: ts, tag: tag, content: content})
+	}
+	write("dhcp4d", "DHCPDISCOVER")
+	write("ntp", "clock synchronized")
+
+	// An update deploys a new version.
+	if err := os.WriteFile(fn, []byte("github.com/rtr7/router7/cmd/dhcp4d@v2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.reload(); err != nil {
+		t.Fatal(err)
+	}
+	write("dhcp4d", "DHCPREQUEST")
+	srv.flushFiles()
+
+	b, err := os.ReadFile(filepath.Join(srv.dir, "router7", "2022-08-13.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rfc3339=2022-08-13T16:20:00Z seq=1 service=github.com/rtr7/router7/cmd/dhcp4d@v1 dhcp4d: DHCPDISCOVER\n" +
+		"rfc3339=2022-08-13T16:20:00Z seq=2 ntp: clock synchronized\n" +
+		"rfc3339=2022-08-13T16:20:00Z seq=3 service=github.com/rtr7/router7/cmd/dhcp4d@v2 dhcp4d: DHCPREQUEST\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("unexpected log file contents: diff (-want +got):\n%s", diff)
+	}
+}
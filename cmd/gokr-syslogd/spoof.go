@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Actions for messages which claim a hostname that is bound to different
+// source addresses (see hostSources), selected by -spoofed_action.
+const (
+	spoofedFlag       = "flag"       // write with a spoofed_from= field
+	spoofedQuarantine = "quarantine" // write into -quarantine_dir instead
+	spoofedDrop       = "drop"
+)
+
+// hostSources binds hostnames to the source addresses they may send messages
+// from. Without such a binding, anyone who can reach the (UDP) listen address
+// can write into any host’s log files.
+//
+// Hostnames without configured addresses are not restricted, unless learn is
+// set: then, the first source address seen for a hostname is bound to it
+// (trust on first use). Learned bindings are not persisted across restarts.
+type hostSources struct {
+	learn   bool
+	allowed map[string]map[netip.Addr]bool
+}
+
+// parseHostSources parses a comma-separated list of hostname=address pairs,
+// e.g. dr=10.0.0.16,router7=10.0.0.1,router7=fd00::1.
+func parseHostSources(spec string) (map[string]map[netip.Addr]bool, error) {
+	allowed := make(map[string]map[netip.Addr]bool)
+	if spec == "" {
+		return allowed, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		hostname, addr, ok := strings.Cut(pair, "=")
+		if !ok || hostname == "" {
+			return nil, fmt.Errorf("invalid hostname=address pair %q", pair)
+		}
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hostname=address pair %q: %v", pair, err)
+		}
+		if allowed[hostname] == nil {
+			allowed[hostname] = make(map[netip.Addr]bool)
+		}
+		allowed[hostname][ip.Unmap()] = true
+	}
+	return allowed, nil
+}
+
+// check reports whether hostname may send messages from addr.
+func (h *hostSources) check(hostname string, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return true // source unknown, e.g. unix socket
+	}
+	allowed, ok := h.allowed[hostname]
+	if !ok {
+		if h.learn {
+			log.Printf("binding hostname %q to source address %v", hostname, addr)
+			h.allowed[hostname] = map[netip.Addr]bool{addr: true}
+		}
+		return true
+	}
+	return allowed[addr]
+}
+
+// clientAddr parses the source address from the client field (host:port) of a
+// message, without port and IPv6 zone.
+func clientAddr(client string) netip.Addr {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		host = client
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
+
+// clientZone returns the IPv6 zone of the source address in the client field
+// of a message (e.g. eth0 for [fe80::1%eth0]:514), if it can be stored as
+// part of a key=value field.
+func clientZone(client string) string {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		host = client
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.Is6() || addr.Is4In6() {
+		return ""
+	}
+	zone := addr.Zone()
+	for _, r := range zone {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return ""
+		}
+	}
+	return zone
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestHostSources(t *testing.T) {
+	allowed, err := parseHostSources("router7=10.0.0.1,router7=fd00::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &hostSources{
+		learn:   true,
+		allowed: allowed,
+	}
+	for _, tt := range []struct {
+		hostname string
+		client   string
+		want     bool
+	}{
+		{hostname: "router7", client: "10.0.0.1:514", want: true},
+		{hostname: "router7", client: "[fd00::1]:514", want: true},
+		{hostname: "router7", client: "[::ffff:10.0.0.1]:514", want: true},
+		{hostname: "router7", client: "10.0.0.66:514", want: false},
+		// dr is not configured: the first source is learned.
+		{hostname: "dr", client: "10.0.0.16:58045", want: true},
+		{hostname: "dr", client: "10.0.0.16:58046", want: true},
+		{hostname: "dr", client: "10.0.0.66:58045", want: false},
+	} {
+		if got := hs.check(tt.hostname, clientAddr(tt.client)); got != tt.want {
+			t.Errorf("check(%q, %q) = %v, want %v", tt.hostname, tt.client, got, tt.want)
+		}
+	}
+}
+
+func TestParseHostSourcesInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"router7",
+		"=10.0.0.1",
+		"router7=not-an-ip",
+	} {
+		if _, err := parseHostSources(spec); err == nil {
+			t.Errorf("parseHostSources(%q) unexpectedly succeeded", spec)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	for _, tt := range []struct {
+		client string
+		want   netip.Addr
+	}{
+		{client: "10.0.0.16:58045", want: netip.MustParseAddr("10.0.0.16")},
+		{client: "[fe80::1%eth0]:514", want: netip.MustParseAddr("fe80::1")},
+		{client: "", want: netip.Addr{}},
+	} {
+		if got := clientAddr(tt.client); got != tt.want {
+			t.Errorf("clientAddr(%q) = %v, want %v", tt.client, got, tt.want)
+		}
+	}
+}
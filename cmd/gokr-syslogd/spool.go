@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spoolQueue is how many messages may wait for the write loop with -spool_dir
+// before further datagrams are spooled instead of parsed.
+const spoolQueue = 1024
+
+// spoolSuffix is the file name suffix of spool files.
+const spoolSuffix = ".spool"
+
+var (
+	// spooledDatagrams counts datagrams which were spooled during overload.
+	spooledDatagrams = expvar.NewInt("spooled_datagrams")
+
+	// replayedDatagrams counts spooled datagrams which were passed on to the
+	// write loop.
+	replayedDatagrams = expvar.NewInt("replayed_datagrams")
+)
+
+// spool bounds message loss during bursts by disk speed instead of parser
+// speed: while the write loop falls behind, datagrams are appended unparsed
+// (with receive time and source) to files in dir. catchUp parses and passes
+// them on once the write loop has capacity again, so they are filed as if
+// they had been written right away (only later).
+//
+// Each line of a spool file holds one datagram:
+//
+//	<received RFC3339Nano> <client> <base64-encoded datagram>
+type spool struct {
+	dir     string
+	channel chan messageParts
+	format  rawFormat // parses spooled datagrams
+
+	mu sync.Mutex
+	f  *os.File // current spool file, nil until the next datagram is spooled
+	w  *bufio.Writer
+}
+
+func newSpool(dir string, channel chan messageParts, f rawFormat) *spool {
+	return &spool{dir: dir, channel: channel, format: f}
+}
+
+// overloaded reports whether the write loop is falling behind.
+func (sp *spool) overloaded() bool {
+	return len(sp.channel) >= cap(sp.channel)
+}
+
+// handle is the handler of the syslogServer: spooled datagrams (see
+// spoolFormat) are appended to the spool, all others are passed to the write
+// loop.
+func (sp *spool) handle(logParts messageParts) {
+	if spooled, _ := logParts["spool"].(bool); !spooled {
+		sp.channel <- logParts
+		return
+	}
+	raw, _ := logParts["raw"].(string)
+	client, _ := logParts["client"].(string)
+	if err := sp.append(time.Now(), client, raw); err != nil {
+		selfLog.Printf("spool", "spooling datagram from %s: %v", client, err)
+		drop("spool_failed")
+		return
+	}
+	spooledDatagrams.Add(1)
+}
+
+func (sp *spool) append(received time.Time, client, raw string) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f == nil {
+		fn := filepath.Join(sp.dir, fmt.Sprintf("%d%s", received.UnixNano(), spoolSuffix))
+		f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		sp.f = f
+		sp.w = bufio.NewWriter(f)
+	}
+	if client == "" {
+		client = "-"
+	}
+	_, err := fmt.Fprintf(sp.w, "%s %s %s\n", received.Format(time.RFC3339Nano), client, base64.StdEncoding.EncodeToString([]byte(raw)))
+	return err
+}
+
+// rotate closes the current spool file, so that the next datagram starts a
+// new one, and returns the names of all complete spool files, oldest first.
+func (sp *spool) rotate() ([]string, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f != nil {
+		err := sp.w.Flush()
+		if cerr := sp.f.Close(); err == nil {
+			err = cerr
+		}
+		sp.f, sp.w = nil, nil
+		if err != nil {
+			return nil, err
+		}
+	}
+	fns, err := filepath.Glob(filepath.Join(sp.dir, "*"+spoolSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fns) // names are the time of their first datagram
+	return fns, nil
+}
+
+// catchUp periodically passes the spooled datagrams to the write loop while it
+// is not overloaded. Files left over from a previous run are processed, too.
+func (sp *spool) catchUp() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if len(sp.channel) > cap(sp.channel)/2 {
+			continue // still busy
+		}
+		fns, err := sp.rotate()
+		if err != nil {
+			selfLog.Printf("spool", "%v", err)
+			continue
+		}
+		for _, fn := range fns {
+			if err := sp.replay(fn); err != nil {
+				selfLog.Printf("spool", "replaying %s: %v", fn, err)
+				break
+			}
+		}
+	}
+}
+
+// replay parses the datagrams of the spool file fn, passes them to the write
+// loop and removes fn.
+func (sp *spool) replay(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 128*1024) // base64 of a 64 KiB datagram
+	for scanner.Scan() {
+		logParts, err := parseSpoolLine(scanner.Text(), sp.format)
+		if err != nil {
+			log.Printf("%s: %v", fn, err)
+			drop("spool_corrupt")
+			continue
+		}
+		sp.channel <- logParts
+		replayedDatagrams.Add(1)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return os.Remove(fn)
+}
+
+// parseSpoolLine parses a line of a spool file into the logParts of the
+// datagram, which carry the time at which it was received.
+func parseSpoolLine(line string, f rawFormat) (messageParts, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed spool line %q", line)
+	}
+	received, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	logParts, _ := f.parse(raw) // the error is stored as parse_error
+	if client := fields[1]; client != "-" {
+		logParts["client"] = client
+	}
+	logParts["received"] = received
+	return logParts, nil
+}
+
+// spoolFormat skips parsing datagrams while the write loop is overloaded,
+// marking them for the spool instead.
+type spoolFormat struct {
+	messageFormat
+	spool *spool
+}
+
+func (f spoolFormat) parse(raw []byte) (messageParts, error) {
+	if !f.spool.overloaded() {
+		return f.messageFormat.parse(raw)
+	}
+	return messageParts{"raw": string(raw), "spool": true}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	channel := make(chan messageParts, 1)
+	sp := newSpool(t.TempDir(), channel, rawFormat{})
+	f := spoolFormat{messageFormat: rawFormat{}, spool: sp}
+	receive := func(raw string) {
+		logParts, _ := f.parse([]byte(raw))
+		logParts["client"] = "10.0.0.16:58045"
+		sp.handle(logParts)
+	}
+	const (
+		first  = "<14>Aug 13 16:20:00 dr dhcpd: DHCPDISCOVER"
+		second = "<14>Aug 13 16:20:01 dr dhcpd: DHCPOFFER\nwith a newline"
+	)
+	receive(first)  // parsed, fills the channel
+	receive(second) // spooled
+
+	if got := (<-channel)["raw"]; got != first {
+		t.Fatalf("first message: raw = %q, want %q", got, first)
+	}
+	before := time.Now()
+	fns, err := sp.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fns) != 1 {
+		t.Fatalf("rotate() = %q, want 1 spool file", fns)
+	}
+	if err := sp.replay(fns[0]); err != nil {
+		t.Fatal(err)
+	}
+	logParts := <-channel
+	if got := logParts["raw"]; got != second {
+		t.Errorf("spooled message: raw = %q, want %q", got, second)
+	}
+	if got := logParts["tag"]; got != "dhcpd" {
+		t.Errorf("spooled message: tag = %q, want dhcpd", got)
+	}
+	if got := logParts["client"]; got != "10.0.0.16:58045" {
+		t.Errorf("spooled message: client = %q, want 10.0.0.16:58045", got)
+	}
+	if received, _ := logParts["received"].(time.Time); !received.Before(before) {
+		t.Errorf("spooled message: received = %v, want the time of spooling (before %v)", received, before)
+	}
+	if fns, err := sp.rotate(); err != nil || len(fns) != 0 {
+		t.Errorf("rotate() after replay = %q, %v, want no spool files", fns, err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// allHosts is the hostname of tag filter lines which apply to every host.
+const allHosts = "*"
+
+// tagRules are the tag filters of one host (or of allHosts).
+type tagRules struct {
+	allow map[string]bool
+	deny  map[string]bool
+
+	// floors are the least severe severity codes accepted, by tag. The
+	// empty tag holds the floor for all tags.
+	floors map[string]int
+}
+
+// tagFilters decides which messages are accepted from which host, by tag and
+// severity, read from the file given by -tag_filters. Other messages are
+// dropped before anything is written. The file is re-read when it changes.
+type tagFilters struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	rules   map[string]*tagRules // hostname (or allHosts) → rules
+}
+
+// parseTagFilters parses one rule per line: a hostname (or * for all hosts),
+// allow or deny, and one or more tags, or floor, a severity and optionally
+// the tags to which the floor applies, e.g.:
+//
+//	dr      allow dhcpd ntpd
+//	*       deny  wpa_supplicant
+//	chatty  floor notice
+//	*       floor err dhcpd
+//
+// Empty lines and lines starting with # are skipped.
+func parseTagFilters(r io.Reader) (map[string]*tagRules, error) {
+	rules := make(map[string]*tagRules)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected <host> allow|deny <tag>… or <host> floor <severity> [<tag>…], got %q", lineno, line)
+		}
+		host, action, args := fields[0], fields[1], fields[2:]
+		r := rules[host]
+		if r == nil {
+			r = &tagRules{
+				allow:  make(map[string]bool),
+				deny:   make(map[string]bool),
+				floors: make(map[string]int),
+			}
+			rules[host] = r
+		}
+		switch action {
+		case "allow", "deny":
+			set := r.allow
+			if action == "deny" {
+				set = r.deny
+			}
+			for _, tag := range args {
+				set[tag] = true
+			}
+		case "floor":
+			code, err := severity.Parse(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			tags := args[1:]
+			if len(tags) == 0 {
+				tags = []string{""}
+			}
+			for _, tag := range tags {
+				if _, ok := r.floors[tag]; ok {
+					return nil, fmt.Errorf("line %d: duplicate floor for %s", lineno, floorScope(host, tag))
+				}
+				r.floors[tag] = code
+			}
+		default:
+			return nil, fmt.Errorf("line %d: invalid action %q: expected allow, deny or floor", lineno, action)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// newTagFilters reads the tag filters from path.
+func newTagFilters(path string) (*tagFilters, error) {
+	f := &tagFilters{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// reload re-reads the tag filters if their file changed. When the file cannot
+// be read, the previous filters are kept.
+func (f *tagFilters) reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	unchanged := st.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	rules, err := parseTagFilters(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modTime = st.ModTime()
+	f.rules = rules
+	return nil
+}
+
+func floorScope(host, tag string) string {
+	if tag == "" {
+		return host
+	}
+	return host + " " + tag
+}
+
+// filter returns why a message of hostname with tag and severity is dropped,
+// or the empty string if it is accepted.
+//
+// The tag must not be denied for hostname or all hosts, and if allow lines
+// exist for either, one of them must list the tag (reason tag_filtered).
+// Messages must be at least as severe as the most specific floor: for
+// hostname and tag, all hosts and tag, hostname, or all hosts (reason
+// severity_floor). Messages of unknown severity pass floors.
+func (f *tagFilters) filter(hostname, tag string, severity int) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	rules := []*tagRules{f.rules[hostname], f.rules[allHosts]}
+	restricted, allowed := false, false
+	for _, r := range rules {
+		if r == nil {
+			continue
+		}
+		if r.deny[tag] {
+			return "tag_filtered"
+		}
+		if len(r.allow) > 0 {
+			restricted = true
+			allowed = allowed || r.allow[tag]
+		}
+	}
+	if restricted && !allowed {
+		return "tag_filtered"
+	}
+	if severity < 0 {
+		return ""
+	}
+	for _, key := range []string{tag, ""} {
+		for _, r := range rules {
+			if r == nil {
+				continue
+			}
+			if floor, ok := r.floors[key]; ok {
+				if severity > floor {
+					return "severity_floor"
+				}
+				return ""
+			}
+		}
+	}
+	return ""
+}
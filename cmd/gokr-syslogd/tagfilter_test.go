@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagFilters(t *testing.T) {
+	rules, err := parseTagFilters(strings.NewReader(`# spam
+*       deny  wpa_supplicant
+dr      allow dhcpd ntpd
+dr      deny  ntpd
+chatty  floor notice
+chatty  floor info dhcpd
+*       floor err sshd
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		errSev  = 3
+		warning = 4
+		info    = 6
+		debug   = 7
+	)
+	f := &tagFilters{rules: rules}
+	for _, tt := range []struct {
+		hostname, tag string
+		severity      int
+		want          string
+	}{
+		{"router7", "dhcp4d", info, ""},
+		{"router7", "wpa_supplicant", errSev, "tag_filtered"},
+		{"dr", "dhcpd", info, ""},
+		{"dr", "ntpd", info, "tag_filtered"},   // deny wins
+		{"dr", "sshd", errSev, "tag_filtered"}, // not allowed
+		{"dr", "wpa_supplicant", errSev, "tag_filtered"},
+		{"chatty", "ntpd", warning, ""},
+		{"chatty", "ntpd", info, "severity_floor"},
+		{"chatty", "ntpd", -1, ""}, // unknown severity
+		{"chatty", "dhcpd", info, ""},
+		{"chatty", "dhcpd", debug, "severity_floor"},
+		{"chatty", "sshd", warning, "severity_floor"}, // * sshd is more specific
+		{"router7", "sshd", errSev, ""},
+	} {
+		if got := f.filter(tt.hostname, tt.tag, tt.severity); got != tt.want {
+			t.Errorf("filter(%q, %q, %d) = %q, want %q", tt.hostname, tt.tag, tt.severity, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		"* deny",
+		"dr block sshd",
+		"dr floor loud",
+		"dr floor info\ndr floor err",
+	} {
+		if _, err := parseTagFilters(strings.NewReader(input)); err == nil {
+			t.Errorf("parseTagFilters(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestTagFiltersReload(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "tag-filters.txt")
+	if err := os.WriteFile(fn, []byte("* deny wpa_supplicant\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filters, err := newTagFilters(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{tagFilters: filters}
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	parse := func(tag string) bool {
+		_, ok := srv.parse(messageParts{
+			"hostname":  "dr",
+			"tag":       tag,
+			"content":   "CTRL-EVENT-SCAN-STARTED",
+			"timestamp": now,
+		}, now)
+		return ok
+	}
+	if parse("wpa_supplicant") {
+		t.Errorf("parse(tag=wpa_supplicant) unexpectedly succeeded")
+	}
+	if !parse("dhcpd") {
+		t.Errorf("parse(tag=dhcpd) unexpectedly failed")
+	}
+
+	if err := os.WriteFile(fn, []byte("# all tags accepted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fn, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := filters.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !parse("wpa_supplicant") {
+		t.Errorf("parse(tag=wpa_supplicant) after reload unexpectedly failed")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+)
+
+// streamListeners are the listeners of a syslog server in addition to its UDP
+// listener. Empty addresses are not listened on.
+type streamListeners struct {
+	tcpAddr   string // -listen_tcp
+	tlsAddr   string // -listen_tls
+	tlsConfig *tls.Config
+}
+
+// maxFrameLength bounds the length of octet-counted frames, so that they fit
+// into the buffer of the bufio.Scanner of syslogServer.serveStream along with
+// their length.
+const maxFrameLength = bufio.MaxScanTokenSize - len("65536 ")
+
+// splitFrames splits a TCP syslog stream into messages, as framed per RFC
+// 6587: either octet-counted (“37 <14>Aug 13 …”, e.g. rsyslog with
+// TCP_Framing="octet-counted") or terminated by a newline or NUL byte (e.g.
+// rsyslog with @@host, syslog-ng), which is detected per message. A trailing
+// carriage return or newline is removed from the message, empty messages are
+// skipped.
+func splitFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	if data[0] >= '1' && data[0] <= '9' {
+		sp := bytes.IndexByte(data, ' ')
+		if sp == -1 {
+			if len(data) > len(strconv.Itoa(maxFrameLength)) || atEOF {
+				return 0, nil, fmt.Errorf("invalid octet-counted frame: no length followed by space")
+			}
+			return 0, nil, nil // request more data
+		}
+		length, err := strconv.Atoi(string(data[:sp]))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid octet-counted frame: %v", err)
+		}
+		if length > maxFrameLength {
+			return 0, nil, fmt.Errorf("octet-counted frame of %d bytes exceeds %d bytes", length, maxFrameLength)
+		}
+		end := sp + 1 + length
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, fmt.Errorf("truncated octet-counted frame")
+			}
+			return 0, nil, nil // request more data
+		}
+		return end, nonEmpty(bytes.TrimRight(data[sp+1:end], "\r\n")), nil
+	}
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		return i + 1, nonEmpty(bytes.TrimRight(data[:i], "\r")), nil
+	}
+	if atEOF {
+		return len(data), nonEmpty(bytes.TrimRight(data, "\r")), nil
+	}
+	return 0, nil, nil // request more data
+}
+
+// nonEmpty returns msg, or nil (which bufio.Scanner skips) if it is empty.
+func nonEmpty(msg []byte) []byte {
+	if len(msg) == 0 {
+		return nil
+	}
+	return msg
+}
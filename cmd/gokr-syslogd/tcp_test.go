@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitFrames(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		stream  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc:   "newline-terminated",
+			stream: "<14>one\n<14>two\r\n\n<14>three\x00<14>four",
+			want:   []string{"<14>one", "<14>two", "<14>three", "<14>four"},
+		},
+		{
+			desc:   "octet-counted",
+			stream: "7 <14>one11 <14>two 2nd9 <14>four\n",
+			want:   []string{"<14>one", "<14>two 2nd", "<14>four"},
+		},
+		{
+			desc:   "mixed",
+			stream: "7 <14>one<14>two\n7 <14>six",
+			want:   []string{"<14>one", "<14>two", "<14>six"},
+		},
+		{
+			desc:    "truncated",
+			stream:  "9 <14>one",
+			wantErr: true,
+		},
+		{
+			desc:    "too long",
+			stream:  "99999999 <14>one",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			// A small buffer exercises frames split across reads.
+			scanner := bufio.NewScanner(&oneByteReader{strings.NewReader(tt.stream)})
+			scanner.Split(splitFrames)
+			var got []string
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if err := scanner.Err(); (err != nil) != tt.wantErr {
+				t.Fatalf("Scan: err = %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("splitFrames: unexpected diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// oneByteReader returns at most one byte per Read call.
+type oneByteReader struct {
+	r *strings.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
+
+func TestListenTCP(t *testing.T) {
+	srv := &server{
+		dir:          t.TempDir(),
+		files:        make(map[fileKey]*openFile),
+		flushIdle:    1 * time.Millisecond,
+		bufferLimit:  1 << 20,
+		retentionNow: make(chan struct{}, 1),
+	}
+	tcpAddr := freePort(t, "tcp")
+	syslogsrv, channel, err := srv.listen(freePort(t, "udp"), streamListeners{tcpAddr: tcpAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	conn, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().Format(time.Stamp)
+	first := "<14>" + ts + " dr sshd: via newline"
+	second := "<14>" + ts + " dr sshd: octet-counted"
+	if _, err := conn.Write([]byte(first + "\n" + strconv.Itoa(len(second)) + " " + second)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	fn := filepath.Join(srv.dir, "dr", time.Now().Format(basenameFormat))
+	want := []string{"sshd: via newline", "sshd: octet-counted"}
+	var got []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			continue
+		}
+		got = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			got = append(got, logline.Strip(line))
+		}
+		if len(got) == len(want) {
+			break
+		}
+	}
+	syslogsrv.close()
+	syslogsrv.wait()
+	close(channel)
+	<-done
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("stored messages: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/errindex"
+)
+
+// tenant is a separate log tree with its own listen address, directories and
+// retention, e.g. to collect a friend’s logs on the same machine without
+// mixing them with one’s own.
+type tenant struct {
+	name          string
+	listen        string
+	outdir        string
+	quarantineDir string
+	retentionDays int
+}
+
+var validTenantName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// tenantFlag is the -tenant flag, which can be specified multiple times.
+type tenantFlag []tenant
+
+func (f *tenantFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, t := range *f {
+		names = append(names, t.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Set parses a tenant specification like
+// friend,listen=:5515,outdir=/perm/syslogd-friend,retention_days=14. Only the
+// name and listen= are required, the other keys default to the values of the
+// corresponding flags (see resolve).
+func (f *tenantFlag) Set(spec string) error {
+	parts := strings.Split(spec, ",")
+	t := tenant{name: parts[0]}
+	if !validTenantName.MatchString(t.name) {
+		return fmt.Errorf("invalid tenant name %q: expected [a-z0-9_-]+", t.name)
+	}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid key=value pair %q", part)
+		}
+		switch key {
+		case "listen":
+			t.listen = value
+		case "outdir":
+			t.outdir = value
+		case "quarantine_dir":
+			t.quarantineDir = value
+		case "retention_days":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 {
+				return fmt.Errorf("invalid retention_days=%q: expected a positive number of days", value)
+			}
+			t.retentionDays = days
+		default:
+			return fmt.Errorf("unknown key %q (expected one of listen, outdir, quarantine_dir or retention_days)", key)
+		}
+	}
+	if t.listen == "" {
+		return fmt.Errorf("tenant %q: listen= is required", t.name)
+	}
+	for _, other := range *f {
+		if other.name == t.name {
+			return fmt.Errorf("tenant %q specified more than once", t.name)
+		}
+	}
+	*f = append(*f, t)
+	return nil
+}
+
+// resolve fills in the defaults of t: directories next to (not within, so
+// that gokr-syslogweb does not show them as hosts) outdir and quarantineDir,
+// named after the tenant, and the same retention.
+func (t tenant) resolve(outdir, quarantineDir string, retentionDays int) tenant {
+	if t.outdir == "" {
+		t.outdir = filepath.Clean(outdir) + "-" + t.name
+	}
+	if t.quarantineDir == "" {
+		t.quarantineDir = filepath.Clean(quarantineDir) + "-" + t.name
+	}
+	if t.retentionDays == 0 {
+		t.retentionDays = retentionDays
+	}
+	return t
+}
+
+// forTenant returns a server with the settings of s, writing into the
+// directories of t.
+func (s *server) forTenant(t tenant, hs *hostSources) *server {
+	ts := *s
+	ts.dir = t.outdir
+	ts.quarantineDir = t.quarantineDir
+	ts.retentionDays = t.retentionDays
+	ts.hostSources = hs
+	if s.spoolDir != "" {
+		ts.spoolDir = filepath.Join(s.spoolDir, t.name)
+	}
+	ts.files = make(map[fileKey]*openFile)
+	ts.lineBuf = nil
+	ts.mirrorBuf = bytes.Buffer{}
+	ts.flushRequests = make(chan chan error)
+	ts.loopRequests = make(chan func())
+	ts.retentionNow = make(chan struct{}, 1)
+	ts.ping = make(chan struct{}, 1)
+	ts.hangup = make(chan struct{}, 1)
+	if s.ingested != nil {
+		ts.ingested = make(chan messageParts)
+	}
+	if s.boots != nil {
+		ts.boots = make(map[string]*bootState)
+	}
+	if s.errorIndexes != nil {
+		ts.errorIndexes = make(map[string]*errindex.Index)
+	}
+	if s.entityIndexes != nil {
+		ts.entityIndexes = make(map[string]*entityindex.Index)
+	}
+	if s.bindingTables != nil {
+		ts.bindingTables = make(map[string]*bindings.Table)
+	}
+	// Counted per tenant, so that the /hosts and /matrix of a tenant only
+	// show its hosts.
+	if s.hostMetrics != nil {
+		ts.hostMetrics = newHostMetrics()
+		ts.hostMetrics.tenant = t.name
+	}
+	if s.matrix != nil {
+		ts.matrix = newMatrix()
+	}
+	return &ts
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTenantFlag(t *testing.T) {
+	var f tenantFlag
+	for _, spec := range []string{
+		"friend,listen=:5515",
+		"lab,listen=10.0.0.1:514,outdir=/perm/lab,quarantine_dir=/perm/lab-quarantine,retention_days=30",
+	} {
+		if err := f.Set(spec); err != nil {
+			t.Fatalf("Set(%q): %v", spec, err)
+		}
+	}
+	var got []tenant
+	for _, tn := range f {
+		got = append(got, tn.resolve("/perm/syslogd/", "/perm/syslogd-quarantine", 7))
+	}
+	want := []tenant{
+		{
+			name:          "friend",
+			listen:        ":5515",
+			outdir:        "/perm/syslogd-friend",
+			quarantineDir: "/perm/syslogd-quarantine-friend",
+			retentionDays: 7,
+		},
+		{
+			name:          "lab",
+			listen:        "10.0.0.1:514",
+			outdir:        "/perm/lab",
+			quarantineDir: "/perm/lab-quarantine",
+			retentionDays: 30,
+		},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(tenant{})); diff != "" {
+		t.Fatalf("tenants: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{
+		"friend,listen=:5516", // duplicate
+		"../etc,listen=:5516",
+		"nolisten",
+		"x,listen=:1,retention_days=0",
+		"x,listen=:1,color=blue",
+	} {
+		if err := f.Set(spec); err == nil {
+			t.Errorf("Set(%q) unexpectedly succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits reads from r to rate bytes per second on average (see
+// -compress_io_limit), so that compressing a backlog of log files leaves
+// storage bandwidth for writing received messages.
+type throttledReader struct {
+	r    io.Reader
+	rate int64
+
+	start time.Time
+	read  int64
+
+	// now and sleep are replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newThrottledReader(r io.Reader, rate int64) *throttledReader {
+	return &throttledReader{
+		r:     r,
+		rate:  rate,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = t.now()
+	}
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate] // at most a second’s worth per read
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	if wait := due.Sub(t.now()); wait > 0 {
+		t.sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 3, 0, 0, 0, time.UTC)
+	var slept time.Duration
+	contents := strings.Repeat("x", 5000)
+	tr := newThrottledReader(strings.NewReader(contents), 1000)
+	tr.now = func() time.Time { return now }
+	tr.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, tr); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != contents {
+		t.Errorf("read %d bytes, want %d", buf.Len(), len(contents))
+	}
+	if want := 5 * time.Second; slept != want {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/severity"
+	"github.com/klauspost/compress/zstd"
+)
+
+// severityTier keeps lines of severity maxSeverity or more severe (i.e. with a
+// lower code) for days, instead of only for the retention period.
+type severityTier struct {
+	maxSeverity int
+	days        int
+}
+
+// parseSeverityTiers parses a comma-separated list of severity=days pairs,
+// e.g. warning=90,err=365.
+func parseSeverityTiers(spec string) ([]severityTier, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var tiers []severityTier
+	for _, pair := range strings.Split(spec, ",") {
+		name, daysStr, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid severity=days pair %q", pair)
+		}
+		code, err := severity.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("invalid severity=days pair %q: expected a positive number of days", pair)
+		}
+		tiers = append(tiers, severityTier{maxSeverity: code, days: days})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].days > tiers[j].days })
+	return tiers, nil
+}
+
+// baseRetentionDays returns the number of days for which all lines of f are
+// kept.
+func (s *server) baseRetentionDays(f logFile) int {
+	if r := s.routeOf(filepath.Base(f.path)); r != nil && r.retentionDays > 0 {
+		return r.retentionDays
+	}
+	return s.retentionDays
+}
+
+// fileRetentionDays returns the number of days after which f is deleted.
+func (s *server) fileRetentionDays(f logFile) int {
+	days := s.baseRetentionDays(f)
+	for _, tier := range s.severityTiers {
+		if tier.days > days {
+			days = tier.days
+		}
+	}
+	return days
+}
+
+// keepDays returns how many days a line of f is kept.
+func (s *server) keepDays(f logFile, line string) int {
+	days := s.baseRetentionDays(f)
+	v, ok := logline.Field(line, "severity")
+	if !ok {
+		return days
+	}
+	code, err := severity.Parse(v)
+	if err != nil {
+		return days
+	}
+	for _, tier := range s.severityTiers {
+		if code <= tier.maxSeverity && tier.days > days {
+			days = tier.days
+		}
+	}
+	return days
+}
+
+// ageDays returns the number of days between the day of a log file and t.
+func ageDays(day, t time.Time) int {
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	return int(math.Round(today.Sub(day).Hours() / 24))
+}
+
+// needsFiltering reports whether lines of f reached the end of their retention
+// since f was last written (compressed or filtered) at mtime.
+func (s *server) needsFiltering(f logFile, mtime, now time.Time) bool {
+	lastAge, age := ageDays(f.day, mtime), ageDays(f.day, now)
+	thresholds := []int{s.baseRetentionDays(f)}
+	for _, tier := range s.severityTiers {
+		thresholds = append(thresholds, tier.days)
+	}
+	for _, days := range thresholds {
+		if lastAge <= days && days < age {
+			return true
+		}
+	}
+	return false
+}
+
+// filterOldLogs rewrites compressed log files which contain lines that reached
+// the end of their retention (see -severity_retention), so that e.g. warnings
+// outlive info messages.
+func (s *server) filterOldLogs(now time.Time) error {
+	if len(s.severityTiers) == 0 {
+		return nil
+	}
+	files, err := s.logFiles()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, f := range files {
+		if s.state(f, now) != stateCompressed || s.retired.frozen(f.hostname) || s.holds.held(f.hostname, f.day) {
+			continue
+		}
+		st, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		if !s.needsFiltering(f, st.ModTime(), now) {
+			continue
+		}
+		if err := s.filterLogFile(f, now); err != nil {
+			log.Printf("filtering %s: %v", f.path, err)
+		}
+	}
+	return nil
+}
+
+// filteredLines are the lines of a compressed log file which are still within
+// their retention (see filterLines).
+type filteredLines struct {
+	kept           bytes.Buffer
+	total, removed int
+	size           int64 // of all lines, uncompressed
+}
+
+// filterLines reads the compressed log file f and returns the lines which
+// are still within their retention at now.
+func (s *server) filterLines(f logFile, now time.Time) (*filteredLines, error) {
+	src, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dec, err := zstd.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	var fl filteredLines
+	age := ageDays(f.day, now)
+	rd := bufio.NewReader(dec)
+	for {
+		line, err := rd.ReadString('\n')
+		if line != "" {
+			fl.total++
+			fl.size += int64(len(line))
+			if s.keepDays(f, line) >= age {
+				fl.kept.WriteString(line)
+			} else {
+				fl.removed++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &fl, nil
+}
+
+// filterLogFile rewrites the compressed log file f with only the lines which
+// are still within their retention at now. Files without such lines are
+// deleted.
+func (s *server) filterLogFile(f logFile, now time.Time) error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	fl, err := s.filterLines(f, now)
+	if err != nil {
+		return err
+	}
+	total, removed, kept := fl.total, fl.removed, &fl.kept
+	if removed == total {
+		log.Printf("deleting %s: all %d lines past their retention", f.path, total)
+		if err := s.preDelete(f.path); err != nil {
+			return fmt.Errorf("not deleting: -pre_delete_cmd failed: %v", err)
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		s.updateManifest(f.path)
+		s.notifyRetention(retentionEvent{Event: retentionDeleted, File: f.path, Size: st.Size()})
+		return nil
+	}
+	dst, err := newPendingFile(f.path, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer dst.Cleanup()
+	wr, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := wr.Write(kept.Bytes()); err != nil {
+		return err
+	}
+	if err := wr.Close(); err != nil {
+		return err
+	}
+	if err := dst.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	log.Printf("filtered %s: removed %d of %d lines past their retention", f.path, removed, total)
+	s.updateManifest(f.path)
+	return s.chown(f.path)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSeverityTiers(t *testing.T) {
+	for _, spec := range []string{
+		"warning",    // no days
+		"fatal=90",   // unknown severity
+		"warning=0",  // too few days
+		"warning=ab", // invalid days
+	} {
+		if _, err := parseSeverityTiers(spec); err == nil {
+			t.Errorf("parseSeverityTiers(%q) unexpectedly succeeded", spec)
+		}
+	}
+	tiers, err := parseSeverityTiers("warn=90,err=365")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server{
+		dir:           t.TempDir(),
+		files:         make(map[fileKey]*openFile),
+		bufferLimit:   1 << 20,
+		retentionDays: 7,
+		severityTiers: tiers,
+	}
+	ts := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.Local)
+	for _, msg := range []message{
+		{severity: 6, tag: "dhcpd", content: "DHCPDISCOVER"},
+		{severity: 4, tag: "kernel", content: "temperature above threshold"},
+		{severity: 2, tag: "kernel", content: "disk failure"},
+		{severity: -1, tag: "iptables", content: "unknown severity"},
+	} {
+		msg.hostname = "dr"
+		msg.timestamp = ts
+		msg.received = ts
+		srv.write(msg)
+	}
+	srv.flushFiles()
+	srv.closeUnusedFiles(time.Now().Add(time.Hour))
+
+	fn := filepath.Join(srv.dir, "dr", "2022-08-13.log")
+	if err := compressFile(fn, 0); err != nil {
+		t.Fatal(err)
+	}
+	zst := fn + ".zst"
+	compressed := ts.AddDate(0, 0, 1)
+	if err := os.Chtimes(zst, compressed, compressed); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		t.Helper()
+		f, err := os.Open(zst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, dec); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// Within the base retention, nothing is filtered.
+	if err := srv.filterOldLogs(ts.AddDate(0, 0, 7)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(read()), 0; got == want {
+		t.Fatalf("log file unexpectedly empty")
+	}
+
+	now := ts.AddDate(0, 0, 8)
+	if err := srv.filterOldLogs(now); err != nil {
+		t.Fatal(err)
+	}
+	want := "rfc3339=2022-08-13T16:20:00" + ts.Format("Z07:00") + " seq=2 severity=warning kernel: temperature above threshold\n" +
+		"rfc3339=2022-08-13T16:20:00" + ts.Format("Z07:00") + " seq=3 severity=crit kernel: disk failure\n"
+	if diff := cmp.Diff(want, read()); diff != "" {
+		t.Errorf("after base retention: unexpected diff (-want +got):\n%s", diff)
+	}
+	if err := os.Chtimes(zst, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	now = ts.AddDate(0, 0, 91)
+	if err := srv.filterOldLogs(now); err != nil {
+		t.Fatal(err)
+	}
+	want = "rfc3339=2022-08-13T16:20:00" + ts.Format("Z07:00") + " seq=3 severity=crit kernel: disk failure\n"
+	if diff := cmp.Diff(want, read()); diff != "" {
+		t.Errorf("after warning retention: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if got, want := srv.state(logFile{path: zst, hostname: "dr", day: time.Date(2022, time.August, 13, 0, 0, 0, 0, time.Local), compressed: true}, ts.AddDate(0, 0, 365)), stateCompressed; got != want {
+		t.Errorf("state after 365 days = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certLoader serves the certificate of -tls_cert and -tls_key, reloading it
+// once either file changed (e.g. after a renewal), so that renewed
+// certificates take effect without a restart.
+type certLoader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime time.Time // newest modification time of the loaded files
+	cert    *tls.Certificate
+}
+
+// newCertLoader loads the certificate of certFile and keyFile.
+func newCertLoader(certFile, keyFile string) (*certLoader, error) {
+	cl := &certLoader{certFile: certFile, keyFile: keyFile}
+	if _, err := cl.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// filesModTime returns the newest modification time of the files of cl.
+func (cl *certLoader) filesModTime() (time.Time, error) {
+	var newest time.Time
+	for _, fn := range []string{cl.certFile, cl.keyFile} {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. If reloading a changed
+// certificate fails, e.g. because only one of the files was replaced so far,
+// the previous certificate is served.
+func (cl *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	modTime, err := cl.filesModTime()
+	if err == nil && cl.cert != nil && modTime.Equal(cl.modTime) {
+		return cl.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(cl.certFile, cl.keyFile)
+		if err == nil {
+			if cl.cert != nil {
+				log.Printf("reloaded TLS certificate %s", cl.certFile)
+			}
+			cl.cert, cl.modTime = &cert, modTime
+			return cl.cert, nil
+		}
+	}
+	if cl.cert == nil {
+		return nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	log.Printf("reloading TLS certificate %s: %v (serving the previous one)", cl.certFile, err)
+	cl.modTime = modTime // do not retry until the files change again
+	return cl.cert, nil
+}
+
+// tlsConfig returns the configuration of the -listen_tls listener.
+func (cl *certLoader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cl.getCertificate,
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 with the specified
+// common name to certFile and keyFile, and returns it.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestListenTLS(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	cert := writeCert(t, certFile, keyFile, "syslog.lan")
+	certs, err := newCertLoader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		dir:          t.TempDir(),
+		files:        make(map[fileKey]*openFile),
+		flushIdle:    1 * time.Millisecond,
+		bufferLimit:  1 << 20,
+		retentionNow: make(chan struct{}, 1),
+	}
+	tlsAddr := freePort(t, "tcp")
+	syslogsrv, channel, err := srv.listen(freePort(t, "udp"), streamListeners{tlsAddr: tlsAddr, tlsConfig: certs.tlsConfig()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	conn, err := tls.Dial("tcp", tlsAddr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "<14>" + time.Now().Format(time.Stamp) + " dr sshd: over TLS"
+	if _, err := conn.Write([]byte(strconv.Itoa(len(msg)) + " " + msg)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	fn := filepath.Join(srv.dir, "dr", time.Now().Format(basenameFormat))
+	var got string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline) && got == ""; time.Sleep(10 * time.Millisecond) {
+		if b, err := os.ReadFile(fn); err == nil {
+			got = logline.Strip(strings.TrimSpace(string(b)))
+		}
+	}
+	syslogsrv.close()
+	syslogsrv.wait()
+	close(channel)
+	<-done
+	if diff := cmp.Diff("sshd: over TLS", got); diff != "" {
+		t.Errorf("stored message: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCertLoaderReload(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	writeCert(t, certFile, keyFile, "old")
+	certs, err := newCertLoader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commonName := func() string {
+		t.Helper()
+		cert, err := certs.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+	if got, want := commonName(), "old"; got != want {
+		t.Errorf("certificate = %q, want %q", got, want)
+	}
+
+	// A half-replaced pair keeps serving the previous certificate.
+	future := time.Now().Add(time.Minute)
+	if err := os.WriteFile(certFile, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := commonName(), "old"; got != want {
+		t.Errorf("after a broken renewal: certificate = %q, want %q", got, want)
+	}
+
+	writeCert(t, certFile, keyFile, "renewed")
+	future = future.Add(time.Minute)
+	for _, fn := range []string{certFile, keyFile} {
+		if err := os.Chtimes(fn, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := commonName(), "renewed"; got != want {
+		t.Errorf("after renewal: certificate = %q, want %q", got, want)
+	}
+}
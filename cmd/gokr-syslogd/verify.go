@@ -0,0 +1,146 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/manifest"
+	"github.com/klauspost/compress/zstd"
+)
+
+// verifiedFiles counts compressed log files which were checked by the
+// integrity verification job.
+var verifiedFiles = expvar.NewInt("verified_files")
+
+// corruptFiles holds the compressed log files which failed verification in
+// the most recent pass, keyed by path.
+var corruptFiles = struct {
+	sync.Mutex
+	errs map[string]string
+}{
+	errs: make(map[string]string),
+}
+
+func init() {
+	expvar.Publish("corrupt_files", expvar.Func(func() any {
+		corruptFiles.Lock()
+		defer corruptFiles.Unlock()
+		errs := make(map[string]string, len(corruptFiles.errs))
+		for fn, err := range corruptFiles.errs {
+			errs[fn] = err
+		}
+		return errs
+	}))
+}
+
+// verifyFile decompresses the zstd-compressed file fn, which verifies the
+// frame structure and the checksum which zstd.NewWriter stores by default.
+func verifyFile(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	if _, err := io.Copy(io.Discard, dec); err != nil {
+		return err
+	}
+	return nil
+}
+
+// verifyManifest returns an error if fn does not match its entry in the
+// manifest of its directory (see -manifest). Files without entry (e.g.
+// compressed before -manifest existed) are not checked. manifests caches the
+// manifests by directory.
+func verifyManifest(fn string, manifests map[string]*manifest.Manifest) error {
+	dir := filepath.Dir(fn)
+	m, ok := manifests[dir]
+	if !ok {
+		var err error
+		m, err = manifest.ReadFile(filepath.Join(dir, manifest.FileName))
+		if err != nil {
+			return err
+		}
+		manifests[dir] = m
+	}
+	e, ok := m.Lookup(filepath.Base(fn))
+	if !ok {
+		return nil
+	}
+	return e.Verify(fn)
+}
+
+// verifyLoop re-reads all compressed log files once per interval, spreading
+// the work over the interval so that it does not compete with ingestion for
+// the (often slow) SD card. Files which fail to decompress (or do not match
+// the manifest) are logged and reported in the metrics: SD cards and cheap
+// flash do rot.
+func (s *server) verifyLoop(interval time.Duration) {
+	for {
+		start := time.Now()
+		compressed, err := s.logFileNamesInState(start, stateCompressed)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("integrity verification: %v", err)
+		}
+		pause := 1 * time.Second
+		if len(compressed) > 0 {
+			if p := interval / time.Duration(len(compressed)) / 2; p > pause {
+				pause = p
+			}
+		}
+		corrupt := make(map[string]string)
+		manifests := make(map[string]*manifest.Manifest)
+		for _, fn := range compressed {
+			err := verifyFile(fn)
+			if err == nil && s.manifest {
+				err = verifyManifest(fn, manifests)
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue // deleted by retention in the meantime
+				}
+				log.Printf("integrity verification: %s is corrupt: %v", fn, err)
+				corrupt[fn] = err.Error()
+			}
+			verifiedFiles.Add(1)
+			time.Sleep(pause)
+		}
+		corruptFiles.Lock()
+		corruptFiles.errs = corrupt
+		corruptFiles.Unlock()
+		time.Sleep(time.Until(start.Add(interval)))
+	}
+}
+
+// writeVerifyMetrics writes the metrics of the integrity verification job in
+// the Prometheus text exposition format.
+func writeVerifyMetrics(w http.ResponseWriter) {
+	fmt.Fprintf(w, "# HELP syslogd_verified_files_total Compressed log files checked by the integrity verification job.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_verified_files_total counter\n")
+	fmt.Fprintf(w, "syslogd_verified_files_total %d\n", verifiedFiles.Value())
+	corruptFiles.Lock()
+	defer corruptFiles.Unlock()
+	fns := make([]string, 0, len(corruptFiles.errs))
+	for fn := range corruptFiles.errs {
+		fns = append(fns, fn)
+	}
+	sort.Strings(fns)
+	fmt.Fprintf(w, "# HELP syslogd_corrupt_files Compressed log files which failed verification in the most recent pass.\n")
+	fmt.Fprintf(w, "# TYPE syslogd_corrupt_files gauge\n")
+	fmt.Fprintf(w, "syslogd_corrupt_files %d\n", len(fns))
+	for _, fn := range fns {
+		fmt.Fprintf(w, "syslogd_corrupt_file{path=%q} 1\n", fn)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "2022-08-10.log")
+	if err := os.WriteFile(fn, []byte(strings.Repeat("hello syslog\n", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressFile(fn, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyFile(fn + ".zst"); err != nil {
+		t.Fatalf("verifyFile(intact file): %v", err)
+	}
+
+	// Flip a bit within the compressed data.
+	b, err := os.ReadFile(fn + ".zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)/2] ^= 0x10
+	if err := os.WriteFile(fn+".zst", b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyFile(fn + ".zst"); err == nil {
+		t.Fatalf("verifyFile(corrupt file) unexpectedly succeeded")
+	}
+}
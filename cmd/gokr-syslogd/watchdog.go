@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// watchdogExit is called when the write loop is wedged. It is a variable so
+// that tests can replace it.
+var watchdogExit = func(err error) {
+	log.Print(err)
+	pprof.Lookup("goroutine").WriteTo(os.Stderr, 2)
+	// Exit with a non-zero status so that the gokrazy supervisor (or
+	// systemd, or Kubernetes) restarts gokr-syslogd.
+	os.Exit(2)
+}
+
+// watchdog checks that the write loop of s responds to pings within timeout,
+// i.e. that it is not wedged (e.g. blocked in a write to a hung file system).
+// A wedged write loop cannot accept messages anymore, so watchdog exits the
+// process instead of silently dropping messages forever. The watchdog stops
+// when done is closed.
+func (s *server) watchdog(timeout time.Duration, lastBeat *atomic.Int64, done <-chan struct{}) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-done:
+			return
+		}
+		select {
+		case s.ping <- struct{}{}:
+		default: // previous ping not yet answered
+		}
+		if since := now.Sub(time.Unix(0, lastBeat.Load())); since > timeout {
+			watchdogExit(fmt.Errorf("watchdog: write loop for %s did not respond for %v, exiting", s.dir, since.Round(time.Second)))
+			return
+		}
+	}
+}
+
+// reopenFiles closes and re-opens the log files of s, retaining their buffered
+// lines. This is the self-healing step when writes keep failing, e.g. because
+// the file system was remounted and the old file handles became stale.
+func (s *server) reopenFiles() {
+	for key, of := range s.files {
+		f, err := s.openFile(key)
+		if err != nil {
+			selfLog.Printf("open", "reopening log file: %v", err)
+			continue
+		}
+		of.f.Close()
+		of.f = f
+	}
+}
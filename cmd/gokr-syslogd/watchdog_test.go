@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	exited := make(chan error, 1)
+	oldExit := watchdogExit
+	watchdogExit = func(err error) { exited <- err }
+	defer func() { watchdogExit = oldExit }()
+
+	const timeout = 40 * time.Millisecond
+
+	// A responsive write loop keeps the watchdog happy.
+	srv := server{
+		dir:             t.TempDir(),
+		files:           make(map[fileKey]*openFile),
+		bufferLimit:     1 << 20,
+		watchdogTimeout: timeout,
+		ping:            make(chan struct{}, 1),
+	}
+	channel := make(chan messageParts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.run(channel)
+	}()
+	select {
+	case err := <-exited:
+		t.Fatalf("watchdog fired for a responsive write loop: %v", err)
+	case <-time.After(10 * timeout):
+	}
+	close(channel)
+	<-done
+
+	// A wedged write loop (here: none at all) makes the watchdog exit.
+	var lastBeat atomic.Int64
+	lastBeat.Store(time.Now().UnixNano())
+	stop := make(chan struct{})
+	defer close(stop)
+	go srv.watchdog(timeout, &lastBeat, stop)
+	select {
+	case <-exited:
+	case <-time.After(10 * timeout):
+		t.Fatalf("watchdog did not fire for a wedged write loop")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kinds of retention events, see retentionEvent.
+const (
+	retentionCompressed = "compressed"
+	retentionDeleted    = "deleted"
+)
+
+// retentionEvent is posted as JSON to the URLs of -retention_webhook when a
+// log file was compressed or deleted.
+type retentionEvent struct {
+	Event          string    `json:"event"`
+	Host           string    `json:"host"`
+	File           string    `json:"file"`                      // e.g. /perm/syslogd/dr/2022-08-13.log.zst
+	Size           int64     `json:"size"`                      // bytes of the uncompressed (or deleted) file
+	CompressedSize int64     `json:"compressed_size,omitempty"` // bytes of the .zst file
+	Time           time.Time `json:"time"`
+}
+
+// parseWebhooks parses the -retention_webhook flag value.
+func parseWebhooks(spec string) []string {
+	var urls []string
+	for _, u := range strings.Split(spec, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyRetention posts ev to the s.retentionWebhooks whose -output_rules
+// match, through their outboxes with -output_spool_dir. Failures are logged,
+// but do not affect retention.
+func (s *server) notifyRetention(ev retentionEvent) {
+	if len(s.retentionWebhooks) == 0 {
+		return
+	}
+	ev.Host = filepath.Base(filepath.Dir(ev.File))
+	ev.Time = time.Now()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		selfLog.Printf("webhook", "%v", err)
+		return
+	}
+	for i, u := range s.retentionWebhooks {
+		if !s.outputRules.matches(u, ev.Host, "", -1) {
+			continue
+		}
+		if s.webhookOutboxes != nil {
+			if err := s.webhookOutboxes[i].enqueue(b); err != nil {
+				selfLog.Printf("webhook", "queueing %s %s for %s: %v", ev.Event, ev.File, u, err)
+			}
+			continue
+		}
+		if err := postWebhook(s.webhookTemplate, u, b); err != nil {
+			selfLog.Printf("webhook", "notifying %s of %s %s: %v", u, ev.Event, ev.File, err)
+		}
+	}
+}
+
+// postWebhook posts the JSON-encoded retention event body to u, or the request
+// which wt renders for it if wt is non-nil.
+func postWebhook(wt *webhookTemplate, u string, body []byte) error {
+	var req *http.Request
+	var err error
+	if wt != nil {
+		req, err = wt.request(u, body)
+	} else {
+		req, err = http.NewRequest("POST", u, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected HTTP response: %v", resp.Status)
+	}
+	return nil
+}
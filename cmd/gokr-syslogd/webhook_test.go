@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRetentionWebhook(t *testing.T) {
+	events := make(chan retentionEvent, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev retentionEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	srv := server{
+		dir:               t.TempDir(),
+		files:             make(map[fileKey]*openFile),
+		retentionDays:     7,
+		retentionWebhooks: parseWebhooks(ts.URL + ", "),
+	}
+	fn := filepath.Join(srv.dir, "dr", "2022-08-10.log.zst")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.deleteOldLogs(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	want := retentionEvent{
+		Event: retentionDeleted,
+		Host:  "dr",
+		File:  fn,
+		Size:  5,
+	}
+	if diff := cmp.Diff(want, <-events, cmpopts.IgnoreFields(retentionEvent{}, "Time")); diff != "" {
+		t.Errorf("retention event: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	type request struct {
+		Method, Path, Query, Auth, ContentType, Body string
+	}
+	requests := make(chan request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(b)}
+	}))
+	defer ts.Close()
+
+	fn := filepath.Join(t.TempDir(), "gotify.tmpl")
+	const tmpl = `{{define "url"}}{{.URL}}/message?host={{urlquery .Host}}{{end}}
+{{define "header Authorization"}}Bearer s3cr3t{{end}}
+{{define "body"}}{"title": {{json .Event}}, "message": {{json (printf "%s (%d bytes)" .File .Size)}}}{{end}}
+`
+	if err := os.WriteFile(fn, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wt, err := parseWebhookTemplate(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{
+		retentionWebhooks: []string{ts.URL},
+		webhookTemplate:   wt,
+	}
+	srv.notifyRetention(retentionEvent{Event: retentionDeleted, File: "/perm/syslogd/dr/2022-08-10.log.zst", Size: 5})
+	want := request{
+		Method:      "POST",
+		Path:        "/message",
+		Query:       "host=dr",
+		Auth:        "Bearer s3cr3t",
+		ContentType: "application/json",
+		Body:        `{"title": "deleted", "message": "/perm/syslogd/dr/2022-08-10.log.zst (5 bytes)"}`,
+	}
+	if diff := cmp.Diff(want, <-requests); diff != "" {
+		t.Errorf("webhook request: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(fn, []byte(`{{define "url"}}{{.URL}}{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseWebhookTemplate(fn); err == nil {
+		t.Errorf("parseWebhookTemplate without body template unexpectedly succeeded")
+	}
+}
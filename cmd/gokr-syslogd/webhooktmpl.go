@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// webhookTemplate builds the webhook requests from a file of template
+// definitions (see -webhook_template), so that retention events can be sent
+// to APIs expecting their own payloads:
+//
+//	{{define "url"}}{{.URL}}?title={{urlquery .Event}}{{end}}
+//	{{define "header Authorization"}}Bearer s3cr3t{{end}}
+//	{{define "body"}}{"message": {{json (printf "%s: %s" .Event .File)}}}{{end}}
+//
+// body is required; url (default: the -retention_webhook URL), method
+// (default: POST) and "header <name>" templates (default: Content-Type
+// application/json) are optional. The templates are executed with the
+// retentionEvent and the configured URL as .URL.
+type webhookTemplate struct {
+	tmpl *template.Template
+}
+
+// webhookData is the data with which webhook templates are executed.
+type webhookData struct {
+	retentionEvent
+	URL string
+}
+
+var webhookFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. to quote strings in a JSON body.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseWebhookTemplate reads the template definitions from path.
+func parseWebhookTemplate(path string) (*webhookTemplate, error) {
+	tmpl, err := template.New("").Funcs(webhookFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup("body") == nil {
+		return nil, fmt.Errorf("%s: no body template defined", path)
+	}
+	return &webhookTemplate{tmpl: tmpl}, nil
+}
+
+func (wt *webhookTemplate) execute(name string, data webhookData) (string, error) {
+	var buf bytes.Buffer
+	if err := wt.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// request renders the request for the JSON-encoded retention event body to
+// the webhook u.
+func (wt *webhookTemplate) request(u string, body []byte) (*http.Request, error) {
+	data := webhookData{URL: u}
+	if err := json.Unmarshal(body, &data.retentionEvent); err != nil {
+		return nil, err
+	}
+	payload, err := wt.execute("body", data)
+	if err != nil {
+		return nil, err
+	}
+	method := "POST"
+	if wt.tmpl.Lookup("method") != nil {
+		if method, err = wt.execute("method", data); err != nil {
+			return nil, err
+		}
+		method = strings.TrimSpace(method)
+	}
+	if wt.tmpl.Lookup("url") != nil {
+		if u, err = wt.execute("url", data); err != nil {
+			return nil, err
+		}
+		u = strings.TrimSpace(u)
+	}
+	req, err := http.NewRequest(method, u, strings.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, t := range wt.tmpl.Templates() {
+		if !strings.HasPrefix(t.Name(), "header ") {
+			continue
+		}
+		name := strings.TrimPrefix(t.Name(), "header ")
+		value, err := wt.execute(t.Name(), data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, strings.TrimSpace(value))
+	}
+	return req, nil
+}
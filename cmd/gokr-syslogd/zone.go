@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// zone labels the senders within a network, e.g. the site they are at, for
+// collectors receiving messages from multiple sites (e.g. over VPN).
+type zone struct {
+	name   string
+	prefix netip.Prefix
+}
+
+var validZoneName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// parseZones parses a comma-separated list of zone=cidr pairs, e.g.
+// home=10.0.0.0/24,office=10.8.0.0/16,office=fd08::/64.
+func parseZones(spec string) ([]zone, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var zones []zone
+	for _, pair := range strings.Split(spec, ",") {
+		name, cidr, ok := strings.Cut(pair, "=")
+		if !ok || !validZoneName.MatchString(name) {
+			return nil, fmt.Errorf("invalid zone=cidr pair %q (zone names must match [a-z0-9_-]+)", pair)
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone=cidr pair %q: %v", pair, err)
+		}
+		zones = append(zones, zone{name: name, prefix: prefix.Masked()})
+	}
+	return zones, nil
+}
+
+// zoneFor returns the name of the most specific zone containing addr, or the
+// empty string if no zone contains addr.
+func zoneFor(zones []zone, addr netip.Addr) string {
+	var (
+		name string
+		bits = -1
+	)
+	for _, z := range zones {
+		if z.prefix.Contains(addr) && z.prefix.Bits() > bits {
+			name, bits = z.name, z.prefix.Bits()
+		}
+	}
+	return name
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestZoneFor(t *testing.T) {
+	zones, err := parseZones("vpn=10.0.0.0/8,home=10.0.0.0/24,office=10.8.0.0/16,office=fd08::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		addr string
+		want string
+	}{
+		{addr: "10.0.0.16", want: "home"}, // most specific
+		{addr: "10.8.1.2", want: "office"},
+		{addr: "fd08::1", want: "office"},
+		{addr: "10.9.0.1", want: "vpn"},
+		{addr: "192.168.1.1", want: ""},
+	} {
+		if got := zoneFor(zones, netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("zoneFor(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"home",
+		"home=10.0.0.1",
+		"Home Network=10.0.0.0/24",
+	} {
+		if _, err := parseZones(spec); err == nil {
+			t.Errorf("parseZones(%q) unexpectedly succeeded", spec)
+		}
+	}
+}
+
+func TestWriteZone(t *testing.T) {
+	zones, err := parseZones("home=10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server{
+		dir:         t.TempDir(),
+		files:       make(map[fileKey]*openFile),
+		bufferLimit: 1 << 20,
+		zones:       zones,
+	}
+	now := time.Now()
+	for _, client := range []string{"10.0.0.16:58045", "192.168.1.1:514"} {
+		msg, ok := srv.parse(messageParts{
+			"hostname":  "dr",
+			"tag":       "dhcpd",
+			"content":   "DHCPDISCOVER",
+			"timestamp": now,
+			"client":    client,
+		}, now)
+		if !ok {
+			t.Fatalf("parse() failed")
+		}
+		srv.write(msg)
+	}
+	srv.flushFiles()
+
+	b, err := os.ReadFile(filepath.Join(srv.dir, "dr", now.Format(basenameFormat)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := now.Format(time.RFC3339Nano)
+	want := "rfc3339=" + ts + " seq=1 zone=home dhcpd: DHCPDISCOVER\n" +
+		"rfc3339=" + ts + " seq=2 dhcpd: DHCPDISCOVER\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("log file: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,448 @@
+// Binary gokr-sysloggen sends generated messages to gokr-syslogd to load test
+// it. For soak tests, faults can be injected into the generated traffic (see
+// fault), and with -verify_dir, gokr-sysloggen reports at the end of the run
+// exactly which of its messages made it to disk.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// tag is the tag of all generated messages.
+const tag = "sysloggen"
+
+// maxDrift is how old a timestamp gokr-syslogd accepts (see its clock_drift
+// reject reason).
+const maxDrift = 24 * time.Hour
+
+// maxFutureSkew is how far in the future a timestamp gokr-syslogd accepts by
+// default (see its -max_future_skew flag).
+const maxFutureSkew = 24 * time.Hour
+
+// fault is injected into a generated message.
+type fault int
+
+const (
+	faultNone fault = iota
+
+	// faultDuplicate sends the datagram twice.
+	faultDuplicate
+
+	// faultReorder sends the datagram after the next one.
+	faultReorder
+
+	// faultTruncate cuts the datagram at a random offset.
+	faultTruncate
+
+	// faultSkew moves the timestamp by -skew_by into the past.
+	faultSkew
+
+	numFaults
+)
+
+func (f fault) String() string {
+	switch f {
+	case faultNone:
+		return "none"
+	case faultDuplicate:
+		return "duplicate"
+	case faultReorder:
+		return "reorder"
+	case faultTruncate:
+		return "truncate"
+	case faultSkew:
+		return "skew"
+	}
+	return "unknown"
+}
+
+// generator generates the messages of one run.
+type generator struct {
+	rng      *rand.Rand
+	run      string // identifies the messages of this run
+	hostname string
+	size     int // of the padding after the message ID
+
+	// probability of each fault, indexed by fault
+	probability [numFaults]float64
+	skewBy      time.Duration
+}
+
+// content returns the content of message n: its ID, followed by padding
+// whose integrity can be verified.
+func (g *generator) content(n int) string {
+	return fmt.Sprintf("run=%s n=%d %s", g.run, n, padding(g.size))
+}
+
+func padding(size int) string {
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	return strings.Repeat(alphabet, size/len(alphabet)+1)[:size]
+}
+
+// message returns the datagram of message n, sent at now, and the fault to
+// inject, which the caller applies for faultDuplicate and faultReorder.
+func (g *generator) message(n int, now time.Time) ([]byte, fault) {
+	f := faultNone
+	r := g.rng.Float64()
+	for candidate := faultDuplicate; candidate < numFaults; candidate++ {
+		if r < g.probability[candidate] {
+			f = candidate
+			break
+		}
+		r -= g.probability[candidate]
+	}
+	ts := now
+	if f == faultSkew {
+		ts = ts.Add(-g.skewBy)
+	}
+	b := []byte(fmt.Sprintf("<14>%s %s %s: %s", ts.Format(time.Stamp), g.hostname, tag, g.content(n)))
+	if f == faultTruncate {
+		b = b[:1+g.rng.Intn(len(b)-1)]
+	}
+	return b, f
+}
+
+// expectedCopies returns how many copies of a message with fault f gokr-syslogd
+// should write, or -1 if it is unknown (truncated messages might be rejected).
+func (g *generator) expectedCopies(f fault) int {
+	switch f {
+	case faultDuplicate:
+		return 2 // gokr-syslogd does not deduplicate
+	case faultTruncate:
+		return -1
+	case faultSkew:
+		if g.skewBy > maxDrift || -g.skewBy > maxFutureSkew {
+			return 0
+		}
+	}
+	return 1
+}
+
+// send sends count messages at rate messages per second (0 means as fast as
+// possible) and returns the fault injected into each message, and how many
+// datagrams could not be sent. Sending continues after errors (e.g.
+// connection refused while gokr-syslogd restarts), the messages are lost.
+func (g *generator) send(ctx context.Context, w io.Writer, count int, rate float64) (faults []fault, failed int) {
+	faults = make([]fault, 0, count)
+	write := func(b []byte) {
+		if _, err := w.Write(b); err != nil {
+			if failed == 0 {
+				log.Printf("sending message: %v", err)
+			}
+			failed++
+		}
+	}
+	var held []byte // reordered datagram, sent after the next one
+	start := time.Now()
+	for n := 0; n < count; n++ {
+		if rate > 0 {
+			next := start.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+			time.Sleep(time.Until(next))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		b, f := g.message(n, time.Now())
+		faults = append(faults, f)
+		if f == faultReorder && held == nil {
+			held = b
+			continue
+		}
+		write(b)
+		if f == faultDuplicate {
+			write(b)
+		}
+		if held != nil {
+			write(held)
+			held = nil
+		}
+	}
+	if held != nil {
+		write(held)
+	}
+	return faults, failed
+}
+
+// found is what verify found on disk for one message.
+type found struct {
+	copies    int // complete copies
+	truncated int // copies with truncated padding
+}
+
+// verify scans the log files of g.hostname in dir for the messages of the
+// run and returns the copies found per message number. Lines of the run whose
+// padding is not a prefix of the generated padding are returned as corrupted.
+func (g *generator) verify(ctx context.Context, dir string) (map[int]found, []string, error) {
+	hostDir := filepath.Join(dir, g.hostname)
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := tag + ": run=" + g.run + " n="
+	want := padding(g.size)
+	results := make(map[int]found)
+	var corrupted []string
+	for _, entry := range entries {
+		if !logtree.IsLogFile(entry.Name()) {
+			continue
+		}
+		fn := filepath.Join(hostDir, strings.TrimSuffix(entry.Name(), ".zst"))
+		if strings.HasSuffix(entry.Name(), ".zst") {
+			if _, err := os.Stat(fn); err == nil {
+				continue // scanned as fn
+			}
+		}
+		err := logtree.Scan(ctx, fn, func(line string) {
+			rest := logline.Strip(line)
+			if !strings.HasPrefix(rest, prefix) {
+				return
+			}
+			num, pad, ok := strings.Cut(strings.TrimPrefix(rest, prefix), " ")
+			n, err := strconv.Atoi(num)
+			if !ok || err != nil {
+				return // truncated within the ID
+			}
+			r := results[n]
+			switch {
+			case pad == want:
+				r.copies++
+			case strings.HasPrefix(want, pad):
+				r.truncated++
+			default:
+				corrupted = append(corrupted, line)
+			}
+			results[n] = r
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return results, corrupted, nil
+}
+
+// stats summarize the verification of the messages with one fault.
+type stats struct {
+	sent       int
+	stored     int // messages with at least one complete copy
+	lost       []int
+	unexpected []int // more (or fewer, but some) copies than expected
+	truncated  int   // messages stored truncated
+}
+
+// summarize compares the copies found with the expected copies.
+func (g *generator) summarize(faults []fault, results map[int]found) [numFaults]stats {
+	var st [numFaults]stats
+	for n, f := range faults {
+		s := &st[f]
+		s.sent++
+		r := results[n]
+		if r.copies > 0 {
+			s.stored++
+		}
+		if r.truncated > 0 {
+			s.truncated++
+		}
+		want := g.expectedCopies(f)
+		switch {
+		case want == -1:
+		case r.copies == 0 && want > 0:
+			s.lost = append(s.lost, n)
+		case r.copies != want:
+			s.unexpected = append(s.unexpected, n)
+		}
+	}
+	return st
+}
+
+// formatNumbers formats at most 10 message numbers.
+func formatNumbers(ns []int) string {
+	sort.Ints(ns)
+	var parts []string
+	for i, n := range ns {
+		if i == 10 {
+			parts = append(parts, fmt.Sprintf("… (%d more)", len(ns)-i))
+			break
+		}
+		parts = append(parts, strconv.Itoa(n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// report prints the verification results to w and returns an error if
+// messages were lost, duplicated or corrupted unexpectedly.
+func report(w io.Writer, st [numFaults]stats, corrupted []string) error {
+	var lost, unexpected int
+	for f := faultNone; f < numFaults; f++ {
+		s := st[f]
+		if s.sent == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "fault=%s: %d sent, %d stored", f, s.sent, s.stored)
+		if s.truncated > 0 {
+			fmt.Fprintf(w, ", %d stored truncated", s.truncated)
+		}
+		fmt.Fprintf(w, ", %d lost, %d unexpected copies\n", len(s.lost), len(s.unexpected))
+		if len(s.lost) > 0 {
+			fmt.Fprintf(w, "  lost: %s\n", formatNumbers(s.lost))
+		}
+		if len(s.unexpected) > 0 {
+			fmt.Fprintf(w, "  unexpected copies: %s\n", formatNumbers(s.unexpected))
+		}
+		lost += len(s.lost)
+		unexpected += len(s.unexpected)
+	}
+	for _, line := range corrupted {
+		fmt.Fprintf(w, "corrupted: %s\n", line)
+	}
+	if lost > 0 || unexpected > 0 || len(corrupted) > 0 {
+		return fmt.Errorf("%d messages lost, %d with unexpected copies, %d corrupted lines", lost, unexpected, len(corrupted))
+	}
+	return nil
+}
+
+func sysloggen(ctx context.Context) error {
+	var (
+		target = flag.String("target",
+			"localhost:5514",
+			"host:port of gokr-syslogd (UDP)")
+
+		hostname = flag.String("hostname",
+			"sysloggen",
+			"hostname to send messages as")
+
+		count = flag.Int("count",
+			10000,
+			"number of messages to send")
+
+		rate = flag.Float64("rate",
+			1000,
+			"messages per second (0 sends as fast as possible)")
+
+		size = flag.Int("size",
+			100,
+			"bytes of padding per message")
+
+		seed = flag.Int64("seed",
+			0,
+			"seed for message and fault selection, to reproduce a run (0 picks one)")
+
+		duplicate = flag.Float64("duplicate",
+			0,
+			"probability of sending a message twice")
+
+		reorder = flag.Float64("reorder",
+			0,
+			"probability of sending a message after the next one")
+
+		truncate = flag.Float64("truncate",
+			0,
+			"probability of cutting a message at a random offset")
+
+		skew = flag.Float64("skew",
+			0,
+			"probability of moving the timestamp of a message by -skew_by into the past")
+
+		skewBy = flag.Duration("skew_by",
+			48*time.Hour,
+			"how far -skew moves timestamps into the past (gokr-syslogd rejects timestamps older than 24h, and by default more than 24h in the future; negative values move into the future)")
+
+		verifyDir = flag.String("verify_dir",
+			"",
+			"if non-empty, the -syslogd_dir of gokr-syslogd: after sending, check which messages were written to disk, and exit with an error if messages were lost")
+
+		flushURL = flag.String("flush_url",
+			"",
+			"if non-empty, the /flush URL of gokr-syslogd (e.g. http://localhost:5515/flush), which is requested before verifying")
+
+		settle = flag.Duration("settle",
+			2*time.Second,
+			"how long to wait after sending before verifying, so that gokr-syslogd processes the last datagrams")
+	)
+	flag.Parse()
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	g := &generator{
+		rng:      rand.New(rand.NewSource(*seed)),
+		hostname: *hostname,
+		size:     *size,
+		skewBy:   *skewBy,
+	}
+	// Not derived from the seed, so that repeated runs can be told apart.
+	g.run = strconv.FormatInt(time.Now().UnixNano(), 36)
+	g.probability[faultDuplicate] = *duplicate
+	g.probability[faultReorder] = *reorder
+	g.probability[faultTruncate] = *truncate
+	g.probability[faultSkew] = *skew
+	var total float64
+	for _, p := range g.probability {
+		if p < 0 {
+			return fmt.Errorf("fault probabilities must not be negative")
+		}
+		total += p
+	}
+	if total > 1 {
+		return fmt.Errorf("fault probabilities add up to %v, more than 1", total)
+	}
+
+	conn, err := net.Dial("udp", *target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Printf("run %s (-seed=%d): sending %d messages to %s", g.run, *seed, *count, *target)
+	start := time.Now()
+	faults, failed := g.send(ctx, conn, *count, *rate)
+	elapsed := time.Since(start)
+	log.Printf("sent %d messages in %v (%.0f messages/s)", len(faults), elapsed.Round(time.Millisecond), float64(len(faults))/elapsed.Seconds())
+	if failed > 0 {
+		log.Printf("%d datagrams could not be sent", failed)
+	}
+
+	if *verifyDir == "" {
+		return nil
+	}
+	time.Sleep(*settle)
+	if *flushURL != "" {
+		resp, err := http.Post(*flushURL, "", nil)
+		if err != nil {
+			return err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s: %s", *flushURL, resp.Status, strings.TrimSpace(string(body)))
+		}
+	}
+	results, corrupted, err := g.verify(ctx, *verifyDir)
+	if err != nil {
+		return err
+	}
+	return report(os.Stdout, g.summarize(faults, results), corrupted)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := sysloggen(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
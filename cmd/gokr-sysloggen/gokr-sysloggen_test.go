@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// datagrams records the datagrams written to it.
+type datagrams [][]byte
+
+func (d *datagrams) Write(b []byte) (int, error) {
+	*d = append(*d, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestSoak(t *testing.T) {
+	g := &generator{
+		rng:      rand.New(rand.NewSource(1)),
+		run:      "0badc0de",
+		hostname: "soak",
+		size:     40,
+		skewBy:   48 * time.Hour,
+	}
+	for f := faultDuplicate; f < numFaults; f++ {
+		g.probability[f] = 0.1
+	}
+	var sent datagrams
+	faults, failed := g.send(context.Background(), &sent, 200, 0)
+	if failed > 0 {
+		t.Fatalf("send: %d datagrams failed", failed)
+	}
+	counts := make(map[fault]int)
+	for _, f := range faults {
+		counts[f]++
+	}
+	for f := faultNone; f < numFaults; f++ {
+		if counts[f] == 0 {
+			t.Fatalf("no message with fault=%s in %d messages", f, len(faults))
+		}
+	}
+	if got, want := len(sent), len(faults)+counts[faultDuplicate]; got != want {
+		t.Errorf("sent %d datagrams, want %d (one per message plus duplicates)", got, want)
+	}
+
+	// Store the datagrams like gokr-syslogd would, losing one message.
+	var lose int
+	for n, f := range faults {
+		if f == faultNone {
+			lose = n
+			break
+		}
+	}
+	var stored bytes.Buffer
+	for i, b := range sent {
+		rest := string(b[len("<14>"):])
+		if len(rest) < len(time.Stamp) {
+			continue
+		}
+		ts, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], time.Local)
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		ts = ts.AddDate(now.Year(), 0, 0)
+		if now.Sub(ts) > maxDrift {
+			continue // clock_drift
+		}
+		_, msg, ok := strings.Cut(rest, " soak ")
+		if !ok || strings.Contains(msg, fmt.Sprintf(" n=%d ", lose)) {
+			continue
+		}
+		fmt.Fprintf(&stored, "rfc3339=%s seq=%d %s\n", ts.Format(time.RFC3339), i+1, msg)
+	}
+	// A corrupted line, which is reported, and a line of another run, which is
+	// ignored.
+	fmt.Fprintf(&stored, "rfc3339=%s seq=998 sysloggen: run=0badc0de n=0 corrupted\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&stored, "rfc3339=%s seq=999 sysloggen: run=cafe n=0 %s\n", time.Now().Format(time.RFC3339), padding(40))
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "soak"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "soak", time.Now().Format("2006-01-02.log")), stored.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, corrupted, err := g.verify(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := g.summarize(faults, results)
+	if diff := cmp.Diff([]int{lose}, st[faultNone].lost); diff != "" {
+		t.Errorf("lost: unexpected diff (-want +got):\n%s", diff)
+	}
+	for f := faultDuplicate; f < numFaults; f++ {
+		if len(st[f].lost) > 0 || len(st[f].unexpected) > 0 {
+			t.Errorf("fault=%s: lost %v, unexpected copies %v, want none", f, st[f].lost, st[f].unexpected)
+		}
+	}
+	if got, want := st[faultSkew].stored, 0; got != want {
+		t.Errorf("fault=skew: %d stored, want %d (rejected because of clock drift)", got, want)
+	}
+	if len(corrupted) != 1 {
+		t.Errorf("corrupted = %q, want 1 line", corrupted)
+	}
+	var out strings.Builder
+	if err := report(&out, st, corrupted); err == nil {
+		t.Errorf("report() unexpectedly succeeded:\n%s", out.String())
+	}
+	if want := fmt.Sprintf("  lost: %d\n", lose); !strings.Contains(out.String(), want) {
+		t.Errorf("report does not contain %q:\n%s", want, out.String())
+	}
+}
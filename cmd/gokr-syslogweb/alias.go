@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// hostDirs returns the host directories in dir which hold the logs of host:
+// the directory of host itself and those of its old names (see
+// -host_aliases), as far as they exist.
+func hostDirs(dir string, aliases hostalias.Map, host string) ([]string, error) {
+	hosts, err := logtree.ListHosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, h := range hosts {
+		exists[h] = true
+	}
+	var dirs []string
+	for _, name := range aliases.Names(aliases.Resolve(host)) {
+		if exists[name] {
+			dirs = append(dirs, name)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, httpError(http.StatusNotFound, fmt.Errorf("host %q not found", host))
+	}
+	return dirs, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+)
+
+// annotationTag is the tag of the annotations of operator actions which
+// gokr-syslogd stores (see its /annotate endpoint and gokr-syslogctl
+// annotate).
+const annotationTag = "annotation"
+
+// annotationMarker precedes annotations in plain text responses, so that
+// they stand out between the messages of the devices.
+const annotationMarker = "*** "
+
+// isAnnotation reports whether the stored line is an annotation.
+func isAnnotation(line string) bool {
+	_, rest := logline.Split(line)
+	return strings.HasPrefix(rest, annotationTag+": ")
+}
+
+// markAnnotation returns line preceded by annotationMarker if stored is an
+// annotation, and line otherwise.
+func markAnnotation(stored, line string) string {
+	if !isAnnotation(stored) {
+		return line
+	}
+	return annotationMarker + line
+}
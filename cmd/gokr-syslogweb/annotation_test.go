@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestMarkAnnotation(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want string
+	}{
+		{
+			line: `rfc3339=2022-08-13T14:02:00Z seq=7 annotation: author=michael text="upgraded router7 to v2.3"`,
+			want: `*** rfc3339=2022-08-13T14:02:00Z seq=7 annotation: author=michael text="upgraded router7 to v2.3"`,
+		},
+		{
+			line: `rfc3339=2022-08-13T14:02:00Z seq=8 sshd: annotation: not one`,
+			want: `rfc3339=2022-08-13T14:02:00Z seq=8 sshd: annotation: not one`,
+		},
+	} {
+		if got := markAnnotation(tt.line, tt.line); got != tt.want {
+			t.Errorf("markAnnotation(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
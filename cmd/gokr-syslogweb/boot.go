@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gokrazy/syslogd/internal/bootlog"
+	"github.com/gokrazy/syslogd/internal/logline"
+)
+
+// findBoot returns the boot session id of the host whose directory is
+// hostDir. The id "current" refers to the latest boot session, "previous" to
+// the one before.
+func findBoot(hostDir, id string) (bootlog.Boot, error) {
+	boots, err := bootlog.ReadFile(filepath.Join(hostDir, bootlog.FileName))
+	if err != nil {
+		return bootlog.Boot{}, err
+	}
+	switch id {
+	case "current":
+		if len(boots) > 0 {
+			return boots[len(boots)-1], nil
+		}
+	case "previous":
+		if len(boots) > 1 {
+			return boots[len(boots)-2], nil
+		}
+	default:
+		for _, b := range boots {
+			if b.ID == id {
+				return b, nil
+			}
+		}
+	}
+	return bootlog.Boot{}, httpError(http.StatusNotFound, fmt.Errorf("boot %q not found (is gokr-syslogd running with -boot_sessions?)", id))
+}
+
+// inBoot reports whether line was stored with a boot= field of id.
+func inBoot(line, id string) bool {
+	v, ok := logline.Field(line, "boot")
+	return ok && v == id
+}
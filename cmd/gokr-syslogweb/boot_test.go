@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bootlog"
+)
+
+func TestFindBoot(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := findBoot(dir, "current"); err == nil {
+		t.Errorf("findBoot(current) without boots unexpectedly succeeded")
+	}
+	boots := []bootlog.Boot{
+		{Start: time.Date(2022, time.August, 12, 9, 0, 0, 0, time.UTC), ID: "3f2a9c1d0b7e4a68"},
+		{Start: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC), ID: "c0ffee0123456789"},
+	}
+	if err := os.WriteFile(filepath.Join(dir, bootlog.FileName), []byte(boots[0].String()+"\n"+boots[1].String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{
+		"current":          "c0ffee0123456789",
+		"previous":         "3f2a9c1d0b7e4a68",
+		"3f2a9c1d0b7e4a68": "3f2a9c1d0b7e4a68",
+	} {
+		b, err := findBoot(dir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.ID != want {
+			t.Errorf("findBoot(%q) = %q, want %q", id, b.ID, want)
+		}
+	}
+	if _, err := findBoot(dir, "deadbeef"); err == nil {
+		t.Errorf("findBoot(deadbeef) unexpectedly succeeded")
+	}
+}
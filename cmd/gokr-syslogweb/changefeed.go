@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+const (
+	defaultChangesLimit = 1000
+	maxChangesLimit     = 100000
+)
+
+// changefeedCursor records how far a consumer has read: the number of bytes
+// consumed per host directory and log file (without .zst, so that compressing
+// a file does not change its position).
+type changefeedCursor map[string]map[string]int64
+
+// parseCursor decodes a cursor returned by encode. The empty cursor starts at
+// the beginning of all files.
+func parseCursor(s string) (changefeedCursor, error) {
+	c := make(changefeedCursor)
+	if s == "" {
+		return c, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c changefeedCursor) encode() string {
+	b, err := json.Marshal(c) // map keys are sorted
+	if err != nil {
+		panic(err) // cannot happen: only strings and integers
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// change is one record of the changefeed.
+type change struct {
+	Host string `json:"host"`
+	File string `json:"file"`
+	// ID is the permalink ID of the line (see /line/).
+	ID   string `json:"id"`
+	Line string `json:"line"`
+}
+
+// logFiles returns the names of the log files in the host directory, without
+// .zst and sorted (i.e. oldest day first).
+func logFiles(dir string) ([]string, error) {
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, fi := range fis {
+		if fi.IsDir() || !logtree.IsLogFile(fi.Name()) {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".zst")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readChanges returns up to limit complete lines after cursor, host by host
+// and file by file, and the cursor after the last returned line. Entries for
+// files which no longer exist (e.g. deleted by retention) are dropped from
+// the returned cursor.
+func readChanges(ctx context.Context, dir string, cache *logtree.Cache, cursor changefeedCursor, limit int) ([]change, changefeedCursor, error) {
+	hosts, err := logtree.ListHosts(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(hosts)
+	next := make(changefeedCursor)
+	var changes []change
+	for _, host := range hosts {
+		names, err := logFiles(filepath.Join(dir, host))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			offset := cursor[host][name]
+			if len(changes) < limit {
+				fileChanges, n, err := readFileChanges(ctx, cache, filepath.Join(dir, host, name), offset, limit-len(changes))
+				if err != nil {
+					if os.IsNotExist(err) {
+						continue // deleted in the meantime
+					}
+					return nil, nil, err
+				}
+				for _, c := range fileChanges {
+					c.Host = host
+					changes = append(changes, c)
+				}
+				offset = n
+			}
+			if offset > 0 {
+				if next[host] == nil {
+					next[host] = make(map[string]int64)
+				}
+				next[host][name] = offset
+			}
+		}
+	}
+	return changes, next, nil
+}
+
+// readFileChanges returns up to limit complete lines of the log file fn after
+// offset (without their host), and the offset after the last returned line. A file that is shorter
+// than offset was replaced (e.g. rotated externally) and is read from the
+// start again.
+func readFileChanges(ctx context.Context, cache *logtree.Cache, fn string, offset int64, limit int) ([]change, int64, error) {
+	f, err := cache.Open(ctx, fn)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { f.Close() }()
+	if err := skip(f, offset); err == io.EOF {
+		f.Close()
+		if f, err = cache.Open(ctx, fn); err != nil {
+			return nil, 0, err
+		}
+		offset = 0
+	} else if err != nil {
+		return nil, 0, err
+	}
+	name := filepath.Base(fn)
+	var changes []change
+	br := bufio.NewReader(f)
+	for len(changes) < limit {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break // an incomplete line is returned once it is complete
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		text := string(bytes.TrimSuffix(line, []byte{'\n'}))
+		changes = append(changes, change{
+			File: name,
+			ID:   lineID(name, offset, text),
+			Line: text,
+		})
+		offset += int64(len(line))
+	}
+	return changes, offset, nil
+}
+
+// skip advances f by offset bytes, returning io.EOF if f is shorter.
+func skip(f *logtree.File, offset int64) error {
+	if s, ok := f.Reader.(io.Seeker); ok {
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if size < offset {
+			return io.EOF
+		}
+		_, err = s.Seek(offset, io.SeekStart)
+		return err
+	}
+	n, err := io.CopyN(io.Discard, f, offset)
+	if err == io.EOF && n < offset {
+		return io.EOF
+	}
+	return err
+}
+
+var validConsumer = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// consumerFile returns the file in which the cursor of the consumer= parameter
+// is stored in cursorDir.
+func consumerFile(cursorDir string, r *http.Request) (string, error) {
+	consumer := r.FormValue("consumer")
+	if cursorDir == "" {
+		return "", httpError(http.StatusNotFound, fmt.Errorf("durable consumers require -changefeed_dir"))
+	}
+	if !validConsumer.MatchString(consumer) {
+		return "", httpError(http.StatusBadRequest, fmt.Errorf("invalid consumer= parameter (expected letters, digits, _ or -)"))
+	}
+	return filepath.Join(cursorDir, consumer+".cursor"), nil
+}
+
+// changesHandler serves the lines after the cursor= parameter (all lines if
+// empty) as NDJSON, with the cursor to continue from in the Changefeed-Cursor
+// header. With consumer=, the cursor stored by commitHandler is used instead.
+func changesHandler(dir string, cache *logtree.Cache, cursorDir string) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		limit := defaultChangesLimit
+		if v := r.FormValue("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxChangesLimit {
+				return httpError(http.StatusBadRequest, fmt.Errorf("invalid limit= parameter (expected 1 to %d)", maxChangesLimit))
+			}
+			limit = n
+		}
+		encoded := r.FormValue("cursor")
+		if r.FormValue("consumer") != "" && encoded == "" {
+			fn, err := consumerFile(cursorDir, r)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(fn)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			encoded = strings.TrimSpace(string(b))
+		}
+		cursor, err := parseCursor(encoded)
+		if err != nil {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid cursor: %v", err))
+		}
+
+		changes, next, err := readChanges(r.Context(), dir, cache, cursor, limit)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Changefeed-Cursor", next.encode())
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		for _, c := range changes {
+			if err := enc.Encode(c); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+}
+
+// commitHandler stores the cursor= parameter as the cursor of the consumer=
+// parameter, which a consumer does once it processed the lines up to cursor.
+func commitHandler(cursorDir string) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return httpError(http.StatusMethodNotAllowed, fmt.Errorf("commit requires POST"))
+		}
+		fn, err := consumerFile(cursorDir, r)
+		if err != nil {
+			return err
+		}
+		encoded := r.FormValue("cursor")
+		if _, err := parseCursor(encoded); err != nil {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid cursor: %v", err))
+		}
+		// Replace the file atomically, so that a crash keeps the previous
+		// cursor instead of losing the position.
+		if err := writeFileAtomically(fn, []byte(encoded+"\n")); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// writeFileAtomically replaces fn with a file containing b, so that readers
+// (and a crash) see either the old or the new content.
+func writeFileAtomically(fn string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fn), "."+filepath.Base(fn))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
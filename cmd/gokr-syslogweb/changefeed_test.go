@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChangefeed(t *testing.T) {
+	dir := t.TempDir()
+	for _, host := range []string{"dr", "router7"} {
+		if err := os.MkdirAll(filepath.Join(dir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendFile := func(fn, content string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join(dir, fn), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendFile("dr/2022-08-13.log", "seq=1 dhcpd: DHCPDISCOVER\nseq=2 dhcpd: DHCPOFFER\n")
+	appendFile("dr/2022-08-14.log", "seq=3 dhcpd: DHCPREQUEST\n")
+	appendFile("router7/2022-08-14.log", "seq=1 dhcp4d: lease\nseq=2 incomplete")
+
+	cursorDir := t.TempDir()
+	changes := middleware(changesHandler(dir, nil, cursorDir))
+	commit := middleware(commitHandler(cursorDir))
+	get := func(query string) (lines []string, cursor string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		changes.ServeHTTP(rec, httptest.NewRequest("GET", "/changes?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /changes?%s: status = %d: %s", query, rec.Code, rec.Body.String())
+		}
+		dec := json.NewDecoder(rec.Body)
+		for dec.More() {
+			var c change
+			if err := dec.Decode(&c); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, c.Host+"/"+c.File+" "+c.Line)
+		}
+		return lines, rec.Header().Get("Changefeed-Cursor")
+	}
+
+	// Incomplete lines are not returned; the limit splits files.
+	got, cursor := get("limit=2")
+	want := []string{
+		"dr/2022-08-13.log seq=1 dhcpd: DHCPDISCOVER",
+		"dr/2022-08-13.log seq=2 dhcpd: DHCPOFFER",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("first page: unexpected diff (-want +got):\n%s", diff)
+	}
+	got, cursor = get("cursor=" + url.QueryEscape(cursor))
+	want = []string{
+		"dr/2022-08-14.log seq=3 dhcpd: DHCPREQUEST",
+		"router7/2022-08-14.log seq=1 dhcp4d: lease",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("second page: unexpected diff (-want +got):\n%s", diff)
+	}
+	if got, _ := get("cursor=" + url.QueryEscape(cursor)); len(got) > 0 {
+		t.Errorf("drained feed returned %q, want nothing", got)
+	}
+
+	// Durable consumers continue where they committed.
+	rec := httptest.NewRecorder()
+	commit.ServeHTTP(rec, httptest.NewRequest("POST", "/changes/commit?consumer=backup&cursor="+url.QueryEscape(cursor), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("commit: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	appendFile("router7/2022-08-14.log", "\n")
+	appendFile("dr/2022-08-14.log", "seq=4 dhcpd: DHCPACK\n")
+	got, _ = get("consumer=backup")
+	want = []string{
+		"dr/2022-08-14.log seq=4 dhcpd: DHCPACK",
+		"router7/2022-08-14.log seq=2 incomplete",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("consumer: unexpected diff (-want +got):\n%s", diff)
+	}
+	if got, _ := get("consumer=unknown"); len(got) != 6 {
+		t.Errorf("new consumer got %d lines, want all 6", len(got))
+	}
+
+	// Deleted files are dropped from the cursor.
+	if err := os.Remove(filepath.Join(dir, "dr", "2022-08-13.log")); err != nil {
+		t.Fatal(err)
+	}
+	_, cursor = get("consumer=backup")
+	c, err := parseCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c["dr"]["2022-08-13.log"]; ok {
+		t.Errorf("cursor %v still contains deleted file dr/2022-08-13.log", c)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		hdl          http.Handler
+		wantCode     int
+	}{
+		{"GET", "/changes?cursor=garbage", changes, http.StatusBadRequest},
+		{"GET", "/changes?limit=0", changes, http.StatusBadRequest},
+		{"GET", "/changes?consumer=../x", changes, http.StatusBadRequest},
+		{"GET", "/changes/commit?consumer=backup", commit, http.StatusMethodNotAllowed},
+		{"POST", "/changes/commit?consumer=backup&cursor=garbage", commit, http.StatusBadRequest},
+		{"POST", "/changes/commit?cursor=", commit, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		tt.hdl.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader("")))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.wantCode)
+		}
+	}
+}
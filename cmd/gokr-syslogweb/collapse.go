@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// collapser folds consecutive lines of the same host with identical tag and
+// content (i.e. ignoring their key=value fields like rfc3339= and seq=) into
+// the first of them, for the collapse=true parameter.
+type collapser struct {
+	emit func(host string, first logtree.Line, last string, count int) error
+
+	key   string
+	host  string
+	first logtree.Line
+	last  string // the most recent line folded into first
+	count int
+}
+
+// wantCollapse reports whether r asks for repeated lines to be collapsed,
+// falling back to the preferences of the browser without collapse=.
+func wantCollapse(r *http.Request) bool {
+	v := r.FormValue("collapse")
+	if _, ok := r.Form["collapse"]; !ok {
+		return requestPreferences(r).Collapse
+	}
+	return v == "true" || v == "1"
+}
+
+func (c *collapser) add(host string, l logtree.Line) error {
+	key := host + " " + logline.Strip(l.Text)
+	if c.count > 0 && key == c.key {
+		c.last = l.Text
+		c.count++
+		return nil
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.key, c.host, c.first, c.last, c.count = key, host, l, l.Text, 1
+	return nil
+}
+
+// flush emits the pending line, if any.
+func (c *collapser) flush() error {
+	if c.count == 0 {
+		return nil
+	}
+	count := c.count
+	c.count = 0
+	return c.emit(c.host, c.first, c.last, count)
+}
+
+// collapsedText returns line, annotated with how often it was repeated.
+func collapsedText(line, last string, count int) string {
+	if count == 1 {
+		return line
+	}
+	if ts, ok := logline.Field(last, "rfc3339"); ok {
+		return fmt.Sprintf("%s (%d times, last at %s)", line, count, ts)
+	}
+	return fmt.Sprintf("%s (%d times)", line, count)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCollapse(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		"rfc3339=2022-08-13T16:00:00Z seq=1 wpa_supplicant: CTRL-EVENT-DISCONNECTED",
+		"rfc3339=2022-08-13T16:00:05Z seq=2 wpa_supplicant: CTRL-EVENT-CONNECTED",
+		"rfc3339=2022-08-13T16:00:10Z seq=3 wpa_supplicant: CTRL-EVENT-CONNECTED",
+		"rfc3339=2022-08-13T16:00:15Z seq=4 wpa_supplicant: CTRL-EVENT-CONNECTED",
+		"rfc3339=2022-08-13T16:00:20Z seq=5 wpa_supplicant: CTRL-EVENT-DISCONNECTED",
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-13.log"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hdl := middleware(searchHandler(dir, nil, nil))
+	get := func(query string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("GET", "/search?collapse=true&q="+url.QueryEscape("since:87600h")+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	want := `dr rfc3339=2022-08-13T16:00:00Z seq=1 wpa_supplicant: CTRL-EVENT-DISCONNECTED
+dr rfc3339=2022-08-13T16:00:05Z seq=2 wpa_supplicant: CTRL-EVENT-CONNECTED (3 times, last at 2022-08-13T16:00:15Z)
+dr rfc3339=2022-08-13T16:00:20Z seq=5 wpa_supplicant: CTRL-EVENT-DISCONNECTED
+`
+	if diff := cmp.Diff(want, get("")); diff != "" {
+		t.Errorf("collapse: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	var got []searchResult
+	dec := json.NewDecoder(strings.NewReader(get("&format=jsonl")))
+	for dec.More() {
+		var r searchResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if len(got) != 3 || got[1].Count != 3 || got[1].Line != lines[1] || got[1].Last != lines[3] || got[0].Count != 0 {
+		t.Errorf("collapse (jsonl): unexpected results %+v", got)
+	}
+}
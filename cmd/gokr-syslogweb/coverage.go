@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/coverage"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+)
+
+// coverageHandler serves which of the last days= (default 30) days have log
+// files per host, which are missing and which are unusually small (see package
+// coverage), hosts with the most gaps first.
+func coverageHandler(dir string, aliases hostalias.Map) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		days := coverage.DefaultDays
+		if v := r.FormValue("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 366 {
+				return httpError(http.StatusBadRequest, fmt.Errorf("invalid days= parameter (expected 1 to 366)"))
+			}
+			days = n
+		}
+		now := time.Now()
+		hosts, err := coverage.Report(dir, aliases, now, days)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return coverage.Write(w, hosts, now, days)
+	}
+}
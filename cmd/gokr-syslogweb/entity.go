@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/query"
+	"github.com/gokrazy/syslogd/internal/retired"
+)
+
+// mentions reports whether the stored line mentions entity.
+func mentions(line, entity string) bool {
+	for _, e := range entityindex.Extract(logline.Strip(line)) {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
+
+// linesAt returns the lines of the log file fn starting at offsets (in
+// ascending order) which mention entity, and false if any of them does not
+// (i.e. the file was rewritten).
+func linesAt(ctx context.Context, cache *logtree.Cache, fn string, offsets []int64, entity string) ([]logtree.Line, bool, error) {
+	f, err := cache.Open(ctx, fn)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var (
+		lines []logtree.Line
+		pos   int64
+	)
+	for _, offset := range offsets {
+		if offset < pos {
+			return nil, false, nil
+		}
+		if _, err := br.Discard(int(offset - pos)); err != nil {
+			if err == io.EOF {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		if text == "" {
+			return nil, false, nil // past the end
+		}
+		pos = offset + int64(len(text))
+		text = strings.TrimSuffix(text, "\n")
+		if !mentions(text, entity) {
+			return nil, false, nil
+		}
+		lines = append(lines, logtree.Line{File: filepath.Base(fn), Offset: offset, Text: text})
+	}
+	return lines, true, nil
+}
+
+// entityLines returns the lines of the log file fn mentioning entity, using
+// the offsets of ref where they are complete and valid.
+func entityLines(ctx context.Context, cache *logtree.Cache, fn string, ref *entityindex.Ref, entity string) ([]logtree.Line, error) {
+	if ref.Complete() {
+		offsets := append([]int64(nil), ref.Offsets...)
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		lines, ok, err := linesAt(ctx, cache, fn, offsets, entity)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return lines, nil
+		}
+	}
+	var lines []logtree.Line
+	err := cache.ScanOffsets(ctx, fn, func(offset int64, text string) {
+		if mentions(text, entity) {
+			lines = append(lines, logtree.Line{File: filepath.Base(fn), Offset: offset, Text: text})
+		}
+	})
+	return lines, err
+}
+
+// collectEntity returns the lines of all hosts mentioning entity which match
+// q, in timestamp order (see collectTrace).
+func collectEntity(ctx context.Context, dir string, aliases hostalias.Map, cache *logtree.Cache, entity string, q *query.Query, now time.Time) ([]traceLine, bool, error) {
+	start, end := q.Period(now)
+	if start.IsZero() {
+		start = now.Add(-logtree.DefaultSearchPeriod)
+	}
+	firstDay, lastDay := logtree.SearchDays(start, end)
+	hosts, err := logtree.ListHosts(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	retiredHosts, err := retired.Hosts(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	var (
+		lines     []traceLine
+		truncated bool
+	)
+Hosts:
+	for _, hostDir := range hosts {
+		host := aliases.Resolve(hostDir)
+		if !q.MatchHost(host) {
+			continue
+		}
+		if _, ok := retiredHosts[hostDir]; ok && !q.IncludeRetired && len(q.Hosts) == 0 {
+			continue
+		}
+		idx, err := entityindex.ReadFile(filepath.Join(dir, hostDir, entityindex.FileName))
+		if err != nil {
+			return nil, false, err
+		}
+		e, ok := idx.Lookup(entity)
+		if !ok {
+			continue
+		}
+		for _, ref := range e.Files {
+			if len(ref.File) < len("2006-01-02") || strings.ContainsAny(ref.File, `/\`) {
+				continue
+			}
+			day := ref.File[:len("2006-01-02")]
+			if day < firstDay || lastDay != "" && day > lastDay {
+				continue
+			}
+			found, err := entityLines(ctx, cache, filepath.Join(dir, hostDir, ref.File), ref, entity)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue // deleted by retention
+				}
+				return nil, false, err
+			}
+			for _, l := range found {
+				if !q.Match(l.Text, start, end) {
+					continue
+				}
+				if len(lines) == maxTraceLines {
+					truncated = true
+					break Hosts
+				}
+				l.HostDir = hostDir
+				lines = append(lines, newTraceLine(host, l))
+			}
+		}
+	}
+	sortTimeline(lines)
+	return lines, truncated, nil
+}
+
+// entityHandler serves the lines of all hosts mentioning the IP or MAC address
+// of /entity/<address>, according to the entity indexes of gokr-syslogd
+// -entity_index, in timestamp order. The q=, format=, tz= and names= parameters
+// work like for traceHandler, e.g. q=since:7d for the last week.
+func entityHandler(dir string, aliases hostalias.Map, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		addr := strings.TrimPrefix(r.URL.Path, "/entity/")
+		entity, ok := entityindex.Normalize(addr)
+		if !ok {
+			return httpError(http.StatusNotFound, fmt.Errorf("%q is not an IP or MAC address", addr))
+		}
+		q, err := query.Parse(r.FormValue("q"))
+		if err != nil {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid query (q= parameter): %v", err))
+		}
+		q.IncludeRetired = r.FormValue("retired") == "1"
+		format := r.FormValue("format")
+		if format != "" && format != "jsonl" {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid format= parameter (expected jsonl)"))
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if loc != nil {
+			now = now.In(loc)
+		}
+		names, err := requestBindings(r, dir)
+		if err != nil {
+			return err
+		}
+		lines, truncated, err := collectEntity(r.Context(), dir, aliases, cache, entity, q, now)
+		if err != nil {
+			return err
+		}
+		return writeTimeline(w, lines, truncated, format, loc, names)
+	}
+}
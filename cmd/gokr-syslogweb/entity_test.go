@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEntity(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	for _, h := range []struct {
+		host  string
+		lines []string
+		// offsets overrides the offsets recorded in the index, to simulate
+		// a rewritten file.
+		offsets []int64
+	}{
+		{
+			host: "router7",
+			lines: []string{
+				"rfc3339=2022-08-13T16:00:00Z seq=1 dhcp4d: DHCPACK 192.168.1.57 to aa:bb:cc:dd:ee:ff",
+				"rfc3339=2022-08-13T16:00:02Z seq=2 dhcp4d: DHCPACK 192.168.1.60",
+				"rfc3339=2022-08-13T16:00:03Z seq=3 dnsd: query example.com from 192.168.1.57:5353",
+			},
+		},
+		{
+			host: "dr",
+			lines: []string{
+				"rfc3339=2022-08-13T16:00:01Z seq=1 sshd: Accepted publickey from 192.168.1.57",
+				"rfc3339=2022-08-13T16:00:04Z seq=2 sshd: Disconnected from 192.168.1.57",
+			},
+			offsets: []int64{0, 1},
+		},
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, h.host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		idx := entityindex.New()
+		var offset int64
+		for i, line := range h.lines {
+			o := offset
+			if h.offsets != nil {
+				o = h.offsets[i]
+			}
+			for _, e := range entityindex.Extract(line) {
+				idx.Observe(e, "2022-08-13.log", o, ts)
+			}
+			offset += int64(len(line)) + 1
+		}
+		f, err := os.Create(filepath.Join(dir, h.host, entityindex.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fn := filepath.Join(dir, h.host, "2022-08-13.log")
+		if err := os.WriteFile(fn, []byte(strings.Join(h.lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entity := middleware(entityHandler(dir, nil, nil))
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		entity.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	code, body := get("/entity/192.168.1.57?q=" + url.QueryEscape("since:87600h"))
+	if code != http.StatusOK {
+		t.Fatalf("entity: status = %d: %s", code, body)
+	}
+	want := `router7 rfc3339=2022-08-13T16:00:00Z seq=1 dhcp4d: DHCPACK 192.168.1.57 to aa:bb:cc:dd:ee:ff
+dr rfc3339=2022-08-13T16:00:01Z seq=1 sshd: Accepted publickey from 192.168.1.57
+router7 rfc3339=2022-08-13T16:00:03Z seq=3 dnsd: query example.com from 192.168.1.57:5353
+dr rfc3339=2022-08-13T16:00:04Z seq=2 sshd: Disconnected from 192.168.1.57
+`
+	if diff := cmp.Diff(want, body); diff != "" {
+		t.Errorf("entity: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	code, body = get("/entity/AA-BB-CC-DD-EE-FF?q=" + url.QueryEscape("since:87600h"))
+	if code != http.StatusOK || !strings.Contains(body, "DHCPACK 192.168.1.57") {
+		t.Errorf("entity by MAC: status = %d, body = %q", code, body)
+	}
+	if code, _ := get("/entity/router7"); code != http.StatusNotFound {
+		t.Errorf("entity of a hostname: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
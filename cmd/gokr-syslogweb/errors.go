@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/errindex"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// errorsHandler serves the error indexes which gokr-syslogd maintains (see
+// gokr-syslogd -error_index) of all hosts (or the host= parameter, including
+// its old names), most recently first seen first. The q= parameter restricts the list to errors
+// containing q, answering whether an error is new or has always been there.
+func errorsHandler(dir string, aliases hostalias.Map) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		hosts, err := logtree.ListHosts(dir)
+		if err != nil {
+			return err
+		}
+		if host := r.FormValue("host"); host != "" {
+			hosts, err = hostDirs(dir, aliases, host)
+			if err != nil {
+				return err
+			}
+		}
+		q := r.FormValue("q")
+
+		type hostEntry struct {
+			host string
+			errindex.Entry
+		}
+		var entries []hostEntry
+		for _, host := range hosts {
+			idx, err := errindex.ReadFile(filepath.Join(dir, host, errindex.FileName))
+			if err != nil {
+				return err
+			}
+			for _, e := range idx.Entries() {
+				if q != "" && !strings.Contains(e.Template, q) && !strings.Contains(e.Example, q) {
+					continue
+				}
+				entries = append(entries, hostEntry{aliases.Resolve(host), e})
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].FirstSeen.After(entries[j].FirstSeen)
+		})
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+		format := func(t time.Time) string {
+			if loc != nil {
+				t = t.In(loc)
+			}
+			return t.Format(time.RFC3339)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "# distinct errors across %d hosts, most recently first seen first\n", len(aliases.Current(hosts)))
+		fmt.Fprintf(w, "# %-25s %-25s %8s  %-20s %s\n", "first seen", "last seen", "count", "host", "template")
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "  %-25s %-25s %8d  %-20s %s\n",
+				format(e.FirstSeen),
+				format(e.LastSeen),
+				e.Count,
+				e.host,
+				e.Template); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+const (
+	// estimateSampleBytes is how much of the newest files estimateQuery
+	// reads to estimate the number of matches.
+	estimateSampleBytes = 1 << 20
+
+	// assumedCompressionRatio is the ratio by which zstd typically shrinks
+	// log files, used to estimate their decompressed size.
+	assumedCompressionRatio = 10
+)
+
+// queryEstimate is what a query would cost, as served for HEAD requests (in
+// X-Syslog-* headers) and with estimate=1 (as JSON) by the query endpoints.
+type queryEstimate struct {
+	Files           int   `json:"files"`
+	CompressedFiles int   `json:"compressed_files"`
+	Bytes           int64 `json:"bytes"` // as stored
+	// ScanBytes is the estimated size of the files once decompressed, i.e.
+	// how much the query reads.
+	ScanBytes int64 `json:"scan_bytes"`
+	// SampledBytes of the newest files contained SampledMatches matching
+	// lines, from which EstimatedMatches is extrapolated.
+	SampledBytes     int64 `json:"sampled_bytes"`
+	SampledMatches   int64 `json:"sampled_matches"`
+	EstimatedMatches int64 `json:"estimated_matches"`
+}
+
+// wantEstimate reports whether r asks for the estimate of its query instead of
+// its results: HEAD requests or estimate=1.
+func wantEstimate(r *http.Request) bool {
+	return r.Method == http.MethodHead || r.FormValue("estimate") == "1"
+}
+
+// estimateQuery estimates the cost of scanning files (paths without .zst, as
+// cache.Open takes them, oldest first) for lines for which match returns true,
+// by scanning up to estimateSampleBytes of the newest files.
+func estimateQuery(ctx context.Context, cache *logtree.Cache, files []string, match func(line []byte) bool) (queryEstimate, error) {
+	var est queryEstimate
+	for _, fn := range files {
+		if st, err := os.Stat(fn); err == nil {
+			est.Bytes += st.Size()
+			est.ScanBytes += st.Size()
+		} else if st, err := os.Stat(fn + ".zst"); err == nil {
+			est.CompressedFiles++
+			est.Bytes += st.Size()
+			est.ScanBytes += assumedCompressionRatio * st.Size()
+		} else {
+			continue // e.g. deleted in the meantime
+		}
+		est.Files++
+	}
+	complete := true // whether the sample covers all files
+	for i := len(files) - 1; i >= 0; i-- {
+		if est.SampledBytes >= estimateSampleBytes {
+			complete = false
+			break
+		}
+		f, err := cache.Open(ctx, files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return est, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if est.SampledBytes >= estimateSampleBytes {
+				complete = false
+				break
+			}
+			est.SampledBytes += int64(len(scanner.Bytes())) + 1
+			if match(scanner.Bytes()) {
+				est.SampledMatches++
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return est, err
+		}
+	}
+	switch {
+	case complete:
+		est.EstimatedMatches = est.SampledMatches
+		est.ScanBytes = est.SampledBytes
+	case est.SampledBytes > 0:
+		est.EstimatedMatches = int64(float64(est.SampledMatches) / float64(est.SampledBytes) * float64(est.ScanBytes))
+	}
+	return est, nil
+}
+
+// write serves est, as headers only for HEAD requests.
+func (est queryEstimate) write(w http.ResponseWriter, r *http.Request) error {
+	h := w.Header()
+	h.Set("X-Syslog-Files", strconv.Itoa(est.Files))
+	h.Set("X-Syslog-Compressed-Files", strconv.Itoa(est.CompressedFiles))
+	h.Set("X-Syslog-Bytes", strconv.FormatInt(est.Bytes, 10))
+	h.Set("X-Syslog-Scan-Bytes", strconv.FormatInt(est.ScanBytes, 10))
+	h.Set("X-Syslog-Estimated-Matches", strconv.FormatInt(est.EstimatedMatches, 10))
+	h.Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(est)
+}
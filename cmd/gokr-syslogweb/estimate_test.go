@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEstimate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		"rfc3339=2022-08-13T16:00:00Z seq=1 sshd: Accepted publickey",
+		"rfc3339=2022-08-13T16:01:00Z seq=2 dhcpd: DHCPDISCOVER",
+		"rfc3339=2022-08-13T16:02:00Z seq=3 sshd: Accepted publickey",
+	}
+	contents := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-13.log"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	search := middleware(searchHandler(dir, nil, nil))
+	target := "/search?q=" + url.QueryEscape("host:dr sshd since:87600h")
+	rec := httptest.NewRecorder()
+	search.ServeHTTP(rec, httptest.NewRequest("HEAD", target, nil))
+	if rec.Code != 200 {
+		t.Fatalf("HEAD: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.Len() > 0 {
+		t.Errorf("HEAD: unexpected body %q", rec.Body.String())
+	}
+	for header, want := range map[string]string{
+		"X-Syslog-Files":             "1",
+		"X-Syslog-Bytes":             fmt.Sprint(len(contents)),
+		"X-Syslog-Estimated-Matches": "2",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("HEAD: %s = %q, want %q", header, got, want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	search.ServeHTTP(rec, httptest.NewRequest("GET", target+"&estimate=1", nil))
+	var got queryEstimate
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("estimate=1: %v (body %q)", err, rec.Body.String())
+	}
+	want := queryEstimate{
+		Files:            1,
+		Bytes:            int64(len(contents)),
+		ScanBytes:        int64(len(contents)),
+		SampledBytes:     int64(len(contents)),
+		SampledMatches:   2,
+		EstimatedMatches: 2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("estimate=1: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestEstimateExtrapolates(t *testing.T) {
+	dir := t.TempDir()
+	// Each file holds twice the sample size, every other line matching.
+	var b strings.Builder
+	for i := 0; b.Len() < 2*estimateSampleBytes; i++ {
+		if i%2 == 0 {
+			b.WriteString("rfc3339=2022-08-13T16:00:00Z seq=1 sshd: match\n")
+		} else {
+			b.WriteString("rfc3339=2022-08-13T16:00:00Z seq=2 sshd: other\n")
+		}
+	}
+	var files []string
+	for _, name := range []string{"2022-08-12.log", "2022-08-13.log"} {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fn)
+	}
+	est, err := estimateQuery(context.Background(), nil, files, func(line []byte) bool {
+		return strings.HasSuffix(string(line), "match")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.SampledBytes < estimateSampleBytes || est.SampledBytes > estimateSampleBytes+100 {
+		t.Errorf("sampled %d bytes, want about %d (whole lines)", est.SampledBytes, estimateSampleBytes)
+	}
+	want := int64(strings.Count(b.String(), "match\n") * len(files))
+	if diff := est.EstimatedMatches - want; diff < -want/100 || diff > want/100 {
+		t.Errorf("estimated %d matches, want about %d", est.EstimatedMatches, want)
+	}
+}
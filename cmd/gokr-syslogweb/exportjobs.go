@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/query"
+)
+
+// maxExportBytes bounds the result of an export job, which is held in memory.
+const maxExportBytes = 64 << 20
+
+// exportSchedule is when an export job runs: hourly (at minute 0), daily at
+// hour:minute or weekly on weekday at hour:minute.
+type exportSchedule struct {
+	every        string // hourly, daily or weekly
+	weekday      time.Weekday
+	hour, minute int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseExportSchedule parses hourly, daily=06:00 or weekly=mon@06:00.
+func parseExportSchedule(spec string) (exportSchedule, error) {
+	every, arg, _ := strings.Cut(spec, "=")
+	sched := exportSchedule{every: every}
+	switch every {
+	case "hourly":
+		if arg != "" {
+			return exportSchedule{}, fmt.Errorf("invalid schedule %q: hourly takes no time", spec)
+		}
+		return sched, nil
+	case "daily":
+	case "weekly":
+		day, rest, ok := strings.Cut(arg, "@")
+		wd, known := weekdays[strings.ToLower(day)]
+		if !ok || !known {
+			return exportSchedule{}, fmt.Errorf("invalid schedule %q: expected e.g. weekly=mon@06:00", spec)
+		}
+		sched.weekday = wd
+		arg = rest
+	default:
+		return exportSchedule{}, fmt.Errorf("invalid schedule %q: expected hourly, daily=06:00 or weekly=mon@06:00", spec)
+	}
+	t, err := time.Parse("15:04", arg)
+	if err != nil {
+		return exportSchedule{}, fmt.Errorf("invalid schedule %q: %q is not a time of day like 06:00", spec, arg)
+	}
+	sched.hour, sched.minute = t.Hour(), t.Minute()
+	return sched, nil
+}
+
+// next returns the first time after t at which the job runs.
+func (s exportSchedule) next(t time.Time) time.Time {
+	if s.every == "hourly" {
+		return t.Truncate(time.Hour).Add(time.Hour)
+	}
+	for d := 0; ; d++ {
+		next := time.Date(t.Year(), t.Month(), t.Day()+d, s.hour, s.minute, 0, 0, t.Location())
+		if next.After(t) && (s.every == "daily" || next.Weekday() == s.weekday) {
+			return next
+		}
+	}
+}
+
+// exportJob periodically writes the lines matching a query into a file or
+// POSTs them to a webhook (see -export_jobs).
+type exportJob struct {
+	schedule exportSchedule
+	file     string // with {date} replaced by the day of the run
+	webhook  string
+	query    *query.Query
+}
+
+// parseExportJobs parses one job per line: a schedule, a destination
+// (file=<path> or webhook=<url>) and a query (see package query), e.g.:
+//
+//	weekly=mon@06:00 file=/perm/exports/auth-{date}.txt tag:sshd "Failed password" since:168h
+//	daily=07:00      webhook=https://reports.example/hook sev>=err
+//
+// Empty lines and lines starting with # are skipped.
+func parseExportJobs(r io.Reader) ([]exportJob, error) {
+	var jobs []exportJob
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a schedule, a destination and a query", lineNum)
+		}
+		sched, err := parseExportSchedule(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		job := exportJob{schedule: sched}
+		switch key, value, _ := strings.Cut(fields[1], "="); {
+		case key == "file" && value != "":
+			job.file = value
+		case key == "webhook" && (strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")):
+			job.webhook = value
+		default:
+			return nil, fmt.Errorf("line %d: invalid destination %q: expected file=<path> or webhook=<url>", lineNum, fields[1])
+		}
+		// The query is the rest of the line, which may contain quoted
+		// whitespace.
+		rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		if job.query, err = query.Parse(rest); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+// run writes the lines matching the query of j at now, each prefixed with its
+// host like /search, to the destination of j.
+func (j exportJob) run(ctx context.Context, dir string, aliases hostalias.Map, cache *logtree.Cache, now time.Time) error {
+	var buf bytes.Buffer
+	err := cache.Search(ctx, dir, aliases, j.query, now, func(host, line string) error {
+		if buf.Len() > maxExportBytes {
+			return fmt.Errorf("result exceeds %d bytes", maxExportBytes)
+		}
+		fmt.Fprintf(&buf, "%s %s\n", host, line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if j.file != "" {
+		fn := strings.ReplaceAll(j.file, "{date}", now.Format("2006-01-02"))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		return writeFileAtomically(fn, buf.Bytes())
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", j.webhook, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("X-Query", j.query.String())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected HTTP status %v", j.webhook, resp.Status)
+	}
+	return nil
+}
+
+// runExportJobs runs each job on its schedule until ctx is done. Runs which
+// were due while gokr-syslogweb was not running are skipped.
+func runExportJobs(ctx context.Context, dir string, aliases hostalias.Map, cache *logtree.Cache, jobs []exportJob) {
+	for _, j := range jobs {
+		go func(j exportJob) {
+			for {
+				next := j.schedule.next(time.Now())
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+				if err := j.run(ctx, dir, aliases, cache, next); err != nil {
+					log.Printf("export job %q: %v", j.query, err)
+				}
+			}
+		}(j)
+	}
+}
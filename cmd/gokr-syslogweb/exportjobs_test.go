@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportSchedule(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC) // a Saturday
+	for _, tt := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"hourly", time.Date(2022, time.August, 13, 17, 0, 0, 0, time.UTC)},
+		{"daily=06:00", time.Date(2022, time.August, 14, 6, 0, 0, 0, time.UTC)},
+		{"daily=23:30", time.Date(2022, time.August, 13, 23, 30, 0, 0, time.UTC)},
+		{"weekly=mon@06:00", time.Date(2022, time.August, 15, 6, 0, 0, 0, time.UTC)},
+		{"weekly=Sat@16:20", time.Date(2022, time.August, 20, 16, 20, 0, 0, time.UTC)},
+	} {
+		sched, err := parseExportSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sched.next(now); !got.Equal(tt.want) {
+			t.Errorf("%s: next(%v) = %v, want %v", tt.spec, now, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		"weekly=monday@06:00 file=/tmp/x",
+		"daily=6am file=/tmp/x",
+		"hourly=06:00 file=/tmp/x",
+		"daily=06:00 email=ops@example",
+		"daily=06:00 webhook=reports.example",
+		`daily=06:00 file=/tmp/x "unterminated`,
+		"daily=06:00",
+	} {
+		if _, err := parseExportJobs(strings.NewReader(input)); err == nil {
+			t.Errorf("parseExportJobs(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestExportJobs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, time.August, 15, 6, 0, 0, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "rfc3339=2022-08-14T10:00:00Z seq=1 sshd: Failed password for root\n" +
+		"rfc3339=2022-08-14T10:00:01Z seq=2 sshd: Accepted publickey for michael\n"
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-14.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var posted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		posted = string(b)
+	}))
+	defer srv.Close()
+
+	exportDir := t.TempDir()
+	jobs, err := parseExportJobs(strings.NewReader(`
+# last week's auth failures
+weekly=mon@06:00 file=` + exportDir + `/auth-{date}.txt  tag:sshd "Failed password" since:168h
+daily=06:00 webhook=` + srv.URL + ` host:dr since:24h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range jobs {
+		if err := j.run(context.Background(), dir, nil, nil, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(exportDir, "auth-2022-08-15.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("dr rfc3339=2022-08-14T10:00:00Z seq=1 sshd: Failed password for root\n", string(b)); diff != "" {
+		t.Errorf("file export: unexpected diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(2, strings.Count(posted, "\n")); diff != "" {
+		t.Errorf("webhook export: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -12,14 +12,21 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/klauspost/compress/zstd"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/mdns"
+	"github.com/gokrazy/syslogd/internal/prefilter"
+	"github.com/gokrazy/syslogd/internal/retired"
 )
 
 type errorHTTPHandler func(http.ResponseWriter, *http.Request) error
@@ -40,20 +47,64 @@ var indexTmpl = template.Must(template.New("index.html.tmpl").Funcs(template.Fun
 
 const basenameFormat = "2006-01-02.log"
 
+// inZone reports whether line was stored with a zone= field of zone (see
+// gokr-syslogd -zones).
+func inZone(line, zone string) bool {
+	v, ok := logline.Field(line, "zone")
+	return ok && v == zone
+}
+
 func syslogweb() error {
 	// TODO: listen on (all?) gokrazy private IPs by default
 	var (
 		syslogdDir = flag.String("syslogd_dir",
 			"/perm/syslogd",
-			"directory to which to serve syslogs from")
+			"directory to which to serve syslogs from (only read from, so this can be a read-only or network-mounted copy)")
 
 		listenAddrs = flag.String("listen",
 			"localhost:8514", // 514 is syslog, 80 is web
 			"comma-separated list of [host]:port pairs to listen on")
+
+		exportJobsPath = flag.String("export_jobs",
+			"",
+			"path to a file with scheduled export jobs, one per line: a schedule (hourly, daily=06:00 or weekly=mon@06:00), a destination (file=<path>, where {date} is replaced by the day of the run, or webhook=<url>) and a query, e.g. weekly=mon@06:00 file=/perm/exports/auth-{date}.txt tag:sshd \"Failed password\" since:168h")
+
+		mdnsAdvertise = flag.Bool("mdns",
+			false,
+			"advertise the port of the first -listen address on the local network via mDNS/DNS-SD (as _http._tcp)")
+
+		hostAliases = flag.String("host_aliases",
+			"",
+			"comma-separated list of old=new hostname pairs (e.g. raspberrypi=dr) for renamed hosts, like gokr-syslogd -host_aliases: the directories of old names are shown and searched as part of the new name")
+
+		cacheDir = flag.String("cache_dir",
+			"",
+			"if non-empty, a directory in which to keep decompressed copies of the compressed log files which were read recently, so that reading the same day again is fast. The directory is cleared on startup.")
+
+		cacheSize = flag.Int64("cache_size",
+			256<<20,
+			"how many bytes of decompressed log files to keep in -cache_dir at most (least recently used files are evicted first)")
+
+		changefeedDir = flag.String("changefeed_dir",
+			"",
+			"if non-empty, a writable directory in which to store the cursors of named /changes consumers (see /changes/commit)")
 	)
 
 	flag.Parse()
 
+	aliases, err := hostalias.Parse(*hostAliases)
+	if err != nil {
+		return fmt.Errorf("invalid -host_aliases: %v", err)
+	}
+
+	var cache *logtree.Cache // nil (no caching) unless -cache_dir is set
+	if *cacheDir != "" {
+		cache, err = logtree.NewCache(*cacheDir, *cacheSize)
+		if err != nil {
+			return err
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	mux.Handle("/grep/", middleware(func(w http.ResponseWriter, r *http.Request) error {
@@ -68,12 +119,22 @@ func syslogweb() error {
 		if q == "" {
 			return httpError(http.StatusBadRequest, fmt.Errorf("empty pattern (q= parameter)"))
 		}
-		re, err := regexp.Compile(q)
+		re, err := prefilter.Compile(q)
 		if err != nil {
 			return httpError(http.StatusBadRequest, fmt.Errorf("invalid Go regexp: %q: %v", q, err))
 		}
 
+		zone := r.FormValue("zone")
+
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+
 		timeRange := r.FormValue("range")
+		if timeRange == "" {
+			timeRange = requestPreferences(r).Range
+		}
 		if timeRange == "" {
 			timeRange = "todayyesterday"
 		}
@@ -82,54 +143,105 @@ func syslogweb() error {
 			return httpError(http.StatusBadRequest, fmt.Errorf("invalid range= parameter (expected one of todayyesterday or all)"))
 		}
 
-		fis, err := os.ReadDir(*syslogdDir)
+		// Includes the directories of the host’s old names.
+		dirs, err := hostDirs(*syslogdDir, aliases, host)
 		if err != nil {
 			return err
 		}
-		hosts := make(map[string]bool)
-		for _, fi := range fis {
-			hosts[fi.Name()] = true
-		}
-		if !hosts[host] {
-			return httpError(http.StatusNotFound, fmt.Errorf("host %q not found", host))
+
+		// boot= restricts the results to one boot session (see gokr-syslogd
+		// -boot_sessions), regardless of range=.
+		boot := r.FormValue("boot")
+		var sinceDay string
+		if boot != "" {
+			b, err := findBoot(filepath.Join(*syslogdDir, dirs[0]), boot)
+			if err != nil {
+				return err
+			}
+			boot = b.ID
+			// The day before, in case the sender’s clock is behind.
+			sinceDay = b.Start.Local().AddDate(0, 0, -1).Format("2006-01-02")
 		}
 
 		now := time.Now()
-		var files []string
-		if timeRange == "all" {
-			fis, err := os.ReadDir(filepath.Join(*syslogdDir, host))
+		yesterday := now.Add(-24 * time.Hour).Format("2006-01-02")
+		today := now.Format("2006-01-02")
+		var files []string // relative to *syslogdDir
+		listed := make(map[string]bool)
+		for _, hostDir := range dirs {
+			fis, err := os.ReadDir(filepath.Join(*syslogdDir, hostDir))
 			if err != nil {
 				return err
 			}
 			for _, fi := range fis {
-				files = append(files, fi.Name())
+				if !logtree.IsLogFile(fi.Name()) {
+					continue
+				}
+				if sinceDay != "" {
+					if fi.Name() < sinceDay {
+						continue
+					}
+				} else if timeRange != "all" &&
+					!strings.HasPrefix(fi.Name(), yesterday) &&
+					!strings.HasPrefix(fi.Name(), today) {
+					continue
+				}
+				// Includes the files of gokr-syslogd -route, e.g.
+				// 2022-08-13.auth.log. cache.Open falls back to the
+				// compressed version, so list each file only once.
+				fn := filepath.Join(hostDir, strings.TrimSuffix(fi.Name(), ".zst"))
+				if !listed[fn] {
+					listed[fn] = true
+					files = append(files, fn)
+				}
+			}
+		}
+		// Day by day, regardless of the directory.
+		sort.SliceStable(files, func(i, j int) bool {
+			return filepath.Base(files[i]) < filepath.Base(files[j])
+		})
+
+		if wantEstimate(r) {
+			paths := make([]string, len(files))
+			for i, fn := range files {
+				paths[i] = filepath.Join(*syslogdDir, fn)
 			}
-		} else {
-			yesterday := now.Add(-24 * time.Hour).Format(basenameFormat)
-			today := now.Format(basenameFormat)
-			files = []string{
-				yesterday,
-				today,
+			est, err := estimateQuery(ctx, cache, paths, func(line []byte) bool {
+				return re.Match(line) &&
+					(zone == "" || inZone(string(line), zone)) &&
+					(boot == "" || inBoot(string(line), boot))
+			})
+			if err != nil {
+				return err
 			}
+			return est.write(w, r)
 		}
 
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		for _, fn := range files {
-			f, err := os.Open(filepath.Join(*syslogdDir, host, fn))
+		sw := newStreamWriter(w, r)
+		c := collapser{emit: func(_ string, l logtree.Line, last string, count int) error {
+			_, err := io.WriteString(sw, markAnnotation(l.Text, collapsedText(inZoneOf(l.Text, loc), inZoneOf(last, loc), count))+"\n")
+			return err
+		}}
+		collapse := wantCollapse(r)
+		scanned := make(map[string]bool)
+		for i, fn := range files {
+			if err := sw.Progress(i, len(files)); err != nil {
+				return err
+			}
+			f, err := cache.Open(ctx, filepath.Join(*syslogdDir, fn))
 			if err != nil {
+				if os.IsNotExist(err) {
+					continue // e.g. no messages yesterday
+				}
 				return err
 			}
 			defer f.Close()
-			rd := io.Reader(f)
-			if strings.HasSuffix(fn, ".zst") {
-				dec, err := zstd.NewReader(f)
-				if err != nil {
-					return err
-				}
-				defer dec.Close()
-				rd = dec
+			if scanned[f.Name()] {
+				continue // compressed after listing, already scanned
 			}
-			scanner := bufio.NewScanner(rd)
+			scanned[f.Name()] = true
+			scanner := bufio.NewScanner(f)
 			for scanner.Scan() {
 				if err := ctx.Err(); err != nil {
 					return err
@@ -138,7 +250,22 @@ func syslogweb() error {
 				if !re.Match(line) {
 					continue
 				}
-				if _, err := w.Write(append(line, '\n')); err != nil {
+				if zone != "" && !inZone(string(line), zone) {
+					continue
+				}
+				if boot != "" && !inBoot(string(line), boot) {
+					continue
+				}
+				if collapse {
+					if err := c.add("", logtree.Line{Text: string(line)}); err != nil {
+						return err
+					}
+					continue
+				}
+				if loc != nil {
+					line = []byte(inZoneOf(string(line), loc))
+				}
+				if _, err := sw.Write(append(line, '\n')); err != nil {
 					return err
 				}
 			}
@@ -149,28 +276,101 @@ func syslogweb() error {
 				return err
 			}
 		}
-
-		return nil
+		if err := c.flush(); err != nil {
+			return err
+		}
+		return sw.Progress(len(files), len(files))
 	}))
 
+	mux.Handle("/tz", middleware(tzHandler))
+
+	mux.Handle("/prefs", middleware(prefsHandler))
+
+	mux.Handle("/patterns", middleware(patternsHandler(*syslogdDir, aliases, cache)))
+
+	mux.Handle("/errors", middleware(errorsHandler(*syslogdDir, aliases)))
+
+	mux.Handle("/rollups", middleware(rollupsHandler(*syslogdDir, aliases)))
+
+	mux.Handle("/coverage", middleware(coverageHandler(*syslogdDir, aliases)))
+
+	mux.Handle("/search", middleware(searchHandler(*syslogdDir, aliases, cache)))
+
+	mux.Handle("/trace/", middleware(traceHandler(*syslogdDir, aliases, cache)))
+
+	mux.Handle("/entity/", middleware(entityHandler(*syslogdDir, aliases, cache)))
+
+	mux.Handle("/raw/", middleware(rawHandler(*syslogdDir, cache)))
+
+	mux.Handle("/line/", middleware(lineHandler(*syslogdDir, cache)))
+
+	mux.Handle("/loki/api/v1/", middleware(lokiHandler(*syslogdDir, aliases, cache)))
+
+	mux.Handle("/changes", middleware(changesHandler(*syslogdDir, cache, *changefeedDir)))
+
+	mux.Handle("/changes/commit", middleware(commitHandler(*changefeedDir)))
+
 	mux.Handle("/", middleware(func(w http.ResponseWriter, r *http.Request) error {
 		if r.URL.Path != "/" {
 			return httpError(http.StatusNotFound, fmt.Errorf("not found"))
 		}
 
-		fis, err := os.ReadDir(*syslogdDir)
+		hosts, err := logtree.ListHosts(*syslogdDir)
+		if err != nil {
+			return err
+		}
+		retiredHosts, err := retired.Hosts(*syslogdDir)
 		if err != nil {
 			return err
 		}
-		hosts := make([]string, 0, len(fis))
-		for _, fi := range fis {
-			hosts = append(hosts, fi.Name())
+		includeRetired := r.FormValue("retired") == "1"
+		if !includeRetired {
+			active := hosts[:0]
+			for _, host := range hosts {
+				if _, ok := retiredHosts[host]; !ok {
+					active = append(active, host)
+				}
+			}
+			hosts = active
 		}
 
+		hosts = aliases.Current(hosts)
+		prefs := requestPreferences(r)
+		allHosts := len(hosts)
+		if len(prefs.Hosts) > 0 && r.FormValue("all") != "1" {
+			preferred := make(map[string]bool)
+			for _, host := range prefs.Hosts {
+				preferred[aliases.Resolve(host)] = true
+			}
+			shown := hosts[:0]
+			for _, host := range hosts {
+				if preferred[host] {
+					shown = append(shown, host)
+				}
+			}
+			hosts = shown
+		}
+
+		var tz string
+		if loc, err := requestLocation(r); err == nil && loc != nil {
+			tz = loc.String()
+		}
 		tmplData := struct {
-			Hosts []string
+			Hosts          []string
+			AllHosts       int
+			Retired        int
+			IncludeRetired bool
+			TZ             string
+			Prefs          preferences
+			PrefHosts      string
 		}{
-			Hosts: hosts,
+			Hosts:          hosts,
+			AllHosts:       allHosts,
+			Retired:        len(retiredHosts),
+			IncludeRetired: includeRetired,
+			TZ:             tz,
+			Prefs:          prefs,
+			PrefHosts:      strings.Join(prefs.Hosts, ","),
 		}
 		var tmplBuf bytes.Buffer
 		if err := indexTmpl.Execute(&tmplBuf, tmplData); err != nil {
@@ -182,7 +382,25 @@ func syslogweb() error {
 		return err
 	}))
 
+	if *exportJobsPath != "" {
+		f, err := os.Open(*exportJobsPath)
+		if err != nil {
+			return err
+		}
+		jobs, err := parseExportJobs(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("-export_jobs: %s: %v", *exportJobsPath, err)
+		}
+		runExportJobs(context.Background(), *syslogdDir, aliases, cache, jobs)
+	}
+
 	addrs := strings.Split(*listenAddrs, ",")
+	if *mdnsAdvertise {
+		if err := advertise(addrs[0]); err != nil {
+			return fmt.Errorf("-mdns: %v", err)
+		}
+	}
 	log.Printf("listening on %q", addrs)
 	return multiListen(context.Background(), mux, addrs)
 }
@@ -192,3 +410,29 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// advertise advertises listenAddr via mDNS in the background.
+func advertise(listenAddr string) error {
+	_, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := mdns.Advertise(context.Background(), hostname, []mdns.Service{{
+			Instance: "gokr-syslogweb on " + hostname,
+			Service:  mdns.WebService,
+			Port:     port,
+			TXT:      []string{"path=/"},
+		}})
+		log.Printf("mDNS advertisement stopped: %v", err)
+	}()
+	return nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/prefilter"
+	"github.com/gokrazy/syslogd/internal/query"
+	"github.com/gokrazy/syslogd/internal/timeexpr"
+)
+
+const (
+	defaultLokiLimit  = 100
+	maxLokiLimit      = 5000
+	defaultLokiPeriod = 1 * time.Hour
+)
+
+// lokiLabels are the stream labels of all lines.
+var lokiLabels = []string{"host", "tag"}
+
+// lineFilter is a LogQL line filter which query.Query cannot express.
+type lineFilter struct {
+	re     *prefilter.Regexp // for |~ and !~
+	text   string            // for !=
+	negate bool
+}
+
+func (f lineFilter) match(line string) bool {
+	if f.re != nil {
+		return f.re.MatchString(line) != f.negate
+	}
+	return strings.Contains(line, f.text) != f.negate
+}
+
+// parseLogQL translates the supported subset of LogQL log queries into a
+// query.Query and the line filters it cannot express: a stream selector of
+// label="value" matchers (host, tag, zone, severity, or any stored key=value
+// field), followed by line filters (|=, !=, |~, !~), e.g.:
+//
+//	{host="dr", tag="dhcpd"} |= "DHCPDISCOVER" != "00:0d:b9"
+func parseLogQL(s string) (*query.Query, []lineFilter, error) {
+	q := &query.Query{MaxSeverity: 7}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return nil, nil, fmt.Errorf("expected a stream selector like {host=\"dr\"}")
+	}
+	end := strings.IndexByte(s, '}')
+	if end == -1 {
+		return nil, nil, fmt.Errorf("unterminated stream selector")
+	}
+	selector, rest := s[1:end], s[end+1:]
+	for selector = strings.TrimSpace(selector); selector != ""; {
+		name, after, ok := strings.Cut(selector, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, "!~") {
+			return nil, nil, fmt.Errorf("unsupported matcher %q: only label=\"value\" is supported", selector)
+		}
+		if strings.HasPrefix(after, "~") {
+			return nil, nil, fmt.Errorf("unsupported matcher %s=~: only label=\"value\" is supported", name)
+		}
+		value, after, err := unquotePrefix(strings.TrimSpace(after))
+		if err != nil {
+			return nil, nil, fmt.Errorf("label %s: %v", name, err)
+		}
+		switch name {
+		case "host":
+			q.Hosts = append(q.Hosts, value)
+		case "tag":
+			q.Tags = append(q.Tags, value)
+		case "zone":
+			q.Zones = append(q.Zones, value)
+		case "client":
+			cq, err := query.Parse("client:" + value)
+			if err != nil {
+				return nil, nil, err
+			}
+			q.Clients = append(q.Clients, cq.Clients...)
+		case "severity":
+			sq, err := query.Parse("sev:" + value)
+			if err != nil {
+				return nil, nil, err
+			}
+			q.MinSeverity, q.MaxSeverity = sq.MinSeverity, sq.MaxSeverity
+		default:
+			q.Fields = append(q.Fields, name+"="+value)
+		}
+		selector = strings.TrimPrefix(strings.TrimSpace(after), ",")
+		selector = strings.TrimSpace(selector)
+	}
+
+	var filters []lineFilter
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		if len(rest) < 2 {
+			return nil, nil, fmt.Errorf("unsupported expression %q: only line filters (|=, !=, |~, !~) are supported", rest)
+		}
+		op := rest[:2]
+		if op != "|=" && op != "!=" && op != "|~" && op != "!~" {
+			return nil, nil, fmt.Errorf("unsupported expression %q: only line filters (|=, !=, |~, !~) are supported", rest)
+		}
+		var arg string
+		var err error
+		arg, rest, err = unquotePrefix(strings.TrimSpace(rest[2:]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", op, err)
+		}
+		switch op {
+		case "|=":
+			q.Terms = append(q.Terms, arg)
+		case "!=":
+			filters = append(filters, lineFilter{text: arg, negate: true})
+		default:
+			re, err := prefilter.Compile(arg)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", op, err)
+			}
+			filters = append(filters, lineFilter{re: re, negate: op == "!~"})
+		}
+	}
+	return q, filters, nil
+}
+
+// unquotePrefix unquotes the "double-quoted" or `backquoted` string at the
+// start of s and returns the remainder.
+func unquotePrefix(s string) (value, rest string, _ error) {
+	if strings.HasPrefix(s, "`") {
+		end := strings.IndexByte(s[1:], '`')
+		if end == -1 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("expected a quoted string")
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+// parseLokiTime parses the start=, end= and time= parameters: Unix epoch
+// nanoseconds (as sent by Grafana and logcli), fractional seconds or RFC3339.
+func parseLokiTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ns), nil
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// lokiPeriod returns the period of the start= and end= parameters, by default
+// the last hour.
+func lokiPeriod(r *http.Request, now time.Time) (start, end time.Time, _ error) {
+	end, err := parseLokiTime(r.FormValue("end"), now)
+	if err != nil {
+		return start, end, httpError(http.StatusBadRequest, fmt.Errorf("invalid end= parameter: %v", err))
+	}
+	start, err = parseLokiTime(r.FormValue("start"), end.Add(-defaultLokiPeriod))
+	if err != nil {
+		return start, end, httpError(http.StatusBadRequest, fmt.Errorf("invalid start= parameter: %v", err))
+	}
+	return start, end, nil
+}
+
+type lokiEntry struct {
+	host, tag string
+	t         time.Time
+	line      string
+}
+
+// lokiStream is a stream of the streams result type.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func writeLoki(w http.ResponseWriter, data interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(struct {
+		Status string      `json:"status"`
+		Data   interface{} `json:"data"`
+	}{"success", data})
+}
+
+// lokiHandler serves a subset of Loki’s HTTP API at /loki/api/v1/, enough for
+// Grafana’s Loki data source and logcli to read the log files: labels,
+// label/<name>/values and query_range (see parseLogQL).
+func lokiHandler(dir string, aliases hostalias.Map, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/loki/api/v1/")
+		switch {
+		case endpoint == "labels":
+			return writeLoki(w, lokiLabels)
+		case strings.HasPrefix(endpoint, "label/") && strings.HasSuffix(endpoint, "/values"):
+			name := strings.TrimSuffix(strings.TrimPrefix(endpoint, "label/"), "/values")
+			values, err := lokiLabelValues(r, dir, aliases, cache, name)
+			if err != nil {
+				return err
+			}
+			return writeLoki(w, values)
+		case endpoint == "query_range":
+			return lokiQueryRange(w, r, dir, aliases, cache)
+		}
+		return httpError(http.StatusNotFound, fmt.Errorf("unsupported Loki API endpoint %q (supported: labels, label/<name>/values, query_range)", endpoint))
+	}
+}
+
+// lokiLabelValues returns the hosts (for label host) or the tags seen in the
+// requested period (for label tag).
+func lokiLabelValues(r *http.Request, dir string, aliases hostalias.Map, cache *logtree.Cache, name string) ([]string, error) {
+	switch name {
+	case "host":
+		hosts, err := logtree.ListHosts(dir)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		values := []string{}
+		for _, hostDir := range hosts {
+			host := aliases.Resolve(hostDir)
+			if !seen[host] {
+				seen[host] = true
+				values = append(values, host)
+			}
+		}
+		sort.Strings(values)
+		return values, nil
+
+	case "tag":
+		start, end, err := lokiPeriod(r, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		q := &query.Query{MaxSeverity: 7, Since: timeexpr.At(start), Until: timeexpr.At(end)}
+		seen := make(map[string]bool)
+		err = cache.Search(r.Context(), dir, aliases, q, time.Now(), func(host, line string) error {
+			tag, _, _ := strings.Cut(logline.Strip(line), ": ")
+			seen[tag] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		values := []string{}
+		for tag := range seen {
+			values = append(values, tag)
+		}
+		sort.Strings(values)
+		return values, nil
+	}
+	return []string{}, nil
+}
+
+func lokiQueryRange(w http.ResponseWriter, r *http.Request, dir string, aliases hostalias.Map, cache *logtree.Cache) error {
+	q, filters, err := parseLogQL(r.FormValue("query"))
+	if err != nil {
+		return httpError(http.StatusBadRequest, fmt.Errorf("invalid query= parameter: %v", err))
+	}
+	now := time.Now()
+	start, end, err := lokiPeriod(r, now)
+	if err != nil {
+		return err
+	}
+	q.Since, q.Until = timeexpr.At(start), timeexpr.At(end)
+	limit := defaultLokiLimit
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLokiLimit {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid limit= parameter (expected 1 to %d)", maxLokiLimit))
+		}
+		limit = n
+	}
+	forward := r.FormValue("direction") == "forward"
+	if d := r.FormValue("direction"); d != "" && d != "forward" && d != "backward" {
+		return httpError(http.StatusBadRequest, fmt.Errorf("invalid direction= parameter (expected forward or backward)"))
+	}
+
+	// Files are searched host by host, so the lines are not in order: keep
+	// the limit first (forward) or last (backward) lines.
+	var entries []lokiEntry
+	keep := func() {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if forward {
+				return entries[i].t.Before(entries[j].t)
+			}
+			return entries[i].t.After(entries[j].t)
+		})
+		if len(entries) > limit {
+			entries = entries[:limit]
+		}
+	}
+	err = cache.Search(r.Context(), dir, aliases, q, now, func(host, line string) error {
+		for _, f := range filters {
+			if !f.match(line) {
+				return nil
+			}
+		}
+		v, _ := logline.Field(line, "rfc3339")
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil
+		}
+		rest := logline.Strip(line)
+		tag, _, _ := strings.Cut(rest, ": ")
+		entries = append(entries, lokiEntry{host: host, tag: tag, t: t, line: rest})
+		if len(entries) >= 2*limit {
+			keep()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	keep()
+
+	streams := make(map[[2]string]*lokiStream)
+	result := []*lokiStream{}
+	for _, e := range entries {
+		key := [2]string{e.host, e.tag}
+		st, ok := streams[key]
+		if !ok {
+			st = &lokiStream{Stream: map[string]string{"host": e.host, "tag": e.tag}}
+			streams[key] = st
+			result = append(result, st)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(e.t.UnixNano(), 10), e.line})
+	}
+	return writeLoki(w, struct {
+		ResultType string        `json:"resultType"`
+		Result     []*lokiStream `json:"result"`
+	}{"streams", result})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLogQL(t *testing.T) {
+	q, filters, err := parseLogQL(`{host="dr", tag="dhcpd", container="web-1"} |= "DHCP" != "00:0d" |~ ` + "`from [0-9a-f:]+`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`host:dr tag:dhcpd container=web-1 DHCP`, q.String()); diff != "" {
+		t.Errorf("query: unexpected diff (-want +got):\n%s", diff)
+	}
+	for _, tt := range []struct {
+		line string
+		want bool
+	}{
+		{"dhcpd: DHCPDISCOVER from aa:bb", true},
+		{"dhcpd: DHCPDISCOVER from 00:0d:b9", false},
+		{"dhcpd: DHCPDISCOVER", false},
+	} {
+		got := true
+		for _, f := range filters {
+			got = got && f.match(tt.line)
+		}
+		if got != tt.want {
+			t.Errorf("filters match %q = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		`host="dr"`,
+		`{host=~"d.*"}`,
+		`{host!="dr"}`,
+		`{host="dr"`,
+		`{host=dr}`,
+		`{host="dr"} | json`,
+		`{host="dr"} |~ "("`,
+		`count_over_time({host="dr"}[5m])`,
+	} {
+		if _, _, err := parseLogQL(input); err == nil {
+			t.Errorf("parseLogQL(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestLoki(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	write := func(host string, lines ...string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var content string
+		for i, line := range lines {
+			content += fmt.Sprintf("rfc3339=%s seq=%d %s\n", now.Add(time.Duration(i-len(lines))*time.Minute).Format(time.RFC3339), i+1, line)
+		}
+		if err := os.WriteFile(filepath.Join(dir, host, now.Format(basenameFormat)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dr", "dhcpd: DHCPDISCOVER", "ntpd: synchronized", "dhcpd: DHCPOFFER")
+	write("router7", "dhcp4d: lease")
+	hdl := middleware(lokiHandler(dir, nil, nil))
+	get := func(path string, data interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d: %s", path, rec.Code, rec.Body.String())
+		}
+		resp := struct {
+			Status string
+			Data   interface{}
+		}{Data: data}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != "success" {
+			t.Errorf("GET %s: status %q", path, resp.Status)
+		}
+	}
+
+	var values []string
+	get("/loki/api/v1/label/host/values", &values)
+	if diff := cmp.Diff([]string{"dr", "router7"}, values); diff != "" {
+		t.Errorf("host values: unexpected diff (-want +got):\n%s", diff)
+	}
+	get("/loki/api/v1/label/tag/values", &values)
+	if diff := cmp.Diff([]string{"dhcp4d", "dhcpd", "ntpd"}, values); diff != "" {
+		t.Errorf("tag values: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	ts := func(minutesAgo int) string {
+		return fmt.Sprint(now.Add(-time.Duration(minutesAgo) * time.Minute).UnixNano())
+	}
+	for _, tt := range []struct {
+		query string
+		want  []lokiStream
+	}{
+		{
+			query: "query=" + url.QueryEscape(`{host="dr", tag="dhcpd"}`),
+			want: []lokiStream{{
+				Stream: map[string]string{"host": "dr", "tag": "dhcpd"},
+				Values: [][2]string{{ts(1), "dhcpd: DHCPOFFER"}, {ts(3), "dhcpd: DHCPDISCOVER"}},
+			}},
+		},
+		{
+			query: "direction=forward&limit=2&query=" + url.QueryEscape(`{host="dr"} !~ "OFFER"`),
+			want: []lokiStream{
+				{
+					Stream: map[string]string{"host": "dr", "tag": "dhcpd"},
+					Values: [][2]string{{ts(3), "dhcpd: DHCPDISCOVER"}},
+				},
+				{
+					Stream: map[string]string{"host": "dr", "tag": "ntpd"},
+					Values: [][2]string{{ts(2), "ntpd: synchronized"}},
+				},
+			},
+		},
+		{
+			query: "start=" + ts(90) + "&end=" + ts(2) + "&query=" + url.QueryEscape(`{host="router7"}`),
+			want:  []lokiStream{},
+		},
+	} {
+		var data struct {
+			ResultType string
+			Result     []lokiStream
+		}
+		get("/loki/api/v1/query_range?"+tt.query, &data)
+		if data.ResultType != "streams" {
+			t.Errorf("%s: resultType = %q, want streams", tt.query, data.ResultType)
+		}
+		if diff := cmp.Diff(tt.want, data.Result); diff != "" {
+			t.Errorf("%s: unexpected diff (-want +got):\n%s", tt.query, diff)
+		}
+	}
+
+	for _, path := range []string{
+		"/loki/api/v1/query_range?query=" + url.QueryEscape(`{host="dr"} | json`),
+		"/loki/api/v1/query_range?limit=0&query=" + url.QueryEscape(`{host="dr"}`),
+		"/loki/api/v1/query_range?start=yesterday&query=" + url.QueryEscape(`{host="dr"}`),
+		"/loki/api/v1/tail",
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code == http.StatusOK {
+			t.Errorf("GET %s unexpectedly succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/entityindex"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// readBindings returns the binding tables of all hosts (see gokr-syslogd
+// -learn_bindings), merged into one.
+func readBindings(dir string) (*bindings.Table, error) {
+	hosts, err := logtree.ListHosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	all := bindings.New()
+	for _, hostDir := range hosts {
+		t, err := bindings.ReadFile(filepath.Join(dir, hostDir, bindings.FileName))
+		if err != nil {
+			return nil, err
+		}
+		all.Merge(t)
+	}
+	return all, nil
+}
+
+// requestBindings returns the merged binding tables if the request asks for
+// names=1, and nil otherwise.
+func requestBindings(r *http.Request, dir string) (*bindings.Table, error) {
+	if r.FormValue("names") != "1" {
+		return nil, nil
+	}
+	return readBindings(dir)
+}
+
+// lineTime returns the timestamp of the stored line.
+func lineTime(line string) (time.Time, bool) {
+	ts, _ := logline.Field(line, "rfc3339")
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	return t, err == nil
+}
+
+// namesOf returns the names which the IP addresses in the message of the
+// stored line were bound to at its timestamp, by address.
+func namesOf(line string, t *bindings.Table) map[string]string {
+	at, ok := lineTime(line)
+	if t == nil || !ok {
+		return nil
+	}
+	var names map[string]string
+	for _, m := range entityindex.Mentions(logline.Strip(line)) {
+		if name, ok := t.NameAt(m.Entity, at); ok {
+			if names == nil {
+				names = make(map[string]string)
+			}
+			names[m.Entity] = name
+		}
+	}
+	return names
+}
+
+// annotate returns the stored line with each IP address in its message
+// followed by the name it was bound to at the timestamp of the line, e.g.
+// “from 192.168.1.57 (phone)”.
+func annotate(line string, t *bindings.Table) string {
+	at, ok := lineTime(line)
+	if t == nil || !ok {
+		return line
+	}
+	_, rest := logline.Split(line)
+	var b strings.Builder
+	last := 0
+	for _, m := range entityindex.Mentions(rest) {
+		name, ok := t.NameAt(m.Entity, at)
+		if !ok {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(line[:len(line)-len(rest)])
+		}
+		b.WriteString(rest[last:m.End])
+		b.WriteString(" (" + name + ")")
+		last = m.End
+	}
+	if b.Len() == 0 {
+		return line
+	}
+	b.WriteString(rest[last:])
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNames(t *testing.T) {
+	dir := t.TempDir()
+	ts := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	table := bindings.New()
+	table.Observe("192.168.1.57", "phone", ts)
+	table.Observe("192.168.1.57", "laptop", ts.Add(time.Hour))
+	for host, lines := range map[string][]string{
+		"router7": {
+			"rfc3339=2022-08-13T16:00:00Z seq=1 dnsmasq-dhcp: DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff phone",
+			"rfc3339=2022-08-13T17:00:00Z seq=2 dnsmasq-dhcp: DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:01 laptop",
+		},
+		"dr": {
+			"rfc3339=2022-08-13T16:30:00Z seq=1 sshd: Accepted publickey from 192.168.1.57:40022 (not 192.168.1.60)",
+			"rfc3339=2022-08-13T17:30:00Z seq=2 sshd: Accepted publickey from 192.168.1.57",
+		},
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		fn := filepath.Join(dir, host, "2022-08-13.log")
+		if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "router7", bindings.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	search := middleware(searchHandler(dir, nil, nil))
+	get := func(params string) string {
+		rec := httptest.NewRecorder()
+		search.ServeHTTP(rec, httptest.NewRequest("GET", "/search?q="+url.QueryEscape("host:dr since:87600h")+params, nil))
+		if rec.Code != 200 {
+			t.Fatalf("search: status = %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	want := `dr rfc3339=2022-08-13T16:30:00Z seq=1 sshd: Accepted publickey from 192.168.1.57:40022 (phone) (not 192.168.1.60)
+dr rfc3339=2022-08-13T17:30:00Z seq=2 sshd: Accepted publickey from 192.168.1.57 (laptop)
+`
+	if diff := cmp.Diff(want, get("&names=1")); diff != "" {
+		t.Errorf("search: unexpected diff (-want +got):\n%s", diff)
+	}
+	if got := get(""); strings.Contains(got, "(phone)") {
+		t.Errorf("search without names=1 annotated: %q", got)
+	}
+	if got := get("&names=1&format=jsonl"); !strings.Contains(got, `"names":{"192.168.1.57":"laptop"}`) {
+		t.Errorf("search with format=jsonl: names missing: %q", got)
+	}
+}
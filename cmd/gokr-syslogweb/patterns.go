@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// wildcard replaces the variable tokens of a pattern.
+const wildcard = "<*>"
+
+// similarityThreshold is the fraction of tokens which need to match for a
+// message to be assigned to an existing pattern.
+const similarityThreshold = 0.5
+
+// pattern is a cluster of similar messages, like "dhcp: lease <*> expired".
+type pattern struct {
+	tag    string
+	tokens []string
+
+	count         int             // messages in the analyzed period
+	baselineCount int             // messages in the preceding baseline period
+	hosts         map[string]bool // hosts which sent messages in the period
+}
+
+func (t *pattern) String() string {
+	return t.tag + ": " + strings.Join(t.tokens, " ")
+}
+
+type groupKey struct {
+	tag    string
+	length int
+	first  string
+}
+
+// clusterer groups messages into patterns, following the idea of Drain (He
+// et al., 2017): tokens which look variable (containing digits) are masked
+// right away, messages are grouped by tag, token count and first token, and
+// within a group, each message is assigned to the first pattern sharing at
+// least similarityThreshold of its tokens. Differing tokens of the pattern
+// become wildcards.
+type clusterer struct {
+	groups map[groupKey][]*pattern
+}
+
+func newClusterer() *clusterer {
+	return &clusterer{groups: make(map[groupKey][]*pattern)}
+}
+
+func maskToken(token string) string {
+	if strings.ContainsAny(token, "0123456789") {
+		return wildcard
+	}
+	return token
+}
+
+// add assigns content (sent with tag) to a pattern, which is returned.
+func (c *clusterer) add(tag, content string) *pattern {
+	tokens := strings.Fields(content)
+	for i, token := range tokens {
+		tokens[i] = maskToken(token)
+	}
+	key := groupKey{tag: tag, length: len(tokens)}
+	if len(tokens) > 0 {
+		key.first = tokens[0]
+	}
+	for _, t := range c.groups[key] {
+		same := 0
+		for i, token := range tokens {
+			if t.tokens[i] == token || t.tokens[i] == wildcard {
+				same++
+			}
+		}
+		if len(tokens) > 0 && float64(same)/float64(len(tokens)) < similarityThreshold {
+			continue
+		}
+		for i, token := range tokens {
+			if t.tokens[i] != token {
+				t.tokens[i] = wildcard
+			}
+		}
+		return t
+	}
+	t := &pattern{
+		tag:    tag,
+		tokens: tokens,
+		hosts:  make(map[string]bool),
+	}
+	c.groups[key] = append(c.groups[key], t)
+	return t
+}
+
+// patterns returns all patterns with messages in the analyzed period: new
+// patterns (without messages in the baseline period) first, then by count.
+func (c *clusterer) patterns() []*pattern {
+	var result []*pattern
+	for _, group := range c.groups {
+		for _, t := range group {
+			if t.count > 0 {
+				result = append(result, t)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if newA, newB := a.baselineCount == 0, b.baselineCount == 0; newA != newB {
+			return newA
+		}
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return a.String() < b.String()
+	})
+	return result
+}
+
+// clusterLogs clusters the messages which hosts logged in [start, end) and
+// counts those logged in [baselineStart, start) towards the baseline. If zone
+// is non-empty, only messages from that zone are considered.
+func clusterLogs(ctx context.Context, cache *logtree.Cache, dir string, hosts []string, zone string, baselineStart, start, end time.Time) (*clusterer, error) {
+	c := newClusterer()
+	firstDay, lastDay := logtree.SearchDays(baselineStart, end)
+	first, err := time.Parse("2006-01-02", firstDay)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		for day := first; day.Format("2006-01-02") <= lastDay; day = day.AddDate(0, 0, 1) {
+			fn := filepath.Join(dir, host, day.Format(basenameFormat))
+			err := cache.Scan(ctx, fn, func(line string) {
+				v, ok := logline.Field(line, "rfc3339")
+				if !ok {
+					return
+				}
+				ts, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil || ts.Before(baselineStart) || !ts.Before(end) {
+					return
+				}
+				if zone != "" && !inZone(line, zone) {
+					return
+				}
+				tag, content, ok := strings.Cut(logline.Strip(line), ": ")
+				if !ok {
+					return
+				}
+				t := c.add(tag, content)
+				if ts.Before(start) {
+					t.baselineCount++
+				} else {
+					t.count++
+					t.hosts[host] = true
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// patternsHandler serves the message patterns of the last hour (last=
+// parameter) across all hosts (or the host= parameter, or the hosts in the
+// zone= parameter), marking those which did not occur in the preceding day
+// (baseline= parameter) as new.
+func patternsHandler(dir string, aliases hostalias.Map, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := r.Context()
+		duration := func(name string, def time.Duration) (time.Duration, error) {
+			v := r.FormValue(name)
+			if v == "" {
+				return def, nil
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return 0, httpError(http.StatusBadRequest, fmt.Errorf("invalid %s= parameter: %q (expected a positive duration like 1h)", name, v))
+			}
+			return d, nil
+		}
+		last, err := duration("last", 1*time.Hour)
+		if err != nil {
+			return err
+		}
+		baseline, err := duration("baseline", 24*time.Hour)
+		if err != nil {
+			return err
+		}
+		onlyNew := r.FormValue("new") == "1"
+
+		hosts, err := logtree.ListHosts(dir)
+		if err != nil {
+			return err
+		}
+		if host := r.FormValue("host"); host != "" {
+			hosts, err = hostDirs(dir, aliases, host)
+			if err != nil {
+				return err
+			}
+		}
+
+		end := time.Now()
+		start := end.Add(-last)
+		c, err := clusterLogs(ctx, cache, dir, hosts, r.FormValue("zone"), start.Add(-baseline), start, end)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		scope := fmt.Sprintf("%d hosts", len(aliases.Current(hosts)))
+		if zone := r.FormValue("zone"); zone != "" {
+			scope += " (zone " + zone + ")"
+		}
+		fmt.Fprintf(w, "# message patterns of the last %v across %s, compared to the preceding %v\n", last, scope, baseline)
+		fmt.Fprintf(w, "# %6s %6s %4s  %s\n", "count", "hosts", "new", "pattern")
+		for _, t := range c.patterns() {
+			isNew := t.baselineCount == 0
+			if onlyNew && !isNew {
+				continue
+			}
+			marker := ""
+			if isNew {
+				marker = "new"
+			}
+			if _, err := fmt.Fprintf(w, "  %6d %6d %4s  %s\n", t.count, len(t.hosts), marker, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClusterer(t *testing.T) {
+	c := newClusterer()
+	for _, msg := range []struct{ tag, content string }{
+		{"dhcp", "lease 10.0.0.12 for 24:4b:fe:11:22:33 expired"},
+		{"dhcp", "lease 10.0.0.17 for e4:5f:01:aa:bb:cc expired"},
+		{"dhcp", "lease 10.0.0.17 for e4:5f:01:aa:bb:cc renewed"},
+		{"dhcp", "DHCPDISCOVER from unknown client"},
+		{"scan2drive", "DHCPDISCOVER from unknown client"},
+	} {
+		c.add(msg.tag, msg.content).count++
+	}
+	var got []string
+	for _, tmpl := range c.patterns() {
+		got = append(got, tmpl.String())
+	}
+	want := []string{
+		"dhcp: lease <*> for <*> <*>",
+		"dhcp: DHCPDISCOVER from unknown client",
+		"scan2drive: DHCPDISCOVER from unknown client",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("patterns: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestClusterLogs(t *testing.T) {
+	dir := t.TempDir()
+	end := time.Date(2022, 8, 13, 16, 0, 0, 0, time.Local)
+	start := end.Add(-1 * time.Hour)
+	baselineStart := start.Add(-24 * time.Hour)
+	logs := map[string][]string{
+		"dr/2022-08-12.log": {
+			"rfc3339=" + baselineStart.Add(-time.Minute).Format(time.RFC3339) + " seq=1 grafana: too old, not counted",
+			"rfc3339=" + baselineStart.Add(time.Minute).Format(time.RFC3339) + " seq=2 grafana: login by user 1",
+		},
+		"dr/2022-08-13.log": {
+			"rfc3339=" + start.Add(time.Minute).Format(time.RFC3339) + " seq=1 grafana: login by user 2",
+			"rfc3339=" + start.Add(2*time.Minute).Format(time.RFC3339) + " seq=2 grafana: panic: nil map",
+		},
+		"scan2drive/2022-08-13.log": {
+			"rfc3339=" + start.Add(3*time.Minute).Format(time.RFC3339) + " seq=1 zone=home grafana: panic: nil map",
+		},
+	}
+	for rel, lines := range logs {
+		fn := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := clusterLogs(context.Background(), nil, dir, []string{"dr", "scan2drive"}, "", baselineStart, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		Pattern       string
+		Count         int
+		Hosts         int
+		BaselineCount int
+	}
+	var got []result
+	for _, tmpl := range c.patterns() {
+		got = append(got, result{tmpl.String(), tmpl.count, len(tmpl.hosts), tmpl.baselineCount})
+	}
+	want := []result{
+		{"grafana: panic: nil map", 2, 2, 0},
+		{"grafana: login by user <*>", 1, 1, 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("clusterLogs: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	c, err = clusterLogs(context.Background(), nil, dir, []string{"dr", "scan2drive"}, "home", baselineStart, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, tmpl := range c.patterns() {
+		got = append(got, result{tmpl.String(), tmpl.count, len(tmpl.hosts), tmpl.baselineCount})
+	}
+	want = []result{
+		{"grafana: panic: nil map", 1, 1, 0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("clusterLogs(zone=home): unexpected diff (-want +got):\n%s", diff)
+	}
+}
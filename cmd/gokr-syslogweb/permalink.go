@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+const (
+	defaultLineContext = 10
+	maxLineContext     = 1000
+)
+
+// lineHash returns a short hash of line, which identifies the line in its file
+// even after the file was rewritten (e.g. by gokr-syslogd -severity_retention).
+func lineHash(line string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(line, "\r")))
+	return hex.EncodeToString(sum[:4])
+}
+
+// lineID returns the permalink ID of line, which starts at offset in the
+// (decompressed) log file name, e.g. 2022-08-13.log@4711-9f86d081.
+func lineID(name string, offset int64, line string) string {
+	return fmt.Sprintf("%s@%d-%s", name, offset, lineHash(line))
+}
+
+// parseLineID is the inverse of lineID.
+func parseLineID(id string) (name string, offset int64, hash string, _ error) {
+	idx := strings.LastIndexByte(id, '@')
+	if idx == -1 {
+		return "", 0, "", fmt.Errorf("invalid line ID %q: missing @", id)
+	}
+	name = id[:idx]
+	if !logtree.IsLogFile(name) || strings.HasSuffix(name, ".zst") || strings.ContainsAny(name, `/\`) {
+		return "", 0, "", fmt.Errorf("invalid line ID %q: not a log file", id)
+	}
+	offsetStr, hash, ok := strings.Cut(id[idx+1:], "-")
+	if !ok || len(hash) != 8 {
+		return "", 0, "", fmt.Errorf("invalid line ID %q: missing hash", id)
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, "", fmt.Errorf("invalid line ID %q: invalid offset", id)
+	}
+	return name, offset, hash, nil
+}
+
+// lineAt reports whether the line starting at offset of the log file fn has
+// the specified hash.
+func lineAt(ctx context.Context, cache *logtree.Cache, fn string, offset int64, hash string) (bool, error) {
+	f, err := cache.Open(ctx, fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if err := skip(f, offset); err != nil {
+		return false, nil // file is shorter, i.e. was rewritten
+	}
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if line == "" && err != nil {
+		return false, nil
+	}
+	return lineHash(strings.TrimSuffix(line, "\n")) == hash, nil
+}
+
+// contextLine is a line of the response of lineHandler.
+type contextLine struct {
+	text   string
+	target bool
+}
+
+// readLineContext returns the line of the log file fn identified by offset and
+// hash, with up to n lines before and after it. When the file was rewritten,
+// offsets changed, so the first line with hash is returned instead.
+func readLineContext(ctx context.Context, cache *logtree.Cache, fn string, offset int64, hash string, n int) ([]contextLine, error) {
+	exact, err := lineAt(ctx, cache, fn, offset, hash)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		lines []contextLine
+		found = -1
+	)
+	err = cache.ScanOffsets(ctx, fn, func(o int64, text string) {
+		if found == -1 {
+			target := exact && o == offset || !exact && lineHash(text) == hash
+			if len(lines) > 0 && len(lines) >= n {
+				lines = lines[len(lines)-n:]
+			}
+			if target {
+				found = len(lines)
+			}
+			lines = append(lines, contextLine{text: text, target: target})
+			return
+		}
+		if len(lines) == found+1+n {
+			cancel()
+			return
+		}
+		lines = append(lines, contextLine{text: text})
+	})
+	if found == -1 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, os.ErrNotExist
+	}
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// lineHandler serves the line /line/<host>/<id> (see lineID) with the lines
+// around it (context= parameter, 10 by default), marked with >.
+func lineHandler(dir string, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		host, id, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/line/"), "/")
+		if !ok || host == "" {
+			return httpError(http.StatusNotFound, fmt.Errorf("not found"))
+		}
+		name, offset, hash, err := parseLineID(id)
+		if err != nil {
+			return httpError(http.StatusNotFound, err)
+		}
+		hosts, err := logtree.ListHosts(dir)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, h := range hosts {
+			found = found || h == host
+		}
+		if !found {
+			return httpError(http.StatusNotFound, fmt.Errorf("host %q not found", host))
+		}
+		n := defaultLineContext
+		if v := r.FormValue("context"); v != "" {
+			n, err = strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxLineContext {
+				return httpError(http.StatusBadRequest, fmt.Errorf("invalid context= parameter (expected 0 to %d)", maxLineContext))
+			}
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+		lines, err := readLineContext(r.Context(), cache, filepath.Join(dir, host, name), offset, hash, n)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return httpError(http.StatusNotFound, fmt.Errorf("line %s/%s not found (deleted by retention?)", host, id))
+			}
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, l := range lines {
+			marker := " "
+			if l.target {
+				marker = ">"
+			}
+			fmt.Fprintf(bw, "%s %s\n", marker, inZoneOf(l.text, loc))
+		}
+		return bw.Flush()
+	}
+}
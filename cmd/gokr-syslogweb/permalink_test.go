@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPermalink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "dr", "2022-08-13.log")
+	write := func(lines ...string) {
+		t.Helper()
+		if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(
+		"rfc3339=2022-08-13T16:00:00Z seq=1 severity=debug dhcpd: DHCPDISCOVER",
+		"rfc3339=2022-08-13T16:00:01Z seq=2 severity=info dhcpd: DHCPOFFER",
+		"rfc3339=2022-08-13T16:00:02Z seq=3 severity=err dhcpd: no free leases",
+		"rfc3339=2022-08-13T16:00:03Z seq=4 severity=info dhcpd: DHCPREQUEST",
+	)
+
+	search := middleware(searchHandler(dir, nil, nil))
+	rec := httptest.NewRecorder()
+	search.ServeHTTP(rec, httptest.NewRequest("GET", "/search?format=jsonl&q="+url.QueryEscape("leases since:87600h"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var result searchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/line/dr/2022-08-13.log@136-"; !strings.HasPrefix(result.Link, want) {
+		t.Fatalf("link = %q, want prefix %q", result.Link, want)
+	}
+
+	line := middleware(lineHandler(dir, nil))
+	get := func(path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		line.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d: %s", path, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+	want := `  rfc3339=2022-08-13T16:00:01Z seq=2 severity=info dhcpd: DHCPOFFER
+> rfc3339=2022-08-13T16:00:02Z seq=3 severity=err dhcpd: no free leases
+  rfc3339=2022-08-13T16:00:03Z seq=4 severity=info dhcpd: DHCPREQUEST
+`
+	if diff := cmp.Diff(want, get(result.Link+"?context=1")); diff != "" {
+		t.Errorf("line: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// After the debug message was dropped (e.g. by -severity_retention), the
+	// line is found by its hash.
+	write(
+		"rfc3339=2022-08-13T16:00:01Z seq=2 severity=info dhcpd: DHCPOFFER",
+		"rfc3339=2022-08-13T16:00:02Z seq=3 severity=err dhcpd: no free leases",
+	)
+	want = "> rfc3339=2022-08-13T16:00:02Z seq=3 severity=err dhcpd: no free leases\n"
+	if diff := cmp.Diff(want, get(result.Link+"?context=0")); diff != "" {
+		t.Errorf("rewritten: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, path := range []string{
+		"/line/dr/2022-08-13.log@136-00000000",
+		"/line/dr/2022-08-14.log@0-00000000",
+		"/line/dr/..@0-00000000",
+		"/line/nonexistent/" + strings.TrimPrefix(result.Link, "/line/dr/"),
+	} {
+		rec := httptest.NewRecorder()
+		line.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// prefsCookie persists the preferences selected in the UI (see preferences).
+// The time zone has its own cookie, tzCookie.
+const prefsCookie = "prefs"
+
+// checkSettingsRequest rejects requests to change the settings of a browser
+// (its preferences or time zone) unless they are POSTed from a page of
+// gokr-syslogweb itself, so that neither links nor forms of other sites can
+// change them.
+func checkSettingsRequest(r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httpError(http.StatusMethodNotAllowed, fmt.Errorf("method not allowed (use POST)"))
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return nil // not sent by a browser
+	}
+	if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+		return httpError(http.StatusForbidden, fmt.Errorf("cross-origin request from %q rejected", origin))
+	}
+	return nil
+}
+
+// preferences are the defaults of a browser, which apply when a request does
+// not set the corresponding parameter.
+type preferences struct {
+	// Hosts are the hosts which the start page lists, all hosts if empty.
+	Hosts []string
+
+	// Range is the default range= of /grep/: todayyesterday or all.
+	Range string
+
+	// Collapse is the default of collapse=.
+	Collapse bool
+}
+
+// values returns p as the parameters which prefsHandler takes.
+func (p preferences) values() url.Values {
+	v := url.Values{}
+	if len(p.Hosts) > 0 {
+		v.Set("hosts", strings.Join(p.Hosts, ","))
+	}
+	if p.Range != "" {
+		v.Set("range", p.Range)
+	}
+	if p.Collapse {
+		v.Set("collapse", "1")
+	}
+	return v
+}
+
+// parsePreferences parses the parameters which prefsHandler takes.
+func parsePreferences(v url.Values) (preferences, error) {
+	var p preferences
+	for _, host := range strings.Split(v.Get("hosts"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			p.Hosts = append(p.Hosts, host)
+		}
+	}
+	switch p.Range = v.Get("range"); p.Range {
+	case "", "todayyesterday", "all":
+	default:
+		return preferences{}, httpError(http.StatusBadRequest, fmt.Errorf("invalid range= parameter (expected one of todayyesterday or all)"))
+	}
+	collapse := v.Get("collapse")
+	p.Collapse = collapse == "true" || collapse == "1"
+	return p, nil
+}
+
+// requestPreferences returns the preferences stored in the prefs cookie of r.
+// Without a cookie or with an invalid one, nothing is preferred.
+func requestPreferences(r *http.Request) preferences {
+	c, err := r.Cookie(prefsCookie)
+	if err != nil {
+		return preferences{}
+	}
+	encoded, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return preferences{}
+	}
+	v, err := url.ParseQuery(encoded)
+	if err != nil {
+		return preferences{}
+	}
+	p, err := parsePreferences(v)
+	if err != nil {
+		return preferences{}
+	}
+	return p
+}
+
+// prefsHandler stores the preferences of the hosts=, range= and collapse=
+// parameters POSTed to it in a cookie (removing it if none are set) and
+// redirects to the start page (see checkSettingsRequest).
+func prefsHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := checkSettingsRequest(r); err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return httpError(http.StatusBadRequest, err)
+	}
+	p, err := parsePreferences(r.Form)
+	if err != nil {
+		return err
+	}
+	encoded := p.values().Encode()
+	c := &http.Cookie{
+		Name:     prefsCookie,
+		Value:    url.QueryEscape(encoded),
+		Path:     "/",
+		MaxAge:   10 * 365 * 24 * 3600,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if encoded == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func postPrefs(t *testing.T, params url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/prefs", strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	middleware(prefsHandler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPreferences(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		"rfc3339=2022-08-13T16:00:00Z seq=1 wpa_supplicant: CTRL-EVENT-CONNECTED",
+		"rfc3339=2022-08-13T16:00:05Z seq=2 wpa_supplicant: CTRL-EVENT-CONNECTED",
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-13.log"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postPrefs(t, url.Values{"hosts": {"dr, router7"}, "range": {"all"}, "collapse": {"1"}})
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST /prefs: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	want := preferences{
+		Hosts:    []string{"dr", "router7"},
+		Range:    "all",
+		Collapse: true,
+	}
+	if diff := cmp.Diff(want, requestPreferences(req)); diff != "" {
+		t.Errorf("requestPreferences: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// The preferred collapse= applies unless the request sets it.
+	search := middleware(searchHandler(dir, nil, nil))
+	countLines := func(query string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("since:87600h")+query, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		search.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		return strings.Count(rec.Body.String(), "\n")
+	}
+	if got, want := countLines(""), 1; got != want {
+		t.Errorf("with preferences: got %d lines, want %d", got, want)
+	}
+	if got, want := countLines("&collapse=0"), 2; got != want {
+		t.Errorf("collapse=0: got %d lines, want %d", got, want)
+	}
+
+	// Invalid cookies are ignored.
+	for _, value := range []string{
+		"%zz",
+		url.QueryEscape("range=month"),
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: prefsCookie, Value: value})
+		if diff := cmp.Diff(preferences{}, requestPreferences(req)); diff != "" {
+			t.Errorf("requestPreferences(%q): unexpected diff (-want +got):\n%s", value, diff)
+		}
+	}
+
+	// Links cannot change preferences.
+	rec = httptest.NewRecorder()
+	middleware(prefsHandler).ServeHTTP(rec, httptest.NewRequest("GET", "/prefs?hosts=dr", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /prefs: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("GET /prefs: cookies = %v, want none", cookies)
+	}
+
+	// Neither can forms of other sites.
+	req = httptest.NewRequest("POST", "/prefs", strings.NewReader("hosts=dr"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	middleware(prefsHandler).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin POST /prefs: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cross-origin POST /prefs: cookies = %v, want none", cookies)
+	}
+
+	// Saving no preferences removes the cookie.
+	rec = postPrefs(t, url.Values{"hosts": {""}})
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("POST /prefs hosts=: cookies = %v, want one to delete", cookies)
+	}
+
+	rec = postPrefs(t, url.Values{"range": {"month"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("range=month: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/logtree"
+)
+
+// rawHandler serves the log file /raw/<host>/<file> (decompressed, see
+// logtree.Cache.Open). The format= parameter converts each line into the requested
+// format: jsonl for NDJSON (see logline.JSON) or text for the format that
+// gokr-syslogd writes. Timestamps are converted into the zone of tz= (see
+// requestLocation).
+func rawHandler(dir string, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		host, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/raw/"), "/")
+		if !ok || host == "" || strings.Contains(name, "/") || !logtree.IsLogFile(name) {
+			return httpError(http.StatusNotFound, fmt.Errorf("not found"))
+		}
+		hosts, err := logtree.ListHosts(dir)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, h := range hosts {
+			found = found || h == host
+		}
+		if !found {
+			return httpError(http.StatusNotFound, fmt.Errorf("host %q not found", host))
+		}
+		format := r.FormValue("format")
+		if format != "" && format != "text" && format != "jsonl" {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid format= parameter (expected one of text or jsonl)"))
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+
+		f, err := cache.Open(r.Context(), filepath.Join(dir, host, strings.TrimSuffix(name, ".zst")))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return httpError(http.StatusNotFound, fmt.Errorf("%s/%s not found", host, name))
+			}
+			return err
+		}
+		defer f.Close()
+
+		if format == "jsonl" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if format == "" && loc == nil {
+			_, err := io.Copy(w, f)
+			return err
+		}
+		bw := bufio.NewWriter(w)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "{") {
+				line = inZoneOf(line, loc)
+			}
+			if _, err := bw.Write(append(convertLine(line, format), '\n')); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+}
+
+// convertLine converts line, which is either a JSON object or a line in the
+// format that gokr-syslogd writes, into format. Lines are returned unmodified
+// if they are in format already or cannot be converted.
+func convertLine(line, format string) []byte {
+	isJSON := strings.HasPrefix(line, "{")
+	switch {
+	case format == "jsonl" && !isJSON:
+		return logline.JSON(line)
+	case format == "text" && isJSON:
+		if text, err := logline.FromJSON([]byte(line)); err == nil {
+			return []byte(text)
+		}
+	}
+	return []byte(line)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRaw(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const content = "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n"
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-13.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hdl := middleware(rawHandler(dir, nil))
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/raw/dr/2022-08-13.log", wantCode: http.StatusOK, wantBody: content},
+		{path: "/raw/dr/2022-08-13.log?format=text", wantCode: http.StatusOK, wantBody: content},
+		{
+			path:     "/raw/dr/2022-08-13.log?format=jsonl",
+			wantCode: http.StatusOK,
+			wantBody: `{"rfc3339":"2022-08-13T16:20:00Z","seq":"1","tag":"dhcpd","message":"DHCPDISCOVER"}` + "\n",
+		},
+		{path: "/raw/dr/2022-08-13.log?format=xml", wantCode: http.StatusBadRequest},
+		{path: "/raw/dr/2022-08-14.log", wantCode: http.StatusNotFound},
+		{path: "/raw/router7/2022-08-13.log", wantCode: http.StatusNotFound},
+		{path: "/raw/dr/../dr/2022-08-13.log", wantCode: http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, rec.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		if diff := cmp.Diff(tt.wantBody, rec.Body.String()); diff != "" {
+			t.Errorf("GET %s: unexpected diff (-want +got):\n%s", tt.path, diff)
+		}
+	}
+}
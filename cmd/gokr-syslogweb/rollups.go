@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/rollup"
+	"github.com/gokrazy/syslogd/internal/timeexpr"
+)
+
+// rollupsHandler serves the counts of the rollup files (see gokr-syslogd
+// -rollups) as CSV with columns time, host, tag, messages and errors, per hour
+// or (with interval=day) per day. The host=, tag=, since= and until= (see
+// package timeexpr, e.g. since=2022-01-01 or since=7d) parameters restrict the
+// counts.
+func rollupsHandler(dir string, aliases hostalias.Map) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		now := time.Now()
+		var start, end time.Time
+		for _, p := range []struct {
+			name  string
+			start bool
+		}{
+			{"since", true},
+			{"until", false},
+		} {
+			v := r.FormValue(p.name)
+			if v == "" {
+				continue
+			}
+			e, err := timeexpr.Parse(v)
+			if err != nil {
+				return httpError(http.StatusBadRequest, fmt.Errorf("invalid %s= parameter: %v", p.name, err))
+			}
+			if p.start {
+				start, _ = e.Period(now)
+			} else {
+				_, end = e.Period(now)
+			}
+		}
+		// Counts are per hour, so the hour of start is included.
+		start = start.Truncate(time.Hour)
+		var since, until string
+		if !start.IsZero() {
+			since = start.Local().Format("2006-01-02")
+		}
+		if !end.IsZero() {
+			until = end.Add(-time.Nanosecond).Local().Format("2006-01-02")
+		}
+		truncate := func(t time.Time) time.Time { return t }
+		switch r.FormValue("interval") {
+		case "", "hour":
+		case "day":
+			truncate = func(t time.Time) time.Time {
+				t = t.Local()
+				return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+			}
+		default:
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid interval= parameter (expected hour or day)"))
+		}
+		wantHost, wantTag := r.FormValue("host"), r.FormValue("tag")
+		hostDirs, err := logtree.ListHosts(dir)
+		if err != nil {
+			return err
+		}
+		type key struct {
+			t         time.Time
+			host, tag string
+		}
+		counts := make(map[key]*rollup.Count)
+		for _, hostDir := range hostDirs {
+			host := aliases.Resolve(hostDir)
+			if wantHost != "" && host != wantHost {
+				continue
+			}
+			days, err := rollup.Days(filepath.Join(dir, hostDir))
+			if err != nil {
+				return err
+			}
+			for _, day := range days {
+				if (since != "" && day < since) || (until != "" && day > until) {
+					continue
+				}
+				dayCounts, err := rollup.ReadFile(filepath.Join(dir, hostDir, rollup.DirName, rollup.FileName(day)))
+				if err != nil {
+					if os.IsNotExist(err) {
+						continue // deleted in the meantime
+					}
+					return err
+				}
+				for _, c := range dayCounts {
+					if wantTag != "" && c.Tag != wantTag {
+						continue
+					}
+					if (!start.IsZero() && c.Hour.Before(start)) || (!end.IsZero() && !c.Hour.Before(end)) {
+						continue
+					}
+					k := key{truncate(c.Hour), host, c.Tag}
+					sum, ok := counts[k]
+					if !ok {
+						sum = &rollup.Count{Hour: k.t, Tag: c.Tag}
+						counts[k] = sum
+					}
+					sum.Messages += c.Messages
+					sum.Errors += c.Errors
+				}
+			}
+		}
+		keys := make([]key, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			if !a.t.Equal(b.t) {
+				return a.t.Before(b.t)
+			}
+			if a.host != b.host {
+				return a.host < b.host
+			}
+			return a.tag < b.tag
+		})
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "host", "tag", "messages", "errors"})
+		for _, k := range keys {
+			c := counts[k]
+			cw.Write([]string{
+				k.t.Format(time.RFC3339),
+				k.host,
+				k.tag,
+				strconv.FormatUint(c.Messages, 10),
+				strconv.FormatUint(c.Errors, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+}
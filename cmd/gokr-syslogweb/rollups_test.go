@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRollups(t *testing.T) {
+	dir := t.TempDir()
+	for fn, content := range map[string]string{
+		"dr/rollup/2022-08-12.json":          `[{"hour":"2022-08-12T10:00:00Z","tag":"dhcpd","messages":3,"errors":1}]`,
+		"raspberrypi/rollup/2022-01-01.json": `[{"hour":"2022-01-01T10:00:00Z","tag":"dhcpd","messages":7}]`,
+		"router7/rollup/2022-08-12.json":     `[{"hour":"2022-08-12T10:00:00Z","tag":"dhcp4d","messages":5}]`,
+	} {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hdl := middleware(rollupsHandler(dir, hostalias.Map{"raspberrypi": "dr"}))
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("GET", "/rollups?host=dr", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := `time,host,tag,messages,errors
+2022-01-01T10:00:00Z,dr,dhcpd,7,0
+2022-08-12T10:00:00Z,dr,dhcpd,3,1
+`
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("rollups: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	rec = httptest.NewRecorder()
+	hdl.ServeHTTP(rec, httptest.NewRequest("GET", "/rollups?since=2022-08-01&interval=day&tag=dhcp4d", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	hour := time.Date(2022, time.August, 12, 10, 0, 0, 0, time.UTC).Local()
+	day := time.Date(hour.Year(), hour.Month(), hour.Day(), 0, 0, 0, 0, time.Local)
+	want = "time,host,tag,messages,errors\n" + day.Format(time.RFC3339) + ",router7,dhcp4d,5,0\n"
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("rollups per day: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/query"
+)
+
+// searchResult is a line of the format=jsonl response of searchHandler.
+type searchResult struct {
+	Host string `json:"host"`
+	ID   string `json:"id"`
+	Link string `json:"link"`
+	Line string `json:"line"`
+	// Count and Last are set for lines which were repeated (see
+	// collapse=true): how often, and the most recent repetition.
+	Count int    `json:"count,omitempty"`
+	Last  string `json:"last,omitempty"`
+	// Names are the names of the IP addresses in Line at its time (see
+	// names=1), by address.
+	Names map[string]string `json:"names,omitempty"`
+	// Annotation is set for annotations of operator actions (see
+	// isAnnotation).
+	Annotation bool `json:"annotation,omitempty"`
+}
+
+// searchHandler serves the lines matching the query in the q= parameter (see
+// package query), each prefixed with its host. Retired hosts are included with
+// retired=1. With format=jsonl, each line is a JSON object which includes the
+// permalink of the line (see lineHandler). With collapse=true, consecutive
+// repetitions of a message are folded into one line (see collapser).
+// Timestamps are converted into the zone of tz= (see requestLocation), in
+// which days of the query (e.g. since:yesterday) are evaluated, too. With
+// names=1, IP addresses are annotated with the names of the devices which held
+// them at the time (see gokr-syslogd -learn_bindings). HEAD requests and
+// estimate=1 serve what the search would cost instead (see queryEstimate).
+func searchHandler(dir string, aliases hostalias.Map, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, err := query.Parse(r.FormValue("q"))
+		if err != nil {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid query (q= parameter): %v", err))
+		}
+		q.IncludeRetired = r.FormValue("retired") == "1"
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if loc != nil {
+			now = now.In(loc)
+		}
+		format := r.FormValue("format")
+		if format != "" && format != "jsonl" {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid format= parameter (expected jsonl)"))
+		}
+		if wantEstimate(r) {
+			plan, err := logtree.PlanSearch(dir, aliases, q, now)
+			if err != nil {
+				return err
+			}
+			files := make([]string, len(plan.Files))
+			for i, pf := range plan.Files {
+				files[i] = filepath.Join(dir, pf.HostDir, pf.File)
+			}
+			// Day by day, so that the newest files are sampled.
+			sort.SliceStable(files, func(i, j int) bool {
+				return filepath.Base(files[i]) < filepath.Base(files[j])
+			})
+			est, err := estimateQuery(r.Context(), cache, files, func(line []byte) bool {
+				return q.Match(string(line), plan.Start, plan.End)
+			})
+			if err != nil {
+				return err
+			}
+			return est.write(w, r)
+		}
+		names, err := requestBindings(r, dir)
+		if err != nil {
+			return err
+		}
+		// Flushes periodically, so that results appear while the search
+		// continues.
+		sw := newStreamWriter(w, r)
+		var emit func(host string, l logtree.Line, last string, count int) error
+		if format == "jsonl" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(sw)
+			emit = func(host string, l logtree.Line, last string, count int) error {
+				id := lineID(l.File, l.Offset, l.Text)
+				result := searchResult{
+					Host:       host,
+					ID:         id,
+					Link:       "/line/" + l.HostDir + "/" + id,
+					Line:       inZoneOf(l.Text, loc),
+					Names:      namesOf(l.Text, names),
+					Annotation: isAnnotation(l.Text),
+				}
+				if count > 1 {
+					result.Count = count
+					result.Last = inZoneOf(last, loc)
+				}
+				return enc.Encode(result)
+			}
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			emit = func(host string, l logtree.Line, last string, count int) error {
+				_, err := fmt.Fprintln(sw, markAnnotation(l.Text, host+" "+collapsedText(annotate(inZoneOf(l.Text, loc), names), inZoneOf(last, loc), count)))
+				return err
+			}
+		}
+		if !wantCollapse(r) {
+			return cache.SearchLines(r.Context(), dir, aliases, q, now, func(host string, l logtree.Line) error {
+				return emit(host, l, l.Text, 1)
+			})
+		}
+		c := collapser{emit: emit}
+		if err := cache.SearchLines(r.Context(), dir, aliases, q, now, c.add); err != nil {
+			return err
+		}
+		return c.flush()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// flushInterval is how often streamWriter sends the lines written so far.
+const flushInterval = 250 * time.Millisecond
+
+// progressPrefix starts the progress comments of streamWriter, which cannot be
+// confused with stored lines (which start with rfc3339=) or hosts.
+const progressPrefix = "# progress: "
+
+// streamWriter writes the response of a long-running handler, flushing it to
+// the client periodically so that results appear while the handler is still
+// reading files. With the progress=1 parameter, it also writes progress
+// comments like “# progress: 3/10 files scanned”.
+type streamWriter struct {
+	w         http.ResponseWriter
+	flusher   http.Flusher // nil if w cannot flush
+	progress  bool
+	lastFlush time.Time
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request) *streamWriter {
+	flusher, _ := w.(http.Flusher)
+	return &streamWriter{
+		w:         w,
+		flusher:   flusher,
+		progress:  r.FormValue("progress") == "1",
+		lastFlush: time.Now(),
+	}
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if err == nil && time.Since(s.lastFlush) >= flushInterval {
+		s.flush()
+	}
+	return n, err
+}
+
+func (s *streamWriter) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	s.lastFlush = time.Now()
+}
+
+// Progress writes a progress comment (if requested) and flushes.
+func (s *streamWriter) Progress(scanned, total int) error {
+	if s.progress {
+		if _, err := fmt.Fprintf(s.w, "%s%d/%d files scanned\n", progressPrefix, scanned, total); err != nil {
+			return err
+		}
+	}
+	s.flush()
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamWriter(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{"/grep/dr?q=DISCOVER", "line\n"},
+		{"/grep/dr?q=DISCOVER&progress=1", "# progress: 0/2 files scanned\nline\n# progress: 2/2 files scanned\n"},
+	} {
+		rec := httptest.NewRecorder()
+		sw := newStreamWriter(rec, httptest.NewRequest("GET", tt.url, nil))
+		if err := sw.Progress(0, 2); err != nil {
+			t.Fatal(err)
+		}
+		if !rec.Flushed {
+			t.Errorf("%s: Progress did not flush", tt.url)
+		}
+		fmt.Fprintf(sw, "line\n")
+		if err := sw.Progress(2, 2); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
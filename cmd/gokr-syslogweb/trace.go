@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/bindings"
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/query"
+)
+
+// maxTraceLines bounds the lines traceHandler sorts in memory.
+const maxTraceLines = 10000
+
+// traceLine is a line of a trace, with the time by which it is sorted.
+type traceLine struct {
+	host string
+	line logtree.Line
+	time time.Time
+}
+
+func newTraceLine(host string, l logtree.Line) traceLine {
+	t, _ := lineTime(l.Text)
+	return traceLine{host: host, line: l, time: t}
+}
+
+// collectTrace returns the lines matching q across all hosts in timestamp
+// order, and whether more than maxTraceLines matched (of which only the first
+// found are returned).
+func collectTrace(ctx context.Context, dir string, aliases hostalias.Map, cache *logtree.Cache, q *query.Query, now time.Time) ([]traceLine, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		lines     []traceLine
+		truncated bool
+	)
+	err := cache.SearchLines(ctx, dir, aliases, q, now, func(host string, l logtree.Line) error {
+		if len(lines) == maxTraceLines {
+			truncated = true
+			cancel()
+			return nil
+		}
+		lines = append(lines, newTraceLine(host, l))
+		return nil
+	})
+	if err != nil && !truncated {
+		return nil, false, err
+	}
+	sortTimeline(lines)
+	return lines, truncated, nil
+}
+
+// sortTimeline sorts lines by time. Lines of one host are already in order:
+// their order is kept for equal times.
+func sortTimeline(lines []traceLine) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].time.Before(lines[j].time)
+	})
+}
+
+// traceHandler serves the lines of all hosts carrying the correlation ID of
+// /trace/<id> (see gokr-syslogd -correlation_id) in timestamp order, each
+// prefixed with its host. The q= parameter restricts the lines further, e.g.
+// q=since:7d (the default period is that of /search). format=jsonl, tz= and
+// names=1 work like for searchHandler. When more than maxTraceLines match, a comment
+// line “# truncated” ends the response.
+func traceHandler(dir string, aliases hostalias.Map, cache *logtree.Cache) errorHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id := strings.TrimPrefix(r.URL.Path, "/trace/")
+		if id == "" || strings.ContainsAny(id, " =/") {
+			return httpError(http.StatusNotFound, fmt.Errorf("invalid correlation ID %q", id))
+		}
+		q, err := query.Parse(r.FormValue("q"))
+		if err != nil {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid query (q= parameter): %v", err))
+		}
+		q.Fields = append(q.Fields, "correlation_id="+id)
+		q.IncludeRetired = r.FormValue("retired") == "1"
+		format := r.FormValue("format")
+		if format != "" && format != "jsonl" {
+			return httpError(http.StatusBadRequest, fmt.Errorf("invalid format= parameter (expected jsonl)"))
+		}
+		loc, err := requestLocation(r)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if loc != nil {
+			now = now.In(loc)
+		}
+		names, err := requestBindings(r, dir)
+		if err != nil {
+			return err
+		}
+		lines, truncated, err := collectTrace(r.Context(), dir, aliases, cache, q, now)
+		if err != nil {
+			return err
+		}
+		return writeTimeline(w, lines, truncated, format, loc, names)
+	}
+}
+
+// writeTimeline writes lines (see collectTrace) in the text or jsonl format
+// of searchHandler (annotated with names unless nil), followed by a comment if
+// the lines were truncated.
+func writeTimeline(w http.ResponseWriter, lines []traceLine, truncated bool, format string, loc *time.Location, names *bindings.Table) error {
+	bw := bufio.NewWriter(w)
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(bw)
+		for _, tl := range lines {
+			lid := lineID(tl.line.File, tl.line.Offset, tl.line.Text)
+			if err := enc.Encode(searchResult{
+				Host:       tl.host,
+				ID:         lid,
+				Link:       "/line/" + tl.line.HostDir + "/" + lid,
+				Line:       inZoneOf(tl.line.Text, loc),
+				Annotation: isAnnotation(tl.line.Text),
+			}); err != nil {
+				return err
+			}
+		}
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, tl := range lines {
+			if _, err := fmt.Fprintln(bw, markAnnotation(tl.line.Text, tl.host+" "+annotate(inZoneOf(tl.line.Text, loc), names))); err != nil {
+				return err
+			}
+		}
+	}
+	if truncated {
+		fmt.Fprintf(bw, "# truncated after %d lines\n", maxTraceLines)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+	for host, lines := range map[string][]string{
+		"proxy": {
+			"rfc3339=2022-08-13T16:00:00Z seq=1 correlation_id=4f2a nginx: GET /api request_id=4f2a",
+			"rfc3339=2022-08-13T16:00:03Z seq=2 correlation_id=4f2a nginx: 200 request_id=4f2a",
+			"rfc3339=2022-08-13T16:00:04Z seq=3 correlation_id=91c0 nginx: GET / request_id=91c0",
+		},
+		"app": {
+			"rfc3339=2022-08-13T16:00:01Z seq=1 correlation_id=4f2a api: handling request_id=4f2a",
+			"rfc3339=2022-08-13T16:00:02Z seq=2 correlation_id=4f2a api: query took 1s request_id=4f2a",
+			"rfc3339=2022-08-13T16:00:02Z seq=3 api: unrelated",
+		},
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		fn := filepath.Join(dir, host, "2022-08-13.log")
+		if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trace := middleware(traceHandler(dir, nil, nil))
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		trace.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	code, body := get("/trace/4f2a?q=" + url.QueryEscape("since:87600h"))
+	if code != http.StatusOK {
+		t.Fatalf("trace: status = %d: %s", code, body)
+	}
+	want := `proxy rfc3339=2022-08-13T16:00:00Z seq=1 correlation_id=4f2a nginx: GET /api request_id=4f2a
+app rfc3339=2022-08-13T16:00:01Z seq=1 correlation_id=4f2a api: handling request_id=4f2a
+app rfc3339=2022-08-13T16:00:02Z seq=2 correlation_id=4f2a api: query took 1s request_id=4f2a
+proxy rfc3339=2022-08-13T16:00:03Z seq=2 correlation_id=4f2a nginx: 200 request_id=4f2a
+`
+	if diff := cmp.Diff(want, body); diff != "" {
+		t.Errorf("trace: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if code, _ := get("/trace/4f2a?q=" + url.QueryEscape("host:app since:87600h")); code != http.StatusOK {
+		t.Errorf("trace with host: status = %d", code)
+	}
+	if code, _ := get("/trace/"); code != http.StatusNotFound {
+		t.Errorf("trace without ID: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // gokrazy has no zoneinfo files
+
+	"github.com/gokrazy/syslogd/internal/logline"
+)
+
+// tzCookie persists the time zone selected in the UI.
+const tzCookie = "tz"
+
+// requestLocation returns the time zone in which to display timestamps: the
+// tz= parameter (e.g. Europe/Zurich or UTC), falling back to the tz cookie. A
+// nil location means timestamps are displayed as stored, i.e. in the zone of
+// gokr-syslogd.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	name := r.FormValue("tz")
+	if name == "" {
+		c, err := r.Cookie(tzCookie)
+		if err != nil {
+			return nil, nil // no cookie, or an invalid one
+		}
+		name, _ = url.QueryUnescape(c.Value)
+	}
+	if name == "" {
+		return nil, nil
+	}
+	if name == "Local" {
+		return nil, httpError(http.StatusBadRequest, fmt.Errorf("invalid time zone %q (expected e.g. UTC or Europe/Zurich)", name))
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, httpError(http.StatusBadRequest, fmt.Errorf("invalid time zone (tz= parameter): %v", err))
+	}
+	return loc, nil
+}
+
+// timestampFields are converted by inZoneOf.
+var timestampFields = []string{"rfc3339=", "received="}
+
+// inZoneOf returns line with its timestamp fields converted to loc.
+// Unparseable timestamps are left as they are.
+func inZoneOf(line string, loc *time.Location) string {
+	if loc == nil {
+		return line
+	}
+	fields, rest := logline.Split(line)
+	if len(fields) == 0 {
+		return line
+	}
+	var b strings.Builder
+	for _, field := range fields {
+		for _, prefix := range timestampFields {
+			if !strings.HasPrefix(field, prefix) {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339Nano, field[len(prefix):]); err == nil {
+				field = prefix + t.In(loc).Format(time.RFC3339Nano)
+			}
+		}
+		b.WriteString(field)
+		b.WriteByte(' ')
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// tzHandler stores the time zone of the tz= parameter (empty to reset to the
+// zone of gokr-syslogd) in a cookie and redirects to the start page (see
+// checkSettingsRequest).
+func tzHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := checkSettingsRequest(r); err != nil {
+		return err
+	}
+	name := r.FormValue("tz")
+	if name != "" {
+		if _, err := requestLocation(r); err != nil {
+			return err
+		}
+	}
+	c := &http.Cookie{
+		Name:     tzCookie,
+		Value:    url.QueryEscape(name),
+		Path:     "/",
+		MaxAge:   10 * 365 * 24 * 3600,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if name == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTimeZone(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "dr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := "rfc3339=2022-08-13T16:20:00.5+02:00 seq=1 received=2022-08-13T14:20:01Z dhcpd: at 2022-08-13T16:20:00+02:00\n"
+	if err := os.WriteFile(filepath.Join(dir, "dr", "2022-08-13.log"), []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	raw := middleware(rawHandler(dir, nil))
+	get := func(req *http.Request) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		raw.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(httptest.NewRequest("GET", "/raw/dr/2022-08-13.log?tz=America/New_York", nil))
+	want := "rfc3339=2022-08-13T10:20:00.5-04:00 seq=1 received=2022-08-13T10:20:01-04:00 dhcpd: at 2022-08-13T16:20:00+02:00\n"
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("tz=: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	// The UI stores the selected zone in a cookie. Like preferences, only
+	// forms of gokr-syslogweb can change it.
+	postTZ := func(method, origin string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/tz", strings.NewReader("tz=UTC"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		middleware(tzHandler).ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := postTZ("GET", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /tz: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := postTZ("POST", "https://evil.example"); rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin POST /tz: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = postTZ("POST", "http://example.com")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST /tz: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest("GET", "/raw/dr/2022-08-13.log", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	want = "rfc3339=2022-08-13T14:20:00.5Z seq=1 received=2022-08-13T14:20:01Z dhcpd: at 2022-08-13T16:20:00+02:00\n"
+	if diff := cmp.Diff(want, get(req).Body.String()); diff != "" {
+		t.Errorf("cookie: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local"} {
+		if rec := get(httptest.NewRequest("GET", "/raw/dr/2022-08-13.log?tz="+tz, nil)); rec.Code != http.StatusBadRequest {
+			t.Errorf("tz=%s: status = %d, want %d", tz, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
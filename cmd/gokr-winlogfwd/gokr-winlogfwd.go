@@ -0,0 +1,207 @@
+// Binary gokr-winlogfwd forwards the events of selected Windows Event Log
+// channels to gokr-syslogd, so that Windows machines land in the same log
+// store. Events are sent as RFC5424 messages with the event provider as
+// APP-NAME, the event ID as MSGID and the channel and event ID as structured
+// data.
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/senderconfig"
+)
+
+// event is the part of the XML rendering of a Windows event which is
+// forwarded.
+type event struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		}
+		EventID     int
+		Level       int
+		TimeCreated struct {
+			SystemTime time.Time `xml:"SystemTime,attr"`
+		}
+		Channel  string
+		Computer string
+	}
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		}
+	}
+}
+
+func parseEvent(b []byte) (*event, error) {
+	var ev event
+	if err := xml.Unmarshal(b, &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// severity maps the event level to a syslog severity.
+func (ev *event) severity() int {
+	switch ev.System.Level {
+	case 1: // critical
+		return 2
+	case 2: // error
+		return 3
+	case 3: // warning
+		return 4
+	case 5: // verbose
+		return 7
+	default: // information, or 0 (log always, e.g. security audits)
+		return 6
+	}
+}
+
+// facility returns authpriv for the Security channel, user otherwise.
+func (ev *event) facility() int {
+	if ev.System.Channel == "Security" {
+		return 10
+	}
+	return 1
+}
+
+// appName returns the event provider, with characters which are not allowed
+// in an RFC5424 APP-NAME (anything but printable US-ASCII) replaced.
+func (ev *event) appName() string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, ev.System.Provider.Name)
+	if name == "" {
+		return "eventlog"
+	}
+	if len(name) > 48 {
+		name = name[:48]
+	}
+	return name
+}
+
+// sdID is the SD-ID of the structured data of forwarded events. 32473 is the
+// private enterprise number reserved for documentation (RFC 5612).
+const sdID = "winlog@32473"
+
+// sdEscape escapes the characters which must be escaped in an SD-PARAM value.
+var sdEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// format formats ev as an RFC5424 message of hostname. message is the
+// formatted event message, if available; otherwise, the event data is
+// forwarded. Line breaks are replaced, as each message is stored as one
+// line.
+func (ev *event) format(hostname, message string) []byte {
+	if message == "" {
+		var data []string
+		for _, d := range ev.EventData.Data {
+			if d.Name != "" {
+				data = append(data, d.Name+"="+d.Value)
+			} else {
+				data = append(data, d.Value)
+			}
+		}
+		message = strings.Join(data, " ")
+	}
+	message = strings.Join(strings.Fields(message), " ")
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s - %d [%s channel=\"%s\" event_id=\"%d\"] %s",
+		ev.facility()<<3|ev.severity(),
+		ev.System.TimeCreated.SystemTime.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		ev.appName(),
+		ev.System.EventID,
+		sdID,
+		sdEscape.Replace(ev.System.Channel),
+		ev.System.EventID,
+		message))
+}
+
+func winlogfwd(ctx context.Context) error {
+	var (
+		target = flag.String("target",
+			"localhost:5514",
+			"host:port of gokr-syslogd (UDP), mdns to discover a gokr-syslogd started with -mdns on the local network, or an http:// URL of a gokr-syslogd sender configuration (e.g. http://syslog.lan:8080/.well-known/gokr-syslogd.json)")
+
+		hostname = flag.String("hostname",
+			"",
+			"hostname to send messages as (defaults to os.Hostname)")
+
+		channels = flag.String("channels",
+			"System,Application",
+			"comma-separated list of event channels to forward, e.g. System,Application,Security")
+
+		query = flag.String("query",
+			"*",
+			"XPath query selecting the events to forward, e.g. *[System[Level<=3]] for warnings and errors")
+
+		fromOldest = flag.Bool("from_oldest",
+			false,
+			"forward the events which are in the channels already, too (by default, only new events are forwarded)")
+	)
+	flag.Parse()
+
+	if *hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		*hostname = h
+	}
+
+	addr, err := senderconfig.ResolveTarget(ctx, *target)
+	if err != nil {
+		return err
+	}
+	if addr != *target {
+		log.Printf("discovered gokr-syslogd at %s", addr)
+		*target = addr
+	}
+	conn, err := net.Dial("udp", *target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	forward := func(rendered []byte, message string) {
+		ev, err := parseEvent(rendered)
+		if err != nil {
+			log.Printf("parsing event: %v", err)
+			return
+		}
+		if _, err := conn.Write(ev.format(*hostname, message)); err != nil {
+			// E.g. connection refused while gokr-syslogd restarts.
+			log.Printf("sending message: %v", err)
+		}
+	}
+	for _, channel := range strings.Split(*channels, ",") {
+		cancel, err := subscribe(channel, *query, *fromOldest, forward)
+		if err != nil {
+			return fmt.Errorf("subscribing to channel %q: %v", channel, err)
+		}
+		defer cancel()
+	}
+	log.Printf("forwarding events of %s to %s", *channels, *target)
+	<-ctx.Done()
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := winlogfwd(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gokrazy/syslogd/internal/rfc5424"
+)
+
+func TestFormatEvent(t *testing.T) {
+	ev, err := parseEvent([]byte(`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event">
+  <System>
+    <Provider Name="Service Control Manager" Guid="{555908d1-a6d7-4695-8e1e-26931d2012f4}"/>
+    <EventID Qualifiers="16384">7036</EventID>
+    <Level>2</Level>
+    <TimeCreated SystemTime="2022-08-03T14:20:00.1234567Z"/>
+    <EventRecordID>1234</EventRecordID>
+    <Channel>System</Channel>
+    <Computer>WIN-BOX</Computer>
+  </System>
+  <EventData>
+    <Data Name="param1">Windows Update</Data>
+    <Data Name="param2">stopped</Data>
+  </EventData>
+</Event>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(ev.format("win-box", "")), `<11>1 2022-08-03T14:20:00.123456Z win-box Service_Control_Manager - 7036 [winlog@32473 channel="System" event_id="7036"] param1=Windows Update param2=stopped`; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+	if got, want := string(ev.format("win-box", "The Windows Update service\r\nentered the stopped state.")), `<11>1 2022-08-03T14:20:00.123456Z win-box Service_Control_Manager - 7036 [winlog@32473 channel="System" event_id="7036"] The Windows Update service entered the stopped state.`; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+
+	// Channel names with characters which must be escaped in structured data
+	// survive the round trip through the parser of gokr-syslogd.
+	ev.System.Channel = `Odd "Channel"]`
+	msg, err := rfc5424.Parse(ev.format("win-box", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.StructuredData[0].Params[0].Value, ev.System.Channel; got != want {
+		t.Errorf("channel = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+func subscribe(channel, query string, fromOldest bool, forward func(rendered []byte, message string)) (cancel func(), _ error) {
+	return nil, fmt.Errorf("the Windows Event Log is only available on Windows")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	wevtapi                      = syscall.NewLazyDLL("wevtapi.dll")
+	procEvtSubscribe             = wevtapi.NewProc("EvtSubscribe")
+	procEvtRender                = wevtapi.NewProc("EvtRender")
+	procEvtOpenPublisherMetadata = wevtapi.NewProc("EvtOpenPublisherMetadata")
+	procEvtFormatMessage         = wevtapi.NewProc("EvtFormatMessage")
+	procEvtClose                 = wevtapi.NewProc("EvtClose")
+)
+
+const (
+	evtSubscribeToFutureEvents      = 1
+	evtSubscribeStartAtOldestRecord = 2
+
+	evtSubscribeActionError   = 0
+	evtSubscribeActionDeliver = 1
+
+	evtRenderEventXml     = 1
+	evtFormatMessageEvent = 1
+
+	errorInsufficientBuffer = 122
+)
+
+// publishers caches the publisher metadata handles (for formatting event
+// messages) by provider name. Callbacks run on arbitrary threads.
+var publishers struct {
+	sync.Mutex
+	handles map[string]uintptr
+}
+
+func subscribe(channel, query string, fromOldest bool, forward func(rendered []byte, message string)) (cancel func(), _ error) {
+	// Fail instead of panicking in Call if the Event Log API is unavailable.
+	if err := wevtapi.Load(); err != nil {
+		return nil, err
+	}
+	channelPtr, err := syscall.UTF16PtrFromString(channel)
+	if err != nil {
+		return nil, err
+	}
+	queryPtr, err := syscall.UTF16PtrFromString(query)
+	if err != nil {
+		return nil, err
+	}
+	callback := syscall.NewCallback(func(action, userContext, ev uintptr) uintptr {
+		switch action {
+		case evtSubscribeActionError:
+			log.Printf("channel %q: %v", channel, syscall.Errno(ev))
+		case evtSubscribeActionDeliver:
+			rendered, err := renderXML(ev)
+			if err != nil {
+				log.Printf("channel %q: rendering event: %v", channel, err)
+				return 0
+			}
+			message := ""
+			if parsed, err := parseEvent(rendered); err == nil {
+				message = formatMessage(parsed.System.Provider.Name, ev)
+			}
+			forward(rendered, message)
+		}
+		return 0
+	})
+	flags := uintptr(evtSubscribeToFutureEvents)
+	if fromOldest {
+		flags = evtSubscribeStartAtOldestRecord
+	}
+	h, _, err := procEvtSubscribe.Call(
+		0, // local session
+		0, // no signal event: events are delivered to callback
+		uintptr(unsafe.Pointer(channelPtr)),
+		uintptr(unsafe.Pointer(queryPtr)),
+		0, // no bookmark
+		0, // no user context
+		callback,
+		flags)
+	if h == 0 {
+		return nil, err
+	}
+	return func() { procEvtClose.Call(h) }, nil
+}
+
+// renderXML returns the XML rendering of the event ev (UTF-8 encoded).
+func renderXML(ev uintptr) ([]byte, error) {
+	var used, props uint32
+	buf := make([]uint16, 4096)
+	for {
+		r, _, err := procEvtRender.Call(
+			0,
+			ev,
+			evtRenderEventXml,
+			uintptr(len(buf)*2), // in bytes
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(unsafe.Pointer(&used)),
+			uintptr(unsafe.Pointer(&props)))
+		if r != 0 {
+			return []byte(syscall.UTF16ToString(buf)), nil
+		}
+		if err != syscall.Errno(errorInsufficientBuffer) {
+			return nil, err
+		}
+		buf = make([]uint16, used/2+1)
+	}
+}
+
+// formatMessage returns the message of the event ev of provider, or the empty
+// string if it cannot be formatted (e.g. the provider is not installed).
+func formatMessage(provider string, ev uintptr) string {
+	publishers.Lock()
+	defer publishers.Unlock()
+	if publishers.handles == nil {
+		publishers.handles = make(map[string]uintptr)
+	}
+	h, ok := publishers.handles[provider]
+	if !ok {
+		providerPtr, err := syscall.UTF16PtrFromString(provider)
+		if err != nil {
+			return ""
+		}
+		h, _, _ = procEvtOpenPublisherMetadata.Call(0, uintptr(unsafe.Pointer(providerPtr)), 0, 0, 0)
+		publishers.handles[provider] = h // 0 if unavailable, not retried
+	}
+	if h == 0 {
+		return ""
+	}
+	var used uint32
+	buf := make([]uint16, 1024)
+	for {
+		r, _, err := procEvtFormatMessage.Call(
+			h,
+			ev,
+			0,
+			0,
+			0,
+			evtFormatMessageEvent,
+			uintptr(len(buf)), // in characters
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(unsafe.Pointer(&used)))
+		if r != 0 {
+			return syscall.UTF16ToString(buf)
+		}
+		if err != syscall.Errno(errorInsufficientBuffer) {
+			return ""
+		}
+		buf = make([]uint16, used+1)
+	}
+}
@@ -10,7 +10,11 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/query"
 )
 
 func grog(ctx context.Context) error {
@@ -26,11 +30,69 @@ func grog(ctx context.Context) error {
 		grepRange = flag.String("range",
 			"todayyesterday",
 			"syslog range to grep; one of todayyesterday or all")
+
+		zone = flag.String("zone",
+			"",
+			"only print messages from senders in this zone (see gokr-syslogd -zones)")
+
+		queryStr = flag.String("q",
+			"",
+			`search all hosts with a query instead of grepping one host, e.g. -q 'host:dr tag:dhcpd sev>=warn "DISCOVER" since:2h' (same syntax as the gokr-syslogweb search box)`)
+
+		boot = flag.String("boot",
+			"",
+			"only print messages of this boot session: current, previous or a boot ID (see gokr-syslogd -boot_sessions). Overrides -range")
+
+		since = flag.String("since",
+			"",
+			`restrict -q to messages since this time, e.g. 15m, "yesterday 3pm" or 2024-07-01 (adds a since: term)`)
+
+		until = flag.String("until",
+			"",
+			`restrict -q to messages until this time, e.g. 2024-07-03 or "today 9:00" (adds an until: term)`)
+
+		progress = flag.Bool("progress",
+			false,
+			"print how many of the log files were grepped so far to stderr")
+
+		trace = flag.String("trace",
+			"",
+			"print the messages of all hosts with this correlation ID (see gokr-syslogd -correlation_id) in timestamp order, restricted by -q, -since and -until if specified")
+
+		collapse = flag.Bool("collapse",
+			false,
+			"fold consecutive repetitions of a message into one line with a count, e.g. for flappy services")
+
+		names = flag.Bool("names",
+			false,
+			"annotate IP addresses in -q and -trace results with the names of the devices which held them at the time (see gokr-syslogd -learn_bindings)")
 	)
 	flag.Parse()
 
+	if *queryStr != "" || *trace != "" {
+		for _, t := range []struct{ name, value string }{{"since", *since}, {"until", *until}} {
+			if t.value != "" {
+				*queryStr += " " + t.name + ":" + strconv.Quote(t.value)
+			}
+		}
+		params := url.Values{}
+		if *names {
+			params.Set("names", "1")
+		}
+		if *trace != "" {
+			return search(ctx, *base, "/trace/"+*trace, *queryStr, params)
+		}
+		if *collapse {
+			params.Set("collapse", "true")
+		}
+		return search(ctx, *base, "/search", *queryStr, params)
+	}
+	if *since != "" || *until != "" {
+		return fmt.Errorf("-since and -until require -q or -trace")
+	}
+
 	if flag.NArg() != 1 {
-		return fmt.Errorf("syntax: grog [--hostname=<host>] <grep pattern>")
+		return fmt.Errorf("syntax: grog [--hostname=<host>] <grep pattern> | grog -q <query>")
 	}
 	pattern := flag.Arg(0)
 
@@ -42,9 +104,54 @@ func grog(ctx context.Context) error {
 	q := u.Query()
 	q.Set("q", pattern)
 	q.Set("range", *grepRange)
+	if *zone != "" {
+		q.Set("zone", *zone)
+	}
+	if *boot != "" {
+		q.Set("boot", *boot)
+	}
+	if *collapse {
+		q.Set("collapse", "true")
+	}
+	if *progress {
+		q.Set("progress", "1")
+	}
 	u.RawQuery = q.Encode()
 	log.Printf("Grepping syslog via HTTP: %s", u)
+	return get(ctx, u, func(line string) string {
+		return logline.Strip(line)
+	})
+}
+
+// search prints the messages matching the query across all hosts (of the
+// /search or /trace/<id> path, with the additional params), prefixed with their
+// host. The query is parsed locally to report syntax errors right away.
+func search(ctx context.Context, base, path, queryStr string, params url.Values) error {
+	q, err := query.Parse(queryStr)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	params.Set("q", q.String())
+	u.RawQuery = params.Encode()
+	log.Printf("Searching syslog via HTTP: %s", u)
+	return get(ctx, u, func(line string) string {
+		host, rest, _ := strings.Cut(line, " ")
+		return host + " " + logline.Strip(rest)
+	})
+}
 
+// progressPrefix starts the progress comments of gokr-syslogweb (see its
+// progress=1 parameter).
+const progressPrefix = "# progress: "
+
+// get prints the lines of the response to u, formatted by format. Progress
+// comments are printed to stderr.
+func get(ctx context.Context, u *url.URL, format func(line string) string) error {
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return err
@@ -61,12 +168,11 @@ func grog(ctx context.Context) error {
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if strings.HasPrefix(line, "rfc3339=") {
-			if idx := strings.IndexByte(line, ' '); idx > -1 {
-				line = line[idx+1:]
-			}
+		if strings.HasPrefix(line, progressPrefix) {
+			log.Print(strings.TrimPrefix(line, progressPrefix))
+			continue
 		}
-		os.Stdout.WriteString(line)
+		os.Stdout.WriteString(format(line))
 		os.Stdout.Write([]byte{'\n'})
 	}
 	return scanner.Err()
@@ -7,7 +7,4 @@ require (
 	github.com/google/renameio/v2 v2.0.0
 	github.com/klauspost/compress v1.15.9
 	golang.org/x/sync v0.1.0
-	gopkg.in/mcuadros/go-syslog.v2 v2.3.0
 )
-
-require gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
@@ -0,0 +1,237 @@
+// Package bindings implements the per-host table of IP address to hostname
+// bindings which gokr-syslogd learns from the messages of DHCP and DNS servers
+// (dnsmasq, ISC dhcpd) in <host>/bindings.json: for each address, which names
+// held it when.
+package bindings
+
+import (
+	"encoding/json"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the table file within each host directory.
+const FileName = "bindings.json"
+
+// MaxAddresses is the number of addresses a table holds at most. Beyond that,
+// the least recently seen address is dropped.
+const MaxAddresses = 10000
+
+// MaxHistory is the number of bindings recorded per address. Beyond that, the
+// oldest binding is dropped.
+const MaxHistory = 32
+
+// MaxAge is how long after it was last seen a binding is still considered
+// valid, unless the address was bound to another name in the meantime.
+const MaxAge = 7 * 24 * time.Hour
+
+// Parse returns the address and hostname which the message of a DHCP or DNS
+// server binds, or false if it does not bind one. Recognized are:
+//
+//	dnsmasq-dhcp: DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff phone
+//	dnsmasq: DHCP phone.lan is 192.168.1.57 (and /etc/hosts, config)
+//	dhcpd: DHCPACK on 192.168.1.57 to aa:bb:cc:dd:ee:ff (phone) via eth0
+//
+// DNS replies for other names (reply, cached) are not bindings of devices.
+func Parse(tag, content string) (addr, name string, ok bool) {
+	fields := strings.Fields(content)
+	switch {
+	case strings.HasPrefix(tag, "dnsmasq"):
+		for i, f := range fields {
+			if strings.HasPrefix(f, "DHCPACK(") && i+3 < len(fields) {
+				addr, name = fields[i+1], fields[i+3]
+				break
+			}
+		}
+		if addr == "" && len(fields) == 4 && fields[2] == "is" &&
+			(fields[0] == "DHCP" || fields[0] == "config" || strings.HasPrefix(fields[0], "/")) {
+			addr, name = fields[3], fields[1]
+		}
+	case tag == "dhcpd":
+		if len(fields) >= 6 && fields[0] == "DHCPACK" && fields[1] == "on" && fields[3] == "to" &&
+			strings.HasPrefix(fields[5], "(") && strings.HasSuffix(fields[5], ")") {
+			addr, name = fields[2], strings.Trim(fields[5], "()")
+		}
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil || name == "" || name == "*" || strings.ContainsAny(name, "()<>=") {
+		return "", "", false
+	}
+	return ip.Unmap().WithZone("").String(), name, true
+}
+
+// Binding records that an address was bound to Name from From until (at
+// least) Until.
+type Binding struct {
+	Name  string    `json:"name"`
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+// Address is the binding history of one address, oldest first.
+type Address struct {
+	Addr     string     `json:"addr"`
+	Bindings []*Binding `json:"bindings"`
+}
+
+func (a *Address) lastSeen() time.Time {
+	return a.Bindings[len(a.Bindings)-1].Until
+}
+
+// Table is the binding table of one host (or, after Merge, of several). It
+// is not safe for concurrent use.
+type Table struct {
+	addrs map[string]*Address
+	dirty bool
+}
+
+// New returns an empty table.
+func New() *Table {
+	return &Table{addrs: make(map[string]*Address)}
+}
+
+// Read reads the table from r.
+func Read(r io.Reader) (*Table, error) {
+	var addrs []*Address
+	if err := json.NewDecoder(r).Decode(&addrs); err != nil {
+		return nil, err
+	}
+	t := New()
+	for _, a := range addrs {
+		if len(a.Bindings) > 0 {
+			t.addrs[a.Addr] = a
+		}
+	}
+	return t, nil
+}
+
+// ReadFile reads the table from fn. A file which does not exist yields an empty
+// table.
+func ReadFile(fn string) (*Table, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Observe records that addr was bound to name at ts.
+func (t *Table) Observe(addr, name string, ts time.Time) {
+	t.dirty = true
+	a, ok := t.addrs[addr]
+	if !ok {
+		t.evict(MaxAddresses - 1)
+		a = &Address{Addr: addr}
+		t.addrs[addr] = a
+	}
+	t.add(a, &Binding{Name: name, From: ts, Until: ts})
+}
+
+// add inserts b into the history of a, extending the binding it overlaps or
+// follows if that has the same name.
+func (t *Table) add(a *Address, b *Binding) {
+	i := sort.Search(len(a.Bindings), func(i int) bool {
+		return a.Bindings[i].From.After(b.From)
+	})
+	if i > 0 && a.Bindings[i-1].Name == b.Name {
+		prev := a.Bindings[i-1]
+		if b.Until.After(prev.Until) {
+			prev.Until = b.Until
+		}
+		return
+	}
+	if i < len(a.Bindings) && a.Bindings[i].Name == b.Name {
+		a.Bindings[i].From = b.From
+		return
+	}
+	a.Bindings = append(a.Bindings, nil)
+	copy(a.Bindings[i+1:], a.Bindings[i:])
+	a.Bindings[i] = b
+	if len(a.Bindings) > MaxHistory {
+		a.Bindings = a.Bindings[len(a.Bindings)-MaxHistory:]
+	}
+}
+
+// evict drops the least recently seen addresses until at most n remain.
+func (t *Table) evict(n int) {
+	for len(t.addrs) > n {
+		var oldest *Address
+		for _, a := range t.addrs {
+			if oldest == nil || a.lastSeen().Before(oldest.lastSeen()) {
+				oldest = a
+			}
+		}
+		delete(t.addrs, oldest.Addr)
+	}
+}
+
+// Merge adds the bindings of other to t, e.g. to look up names in the tables
+// of all hosts, or when merging the directories of a renamed host.
+func (t *Table) Merge(other *Table) {
+	for addr, o := range other.addrs {
+		t.dirty = true
+		a, ok := t.addrs[addr]
+		if !ok {
+			a = &Address{Addr: addr}
+			t.addrs[addr] = a
+		}
+		for _, b := range o.Bindings {
+			copied := *b
+			t.add(a, &copied)
+		}
+	}
+	t.evict(MaxAddresses)
+}
+
+// NameAt returns the name addr was bound to at ts: that of the most recent
+// binding which started no later than ts, unless it was last seen more than
+// MaxAge before ts.
+func (t *Table) NameAt(addr string, ts time.Time) (string, bool) {
+	a, ok := t.addrs[addr]
+	if !ok {
+		return "", false
+	}
+	i := sort.Search(len(a.Bindings), func(i int) bool {
+		return a.Bindings[i].From.After(ts)
+	})
+	if i == 0 {
+		return "", false
+	}
+	b := a.Bindings[i-1]
+	if ts.Sub(b.Until) > MaxAge {
+		return "", false
+	}
+	return b.Name, true
+}
+
+// Dirty reports whether the table changed since it was read or last written.
+func (t *Table) Dirty() bool { return t.dirty }
+
+// Addresses returns the histories of all addresses, sorted by address.
+func (t *Table) Addresses() []Address {
+	addrs := make([]Address, 0, len(t.addrs))
+	for _, a := range t.addrs {
+		addrs = append(addrs, *a)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Addr < addrs[j].Addr
+	})
+	return addrs
+}
+
+// Write writes the table to w.
+func (t *Table) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(t.Addresses()); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
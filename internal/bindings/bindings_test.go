@@ -0,0 +1,88 @@
+package bindings
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	type binding struct {
+		Addr, Name string
+		OK         bool
+	}
+	for _, tt := range []struct {
+		tag, content string
+		want         binding
+	}{
+		{"dnsmasq-dhcp", "DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff phone", binding{"192.168.1.57", "phone", true}},
+		{"dnsmasq-dhcp", "1827362 DHCPACK(br0) 192.168.1.60 aa:bb:cc:dd:ee:01 printer", binding{"192.168.1.60", "printer", true}},
+		{"dnsmasq-dhcp", "DHCPACK(eth0) 192.168.1.61 aa:bb:cc:dd:ee:02", binding{}},
+		{"dnsmasq", "DHCP nas.lan is 192.168.1.10", binding{"192.168.1.10", "nas.lan", true}},
+		{"dnsmasq", "/etc/hosts router is 192.168.1.1", binding{"192.168.1.1", "router", true}},
+		{"dnsmasq", "reply example.com is 93.184.216.34", binding{}},
+		{"dhcpd", "DHCPACK on 192.168.1.57 to aa:bb:cc:dd:ee:ff (phone) via eth0", binding{"192.168.1.57", "phone", true}},
+		{"dhcpd", "DHCPACK on 192.168.1.58 to aa:bb:cc:dd:ee:03 via eth0", binding{}},
+		{"sshd", "DHCPACK(eth0) 192.168.1.57 aa:bb:cc:dd:ee:ff phone", binding{}},
+	} {
+		var got binding
+		got.Addr, got.Name, got.OK = Parse(tt.tag, tt.content)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("Parse(%q, %q): unexpected diff (-want +got):\n%s", tt.tag, tt.content, diff)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	t1 := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	tt := New()
+	tt.Observe("192.168.1.57", "phone", t1)
+	tt.Observe("192.168.1.57", "phone", t1.Add(time.Hour))
+	tt.Observe("192.168.1.57", "laptop", t1.Add(2*time.Hour))
+
+	var buf bytes.Buffer
+	if err := tt.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if tt.Dirty() {
+		t.Errorf("table dirty after Write")
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another host saw the address earlier, under yet another name.
+	other := New()
+	other.Observe("192.168.1.57", "tablet", t1.Add(-24*time.Hour))
+	read.Merge(other)
+
+	for _, c := range []struct {
+		at   time.Time
+		want string
+	}{
+		{t1.Add(-25 * time.Hour), ""},
+		{t1.Add(-time.Hour), "tablet"},
+		{t1, "phone"},
+		{t1.Add(90 * time.Minute), "phone"},
+		{t1.Add(3 * time.Hour), "laptop"},
+		{t1.Add(2*time.Hour + MaxAge + time.Second), ""},
+	} {
+		got, _ := read.NameAt("192.168.1.57", c.at)
+		if got != c.want {
+			t.Errorf("NameAt(%v) = %q, want %q", c.at, got, c.want)
+		}
+	}
+	if _, ok := read.NameAt("192.168.1.58", t1); ok {
+		t.Errorf("NameAt(192.168.1.58) unexpectedly found")
+	}
+	want := []*Binding{
+		{Name: "tablet", From: t1.Add(-24 * time.Hour), Until: t1.Add(-24 * time.Hour)},
+		{Name: "phone", From: t1, Until: t1.Add(time.Hour)},
+		{Name: "laptop", From: t1.Add(2 * time.Hour), Until: t1.Add(2 * time.Hour)},
+	}
+	if diff := cmp.Diff(want, read.Addresses()[0].Bindings); diff != "" {
+		t.Errorf("Bindings: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,67 @@
+// Package bootlog implements the per-host list of boot sessions which
+// gokr-syslogd maintains in <host>/boots when detecting sender reboots: one
+// line per boot, with the time the boot was detected and its ID.
+//
+//	2022-08-13T16:20:00Z 3f2a9c1d0b7e4a68
+package bootlog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the boot list within each host directory.
+const FileName = "boots"
+
+// Boot is one boot session of a host.
+type Boot struct {
+	Start time.Time
+	ID    string
+}
+
+// String returns b as a line of the boot list (without newline).
+func (b Boot) String() string {
+	return b.Start.Format(time.RFC3339) + " " + b.ID
+}
+
+// Parse parses a line of the boot list.
+func Parse(line string) (Boot, error) {
+	start, id, ok := strings.Cut(line, " ")
+	if !ok || id == "" {
+		return Boot{}, fmt.Errorf("invalid boot line %q: expected <rfc3339> <id>", line)
+	}
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return Boot{}, fmt.Errorf("invalid boot line %q: %v", line, err)
+	}
+	return Boot{Start: t, ID: id}, nil
+}
+
+// ReadFile reads the boot list fn, oldest boot first. A file which does not
+// exist yields no boots.
+func ReadFile(fn string) ([]Boot, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var boots []Boot
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		b, err := Parse(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		boots = append(boots, b)
+	}
+	return boots, scanner.Err()
+}
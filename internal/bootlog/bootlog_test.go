@@ -0,0 +1,45 @@
+package bootlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), FileName)
+	boots, err := ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boots) != 0 {
+		t.Errorf("ReadFile(missing) = %v, want no boots", boots)
+	}
+
+	want := []Boot{
+		{Start: time.Date(2022, time.August, 12, 9, 0, 0, 0, time.UTC), ID: "3f2a9c1d0b7e4a68"},
+		{Start: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC), ID: "c0ffee0123456789"},
+	}
+	if err := os.WriteFile(fn, []byte(want[0].String()+"\n"+want[1].String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	boots, err = ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, boots); diff != "" {
+		t.Errorf("ReadFile: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, line := range []string{
+		"2022-08-13T16:20:00Z",
+		"yesterday c0ffee0123456789",
+	} {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q) unexpectedly succeeded", line)
+		}
+	}
+}
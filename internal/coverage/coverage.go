@@ -0,0 +1,253 @@
+// Package coverage reports which days of a window have log files per host in
+// the log tree which gokr-syslogd writes, which are missing and which are
+// unusually small, e.g. to discover a sender which stopped sending weeks ago.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/logtree"
+	"github.com/gokrazy/syslogd/internal/retired"
+)
+
+const (
+	// DefaultDays is the default window of Report.
+	DefaultDays = 30
+
+	// SmallFraction is the fraction of the median size below which a day is
+	// reported as small.
+	SmallFraction = 0.2
+
+	// minComparable is the number of days stored the same way (compressed
+	// or not) needed to report small days.
+	minComparable = 3
+)
+
+const dayLayout = "2006-01-02"
+
+// Day is a day of the window for which a host has log files.
+type Day struct {
+	Day        string // e.g. 2022-08-13
+	Bytes      int64  // size of the files, as stored
+	Compressed bool   // at least one of the files is compressed
+}
+
+// Host is the coverage of the window for a host.
+type Host struct {
+	Host string
+	// First is the oldest day for which the host has log files, which can be
+	// before the window. Days before First are not missing: the host did not
+	// send yet (or its files expired). Empty if the host has no log files
+	// left, in which case all days are missing.
+	First   string
+	Days    []Day    // oldest first
+	Missing []string // days since First without files, oldest first
+	Small   []string // days with less than SmallFraction of the median size
+}
+
+// Report returns the coverage of the days days up to (and including) the day
+// of now for each host in dir, hosts with the most missing and small days
+// first. Directories of renamed hosts (see aliases) are merged, retired hosts
+// are skipped.
+func Report(dir string, aliases hostalias.Map, now time.Time, days int) ([]Host, error) {
+	hostDirs, err := logtree.ListHosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	retiredHosts, err := retired.Hosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	window := windowDays(now, days)
+	byHost := make(map[string]*Host)
+	sizes := make(map[string]map[string]*Day)
+	for _, hostDir := range hostDirs {
+		if _, ok := retiredHosts[hostDir]; ok {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, hostDir))
+		if err != nil {
+			return nil, err
+		}
+		host := aliases.Resolve(hostDir)
+		h, ok := byHost[host]
+		if !ok {
+			h = &Host{Host: host}
+			byHost[host] = h
+			sizes[host] = make(map[string]*Day)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !logtree.IsLogFile(name) || !entry.Type().IsRegular() || len(name) < len(dayLayout) {
+				continue
+			}
+			day := name[:len(dayLayout)]
+			if _, err := time.Parse(dayLayout, day); err != nil {
+				continue
+			}
+			if h.First == "" || day < h.First {
+				h.First = day
+			}
+			if day < window[0] || day > window[len(window)-1] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // deleted in the meantime
+			}
+			d, ok := sizes[host][day]
+			if !ok {
+				d = &Day{Day: day}
+				sizes[host][day] = d
+			}
+			d.Bytes += info.Size()
+			d.Compressed = d.Compressed || strings.HasSuffix(name, ".zst")
+		}
+	}
+	hosts := make([]Host, 0, len(byHost))
+	for host, h := range byHost {
+		for _, day := range window {
+			if d, ok := sizes[host][day]; ok {
+				h.Days = append(h.Days, *d)
+			} else if day >= h.First {
+				h.Missing = append(h.Missing, day)
+			}
+		}
+		h.Small = smallDays(h.Days, window[len(window)-1])
+		hosts = append(hosts, *h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		pi := len(hosts[i].Missing) + len(hosts[i].Small)
+		pj := len(hosts[j].Missing) + len(hosts[j].Small)
+		if pi != pj {
+			return pi > pj
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts, nil
+}
+
+// windowDays returns the days days up to the day of now, oldest first.
+func windowDays(now time.Time, days int) []string {
+	if days < 1 {
+		days = 1
+	}
+	window := make([]string, days)
+	for i := range window {
+		window[i] = now.AddDate(0, 0, i-days+1).Format(dayLayout)
+	}
+	return window
+}
+
+// smallDays returns the days whose size is below SmallFraction of the median
+// size of the days stored the same way. today is not complete yet, so it is
+// neither reported nor part of the median.
+func smallDays(days []Day, today string) []string {
+	median := func(compressed bool) (int64, bool) {
+		var sizes []int64
+		for _, d := range days {
+			if d.Compressed == compressed && d.Day != today {
+				sizes = append(sizes, d.Bytes)
+			}
+		}
+		if len(sizes) < minComparable {
+			return 0, false
+		}
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		return sizes[len(sizes)/2], true
+	}
+	var small []string
+	for _, d := range days {
+		if d.Day == today {
+			continue
+		}
+		m, ok := median(d.Compressed)
+		if ok && float64(d.Bytes) < SmallFraction*float64(m) {
+			small = append(small, d.Day)
+		}
+	}
+	return small
+}
+
+// ranges returns days (oldest first) with consecutive days joined, e.g.
+// 2022-08-01..2022-08-03, 2022-08-05.
+func ranges(days []string) string {
+	var (
+		parts []string
+		start string
+		prev  time.Time
+	)
+	flush := func() {
+		if end := prev.Format(dayLayout); end != start {
+			parts = append(parts, start+".."+end)
+		} else {
+			parts = append(parts, start)
+		}
+	}
+	for _, day := range days {
+		t, _ := time.Parse(dayLayout, day)
+		if start != "" && !t.Equal(prev.AddDate(0, 0, 1)) {
+			flush()
+			start = ""
+		}
+		if start == "" {
+			start = day
+		}
+		prev = t
+	}
+	if start != "" {
+		flush()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Write writes hosts as a plain text report of the window of days days up to
+// now: per host, one character per day (# for files, . for missing, s for
+// small, blank before the host’s first day), followed by the missing and small
+// days.
+func Write(w io.Writer, hosts []Host, now time.Time, days int) error {
+	window := windowDays(now, days)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# coverage of %s..%s: # files, . missing, s small (below %.0f%% of the median)\n",
+		window[0], window[len(window)-1], 100*SmallFraction)
+	for _, h := range hosts {
+		stored := make(map[string]bool, len(h.Days))
+		for _, d := range h.Days {
+			stored[d.Day] = true
+		}
+		small := make(map[string]bool, len(h.Small))
+		for _, day := range h.Small {
+			small[day] = true
+		}
+		var b strings.Builder
+		for _, day := range window {
+			switch {
+			case small[day]:
+				b.WriteByte('s')
+			case stored[day]:
+				b.WriteByte('#')
+			case day >= h.First:
+				b.WriteByte('.')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		fmt.Fprintf(bw, "%-30s %s", h.Host, b.String())
+		if len(h.Missing) > 0 {
+			fmt.Fprintf(bw, "  %d missing: %s", len(h.Missing), ranges(h.Missing))
+		}
+		if len(h.Small) > 0 {
+			fmt.Fprintf(bw, "  %d small: %s", len(h.Small), ranges(h.Small))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
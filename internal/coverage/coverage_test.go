@@ -0,0 +1,68 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	files := map[string]int{
+		// dr sends every day, but little on 2022-08-10.
+		"dr/2022-08-06.log.zst": 1000,
+		"dr/2022-08-07.log.zst": 1100,
+		"dr/2022-08-08.log.zst": 900,
+		"dr/2022-08-09.log.zst": 1000,
+		"dr/2022-08-10.log.zst": 50,
+		"dr/2022-08-11.log.zst": 1000,
+		"dr/2022-08-12.log":     9000,
+		"dr/2022-08-13.log":     10,
+		// apu stopped sending on 2022-08-09.
+		"apu/2022-07-01.log.zst": 1000,
+		"apu/2022-08-08.log.zst": 1000,
+		// router7 was renamed on 2022-08-11, and is new.
+		"router7-old/2022-08-10.log.zst": 1000,
+		"router7/2022-08-11.log.zst":     1000,
+		"router7/2022-08-12.log":         1000,
+		"router7/2022-08-13.log":         1000,
+		// gone has no files left.
+		"gone/notes.txt": 1,
+	}
+	for fn, size := range files {
+		fn = filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aliases, err := hostalias.Parse("router7-old=router7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := Report(dir, aliases, now, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := Write(&b, hosts, now, 7); err != nil {
+		t.Fatal(err)
+	}
+	want := `# coverage of 2022-08-07..2022-08-13: # files, . missing, s small (below 20% of the median)
+gone                           .......  7 missing: 2022-08-07..2022-08-13
+apu                            .#.....  6 missing: 2022-08-07, 2022-08-09..2022-08-13
+dr                             ###s###  1 small: 2022-08-10
+router7                           ####
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("Write: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,298 @@
+// Package entityindex implements the per-host index of the network entities
+// (IP and MAC addresses) mentioned in messages, which gokr-syslogd maintains
+// in <host>/entities.json: for each entity, the log files and the offsets of
+// the lines mentioning it.
+//
+// Offsets are hints: log files can be rewritten (e.g. by -severity_retention),
+// so readers need to verify that the line at an offset mentions the entity,
+// and scan the whole file otherwise.
+package entityindex
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the index file within each host directory.
+const FileName = "entities.json"
+
+// MaxEntities is the number of entities an index holds at most. Beyond that,
+// the least recently seen entity is dropped.
+const MaxEntities = 10000
+
+// MaxOffsets is the number of offsets recorded per entity and file. Beyond
+// that, only the count grows, and readers scan the whole file.
+const MaxOffsets = 100
+
+// Normalize returns the canonical form of the IP address (without zone) or
+// MAC address (lower-case, colon-separated) s, or false if s is neither.
+func Normalize(s string) (string, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap().WithZone("").String(), true
+	}
+	if len(s) == len("00:00:00:00:00:00") {
+		if mac, err := net.ParseMAC(s); err == nil {
+			return mac.String(), true
+		}
+	}
+	return "", false
+}
+
+// isSeparator reports whether r separates the tokens which Extract considers.
+func isSeparator(r rune) bool {
+	return r == ' ' || r == '\t' || strings.ContainsRune(`,;()[]<>"'=|`, r)
+}
+
+// Mention is an entity mentioned at content[Start:End]. For addresses with a
+// port, the port is part of the range.
+type Mention struct {
+	Entity     string
+	Start, End int
+}
+
+// Mentions returns the entities mentioned in content, in order. Addresses
+// followed by a port (192.168.1.57:53 or [fe80::1]:53) and a trailing period or
+// colon are recognized, too.
+func Mentions(content string) []Mention {
+	var mentions []Mention
+	for start := 0; start < len(content); {
+		if isSeparator(rune(content[start])) {
+			start++
+			continue
+		}
+		end := strings.IndexFunc(content[start:], isSeparator)
+		if end == -1 {
+			end = len(content)
+		} else {
+			end += start
+		}
+		token := strings.TrimRight(content[start:end], ".:")
+		if len(token) >= len("::1") && strings.ContainsAny(token, ".:-") {
+			if e, ok := Normalize(token); ok {
+				mentions = append(mentions, Mention{e, start, start + len(token)})
+			} else if ap, err := netip.ParseAddrPort(token); err == nil {
+				mentions = append(mentions, Mention{ap.Addr().Unmap().WithZone("").String(), start, start + len(token)})
+			}
+		}
+		start = end
+	}
+	return mentions
+}
+
+// Extract returns the entities mentioned in content (see Mentions), in order
+// of their first mention.
+func Extract(content string) []string {
+	var entities []string
+	seen := make(map[string]bool)
+	for _, m := range Mentions(content) {
+		if !seen[m.Entity] {
+			seen[m.Entity] = true
+			entities = append(entities, m.Entity)
+		}
+	}
+	return entities
+}
+
+// Ref records where an entity is mentioned in one log file.
+type Ref struct {
+	File    string  `json:"file"` // name within the host directory
+	Offsets []int64 `json:"offsets"`
+	Count   uint64  `json:"count"`
+}
+
+// Complete reports whether Offsets lists all lines of the file which mention
+// the entity (as of when they were written).
+func (r Ref) Complete() bool { return uint64(len(r.Offsets)) == r.Count }
+
+// Entry describes one entity.
+type Entry struct {
+	Entity    string    `json:"entity"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Files     []*Ref    `json:"files"` // in order of first mention
+}
+
+// Index is the entity index of one host. It is not safe for concurrent use.
+type Index struct {
+	entries map[string]*Entry
+	dirty   bool
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{entries: make(map[string]*Entry)}
+}
+
+// Read reads the index from r.
+func Read(r io.Reader) (*Index, error) {
+	var entries []*Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	idx := New()
+	for _, e := range entries {
+		idx.entries[e.Entity] = e
+	}
+	return idx, nil
+}
+
+// ReadFile reads the index from fn. A file which does not exist yields an empty
+// index.
+func ReadFile(fn string) (*Index, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Observe records that the line at offset of file, received at t, mentions
+// entity.
+func (idx *Index) Observe(entity, file string, offset int64, t time.Time) {
+	idx.dirty = true
+	e, ok := idx.entries[entity]
+	if !ok {
+		if len(idx.entries) >= MaxEntities {
+			var oldest *Entry
+			for _, e := range idx.entries {
+				if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+					oldest = e
+				}
+			}
+			delete(idx.entries, oldest.Entity)
+		}
+		e = &Entry{Entity: entity, FirstSeen: t}
+		idx.entries[entity] = e
+	}
+	if t.After(e.LastSeen) {
+		e.LastSeen = t
+	}
+	var ref *Ref
+	if n := len(e.Files); n > 0 && e.Files[n-1].File == file {
+		ref = e.Files[n-1]
+	} else {
+		for _, r := range e.Files {
+			if r.File == file {
+				ref = r
+			}
+		}
+	}
+	if ref == nil {
+		ref = &Ref{File: file}
+		e.Files = append(e.Files, ref)
+	}
+	ref.Count++
+	if len(ref.Offsets) < MaxOffsets {
+		ref.Offsets = append(ref.Offsets, offset)
+	}
+}
+
+// Prune removes the references to files for which exists returns false (e.g.
+// deleted by retention), and entities without references.
+func (idx *Index) Prune(exists func(file string) bool) {
+	known := make(map[string]bool)
+	for entity, e := range idx.entries {
+		files := e.Files[:0]
+		for _, r := range e.Files {
+			ok, checked := known[r.File]
+			if !checked {
+				ok = exists(r.File)
+				known[r.File] = ok
+			}
+			if ok {
+				files = append(files, r)
+			}
+		}
+		if len(files) != len(e.Files) {
+			idx.dirty = true
+		}
+		e.Files = files
+		if len(files) == 0 {
+			delete(idx.entries, entity)
+		}
+	}
+}
+
+// Merge adds the entries of other to idx, e.g. when merging the directories
+// of a renamed host. Merging log files moves their lines, so the references of
+// other keep their counts but no offsets (readers scan these files). Beyond
+// MaxEntities, the least recently seen entities are dropped.
+func (idx *Index) Merge(other *Index) {
+	for entity, o := range other.entries {
+		idx.dirty = true
+		e, ok := idx.entries[entity]
+		if !ok {
+			e = &Entry{Entity: entity, FirstSeen: o.FirstSeen}
+			idx.entries[entity] = e
+		}
+		if o.FirstSeen.Before(e.FirstSeen) {
+			e.FirstSeen = o.FirstSeen
+		}
+		if o.LastSeen.After(e.LastSeen) {
+			e.LastSeen = o.LastSeen
+		}
+	Refs:
+		for _, or := range o.Files {
+			for _, r := range e.Files {
+				if r.File == or.File {
+					r.Count += or.Count
+					r.Offsets = nil
+					continue Refs
+				}
+			}
+			e.Files = append(e.Files, &Ref{File: or.File, Count: or.Count})
+		}
+	}
+	for len(idx.entries) > MaxEntities {
+		var oldest *Entry
+		for _, e := range idx.entries {
+			if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+				oldest = e
+			}
+		}
+		delete(idx.entries, oldest.Entity)
+	}
+}
+
+// Lookup returns the entry of entity, if any.
+func (idx *Index) Lookup(entity string) (Entry, bool) {
+	e, ok := idx.entries[entity]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Dirty reports whether the index changed since it was read or last written.
+func (idx *Index) Dirty() bool { return idx.dirty }
+
+// Entries returns all entries, sorted by entity.
+func (idx *Index) Entries() []Entry {
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Entity < entries[j].Entity
+	})
+	return entries
+}
+
+// Write writes the index to w.
+func (idx *Index) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(idx.Entries()); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
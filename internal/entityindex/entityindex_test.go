@@ -0,0 +1,73 @@
+package entityindex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtract(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		want    []string
+	}{
+		{"DHCPACK on 192.168.1.57 to AA:BB:CC:DD:EE:FF (phone) via eth0", []string{"192.168.1.57", "aa:bb:cc:dd:ee:ff"}},
+		{"query[A] example.com from 192.168.1.57:5353.", []string{"192.168.1.57"}},
+		{"SRC=10.0.0.16 DST=10.0.0.1 SRC=10.0.0.16", []string{"10.0.0.16", "10.0.0.1"}},
+		{"neighbor [fe80::1%eth0]:546 and 2001:db8::1,", []string{"fe80::1", "2001:db8::1"}},
+		{"lease aa-bb-cc-dd-ee-ff at 16:00:03, version 1.2", []string{"aa:bb:cc:dd:ee:ff"}},
+		{"::ffff:10.0.0.1 mapped", []string{"10.0.0.1"}},
+		{"no addresses here: 3.5 s", nil},
+	} {
+		if diff := cmp.Diff(tt.want, Extract(tt.content)); diff != "" {
+			t.Errorf("Extract(%q): unexpected diff (-want +got):\n%s", tt.content, diff)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	t1 := time.Date(2022, 8, 13, 16, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	idx := New()
+	idx.Observe("192.168.1.57", "2022-08-12.log", 0, t1)
+	idx.Observe("192.168.1.57", "2022-08-13.log", 100, t2)
+	idx.Observe("192.168.1.57", "2022-08-13.log", 200, t2)
+	for i := 0; i < MaxOffsets+1; i++ {
+		idx.Observe("10.0.0.1", "2022-08-13.log", int64(i), t1)
+	}
+	idx.Prune(func(file string) bool { return file != "2022-08-12.log" })
+
+	var buf bytes.Buffer
+	if err := idx.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Dirty() {
+		t.Errorf("index dirty after Write")
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := read.Lookup("192.168.1.57")
+	if !ok {
+		t.Fatalf("Lookup(192.168.1.57) not found")
+	}
+	want := Entry{
+		Entity:    "192.168.1.57",
+		FirstSeen: t1,
+		LastSeen:  t2,
+		Files:     []*Ref{{File: "2022-08-13.log", Offsets: []int64{100, 200}, Count: 2}},
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Errorf("Lookup: unexpected diff (-want +got):\n%s", diff)
+	}
+	e, _ = read.Lookup("10.0.0.1")
+	if e.Files[0].Complete() {
+		t.Errorf("Ref with %d of %d offsets unexpectedly complete", len(e.Files[0].Offsets), e.Files[0].Count)
+	}
+	if _, ok := read.Lookup("10.0.0.2"); ok {
+		t.Errorf("Lookup(10.0.0.2) unexpectedly found")
+	}
+}
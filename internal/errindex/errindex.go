@@ -0,0 +1,176 @@
+// Package errindex implements the per-host index of distinct error messages
+// which gokr-syslogd maintains in <host>/errors.json: for each error template
+// (message with variable parts like numbers masked), when it was first and
+// last seen, and how often.
+package errindex
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the index file within each host directory.
+const FileName = "errors.json"
+
+// MaxEntries is the number of templates an index holds at most. Beyond that,
+// the least recently seen template is dropped.
+const MaxEntries = 1000
+
+// wildcard replaces the variable tokens of a template.
+const wildcard = "<*>"
+
+// Template returns the template of a message: tokens which contain digits
+// (counters, addresses, durations, …) are replaced with a wildcard.
+func Template(tag, content string) string {
+	tokens := strings.Fields(content)
+	for i, token := range tokens {
+		if strings.ContainsAny(token, "0123456789") {
+			tokens[i] = wildcard
+		}
+	}
+	return tag + ": " + strings.Join(tokens, " ")
+}
+
+// Entry describes one template.
+type Entry struct {
+	Template  string    `json:"template"`
+	Example   string    `json:"example"` // the first message of this template
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     uint64    `json:"count"`
+}
+
+// Index is the error index of one host. It is not safe for concurrent use.
+type Index struct {
+	entries map[string]*Entry
+	dirty   bool
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{entries: make(map[string]*Entry)}
+}
+
+// Read reads the index from r.
+func Read(r io.Reader) (*Index, error) {
+	var entries []*Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	idx := New()
+	for _, e := range entries {
+		idx.entries[e.Template] = e
+	}
+	return idx, nil
+}
+
+// ReadFile reads the index from fn. A file which does not exist yields an empty
+// index.
+func ReadFile(fn string) (*Index, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Observe records an error message received at t, and reports whether its
+// template was not seen before.
+func (idx *Index) Observe(tag, content string, t time.Time) bool {
+	idx.dirty = true
+	tmpl := Template(tag, content)
+	if e, ok := idx.entries[tmpl]; ok {
+		e.Count++
+		if t.After(e.LastSeen) {
+			e.LastSeen = t
+		}
+		return false
+	}
+	if len(idx.entries) >= MaxEntries {
+		var oldest *Entry
+		for _, e := range idx.entries {
+			if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+				oldest = e
+			}
+		}
+		delete(idx.entries, oldest.Template)
+	}
+	idx.entries[tmpl] = &Entry{
+		Template:  tmpl,
+		Example:   tag + ": " + content,
+		FirstSeen: t,
+		LastSeen:  t,
+		Count:     1,
+	}
+	return true
+}
+
+// Merge adds the entries of other to idx, e.g. when merging the directories
+// of a renamed host. Beyond MaxEntries, the least recently seen templates are
+// dropped.
+func (idx *Index) Merge(other *Index) {
+	for tmpl, o := range other.entries {
+		idx.dirty = true
+		e, ok := idx.entries[tmpl]
+		if !ok {
+			c := *o
+			idx.entries[tmpl] = &c
+			continue
+		}
+		e.Count += o.Count
+		if o.FirstSeen.Before(e.FirstSeen) {
+			e.FirstSeen = o.FirstSeen
+			e.Example = o.Example
+		}
+		if o.LastSeen.After(e.LastSeen) {
+			e.LastSeen = o.LastSeen
+		}
+	}
+	for len(idx.entries) > MaxEntries {
+		var oldest *Entry
+		for _, e := range idx.entries {
+			if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+				oldest = e
+			}
+		}
+		delete(idx.entries, oldest.Template)
+	}
+}
+
+// Dirty reports whether the index changed since it was read or last written.
+func (idx *Index) Dirty() bool { return idx.dirty }
+
+// Entries returns all entries, most recently first seen first.
+func (idx *Index) Entries() []Entry {
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.After(b.FirstSeen)
+		}
+		return a.Template < b.Template
+	})
+	return entries
+}
+
+// Write writes the index to w.
+func (idx *Index) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(idx.Entries()); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
@@ -0,0 +1,103 @@
+package errindex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIndex(t *testing.T) {
+	t1 := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	t2 := t1.Add(1 * time.Hour)
+
+	idx := New()
+	if !idx.Observe("kernel", "sda: I/O error, sector 1234", t1) {
+		t.Errorf("first error unexpectedly not new")
+	}
+	if idx.Observe("kernel", "sda: I/O error, sector 5678", t2) {
+		t.Errorf("error of the same template unexpectedly new")
+	}
+	if !idx.Observe("dhcpd", "no free leases", t2) {
+		t.Errorf("second error unexpectedly not new")
+	}
+
+	var buf bytes.Buffer
+	if err := idx.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Dirty() {
+		t.Errorf("index unexpectedly dirty after Write")
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{
+			Template:  "dhcpd: no free leases",
+			Example:   "dhcpd: no free leases",
+			FirstSeen: t2,
+			LastSeen:  t2,
+			Count:     1,
+		},
+		{
+			Template:  "kernel: sda: I/O error, sector <*>",
+			Example:   "kernel: sda: I/O error, sector 1234",
+			FirstSeen: t1,
+			LastSeen:  t2,
+			Count:     2,
+		},
+	}
+	if diff := cmp.Diff(want, read.Entries()); diff != "" {
+		t.Errorf("unexpected entries: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	start := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	idx := New()
+	for i := 0; i <= MaxEntries; i++ {
+		idx.Observe("app", "error "+string(rune('a'+i%26))+string(rune('a'+i/26)), start.Add(time.Duration(i)*time.Second))
+	}
+	entries := idx.Entries()
+	if got, want := len(entries), MaxEntries; got != want {
+		t.Fatalf("len(Entries()) = %d, want %d", got, want)
+	}
+	if got, want := entries[len(entries)-1].Template, "app: error ba"; got != want {
+		t.Errorf("oldest remaining template = %q, want %q", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t1 := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	t2 := t1.Add(1 * time.Hour)
+
+	idx := New()
+	idx.Observe("kernel", "sda: I/O error, sector 5678", t2)
+	old := New()
+	old.Observe("kernel", "sda: I/O error, sector 1234", t1)
+	old.Observe("dhcpd", "no free leases", t1)
+	idx.Merge(old)
+
+	want := []Entry{
+		{
+			Template:  "dhcpd: no free leases",
+			Example:   "dhcpd: no free leases",
+			FirstSeen: t1,
+			LastSeen:  t1,
+			Count:     1,
+		},
+		{
+			Template:  "kernel: sda: I/O error, sector <*>",
+			Example:   "kernel: sda: I/O error, sector 1234",
+			FirstSeen: t1,
+			LastSeen:  t2,
+			Count:     2,
+		},
+	}
+	if diff := cmp.Diff(want, idx.Entries()); diff != "" {
+		t.Errorf("Merge: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,78 @@
+// Package hostalias maps the old names of renamed hosts to their current
+// name, so that a host’s history does not split across two directories. The
+// mapping is configured with the -host_aliases flag of gokr-syslogd (which
+// files messages under the current name) and gokr-syslogweb (which shows the
+// directories of old names as part of the current host), e.g.:
+//
+//	-host_aliases=raspberrypi=dr,router=router7
+package hostalias
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Map maps old host names to current host names. The nil Map has no aliases.
+type Map map[string]string
+
+// Parse parses a comma-separated list of old=new pairs.
+func Parse(spec string) (Map, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	m := make(Map)
+	for _, pair := range strings.Split(spec, ",") {
+		old, cur, ok := strings.Cut(pair, "=")
+		if !ok || old == "" || cur == "" || old == cur {
+			return nil, fmt.Errorf("invalid old=new host alias %q", pair)
+		}
+		if prev, ok := m[old]; ok && prev != cur {
+			return nil, fmt.Errorf("host alias %q: %q is already an alias of %q", pair, old, prev)
+		}
+		m[old] = cur
+	}
+	for old, cur := range m {
+		if next, ok := m[cur]; ok {
+			return nil, fmt.Errorf("host alias %s=%s: %q is itself an alias of %q (use %s=%s)", old, cur, cur, next, old, next)
+		}
+	}
+	return m, nil
+}
+
+// Resolve returns the current name of host.
+func (m Map) Resolve(host string) string {
+	if cur, ok := m[host]; ok {
+		return cur
+	}
+	return host
+}
+
+// Names returns all names of the host currently named host: host itself,
+// followed by its old names in alphabetical order.
+func (m Map) Names(host string) []string {
+	var old []string
+	for o, cur := range m {
+		if cur == host {
+			old = append(old, o)
+		}
+	}
+	sort.Strings(old)
+	return append([]string{host}, old...)
+}
+
+// Current returns the current names of hosts (e.g. the host directories of
+// the log tree), sorted and without duplicates.
+func (m Map) Current(hosts []string) []string {
+	seen := make(map[string]bool)
+	var current []string
+	for _, host := range hosts {
+		cur := m.Resolve(host)
+		if !seen[cur] {
+			seen[cur] = true
+			current = append(current, cur)
+		}
+	}
+	sort.Strings(current)
+	return current
+}
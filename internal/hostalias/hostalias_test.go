@@ -0,0 +1,45 @@
+package hostalias
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse("raspberrypi=dr,pi4=dr,router=router7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Resolve("raspberrypi"), "dr"; got != want {
+		t.Errorf("Resolve(raspberrypi) = %q, want %q", got, want)
+	}
+	if got, want := m.Resolve("scan2drive"), "scan2drive"; got != want {
+		t.Errorf("Resolve(scan2drive) = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]string{"dr", "pi4", "raspberrypi"}, m.Names("dr")); diff != "" {
+		t.Errorf("Names(dr): unexpected diff (-want +got):\n%s", diff)
+	}
+	hosts := []string{"dr", "raspberrypi", "router", "scan2drive"}
+	if diff := cmp.Diff([]string{"dr", "router7", "scan2drive"}, m.Current(hosts)); diff != "" {
+		t.Errorf("Current: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, spec := range []string{
+		"dr",                 // no new name
+		"dr=dr",              // alias of itself
+		"pi=dr,pi=router7",   // two current names
+		"pi=dr,dr=router7",   // chain
+		"a=b,b=a",            // cycle
+		"raspberrypi=,pi=dr", // empty new name
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) unexpectedly succeeded", spec)
+		}
+	}
+
+	var none Map
+	if got, want := none.Resolve("dr"), "dr"; got != want {
+		t.Errorf("nil Map: Resolve(dr) = %q, want %q", got, want)
+	}
+}
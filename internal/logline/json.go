@@ -0,0 +1,81 @@
+package logline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSON returns line as a JSON object, e.g. for NDJSON output:
+//
+//	{"rfc3339":"2022-08-13T14:41:30+02:00","seq":"17","tag":"iptables","message":"Try `iptables -h'"}
+//
+// Fields keep their order and are all strings, so that FromJSON restores line
+// exactly, unless fields are named tag or message (which gokr-syslogd does not
+// write). Lines which are not stored lines only have a message.
+func JSON(line string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	add := func(key, value string) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	fields, rest := Split(line)
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		add(key, value)
+	}
+	if fields != nil {
+		if tag, content, ok := strings.Cut(rest, ": "); ok {
+			add("tag", tag)
+			rest = content
+		}
+	}
+	add("message", rest)
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// FromJSON is the inverse of JSON.
+func FromJSON(b []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", fmt.Errorf("not a JSON object")
+	}
+	var (
+		line         strings.Builder
+		tag, message string
+		hasTag       bool
+	)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		key := tok.(string) // object keys are always strings
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+		switch key {
+		case "tag":
+			tag, hasTag = value, true
+		case "message":
+			message = value
+		default:
+			fmt.Fprintf(&line, "%s=%s ", key, value)
+		}
+	}
+	if hasTag {
+		fmt.Fprintf(&line, "%s: ", tag)
+	}
+	line.WriteString(message)
+	return line.String(), nil
+}
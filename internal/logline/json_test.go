@@ -0,0 +1,92 @@
+package logline
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestJSON(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want string
+	}{
+		{
+			line: "rfc3339=2022-08-13T14:41:30+02:00 seq=17 iptables: Try `iptables -h'",
+			want: `{"rfc3339":"2022-08-13T14:41:30+02:00","seq":"17","tag":"iptables","message":"Try ` + "`iptables -h'" + `"}`,
+		},
+		{
+			line: `rfc3339=2022-08-13T14:41:30+02:00 seq=18 zone=lan dhcpd: lease="foo": bar`,
+			want: `{"rfc3339":"2022-08-13T14:41:30+02:00","seq":"18","zone":"lan","tag":"dhcpd","message":"lease=\"foo\": bar"}`,
+		},
+		{
+			line: "rfc3339=2022-08-13T14:41:30+02:00 seq=19 no tag",
+			want: `{"rfc3339":"2022-08-13T14:41:30+02:00","seq":"19","message":"no tag"}`,
+		},
+		{
+			line: "not a stored line: foo",
+			want: `{"message":"not a stored line: foo"}`,
+		},
+	} {
+		got := string(JSON(tt.line))
+		if got != tt.want {
+			t.Errorf("JSON(%q) = %s, want %s", tt.line, got, tt.want)
+		}
+		line, err := FromJSON([]byte(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != tt.line {
+			t.Errorf("FromJSON(%s) = %q, want %q", got, line, tt.line)
+		}
+	}
+	if _, err := FromJSON([]byte(`{"seq":17}`)); err == nil {
+		t.Errorf("FromJSON(non-string value) unexpectedly succeeded")
+	}
+}
+
+func hasReservedField(line string) bool {
+	fields, _ := Split(line)
+	for _, field := range fields {
+		if strings.HasPrefix(field, "tag=") || strings.HasPrefix(field, "message=") {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzJSON(f *testing.F) {
+	for _, line := range corpus {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		b := JSON(line)
+		if !json.Valid(b) {
+			t.Fatalf("JSON(%q) = %s, which is not valid JSON", line, b)
+		}
+		got, err := FromJSON(b)
+		if err != nil {
+			t.Fatalf("FromJSON(%s): %v", b, err)
+		}
+		// JSON strings cannot carry invalid UTF-8, and tag and message
+		// fields collide with the tag and content.
+		if utf8.ValidString(line) && !hasReservedField(line) && got != line {
+			t.Fatalf("FromJSON(%s) = %q, want %q", b, got, line)
+		}
+	})
+}
+
+// FuzzFromJSON feeds arbitrary input to FromJSON, which gokr-syslogweb uses to
+// convert uploaded NDJSON.
+func FuzzFromJSON(f *testing.F) {
+	for _, line := range corpus {
+		f.Add(JSON(line))
+	}
+	f.Add([]byte(`{"seq":17}`))
+	f.Add([]byte(`{"message":"a","message":"b"}`))
+	f.Add([]byte(`[1,2,3]`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		FromJSON(b)
+	})
+}
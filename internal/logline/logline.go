@@ -0,0 +1,58 @@
+// Package logline implements the format of the lines which gokr-syslogd writes
+// into its log files:
+//
+//	rfc3339=2022-08-13T14:41:30.123+02:00 seq=17 iptables: Try `iptables -h'
+//
+// Each line starts with space-separated key=value fields (the rfc3339= field
+// always comes first), followed by the tag, a colon and the message content.
+package logline
+
+import "strings"
+
+// isField reports whether token is a key=value field with a lower-case key.
+func isField(token string) bool {
+	idx := strings.IndexByte(token, '=')
+	if idx < 1 {
+		return false
+	}
+	for _, r := range token[:idx] {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Split splits line into its key=value fields and the remainder (tag and
+// content). Lines which do not start with an rfc3339= field are returned
+// unmodified as rest.
+func Split(line string) (fields []string, rest string) {
+	if !strings.HasPrefix(line, "rfc3339=") {
+		return nil, line
+	}
+	for {
+		idx := strings.IndexByte(line, ' ')
+		if idx == -1 || !isField(line[:idx]) {
+			return fields, line
+		}
+		fields = append(fields, line[:idx])
+		line = line[idx+1:]
+	}
+}
+
+// Strip returns line without its key=value fields.
+func Strip(line string) string {
+	_, rest := Split(line)
+	return rest
+}
+
+// Field returns the value of the key=value field in line, if present.
+func Field(line, key string) (string, bool) {
+	fields, _ := Split(line)
+	for _, field := range fields {
+		if strings.HasPrefix(field, key+"=") {
+			return field[len(key)+1:], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package logline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplit(t *testing.T) {
+	for _, tt := range []struct {
+		line       string
+		wantFields []string
+		wantRest   string
+	}{
+		{
+			line:       "rfc3339=2022-08-13T14:41:30+02:00 iptables: Try `iptables -h'",
+			wantFields: []string{"rfc3339=2022-08-13T14:41:30+02:00"},
+			wantRest:   "iptables: Try `iptables -h'",
+		},
+		{
+			line:       "rfc3339=2022-08-13T14:41:30.5+02:00 seq=17 dhcpd: lease=foo",
+			wantFields: []string{"rfc3339=2022-08-13T14:41:30.5+02:00", "seq=17"},
+			wantRest:   "dhcpd: lease=foo",
+		},
+		{
+			line:     "not a stored line",
+			wantRest: "not a stored line",
+		},
+	} {
+		fields, rest := Split(tt.line)
+		if diff := cmp.Diff(tt.wantFields, fields); diff != "" {
+			t.Errorf("Split(%q): unexpected fields diff (-want +got):\n%s", tt.line, diff)
+		}
+		if rest != tt.wantRest {
+			t.Errorf("Split(%q): rest = %q, want %q", tt.line, rest, tt.wantRest)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	const line = "rfc3339=2022-08-13T14:41:30+02:00 seq=17 dhcpd: seq=18"
+	if got, ok := Field(line, "seq"); !ok || got != "17" {
+		t.Errorf("Field(seq) = %q, %v, want 17, true", got, ok)
+	}
+	if got, ok := Field(line, "event_day"); ok {
+		t.Errorf("Field(event_day) = %q, want not found", got)
+	}
+}
+
+// corpus are real-world stored lines, which seed the fuzz tests.
+var corpus = []string{
+	"rfc3339=2022-08-13T14:41:30+02:00 seq=17 iptables: Try `iptables -h'",
+	"rfc3339=2022-08-13T14:41:30.5+02:00 seq=18 zone=lan dhcpd: DHCPDISCOVER from 00:0d:b9:4a:7e:20 via eth0",
+	"rfc3339=2022-08-13T16:20:00Z seq=1 received=2022-08-13T16:20:03.123Z event_day=2022-08-12 kernel: [    1.234567] Booting Linux",
+	"rfc3339=2022-08-13T16:20:00Z seq=2 image=nginx container=web-1 severity=info nginx/web-1: GET / HTTP/1.1",
+	"rfc3339=2022-08-13T16:20:00Z seq=3 boot=2022-08-13T16:19:58Z service=router7 spoofed_from=10.0.0.16 hmac=ok dhcp4d: lease=foo",
+	"rfc3339=2022-08-13T16:20:00Z seq=4 raw=G1szMW1yZWQbWzBt dhcpd: \\x1b[31mred\\x1b[0m",
+	"rfc3339=2022-08-13T16:20:00Z seq=5 client=10.0.0.16:58045 reason=no_tag rejected: <14>Aug 13 16:20:00 dr : no tag here",
+	"rfc3339=2022-08-13T16:20:00Z seq=6 no tag",
+	"not a stored line: foo",
+	"",
+}
+
+func FuzzSplit(f *testing.F) {
+	for _, line := range corpus {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		fields, rest := Split(line)
+		if fields == nil {
+			if rest != line {
+				t.Fatalf("Split(%q): rest = %q without fields, want the line", line, rest)
+			}
+			return
+		}
+		if got := strings.Join(fields, " ") + " " + rest; got != line {
+			t.Fatalf("Split(%q) = %q, %q, which joins to %q", line, fields, rest, got)
+		}
+		for _, field := range fields {
+			if !isField(field) || strings.Contains(field, " ") {
+				t.Fatalf("Split(%q): invalid field %q", line, field)
+			}
+		}
+		key, value, _ := strings.Cut(fields[0], "=")
+		if got, ok := Field(line, key); !ok || got != value {
+			t.Fatalf("Field(%q, %q) = %q, %v, want %q, true", line, key, got, ok, value)
+		}
+		if got := Strip(line); got != rest {
+			t.Fatalf("Strip(%q) = %q, want %q", line, got, rest)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package logtree
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/singleflight"
+)
+
+// A Cache keeps decompressed copies of compressed log files on disk, so that
+// reading the same day again (scrolling, context expansion, repeated
+// searches) does not decompress the file again. Once the copies exceed the
+// size of the cache, the least recently used ones are evicted. A nil *Cache
+// decompresses files on every read.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	fills    singleflight.Group
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // of *cacheEntry, most recently used first
+	byKey map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+// cacheSuffix is the suffix of the copies within the cache directory.
+const cacheSuffix = ".log.cache"
+
+var errTooLarge = errors.New("decompressed file exceeds the cache size")
+
+// NewCache returns a cache which keeps up to maxBytes of decompressed files
+// in dir. The index of the cache is only kept in memory, so copies left over
+// from a previous process are removed.
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if name := fi.Name(); strings.HasSuffix(name, cacheSuffix) || strings.HasSuffix(name, cacheSuffix+".tmp") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		byKey:    make(map[string]*list.Element),
+	}, nil
+}
+
+// File is an open log file, decompressed if needed.
+type File struct {
+	io.Reader
+	name  string
+	close func() error
+	// file is the seekable file from which Reader reads, for uncompressed
+	// files and cached copies (see seekTime).
+	file *os.File
+}
+
+// Name returns the name of the file which was opened, e.g. fn.zst for a
+// compressed file.
+func (f *File) Name() string { return f.name }
+
+// Close closes the file.
+func (f *File) Close() error { return f.close() }
+
+// ctxReader fails reads once ctx is done, so that cancelled requests (e.g. of
+// a browser which went away) stop decompressing within one block.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Open opens the log file fn like the package-level Open and returns its
+// decompressed contents. Reads fail once ctx is done.
+func (c *Cache) Open(ctx context.Context, fn string) (*File, error) {
+	f, err := Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(f.Name(), ".zst") {
+		return &File{Reader: f, name: f.Name(), close: f.Close, file: f}, nil
+	}
+	if c != nil {
+		if cf, err := c.open(ctx, f); err == nil {
+			f.Close()
+			return cf, nil
+		}
+		// Decompress without the cache, e.g. when the cache directory is
+		// full, the file is larger than the cache or ctx is done.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{
+		Reader: ctxReader{ctx, dec},
+		name:   f.Name(),
+		close: func() error {
+			dec.Close()
+			return f.Close()
+		},
+	}, nil
+}
+
+// open returns the cached copy of the compressed file f, decompressing f into
+// the cache first if needed.
+func (c *Cache) open(ctx context.Context, f *os.File) (*File, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Files are replaced when their lines expire (see gokr-syslogd
+	// -severity_retention), so the key includes size and modification time.
+	key := fmt.Sprintf("%s\x00%d\x00%d", f.Name(), st.Size(), st.ModTime().UnixNano())
+	if cf, ok := c.lookup(ctx, key, f.Name()); ok {
+		return cf, nil
+	}
+	// Concurrent reads of the same file wait for the first one to fill the
+	// cache. If its ctx is done, they fall back to decompressing themselves.
+	if _, err, _ := c.fills.Do(key, func() (any, error) {
+		return nil, c.fill(ctx, key, f)
+	}); err != nil {
+		return nil, err
+	}
+	if cf, ok := c.lookup(ctx, key, f.Name()); ok {
+		return cf, nil
+	}
+	return nil, fmt.Errorf("%s: evicted from the cache", f.Name())
+}
+
+// lookup opens the cached copy identified by key, if any.
+func (c *Cache) lookup(ctx context.Context, key, name string) (*File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.byKey[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	f, err := os.Open(e.path)
+	if err != nil {
+		c.remove(elem) // e.g. deleted underneath us
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return &File{Reader: ctxReader{ctx, f}, name: name, close: f.Close, file: f}, true
+}
+
+// fill decompresses f into the cache under key.
+func (c *Cache) fill(ctx context.Context, key string, f *os.File) error {
+	tmp, err := os.CreateTemp(c.dir, "*"+cacheSuffix+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	defer tmp.Close()
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	n, err := io.Copy(tmp, io.LimitReader(ctxReader{ctx, dec}, c.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if n > c.maxBytes {
+		return errTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:32]+cacheSuffix)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byKey[key]; !ok {
+		c.byKey[key] = c.lru.PushFront(&cacheEntry{key: key, path: path, size: n})
+		c.size += n
+	}
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+// remove evicts elem. c.mu must be held.
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.byKey, e.key)
+	c.size -= e.size
+	os.Remove(e.path) // readers which opened the copy keep reading
+}
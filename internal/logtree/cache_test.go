@@ -0,0 +1,174 @@
+package logtree
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func writeCompressed(t *testing.T, fn, contents string) {
+	t.Helper()
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	day1 := strings.Repeat("rfc3339=2022-08-12T16:20:00Z seq=1 dhcpd: DHCPDISCOVER\n", 10)
+	day2 := strings.Repeat("rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPOFFER\n", 10)
+	writeCompressed(t, filepath.Join(dir, "2022-08-12.log.zst"), day1)
+	writeCompressed(t, filepath.Join(dir, "2022-08-13.log.zst"), day2)
+	writeCompressed(t, filepath.Join(dir, "2022-08-14.log.zst"), day2+day2)
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "stale"+cacheSuffix), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCache(cacheDir, int64(len(day1)+len(day2)/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := func() int {
+		t.Helper()
+		fis, err := os.ReadDir(cacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(fis)
+	}
+	if got, want := cached(), 0; got != want {
+		t.Errorf("after NewCache: %d files in cache, want %d", got, want)
+	}
+	read := func(name, want string) {
+		t.Helper()
+		f, err := c.Open(context.Background(), filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+		if got, want := f.Name(), filepath.Join(dir, name+".zst"); got != want {
+			t.Errorf("Name() = %q, want %q", got, want)
+		}
+	}
+
+	read("2022-08-12.log", day1)
+	read("2022-08-12.log", day1) // cached
+	if got, want := cached(), 1; got != want {
+		t.Errorf("%d files in cache, want %d", got, want)
+	}
+	read("2022-08-13.log", day2) // evicts 2022-08-12
+	if got, want := cached(), 1; got != want {
+		t.Errorf("%d files in cache, want %d", got, want)
+	}
+	read("2022-08-14.log", day2+day2) // larger than the cache
+	if got, want := cached(), 1; got != want {
+		t.Errorf("%d files in cache, want %d", got, want)
+	}
+
+	// Replaced files are decompressed again.
+	filtered := day2[:len(day2)/2]
+	writeCompressed(t, filepath.Join(dir, "2022-08-13.log.zst"), filtered)
+	read("2022-08-13.log", filtered)
+}
+
+func TestCancel(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "dr", "2022-08-13.log")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := "rfc3339=2022-08-13T16:20:00Z seq=1 dhcpd: DHCPDISCOVER from 00:11:22:33:44:55\n"
+	writeCompressed(t, fn+".zst", strings.Repeat(line, 500000))
+
+	c, err := NewCache(t.TempDir(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		cache *Cache
+	}{
+		{name: "uncached", cache: nil},
+		{name: "cached", cache: c},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var (
+				lines     int
+				cancelled time.Time
+			)
+			err := tt.cache.Scan(ctx, fn, func(string) {
+				lines++
+				if lines == 1 {
+					cancel()
+					cancelled = time.Now()
+				}
+			})
+			if err != context.Canceled {
+				t.Errorf("Scan(cancelled) = %v, want %v", err, context.Canceled)
+			}
+			if lines != 1 {
+				t.Errorf("Scan(cancelled) returned %d lines, want 1", lines)
+			}
+			if elapsed := time.Since(cancelled); elapsed > 100*time.Millisecond {
+				t.Errorf("Scan returned %v after cancellation, want < 100ms", elapsed)
+			}
+		})
+	}
+
+	// A cancelled read does not fill the cache.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f, err := c.Open(ctx, filepath.Join(dir, "dr", "2022-08-14.log"))
+	if err == nil {
+		f.Close()
+		t.Fatalf("Open(not existing file) unexpectedly succeeded")
+	}
+	writeCompressed(t, filepath.Join(dir, "dr", "2022-08-14.log.zst"), line)
+	f, err = c.Open(ctx, filepath.Join(dir, "dr", "2022-08-14.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.ReadAll(f); err != context.Canceled {
+		t.Errorf("ReadAll(cancelled) = %v, want %v", err, context.Canceled)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru.Len() != 1 {
+		t.Errorf("cache holds %d files, want 1 (2022-08-13.log)", c.lru.Len())
+	}
+}
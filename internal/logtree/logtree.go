@@ -0,0 +1,260 @@
+// Package logtree reads the log tree which gokr-syslogd writes: one
+// directory per host, with (optionally compressed) log files per day.
+package logtree
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/query"
+	"github.com/gokrazy/syslogd/internal/retired"
+)
+
+// ListHosts returns the host directories in dir. Other entries are skipped,
+// which is important when serving a copy of the tree (e.g. a network mount
+// with a lost+found directory, or a backup).
+func ListHosts(dir string) ([]string, error) {
+	fis, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		hosts = append(hosts, fi.Name())
+	}
+	return hosts, nil
+}
+
+// IsLogFile reports whether fn is an (optionally compressed) log file.
+func IsLogFile(fn string) bool {
+	return strings.HasSuffix(fn, ".log") ||
+		strings.HasSuffix(fn, ".log.zst")
+}
+
+// Open opens the log file fn, falling back to its compressed version
+// (fn.zst) when fn does not exist: gokr-syslogd might have compressed the file
+// in the meantime, or the tree is a copy in which all files were compressed.
+// Files which exist in neither version are reported as os.ErrNotExist.
+func Open(fn string) (*os.File, error) {
+	f, err := os.Open(fn)
+	if err == nil || !os.IsNotExist(err) || !strings.HasSuffix(fn, ".log") {
+		return f, err
+	}
+	return os.Open(fn + ".zst")
+}
+
+// Scan calls fn for each line of the (optionally compressed) log file
+// fn. Files which do not exist are skipped.
+func Scan(ctx context.Context, fn string, line func(string)) error {
+	return (*Cache)(nil).Scan(ctx, fn, line)
+}
+
+// Scan is like the package-level Scan, but reads compressed files through c.
+func (c *Cache) Scan(ctx context.Context, fn string, line func(string)) error {
+	return c.ScanOffsets(ctx, fn, func(_ int64, l string) { line(l) })
+}
+
+// ScanOffsets is like Scan, but also passes the (decompressed) byte offset at
+// which each line starts.
+func (c *Cache) ScanOffsets(ctx context.Context, fn string, line func(offset int64, line string)) error {
+	return c.scanPeriod(ctx, fn, time.Time{}, time.Time{}, line)
+}
+
+// scanPeriod is like ScanOffsets, but may skip lines outside of [start, end)
+// (see seekTime and seekSlack).
+func (c *Cache) scanPeriod(ctx context.Context, fn string, start, end time.Time, line func(offset int64, line string)) error {
+	f, err := c.Open(ctx, fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	pos, err := f.seekTime(start)
+	if err != nil {
+		return err
+	}
+	if err := f.seek(pos); err != nil {
+		return err
+	}
+	var stopAfter time.Time
+	if !end.IsZero() {
+		stopAfter = end.Add(seekSlack)
+	}
+	scanner := bufio.NewScanner(f)
+	var lineStart int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			lineStart = pos
+		}
+		pos += int64(advance)
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !stopAfter.IsZero() {
+			if t, ok := lineTime(scanner.Bytes()); ok && t.After(stopAfter) {
+				break // the remaining lines are after end, too
+			}
+		}
+		line(lineStart, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// DefaultSearchPeriod is searched when a query has no since: term.
+const DefaultSearchPeriod = 24 * time.Hour
+
+// SearchDays returns the first and last day (as in the names of log files,
+// e.g. 2006-01-02) of the files which can contain messages timestamped
+// between start and end. The last day is empty if end is zero.
+//
+// Messages can be filed into the day before their timestamp, or with
+// gokr-syslogd -day_rule=receive into the day after it (e.g. when sent just
+// before midnight), so the days extend one day beyond the period on both ends.
+func SearchDays(start, end time.Time) (firstDay, lastDay string) {
+	firstDay = start.AddDate(0, 0, -1).Format("2006-01-02")
+	if !end.IsZero() {
+		lastDay = end.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+	return firstDay, lastDay
+}
+
+// Search calls match for each line in dir matching q at now, host by host.
+// Hosts are reported (and matched by q) under their current name, so that the
+// directories of old names (see aliases) are searched as part of the host.
+func Search(ctx context.Context, dir string, aliases hostalias.Map, q *query.Query, now time.Time, match func(host, line string) error) error {
+	return (*Cache)(nil).Search(ctx, dir, aliases, q, now, match)
+}
+
+// Search is like the package-level Search, but reads compressed files through
+// c.
+func (c *Cache) Search(ctx context.Context, dir string, aliases hostalias.Map, q *query.Query, now time.Time, match func(host, line string) error) error {
+	return c.SearchLines(ctx, dir, aliases, q, now, func(host string, l Line) error {
+		return match(host, l.Text)
+	})
+}
+
+// Line is a line of a log file, as passed by SearchLines.
+type Line struct {
+	// HostDir is the host directory containing File, which differs from the
+	// host for the directories of old names.
+	HostDir string
+	// File is the name of the log file, without .zst.
+	File string
+	// Offset is the byte offset of the line in the decompressed File.
+	Offset int64
+	Text   string
+}
+
+// PlannedFile is a log file which SearchLines scans.
+type PlannedFile struct {
+	Host    string // current name, see Search
+	HostDir string // directory containing File
+	File    string // name of the log file, without .zst
+}
+
+// Plan is what SearchLines does for a query: which files it scans for lines of
+// which period.
+type Plan struct {
+	// Start and End are the period of the query (see query.Query.Period),
+	// with Start defaulting to DefaultSearchPeriod before now.
+	Start, End time.Time
+	Files      []PlannedFile // host by host, oldest first
+}
+
+// PlanSearch returns the plan of SearchLines for q at now.
+func PlanSearch(dir string, aliases hostalias.Map, q *query.Query, now time.Time) (*Plan, error) {
+	start, end := q.Period(now)
+	if start.IsZero() {
+		start = now.Add(-DefaultSearchPeriod)
+	}
+	plan := &Plan{Start: start, End: end}
+	firstDay, lastDay := SearchDays(start, end)
+	hosts, err := ListHosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	retiredHosts, err := retired.Hosts(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, hostDir := range hosts {
+		host := aliases.Resolve(hostDir)
+		if !q.MatchHost(host) {
+			continue
+		}
+		if _, ok := retiredHosts[hostDir]; ok && !q.IncludeRetired && len(q.Hosts) == 0 {
+			continue
+		}
+		fis, err := os.ReadDir(filepath.Join(dir, hostDir))
+		if err != nil {
+			return nil, err
+		}
+		// Includes the files of gokr-syslogd -route. Scan falls back
+		// to the compressed version, so list each file only once.
+		listed := make(map[string]bool)
+		for _, fi := range fis {
+			name := fi.Name()
+			if !IsLogFile(name) || len(name) < len("2006-01-02") {
+				continue
+			}
+			day := name[:len("2006-01-02")]
+			if day < firstDay || (lastDay != "" && day > lastDay) {
+				continue
+			}
+			fn := strings.TrimSuffix(name, ".zst")
+			if !listed[fn] {
+				listed[fn] = true
+				plan.Files = append(plan.Files, PlannedFile{
+					Host:    host,
+					HostDir: hostDir,
+					File:    fn,
+				})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// SearchLines is like Search, but passes where each line is stored.
+func (c *Cache) SearchLines(ctx context.Context, dir string, aliases hostalias.Map, q *query.Query, now time.Time, match func(host string, l Line) error) error {
+	plan, err := PlanSearch(dir, aliases, q, now)
+	if err != nil {
+		return err
+	}
+	start, end := plan.Start, plan.End
+	for _, pf := range plan.Files {
+		var matchErr error
+		err := c.scanPeriod(ctx, filepath.Join(dir, pf.HostDir, pf.File), start, end, func(offset int64, line string) {
+			if matchErr != nil || !q.Match(line, start, end) {
+				return
+			}
+			matchErr = match(pf.Host, Line{
+				HostDir: pf.HostDir,
+				File:    pf.File,
+				Offset:  offset,
+				Text:    line,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if matchErr != nil {
+			return matchErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package logtree
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/hostalias"
+	"github.com/gokrazy/syslogd/internal/query"
+	"github.com/gokrazy/syslogd/internal/retired"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSearchLogs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, 8, 13, 16, 0, 0, 0, time.Local)
+	rfc3339 := func(d time.Duration) string { return "rfc3339=" + now.Add(-d).Format(time.RFC3339) }
+	logs := map[string][]string{
+		"dr/2022-08-11.log": {
+			rfc3339(50*time.Hour) + " seq=1 dhcpd: DHCPDISCOVER too old",
+		},
+		// Sent just before midnight, filed into the next day with
+		// gokr-syslogd -day_rule=receive.
+		"dr/2022-08-12.log": {
+			rfc3339(40*time.Hour+time.Minute) + " seq=1 dhcpd: DHCPDISCOVER before midnight",
+		},
+		"dr/2022-08-13.log": {
+			rfc3339(3*time.Hour) + " seq=1 severity=info dhcpd: DHCPDISCOVER from 00:11:22",
+			rfc3339(1*time.Hour) + " seq=2 severity=warning dhcpd: DHCPDISCOVER from 00:11:33, no free leases",
+		},
+		"dr/2022-08-13.auth.log": {
+			rfc3339(1*time.Hour) + " seq=1 severity=warning sshd: DHCPDISCOVER in the wrong tag",
+		},
+		// dr before it was renamed, see aliases.
+		"raspberrypi/2022-08-13.log": {
+			rfc3339(2*time.Hour) + " seq=1 severity=info ntpd: synchronized before the rename",
+		},
+		"scan2drive/2022-08-13.log": {
+			rfc3339(1*time.Hour) + " seq=1 severity=warning dhcpd: DHCPDISCOVER on another host",
+		},
+		// decommissioned, see below.
+		"oldpi/2022-08-13.log": {
+			rfc3339(1*time.Hour) + " seq=1 severity=warning dhcpd: DHCPDISCOVER on a retired host",
+		},
+	}
+	aliases := hostalias.Map{"raspberrypi": "dr"}
+	for rel, lines := range logs {
+		fn := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oldpi", retired.FileName), []byte(`{"messages":"drop","retention":"freeze"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		query          string
+		includeRetired bool
+		want           []string
+	}{
+		{
+			query: `host:dr tag:dhcpd DHCPDISCOVER`,
+			want: []string{
+				"dr DHCPDISCOVER from 00:11:22",
+				"dr DHCPDISCOVER from 00:11:33, no free leases",
+			},
+		},
+		{
+			query: `tag:dhcpd sev>=warn "DHCPDISCOVER" since:2h`,
+			want: []string{
+				"dr DHCPDISCOVER from 00:11:33, no free leases",
+				"scan2drive DHCPDISCOVER on another host",
+			},
+		},
+		{
+			query: `host:dr since:72h`,
+			want: []string{
+				"dr DHCPDISCOVER too old",
+				"dr DHCPDISCOVER before midnight",
+				"dr DHCPDISCOVER in the wrong tag",
+				"dr DHCPDISCOVER from 00:11:22",
+				"dr DHCPDISCOVER from 00:11:33, no free leases",
+				"dr synchronized before the rename",
+			},
+		},
+		{
+			query: "host:dr since:72h until:" + now.Add(-40*time.Hour-30*time.Second).Format(time.RFC3339),
+			want: []string{
+				"dr DHCPDISCOVER too old",
+				"dr DHCPDISCOVER before midnight",
+			},
+		},
+		{
+			query: `host:raspberrypi`,
+			want:  nil, // only searched as part of dr
+		},
+		{
+			query: `host:oldpi`,
+			want: []string{
+				"oldpi DHCPDISCOVER on a retired host",
+			},
+		},
+		{
+			query:          `tag:dhcpd sev>=warn "DHCPDISCOVER" since:2h`,
+			includeRetired: true,
+			want: []string{
+				"dr DHCPDISCOVER from 00:11:33, no free leases",
+				"oldpi DHCPDISCOVER on a retired host",
+				"scan2drive DHCPDISCOVER on another host",
+			},
+		},
+	} {
+		q, err := query.Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.IncludeRetired = tt.includeRetired
+		var got []string
+		err = Search(context.Background(), dir, aliases, q, now, func(host, line string) error {
+			_, content, _ := strings.Cut(line, ": ")
+			got = append(got, host+" "+content)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("Search(%q): unexpected diff (-want +got):\n%s", tt.query, diff)
+		}
+	}
+}
+
+func TestScanOffsets(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "2022-08-13.log")
+	content := "first\n\nthird\r\nlast without newline"
+	if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	type line struct {
+		Offset int64
+		Text   string
+	}
+	var got []line
+	if err := (*Cache)(nil).ScanOffsets(context.Background(), fn, func(offset int64, text string) {
+		got = append(got, line{offset, text})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []line{
+		{0, "first"},
+		{6, ""},
+		{7, "third"},
+		{14, "last without newline"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ScanOffsets: unexpected diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSeekTime(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2022, 8, 13, 0, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	offsets := make(map[int]int64)
+	for i := 0; i < 86400; i += 10 {
+		offsets[i] = int64(b.Len())
+		ts := start.Add(time.Duration(i) * time.Second)
+		if i == 43200 {
+			ts = ts.Add(-30 * time.Minute) // out of order, within seekSlack
+		}
+		fmt.Fprintf(&b, "rfc3339=%s seq=%d dhcpd: DHCPDISCOVER %d\n", ts.Format(time.RFC3339), i, i)
+	}
+	fn := filepath.Join(dir, "dr", "2022-08-13.log")
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := (*Cache)(nil).Open(context.Background(), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	since := start.Add(13 * time.Hour)
+	offset, err := f.seekTime(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 12:00 is the target (since less seekSlack), so seeking must not skip
+	// the line at 12:00:10, but should end up close to it.
+	if want := offsets[43210]; offset > want || offset < want-8192 {
+		t.Errorf("seekTime(%v) = %d, want an offset up to 8 KiB before %d", since, offset, want)
+	}
+
+	now := start.Add(24 * time.Hour)
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{
+			`since:2022-08-13T11:58:00Z until:2022-08-13T12:00:00Z`,
+			[]string{"DHCPDISCOVER 43080", "DHCPDISCOVER 43090", "DHCPDISCOVER 43100", "DHCPDISCOVER 43110", "DHCPDISCOVER 43120",
+				"DHCPDISCOVER 43130", "DHCPDISCOVER 43140", "DHCPDISCOVER 43150", "DHCPDISCOVER 43160", "DHCPDISCOVER 43170",
+				"DHCPDISCOVER 43180", "DHCPDISCOVER 43190"},
+		},
+		{
+			// The line of 43200 is out of order.
+			`since:2022-08-13T11:30:00Z until:2022-08-13T11:30:01Z`,
+			[]string{"DHCPDISCOVER 41400", "DHCPDISCOVER 43200"},
+		},
+	} {
+		q, err := query.Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		err = Search(context.Background(), dir, nil, q, now, func(host, line string) error {
+			_, content, _ := strings.Cut(line, ": ")
+			got = append(got, content)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("Search(%q): unexpected diff (-want +got):\n%s", tt.query, diff)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package logtree
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// seekSlack is how far out of timestamp order lines can be without being
+// skipped by seekTime: gokr-syslogd writes messages in the order it receives
+// them (or within its -reorder_window), and the clocks of senders differ.
+const seekSlack = time.Hour
+
+// minSeekSize is the size below which reading a file from the start is about as
+// fast as seeking.
+const minSeekSize = 256 << 10
+
+// lineTime returns the time of the rfc3339= field with which stored lines
+// start.
+func lineTime(line []byte) (time.Time, bool) {
+	const prefix = "rfc3339="
+	if !bytes.HasPrefix(line, []byte(prefix)) {
+		return time.Time{}, false
+	}
+	line = line[len(prefix):]
+	if idx := bytes.IndexByte(line, ' '); idx > -1 {
+		line = line[:idx]
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(line))
+	return t, err == nil
+}
+
+// timeAfter returns the start and time of the first line starting after
+// offset (or at offset 0) in r, skipping lines without a timestamp. ok is false
+// when there is no such line before size.
+func timeAfter(r io.ReaderAt, offset, size int64) (start int64, t time.Time, ok bool, _ error) {
+	buf := make([]byte, 4096)
+	start = offset
+	atLineStart := offset == 0
+	for start < size {
+		n, err := r.ReadAt(buf, start)
+		if n == 0 {
+			if err == io.EOF {
+				err = nil
+			}
+			return 0, time.Time{}, false, err
+		}
+		chunk := buf[:n]
+		if !atLineStart {
+			idx := bytes.IndexByte(chunk, '\n')
+			if idx == -1 {
+				start += int64(n)
+				continue
+			}
+			start += int64(idx) + 1
+			atLineStart = true
+			continue
+		}
+		line := chunk
+		if idx := bytes.IndexByte(chunk, '\n'); idx > -1 {
+			line = chunk[:idx]
+		}
+		if t, ok := lineTime(line); ok {
+			return start, t, true, nil
+		}
+		atLineStart = false // skip the line
+	}
+	return 0, time.Time{}, false, nil
+}
+
+// seekTime returns the offset of a line in the log file f at which reading
+// skips no line at or after t (less seekSlack), by binary search over the
+// timestamps of the lines. Files which are not seekable (compressed files
+// without a cached copy) and small files are read from the start (offset 0).
+func (f *File) seekTime(t time.Time) (int64, error) {
+	if f.file == nil || t.IsZero() {
+		return 0, nil
+	}
+	st, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if st.Size() < minSeekSize {
+		return 0, nil
+	}
+	target := t.Add(-seekSlack)
+	// Lines are in timestamp order within seekSlack, so (at the sampled
+	// lines) the lines before lo (a line start) are before target, and the
+	// first line after hi is not.
+	var lo, hi int64 = 0, st.Size()
+	for hi-lo > 4096 {
+		mid := lo + (hi-lo)/2
+		start, lt, ok, err := timeAfter(f.file, mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		if ok && lt.Before(target) {
+			lo = start
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// seek positions f at offset, which seekTime returned.
+func (f *File) seek(offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	_, err := f.file.Seek(offset, io.SeekStart)
+	return err
+}
@@ -0,0 +1,152 @@
+// Package manifest implements the per-host checksum manifest which
+// gokr-syslogd maintains in <host>/MANIFEST: for each finalized (compressed)
+// log file, its SHA-256 and size, one file per line:
+//
+//	9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 6120 2022-08-13.log.zst
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileName is the name of the manifest within each host directory.
+const FileName = "MANIFEST"
+
+// Entry describes one file.
+type Entry struct {
+	Name   string // base name, e.g. 2022-08-13.log.zst
+	Size   int64
+	SHA256 string // hex-encoded
+}
+
+// String returns e as a line of the manifest (without newline).
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %d %s", e.SHA256, e.Size, e.Name)
+}
+
+// Parse parses a line of the manifest.
+func Parse(line string) (Entry, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || len(parts[0]) != 2*sha256.Size {
+		return Entry{}, fmt.Errorf("malformed manifest line %q", line)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Entry{}, fmt.Errorf("malformed manifest line %q: %v", line, err)
+	}
+	return Entry{Name: parts[2], Size: size, SHA256: parts[0]}, nil
+}
+
+// Hash returns the entry for the file fn.
+func Hash(fn string) (Entry, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Name:   filepath.Base(fn),
+		Size:   size,
+		SHA256: fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
+
+// Verify returns an error if the file fn does not match e.
+func (e Entry) Verify(fn string) error {
+	got, err := Hash(fn)
+	if err != nil {
+		return err
+	}
+	if got.Size != e.Size || got.SHA256 != e.SHA256 {
+		return fmt.Errorf("%s does not match its manifest entry: got %s, want %s", fn, got, e)
+	}
+	return nil
+}
+
+// Manifest is the manifest of one host directory. It is not safe for
+// concurrent use.
+type Manifest struct {
+	entries map[string]Entry
+}
+
+// New returns an empty manifest.
+func New() *Manifest {
+	return &Manifest{entries: make(map[string]Entry)}
+}
+
+// Read reads the manifest from r.
+func Read(r io.Reader) (*Manifest, error) {
+	m := New()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		e, err := Parse(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		m.entries[e.Name] = e
+	}
+	return m, scanner.Err()
+}
+
+// ReadFile reads the manifest from fn. A file which does not exist yields an
+// empty manifest.
+func ReadFile(fn string) (*Manifest, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Lookup returns the entry of the file name.
+func (m *Manifest) Lookup(name string) (Entry, bool) {
+	e, ok := m.entries[name]
+	return e, ok
+}
+
+// Set adds or replaces the entry of e.Name.
+func (m *Manifest) Set(e Entry) { m.entries[e.Name] = e }
+
+// Remove removes the entry of the file name.
+func (m *Manifest) Remove(name string) { delete(m.entries, name) }
+
+// Entries returns all entries, sorted by name.
+func (m *Manifest) Entries() []Entry {
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Write writes the manifest to w.
+func (m *Manifest) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range m.Entries() {
+		fmt.Fprintln(bw, e)
+	}
+	return bw.Flush()
+}
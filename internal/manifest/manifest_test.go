@@ -0,0 +1,65 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "2022-08-13.log.zst")
+	if err := os.WriteFile(fn, []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Hash(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{
+		Name:   "2022-08-13.log.zst",
+		Size:   4,
+		SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Fatalf("Hash: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	m := New()
+	m.Set(e)
+	m.Set(Entry{Name: "2022-08-12.log.zst", Size: 1, SHA256: want.SHA256})
+	m.Set(Entry{Name: "2022-08-11.log.zst", Size: 1, SHA256: want.SHA256})
+	m.Remove("2022-08-11.log.zst")
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(m.Entries(), read.Entries()); diff != "" {
+		t.Errorf("Read(Write()): unexpected diff (-want +got):\n%s", diff)
+	}
+
+	got, ok := read.Lookup("2022-08-13.log.zst")
+	if !ok {
+		t.Fatalf("Lookup(2022-08-13.log.zst) not found")
+	}
+	if err := got.Verify(fn); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := os.WriteFile(fn, []byte("tEst"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Verify(fn); err == nil {
+		t.Errorf("Verify(modified file) unexpectedly succeeded")
+	}
+
+	if _, err := Parse("abc 4 2022-08-13.log.zst"); err == nil {
+		t.Errorf("Parse(short hash) unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,474 @@
+// Package mdns advertises and discovers gokr-syslogd and gokr-syslogweb on the
+// local network with DNS-based service discovery over multicast DNS (RFC 6762,
+// RFC 6763), so that senders do not need a hard-coded collector address:
+//
+//	gokr-syslogd -mdns
+//	gokr-kmsg -target=mdns
+//
+// Only what this needs is implemented: PTR, SRV, TXT and A records over IPv4.
+package mdns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service types, see RFC 6763 section 7.
+const (
+	SyslogService = "_syslog._udp"
+	WebService    = "_http._tcp"
+)
+
+// Target is the sender -target value which discovers the collector.
+const Target = "mdns"
+
+// group is the mDNS multicast group and port.
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+
+	// cacheFlush marks records which only this host answers (RFC 6762
+	// section 10.2); the same bit in questions requests a unicast response
+	// (section 5.4).
+	cacheFlush = 0x8000
+
+	ttl = 120 // seconds, as recommended for records with host names
+
+	// servicesName lists the advertised service types (RFC 6763 section 9).
+	servicesName = "_services._dns-sd._udp.local"
+)
+
+// Service is an advertised service instance.
+type Service struct {
+	Instance string // e.g. "gokr-syslogd on dr"; dots are replaced
+	Service  string // e.g. SyslogService
+	Port     int
+	TXT      []string // key=value pairs, e.g. path=/
+}
+
+func (s Service) serviceName() string { return s.Service + ".local" }
+
+func (s Service) instanceName() string {
+	return strings.ReplaceAll(s.Instance, ".", "-") + "." + s.serviceName()
+}
+
+// builder appends DNS messages. Names are not compressed.
+type builder struct {
+	b []byte
+}
+
+func (b *builder) uint16(v uint16) { b.b = binary.BigEndian.AppendUint16(b.b, v) }
+
+func (b *builder) name(name string) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b.b = append(b.b, byte(len(label)))
+		b.b = append(b.b, label...)
+	}
+	b.b = append(b.b, 0)
+}
+
+// record appends a resource record, with the data appended by data.
+func (b *builder) record(name string, typ, class uint16, data func()) {
+	b.name(name)
+	b.uint16(typ)
+	b.uint16(class)
+	b.b = binary.BigEndian.AppendUint32(b.b, ttl)
+	lenOff := len(b.b)
+	b.uint16(0)
+	data()
+	binary.BigEndian.PutUint16(b.b[lenOff:], uint16(len(b.b)-lenOff-2))
+}
+
+type question struct {
+	name       string
+	typ, class uint16
+}
+
+type record struct {
+	name    string
+	typ     uint16
+	data    []byte
+	dataOff int // offset of data in the message, for compressed names
+}
+
+// readName reads the (possibly compressed) name at off in msg and returns it
+// and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("name exceeds message")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end == -1 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, fmt.Errorf("name exceeds message")
+			}
+			if end == -1 {
+				end = off + 2
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("too many compression pointers")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case n > 63:
+			return "", 0, fmt.Errorf("invalid label length %d", n)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, fmt.Errorf("label exceeds message")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parse parses the header, questions and resource records of msg.
+func parse(msg []byte) (id, flags uint16, questions []question, records []record, _ error) {
+	if len(msg) < 12 {
+		return 0, 0, nil, nil, fmt.Errorf("message too short")
+	}
+	id, flags = binary.BigEndian.Uint16(msg), binary.BigEndian.Uint16(msg[2:])
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		if next+4 > len(msg) {
+			return 0, 0, nil, nil, fmt.Errorf("question exceeds message")
+		}
+		questions = append(questions, question{
+			name:  name,
+			typ:   binary.BigEndian.Uint16(msg[next:]),
+			class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		off = next + 4
+	}
+	for i := 0; i < rrcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return 0, 0, nil, nil, err
+		}
+		if next+10 > len(msg) {
+			return 0, 0, nil, nil, fmt.Errorf("record exceeds message")
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		dataOff := next + 10
+		if dataOff+length > len(msg) {
+			return 0, 0, nil, nil, fmt.Errorf("record data exceeds message")
+		}
+		records = append(records, record{
+			name:    name,
+			typ:     binary.BigEndian.Uint16(msg[next:]),
+			data:    msg[dataOff : dataOff+length],
+			dataOff: dataOff,
+		})
+		off = dataOff + length
+	}
+	return id, flags, questions, records, nil
+}
+
+// responder answers queries for its services.
+type responder struct {
+	host     string // e.g. dr.local
+	addrs    func() []net.IP
+	services []Service
+}
+
+// response builds the response to the questions, or returns nil if none of
+// them concern r. Legacy unicast responses (to queries which were not sent
+// from port 5353) repeat the query ID and questions (RFC 6762 section 6.7).
+func (r *responder) response(id uint16, questions []question, legacy bool) []byte {
+	var answers, additionals []func(*builder)
+	ptr := func(name, target string) func(*builder) {
+		return func(b *builder) {
+			b.record(name, typePTR, classIN, func() { b.name(target) })
+		}
+	}
+	srvTXT := func(s Service) []func(*builder) {
+		return []func(*builder){
+			func(b *builder) {
+				b.record(s.instanceName(), typeSRV, classIN|cacheFlush, func() {
+					b.uint16(0) // priority
+					b.uint16(0) // weight
+					b.uint16(uint16(s.Port))
+					b.name(r.host)
+				})
+			},
+			func(b *builder) {
+				b.record(s.instanceName(), typeTXT, classIN|cacheFlush, func() {
+					txt := s.TXT
+					if len(txt) == 0 {
+						txt = []string{""} // at least one string, RFC 6763 section 6.1
+					}
+					for _, t := range txt {
+						b.b = append(b.b, byte(len(t)))
+						b.b = append(b.b, t...)
+					}
+				})
+			},
+		}
+	}
+	var a []func(*builder)
+	for _, ip := range r.addrs() {
+		ip := ip.To4() // copy
+		a = append(a, func(b *builder) {
+			b.record(r.host, typeA, classIN|cacheFlush, func() { b.b = append(b.b, ip...) })
+		})
+	}
+	is := func(q question, name string, typ uint16) bool {
+		return strings.EqualFold(strings.TrimSuffix(q.name, "."), name) && (q.typ == typ || q.typ == typeANY)
+	}
+	for _, q := range questions {
+		for _, s := range r.services {
+			if is(q, servicesName, typePTR) {
+				answers = append(answers, ptr(servicesName, s.serviceName()))
+			}
+			if is(q, s.serviceName(), typePTR) {
+				answers = append(answers, ptr(s.serviceName(), s.instanceName()))
+				additionals = append(append(additionals, srvTXT(s)...), a...)
+			}
+			if is(q, s.instanceName(), typeSRV) || is(q, s.instanceName(), typeTXT) {
+				answers = append(answers, srvTXT(s)...)
+				additionals = append(additionals, a...)
+			}
+		}
+		if is(q, r.host, typeA) {
+			answers = append(answers, a...)
+		}
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	b := &builder{}
+	if !legacy {
+		id = 0
+	}
+	b.uint16(id)
+	b.uint16(0x8400) // response, authoritative answer
+	if legacy {
+		b.uint16(uint16(len(questions)))
+	} else {
+		b.uint16(0)
+	}
+	b.uint16(uint16(len(answers)))
+	b.uint16(0)
+	b.uint16(uint16(len(additionals)))
+	if legacy {
+		for _, q := range questions {
+			b.name(q.name)
+			b.uint16(q.typ)
+			b.uint16(q.class &^ cacheFlush)
+		}
+	}
+	for _, rr := range append(answers, additionals...) {
+		rr(b)
+	}
+	return b.b
+}
+
+// announcement is the unsolicited response which is sent on startup.
+func (r *responder) announcement() []byte {
+	var questions []question
+	for _, s := range r.services {
+		questions = append(questions, question{name: s.serviceName(), typ: typePTR, class: classIN})
+	}
+	return r.response(0, questions, false)
+}
+
+// localAddrs returns the IPv4 addresses of this host, preferring non-loopback
+// addresses.
+func localAddrs() []net.IP {
+	addrs, _ := net.InterfaceAddrs()
+	var ips, loopback []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		if ipnet.IP.IsLoopback() {
+			loopback = append(loopback, ipnet.IP)
+		} else {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
+
+// Advertise answers mDNS queries for services of hostname (without .local)
+// until ctx is done.
+func Advertise(ctx context.Context, hostname string, services []Service) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	r := &responder{host: hostname + ".local", addrs: localAddrs, services: services}
+	if _, err := conn.WriteToUDP(r.announcement(), group); err != nil {
+		return err
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		id, flags, questions, _, err := parse(buf[:n])
+		if err != nil || flags&0x8000 != 0 {
+			continue // invalid, or a response
+		}
+		legacy := src.Port != group.Port
+		unicast := legacy
+		for _, q := range questions {
+			unicast = unicast || q.class&cacheFlush != 0
+		}
+		resp := r.response(id, questions, legacy)
+		if resp == nil {
+			continue
+		}
+		dst := group
+		if unicast {
+			dst = src
+		}
+		conn.WriteToUDP(resp, dst)
+	}
+}
+
+// query returns a query for the PTR records of service.
+func query(service string) []byte {
+	b := &builder{}
+	b.uint16(0) // ID
+	b.uint16(0) // flags
+	b.uint16(1) // questions
+	b.uint16(0)
+	b.uint16(0)
+	b.uint16(0)
+	b.name(service + ".local")
+	b.uint16(typePTR)
+	b.uint16(classIN)
+	return b.b
+}
+
+// resolve returns the host:port of the first instance of service in the
+// response msg, if it contains the instance’s SRV and A records.
+func resolve(msg []byte, service string) (string, bool) {
+	_, flags, _, records, err := parse(msg)
+	if err != nil || flags&0x8000 == 0 {
+		return "", false
+	}
+	srvs := make(map[string]record)
+	ips := make(map[string]net.IP)
+	var instances []string
+	for _, rr := range records {
+		name := strings.ToLower(rr.name)
+		switch rr.typ {
+		case typePTR:
+			if name == strings.ToLower(service+".local") {
+				if target, _, err := readName(msg, rr.dataOff); err == nil {
+					instances = append(instances, strings.ToLower(target))
+				}
+			}
+		case typeSRV:
+			srvs[name] = rr
+		case typeA:
+			if len(rr.data) == net.IPv4len {
+				ips[name] = net.IP(rr.data)
+			}
+		}
+	}
+	for _, instance := range instances {
+		srv, ok := srvs[instance]
+		if !ok || len(srv.data) < 7 {
+			continue
+		}
+		port := binary.BigEndian.Uint16(srv.data[4:])
+		target, _, err := readName(msg, srv.dataOff+6)
+		if err != nil {
+			continue
+		}
+		if ip, ok := ips[strings.ToLower(target)]; ok {
+			return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), true
+		}
+	}
+	return "", false
+}
+
+// Lookup returns the host:port of an instance of service on the local
+// network, querying once per second until ctx is done.
+func Lookup(ctx context.Context, service string) (string, error) {
+	// Queries from a port other than 5353 are answered by unicast.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	q := query(service)
+	buf := make([]byte, 9000)
+	for {
+		if _, err := conn.WriteToUDP(q, group); err != nil {
+			return "", err
+		}
+		deadline := time.Now().Add(1 * time.Second)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break // retry after the timeout
+			}
+			if target, ok := resolve(buf[:n], service); ok {
+				return target, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("no %s service found via mDNS: %v", service, err)
+		}
+	}
+}
+
+// ResolveTarget returns target, or the collector discovered with Lookup if
+// target is Target.
+func ResolveTarget(ctx context.Context, target string) (string, error) {
+	if target != Target {
+		return target, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return Lookup(ctx, SyslogService)
+}
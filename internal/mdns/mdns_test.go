@@ -0,0 +1,76 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	r := &responder{
+		host:  "dr.local",
+		addrs: func() []net.IP { return []net.IP{net.IPv4(10, 0, 0, 76)} },
+		services: []Service{
+			{Instance: "gokr-syslogd on dr", Service: SyslogService, Port: 514},
+			{Instance: "gokr-syslogweb on dr", Service: WebService, Port: 8514, TXT: []string{"path=/"}},
+		},
+	}
+	for _, tt := range []struct {
+		service string
+		want    string
+	}{
+		{SyslogService, "10.0.0.76:514"},
+		{WebService, "10.0.0.76:8514"},
+	} {
+		id, _, questions, _, err := parse(query(tt.service))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := r.response(id, questions, true)
+		if resp == nil {
+			t.Fatalf("no response to a query for %s", tt.service)
+		}
+		if got, ok := resolve(resp, tt.service); !ok || got != tt.want {
+			t.Errorf("resolve(%s) = %q, %v, want %q", tt.service, got, ok, tt.want)
+		}
+	}
+
+	_, _, questions, _, err := parse(query("_ipp._tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := r.response(0, questions, false); resp != nil {
+		t.Errorf("unexpected response to a query for another service")
+	}
+	if _, ok := resolve(r.announcement(), SyslogService); !ok {
+		t.Errorf("announcement does not resolve %s", SyslogService)
+	}
+}
+
+func TestResolveCompressed(t *testing.T) {
+	// A response as sent by other implementations (e.g. Avahi), which
+	// compress names with pointers to earlier occurrences.
+	msg := []byte{
+		0, 0, 0x84, 0, 0, 0, 0, 3, 0, 0, 0, 0,
+		// 12: _syslog._udp.local PTR
+		7, '_', 's', 'y', 's', 'l', 'o', 'g', 4, '_', 'u', 'd', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0, 12, 0, 1, 0, 0, 0, 120, 0, 6,
+		// 42: nas._syslog._udp.local
+		3, 'n', 'a', 's', 0xc0, 12,
+		// SRV of nas._syslog._udp.local
+		0xc0, 42, 0, 33, 0x80, 1, 0, 0, 0, 120, 0, 12,
+		0, 0, 0, 0, 0x02, 0x02, // port 514
+		// 66: nas.local
+		3, 'n', 'a', 's', 0xc0, 25,
+		// A of nas.local
+		0xc0, 66, 0, 1, 0x80, 1, 0, 0, 0, 120, 0, 4,
+		192, 168, 1, 2,
+	}
+	if got, ok := resolve(msg, SyslogService); !ok || got != "192.168.1.2:514" {
+		t.Errorf("resolve = %q, %v, want 192.168.1.2:514", got, ok)
+	}
+
+	loop := []byte{0, 0, 0x84, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12}
+	if _, _, _, _, err := parse(loop); err == nil {
+		t.Errorf("parse of a compression loop unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,320 @@
+// Package msgexpr implements the conditions of gokr-syslogd -rules: boolean
+// expressions evaluated per message, e.g.
+//
+//	tag == "sshd" && content =~ "Failed password" && !(host == "bastion")
+//	severity >= warning || container == "web-1"
+//
+// A comparison consists of a field, an operator and a value:
+//
+//   - host, tag, content, zone, facility (e.g. authpriv) and any other name,
+//     which refers to the value of a key=value label (e.g. container): ==, !=,
+//     =~ and !~ (regular expression match, RE2 syntax).
+//   - severity: ==, !=, >= (at least as severe as), >, <= and <, with a
+//     severity name (emerg … debug, or warn, error or panic) or code. Messages of unknown severity
+//     only match !=.
+//
+// Values are "quoted" Go strings, numbers or bare words. Comparisons combine
+// with !, && (binding more tightly) and ||, and can be grouped in parentheses.
+package msgexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gokrazy/syslogd/internal/prefilter"
+	"github.com/gokrazy/syslogd/internal/severity"
+)
+
+// Message is what expressions are evaluated against.
+type Message struct {
+	Host     string
+	Tag      string
+	Content  string
+	Zone     string
+	Facility string // name, empty if unknown
+	Severity int    // code, -1 if unknown
+	Labels   []string
+}
+
+func (m *Message) field(name string) string {
+	switch name {
+	case "host":
+		return m.Host
+	case "tag":
+		return m.Tag
+	case "content":
+		return m.Content
+	case "zone":
+		return m.Zone
+	case "facility":
+		return m.Facility
+	}
+	for _, label := range m.Labels {
+		if key, value, ok := strings.Cut(label, "="); ok && key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	text string
+	root node
+}
+
+// String returns the source text of e.
+func (e *Expr) String() string { return e.text }
+
+// Match reports whether m satisfies e.
+func (e *Expr) Match(m *Message) bool { return e.root.eval(m) }
+
+type node interface {
+	eval(m *Message) bool
+}
+
+type andNode struct{ l, r node }
+
+func (n andNode) eval(m *Message) bool { return n.l.eval(m) && n.r.eval(m) }
+
+type orNode struct{ l, r node }
+
+func (n orNode) eval(m *Message) bool { return n.l.eval(m) || n.r.eval(m) }
+
+type notNode struct{ x node }
+
+func (n notNode) eval(m *Message) bool { return !n.x.eval(m) }
+
+type fieldNode struct {
+	field string
+	op    string
+	value string
+	re    *prefilter.Regexp // for =~ and !~
+}
+
+func (n fieldNode) eval(m *Message) bool {
+	v := m.field(n.field)
+	switch n.op {
+	case "==":
+		return v == n.value
+	case "!=":
+		return v != n.value
+	case "=~":
+		return n.re.MatchString(v)
+	default: // !~
+		return !n.re.MatchString(v)
+	}
+}
+
+type severityNode struct {
+	op   string
+	code int
+}
+
+func (n severityNode) eval(m *Message) bool {
+	if m.Severity < 0 {
+		return n.op == "!="
+	}
+	// Lower codes are more severe.
+	switch n.op {
+	case "==":
+		return m.Severity == n.code
+	case "!=":
+		return m.Severity != n.code
+	case ">=":
+		return m.Severity <= n.code
+	case ">":
+		return m.Severity < n.code
+	case "<=":
+		return m.Severity >= n.code
+	default: // <
+		return m.Severity > n.code
+	}
+}
+
+// Parse parses the expression s.
+func Parse(s string) (*Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != "" {
+		return nil, fmt.Errorf("unexpected %q", t)
+	}
+	return &Expr{text: s, root: root}, nil
+}
+
+var operators = []string{"==", "!=", "=~", "!~", ">=", "<=", "&&", "||", ">", "<", "!", "(", ")"}
+
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+Tokens:
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			prefix, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %q", s)
+			}
+			tokens = append(tokens, prefix)
+			s = s[len(prefix):]
+			continue
+		}
+		for _, op := range operators {
+			if strings.HasPrefix(s, op) {
+				tokens = append(tokens, op)
+				s = s[len(op):]
+				continue Tokens
+			}
+		}
+		end := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.:/", r)
+		})
+		if end == 0 {
+			return nil, fmt.Errorf("unexpected %q", s[:1])
+		}
+		if end == -1 {
+			end = len(s)
+		}
+		tokens = append(tokens, s[:end])
+		s = s[end:]
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []string
+}
+
+func (p *parser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	if t != "" {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (node, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case "(":
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t != ")" {
+			return nil, fmt.Errorf("expected ), got %q", t)
+		}
+		return x, nil
+	}
+	return p.comparison()
+}
+
+func isOperator(t string) bool {
+	for _, op := range operators {
+		if t == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) comparison() (node, error) {
+	field := p.next()
+	if field == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if isOperator(field) || strings.HasPrefix(field, `"`) {
+		return nil, fmt.Errorf("expected a field, got %q", field)
+	}
+	op := p.next()
+	switch op {
+	case "==", "!=", "=~", "!~", ">=", ">", "<=", "<":
+	default:
+		return nil, fmt.Errorf("expected an operator after %s, got %q", field, op)
+	}
+	value := p.next()
+	if value == "" || isOperator(value) {
+		return nil, fmt.Errorf("expected a value after %s %s, got %q", field, op, value)
+	}
+	if strings.HasPrefix(value, `"`) {
+		var err error
+		if value, err = strconv.Unquote(value); err != nil {
+			return nil, err
+		}
+	}
+	if field == "severity" {
+		code, err := severity.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		if op == "=~" || op == "!~" {
+			return nil, fmt.Errorf("severity cannot be matched with %s", op)
+		}
+		return severityNode{op: op, code: code}, nil
+	}
+	n := fieldNode{field: field, op: op, value: value}
+	switch op {
+	case "=~", "!~":
+		re, err := prefilter.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		n.re = re
+	case ">=", ">", "<=", "<":
+		return nil, fmt.Errorf("%s cannot be compared with %s (only severity can)", field, op)
+	}
+	return n, nil
+}
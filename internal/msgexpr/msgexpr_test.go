@@ -0,0 +1,90 @@
+package msgexpr
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	msg := &Message{
+		Host:     "dr",
+		Tag:      "sshd",
+		Content:  "Failed password for root from 10.0.0.16",
+		Facility: "authpriv",
+		Severity: 4, // warning
+		Labels:   []string{"container=web-1"},
+	}
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{`tag == "sshd"`, true},
+		{`tag == sshd`, true},
+		{`tag != sshd`, false},
+		{`content =~ "Failed pass(word)?"`, true},
+		{`content !~ "Accepted"`, true},
+		{`facility == authpriv`, true},
+		{`severity >= warning`, true},
+		{`severity >= err`, false},
+		{`severity > notice`, true},
+		{`severity <= err`, true},
+		{`severity <= info`, false},
+		{`severity < warning`, false},
+		{`severity == 4`, true},
+		{`container == "web-1"`, true},
+		{`image == ""`, true},
+		{`host == dr && tag == sshd`, true},
+		{`host == dr && tag == ntpd`, false},
+		{`host == router7 || tag == sshd`, true},
+		{`!(host == dr)`, false},
+		{`!host == dr`, false},
+		{`host == x || host == dr && tag == ntpd`, false}, // && binds more tightly
+		{`(host == x || host == dr) && tag == sshd`, true},
+	} {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := e.Match(msg); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	unknown := &Message{Severity: -1}
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{`severity >= debug`, false},
+		{`severity == info`, false},
+		{`severity != info`, true},
+	} {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Match(unknown); got != tt.want {
+			t.Errorf("%s = %v for unknown severity, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`tag`,
+		`tag ==`,
+		`tag = sshd`,
+		`tag > sshd`,
+		`severity >= loud`,
+		`severity =~ err`,
+		`content =~ "("`,
+		`(tag == sshd`,
+		`tag == sshd)`,
+		`tag == sshd &&`,
+		`"tag" == sshd`,
+		`tag == "unterminated`,
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) = nil error, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package prefilter speeds up matching regular expressions against log lines:
+// most lines of a log do not contain the literal text which a pattern like
+// `DHCPDISCOVER from .*:33` requires, and bytes.Contains rejects them much
+// faster than the regexp engine does (the approach of Russ Cox’ codesearch,
+// restricted to literals which every match contains).
+package prefilter
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Regexp is a compiled regular expression with a pre-filter of the literals
+// which every match contains.
+type Regexp struct {
+	re       *regexp.Regexp
+	literals []string // longest first
+	required [][]byte // literals, for Match
+}
+
+// Compile compiles expr like regexp.Compile.
+func Compile(expr string) (*Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	r := &Regexp{re: re}
+	// The same flags as regexp.Compile.
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return r, nil // unreachable: regexp.Compile succeeded
+	}
+	lits := required(parsed.Simplify())
+	sort.SliceStable(lits, func(i, j int) bool {
+		return len(lits[i]) > len(lits[j])
+	})
+Lits:
+	for _, lit := range lits {
+		// Invalid UTF-8 in lines matches U+FFFD, so it cannot be
+		// pre-filtered.
+		if lit == "" || strings.ContainsRune(lit, utf8.RuneError) {
+			continue
+		}
+		for _, longer := range r.literals {
+			if strings.Contains(longer, lit) {
+				continue Lits // checked by longer
+			}
+		}
+		r.literals = append(r.literals, lit)
+		r.required = append(r.required, []byte(lit))
+	}
+	return r, nil
+}
+
+// String returns the source text of r.
+func (r *Regexp) String() string { return r.re.String() }
+
+// Literals returns the literals which every match of r contains.
+func (r *Regexp) Literals() []string { return r.literals }
+
+// Match reports whether b contains a match of r.
+func (r *Regexp) Match(b []byte) bool {
+	for _, lit := range r.required {
+		if !bytes.Contains(b, lit) {
+			return false
+		}
+	}
+	return r.re.Match(b)
+}
+
+// MatchString reports whether s contains a match of r.
+func (r *Regexp) MatchString(s string) bool {
+	for _, lit := range r.literals {
+		if !strings.Contains(s, lit) {
+			return false
+		}
+	}
+	return r.re.MatchString(s)
+}
+
+// required returns literals which every match of re contains.
+func required(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return required(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return nil
+		}
+		return required(re.Sub[0])
+
+	case syntax.OpConcat:
+		// Adjacent literals form one longer literal.
+		var lits []string
+		var run strings.Builder
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+				run.WriteString(string(sub.Rune))
+				continue
+			}
+			if run.Len() > 0 {
+				lits = append(lits, run.String())
+				run.Reset()
+			}
+			lits = append(lits, required(sub)...)
+		}
+		if run.Len() > 0 {
+			lits = append(lits, run.String())
+		}
+		return lits
+	}
+	// Alternations, optional parts, character classes and anchors require
+	// no particular literal.
+	return nil
+}
@@ -0,0 +1,68 @@
+package prefilter
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestLiterals(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		want []string
+	}{
+		{`DHCPDISCOVER`, []string{"DHCPDISCOVER"}},
+		{`DHCPDISCOVER from .*:33`, []string{"DHCPDISCOVER from ", ":33"}},
+		{`(I/O|disk) error on dev sd[a-z]`, []string{" error on dev sd"}},
+		{`error+`, []string{"erro"}},
+		{`x{2,}y`, []string{"x", "y"}},
+		{`^kernel: (oom)?`, []string{"kernel: "}},
+		{`error|fail`, nil},
+		{`colou?r`, []string{"colo", "r"}},
+		{`(?i)error`, nil},
+		{`.*`, nil},
+		{`\x{fffd}bad`, nil},
+	} {
+		re, err := Compile(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.want, re.Literals(), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Compile(%q).Literals(): unexpected diff (-want +got):\n%s", tt.expr, diff)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	lines := []string{
+		"rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER from 00:11:33",
+		"rfc3339=2022-08-13T16:00:01Z seq=2 dhcpd: DHCPDISCOVER from 00:11:22",
+		"rfc3339=2022-08-13T16:00:02Z seq=3 kernel: I/O error on dev sda",
+		"rfc3339=2022-08-13T16:00:03Z seq=4 kernel: disk error on dev sdz, colour",
+		"rfc3339=2022-08-13T16:00:04Z seq=5 invalid UTF-8: \xff bad",
+	}
+	for _, expr := range []string{
+		`DHCPDISCOVER from .*:33`,
+		`(I/O|disk) error on dev sd[a-z]`,
+		`colou?r`,
+		`(?i)dhcpdiscover`,
+		`\x{fffd} bad`,
+		`seq=[24] `,
+	} {
+		want := regexp.MustCompile(expr)
+		got, err := Compile(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if got, want := got.Match([]byte(line)), want.MatchString(line); got != want {
+				t.Errorf("Compile(%q).Match(%q) = %v, want %v", expr, line, got, want)
+			}
+			if got, want := got.MatchString(line), want.MatchString(line); got != want {
+				t.Errorf("Compile(%q).MatchString(%q) = %v, want %v", expr, line, got, want)
+			}
+		}
+	}
+}
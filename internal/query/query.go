@@ -0,0 +1,385 @@
+// Package query implements the query language shared by gokr-syslogweb’s
+// /search and grog: space-separated terms, all of which need to match.
+//
+//	host:dr tag:dhcpd sev>=warn "DHCPDISCOVER from" since:2h
+//
+// The terms are:
+//
+//   - host:<hostname>, tag:<tag>, zone:<zone>: messages of this host (tag,
+//     zone). Multiple terms of the same kind match any of them.
+//   - client:<address>: messages from this source address or network, e.g.
+//     client:10.0.0.16, client:fe80::1%eth0 or client:2001:db8::/32. IPv6
+//     addresses without zone match any zone. Requires gokr-syslogd
+//     -store_client.
+//   - sev>=<severity>, sev<=<severity>, sev:<severity>: messages at least as
+//     (at most as, exactly as) severe as the severity (emerg, alert, crit,
+//     err, warning, notice, info or debug). Requires gokr-syslogd
+//     -store_severity.
+//   - since:<time>, until:<time>: messages in this period, where the time is
+//     an expression of package timeexpr, e.g. since:2h, since:yesterday,
+//     until:"yesterday 3pm" or since:2022-08-13T16:00:00+02:00.
+//   - time:<time>: messages within the period of the expression, e.g.
+//     time:yesterday or time:2024-07-01..2024-07-03.
+//   - <key>=<value>: lines with this key=value field, e.g. container=web-1.
+//   - anything else, optionally "quoted": messages containing the text.
+package query
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+	"github.com/gokrazy/syslogd/internal/severity"
+	"github.com/gokrazy/syslogd/internal/timeexpr"
+)
+
+// Query is a parsed query.
+type Query struct {
+	Hosts []string
+	Tags  []string
+	Zones []string
+
+	// Clients are source addresses (with optional IPv6 zone) or networks
+	// in CIDR notation, in canonical form.
+	Clients []string
+
+	// Fields are key=value fields which lines need to carry.
+	Fields []string
+
+	// MinSeverity and MaxSeverity are the range of severity codes (0 is most
+	// severe) messages need to be in. Zero values (0 and 7) do not restrict.
+	MinSeverity int
+	MaxSeverity int
+
+	// Since and Until restrict the period, if non-zero: from the start of
+	// the period of Since until the end of the period of Until, at the time
+	// of the query.
+	Since, Until timeexpr.Expr
+
+	// Terms are texts which messages need to contain.
+	Terms []string
+
+	// IncludeRetired also searches the hosts decommissioned with
+	// gokr-syslogctl decommission, which are otherwise only searched when
+	// named in a host: term. It is not part of the syntax: gokr-syslogweb
+	// sets it with its “include retired hosts” toggle.
+	IncludeRetired bool
+}
+
+// tokenize splits s at spaces, keeping "quoted text" (with \" escapes) in one
+// token. Quotes may also start within a token, as in sev:"err".
+func tokenize(s string) ([]string, error) {
+	var (
+		tokens []string
+		cur    strings.Builder
+		inTok  bool
+		quoted bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			quoted = !quoted
+			inTok = true
+		case c == ' ' && !quoted:
+			if inTok {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inTok = false
+			}
+		default:
+			cur.WriteByte(c)
+			inTok = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inTok {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+// Parse parses the query s.
+func Parse(s string) (*Query, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{MaxSeverity: len(severity.Names) - 1}
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(token, "sev>="):
+			code, err := severity.Parse(token[len("sev>="):])
+			if err != nil {
+				return nil, err
+			}
+			q.MaxSeverity = code
+		case strings.HasPrefix(token, "sev<="):
+			code, err := severity.Parse(token[len("sev<="):])
+			if err != nil {
+				return nil, err
+			}
+			q.MinSeverity = code
+		case strings.HasPrefix(token, "sev:"):
+			code, err := severity.Parse(token[len("sev:"):])
+			if err != nil {
+				return nil, err
+			}
+			q.MinSeverity, q.MaxSeverity = code, code
+		case strings.HasPrefix(token, "host:"):
+			q.Hosts = append(q.Hosts, token[len("host:"):])
+		case strings.HasPrefix(token, "tag:"):
+			q.Tags = append(q.Tags, token[len("tag:"):])
+		case strings.HasPrefix(token, "zone:"):
+			q.Zones = append(q.Zones, token[len("zone:"):])
+		case strings.HasPrefix(token, "client:"):
+			client, err := parseClient(token[len("client:"):])
+			if err != nil {
+				return nil, err
+			}
+			q.Clients = append(q.Clients, client)
+		case strings.HasPrefix(token, "since:"):
+			q.Since, err = timeexpr.Parse(token[len("since:"):])
+			if err != nil {
+				return nil, fmt.Errorf("since: %v", err)
+			}
+		case strings.HasPrefix(token, "until:"):
+			q.Until, err = timeexpr.Parse(token[len("until:"):])
+			if err != nil {
+				return nil, fmt.Errorf("until: %v", err)
+			}
+		case strings.HasPrefix(token, "time:"):
+			q.Since, err = timeexpr.Parse(token[len("time:"):])
+			if err != nil {
+				return nil, fmt.Errorf("time: %v", err)
+			}
+			q.Until = q.Since
+		case isField(token):
+			q.Fields = append(q.Fields, token)
+		default:
+			q.Terms = append(q.Terms, token)
+		}
+	}
+	if q.MinSeverity > q.MaxSeverity {
+		return nil, fmt.Errorf("no severity is in sev<=%s and sev>=%s", severity.Names[q.MinSeverity], severity.Names[q.MaxSeverity])
+	}
+	return q, nil
+}
+
+// parseClient returns the canonical form of the address or network of a
+// client: term. IPv6 addresses may be in brackets, as in [fe80::1%eth0].
+func parseClient(s string) (string, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return "", fmt.Errorf("client: %v", err)
+		}
+		return prefix.Masked().String(), nil
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", fmt.Errorf("client: %v", err)
+	}
+	return addr.Unmap().String(), nil
+}
+
+// matchClient reports whether the stored client= value matches the
+// canonical address or network client (see parseClient).
+func matchClient(client, value string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if strings.Contains(client, "/") {
+		prefix, err := netip.ParsePrefix(client)
+		return err == nil && prefix.Contains(addr.WithZone(""))
+	}
+	want, err := netip.ParseAddr(client)
+	if err != nil {
+		return false
+	}
+	if want.Zone() == "" {
+		addr = addr.WithZone("")
+	}
+	return addr == want
+}
+
+// isField reports whether token is a key=value term with a lower-case key, as
+// used for the fields of stored lines.
+func isField(token string) bool {
+	key, _, ok := strings.Cut(token, "=")
+	if !ok || key == "" {
+		return false
+	}
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \"\\") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// String returns q in its canonical form, which parses into the same query.
+func (q *Query) String() string {
+	var terms []string
+	for _, h := range q.Hosts {
+		terms = append(terms, "host:"+quote(h))
+	}
+	for _, t := range q.Tags {
+		terms = append(terms, "tag:"+quote(t))
+	}
+	for _, z := range q.Zones {
+		terms = append(terms, "zone:"+quote(z))
+	}
+	for _, c := range q.Clients {
+		terms = append(terms, "client:"+quote(c))
+	}
+	switch {
+	case q.MinSeverity == q.MaxSeverity:
+		terms = append(terms, "sev:"+severity.Names[q.MinSeverity])
+	default:
+		if q.MaxSeverity < len(severity.Names)-1 {
+			terms = append(terms, "sev>="+severity.Names[q.MaxSeverity])
+		}
+		if q.MinSeverity > 0 {
+			terms = append(terms, "sev<="+severity.Names[q.MinSeverity])
+		}
+	}
+	switch {
+	case !q.Since.IsZero() && q.Since.Equal(q.Until):
+		terms = append(terms, "time:"+quote(q.Since.String()))
+	default:
+		if !q.Since.IsZero() {
+			terms = append(terms, "since:"+quote(q.Since.String()))
+		}
+		if !q.Until.IsZero() {
+			terms = append(terms, "until:"+quote(q.Until.String()))
+		}
+	}
+	for _, f := range q.Fields {
+		terms = append(terms, quote(f))
+	}
+	for _, t := range q.Terms {
+		terms = append(terms, quote(t))
+	}
+	return strings.Join(terms, " ")
+}
+
+// Period returns the period the query covers at now. The zero time means
+// unbounded.
+func (q *Query) Period(now time.Time) (start, end time.Time) {
+	start, _ = q.Since.Period(now)
+	_, end = q.Until.Period(now)
+	return start, end
+}
+
+// MatchHost reports whether messages of host can match q.
+func (q *Query) MatchHost(host string) bool {
+	return len(q.Hosts) == 0 || contains(q.Hosts, host)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the stored line matches q within the period
+// [start, end) (see Period). The host is not checked (see MatchHost).
+func (q *Query) Match(line string, start, end time.Time) bool {
+	fields, rest := logline.Split(line)
+	value := func(key string) (string, bool) {
+		for _, field := range fields {
+			if strings.HasPrefix(field, key+"=") {
+				return field[len(key)+1:], true
+			}
+		}
+		return "", false
+	}
+	if !start.IsZero() || !end.IsZero() {
+		v, _ := value("rfc3339")
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return false
+		}
+		if !start.IsZero() && t.Before(start) {
+			return false
+		}
+		if !end.IsZero() && !t.Before(end) {
+			return false
+		}
+	}
+	if len(q.Tags) > 0 {
+		tag, _, _ := strings.Cut(rest, ": ")
+		if !contains(q.Tags, tag) {
+			return false
+		}
+	}
+	if len(q.Zones) > 0 {
+		if zone, ok := value("zone"); !ok || !contains(q.Zones, zone) {
+			return false
+		}
+	}
+	if len(q.Clients) > 0 {
+		v, ok := value("client")
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, c := range q.Clients {
+			if matchClient(c, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if q.MinSeverity > 0 || q.MaxSeverity < len(severity.Names)-1 {
+		v, ok := value("severity")
+		if !ok {
+			return false
+		}
+		code, err := severity.Parse(v)
+		if err != nil || code < q.MinSeverity || code > q.MaxSeverity {
+			return false
+		}
+	}
+	for _, f := range q.Fields {
+		if !contains(fields, f) {
+			return false
+		}
+	}
+	for _, term := range q.Terms {
+		if !strings.Contains(rest, term) {
+			return false
+		}
+	}
+	return true
+}
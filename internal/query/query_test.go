@@ -0,0 +1,110 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/timeexpr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`host:dr tag:dhcpd sev>=warn "DHCPDISCOVER from" since:2h container=web-1 client:10.0.0.1/24 client:[FE80::1%eth0]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since, err := timeexpr.Parse("2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Query{
+		Hosts:       []string{"dr"},
+		Tags:        []string{"dhcpd"},
+		Clients:     []string{"10.0.0.0/24", "fe80::1%eth0"},
+		Fields:      []string{"container=web-1"},
+		MaxSeverity: 4,
+		Since:       since,
+		Terms:       []string{"DHCPDISCOVER from"},
+	}
+	if diff := cmp.Diff(want, q); diff != "" {
+		t.Fatalf("Parse: unexpected diff (-want +got):\n%s", diff)
+	}
+	if got, want := q.String(), `host:dr tag:dhcpd client:10.0.0.0/24 client:fe80::1%eth0 sev>=warning since:2h container=web-1 "DHCPDISCOVER from"`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	reparsed, err := Parse(q.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(q, reparsed); diff != "" {
+		t.Errorf("Parse(String()): unexpected diff (-want +got):\n%s", diff)
+	}
+
+	for _, input := range []string{
+		`"unterminated`,
+		`sev>=loud`,
+		`since:-2h`,
+		`since:tomorrow`,
+		`time:2024-07-01..`,
+		`sev>=err sev<=debug`,
+		`client:dr`,
+		`client:10.0.0.0/33`,
+	} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) unexpectedly succeeded", input)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		query string
+		line  string
+		want  bool
+	}{
+		{`DISCOVER`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`OFFER`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`seq`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false}, // fields are not text
+		{`tag:dhcpd`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`tag:ntp tag:dhcpd`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`tag:ntp`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`since:30m`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`since:10m`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`until:10m`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`since:2022-08-13T17:00:00+02:00`, "rfc3339=2022-08-13T16:00:00+02:00 seq=1 dhcpd: DHCPDISCOVER", false},
+		{`since:"today 3pm"`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`until:"today 3pm"`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`time:yesterday`, "rfc3339=2022-08-12T23:59:59Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`time:yesterday`, "rfc3339=2022-08-13T00:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`time:2022-08-01..2022-08-12`, "rfc3339=2022-08-12T12:00:00Z seq=1 dhcpd: DHCPDISCOVER", true},
+		{`sev>=warn`, "rfc3339=2022-08-13T16:00:00Z seq=1 severity=err kernel: I/O error", true},
+		{`sev>=warn`, "rfc3339=2022-08-13T16:00:00Z seq=1 severity=info dhcpd: DHCPDISCOVER", false},
+		{`sev>=warn`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false}, // unknown severity
+		{`sev:info`, "rfc3339=2022-08-13T16:00:00Z seq=1 severity=info dhcpd: DHCPDISCOVER", true},
+		{`zone:home`, "rfc3339=2022-08-13T16:00:00Z seq=1 zone=home dhcpd: DHCPDISCOVER", true},
+		{`zone:home`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`client:10.0.0.16`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=10.0.0.16 dhcpd: DHCPDISCOVER", true},
+		{`client:10.0.0.16`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=10.0.0.17 dhcpd: DHCPDISCOVER", false},
+		{`client:10.0.0.16`, "rfc3339=2022-08-13T16:00:00Z seq=1 dhcpd: DHCPDISCOVER", false},
+		{`client:10.0.0.0/24 client:192.168.1.1`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=10.0.0.17 dhcpd: DHCPDISCOVER", true},
+		{`client:::ffff:10.0.0.16`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=10.0.0.16 dhcpd: DHCPDISCOVER", true},
+		{`client:2001:db8::/32`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=2001:db8::1 dhcpd: DHCPDISCOVER", true},
+		{`client:2001:DB8::1`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=2001:db8::1 dhcpd: DHCPDISCOVER", true},
+		{`client:fe80::1`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=fe80::1%eth0 dhcpd: DHCPDISCOVER", true},
+		{`client:[fe80::1%eth0]`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=fe80::1%eth0 dhcpd: DHCPDISCOVER", true},
+		{`client:fe80::1%eth1`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=fe80::1%eth0 dhcpd: DHCPDISCOVER", false},
+		{`client:fe80::/10`, "rfc3339=2022-08-13T16:00:00Z seq=1 client=fe80::1%eth0 dhcpd: DHCPDISCOVER", true},
+		{`container=web-1`, "rfc3339=2022-08-13T16:00:00Z seq=1 image=nginx container=web-1 nginx/web-1: GET /", true},
+		{`container=web-2`, "rfc3339=2022-08-13T16:00:00Z seq=1 image=nginx container=web-1 nginx/web-1: GET /", false},
+	} {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := q.Period(now)
+		if got := q.Match(tt.line, start, end); got != tt.want {
+			t.Errorf("Parse(%q).Match(%q) = %v, want %v", tt.query, tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package retired implements the marker with which gokr-syslogctl decommission
+// marks a host as decommissioned: <host>/RETIRED holds what gokr-syslogd does
+// with further messages claiming the host’s name and what happens to the
+// host’s log files. gokr-syslogweb hides retired hosts unless asked to include
+// them.
+package retired
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileName is the name of the marker within the host directory.
+const FileName = "RETIRED"
+
+// What gokr-syslogd does with messages from a retired host.
+const (
+	MessagesDrop       = "drop"       // reject (counted with reason=retired)
+	MessagesQuarantine = "quarantine" // write into -quarantine_dir instead
+)
+
+// What happens to the log files of a retired host.
+const (
+	RetentionFreeze  = "freeze"  // keep all files, regardless of retention
+	RetentionKeep    = "keep"    // keep applying retention until all are gone
+	RetentionArchive = "archive" // files were moved into Marker.Archive
+)
+
+// Marker is the content of a RETIRED file.
+type Marker struct {
+	Time      time.Time `json:"time"`
+	Messages  string    `json:"messages"`
+	Retention string    `json:"retention"`
+	Archive   string    `json:"archive,omitempty"` // path of the archive file
+}
+
+// Validate returns an error if m contains an unknown action.
+func (m Marker) Validate() error {
+	switch m.Messages {
+	case MessagesDrop, MessagesQuarantine:
+	default:
+		return fmt.Errorf("invalid messages action %q: expected %s or %s", m.Messages, MessagesDrop, MessagesQuarantine)
+	}
+	switch m.Retention {
+	case RetentionFreeze, RetentionKeep, RetentionArchive:
+	default:
+		return fmt.Errorf("invalid retention %q: expected %s, %s or %s", m.Retention, RetentionFreeze, RetentionKeep, RetentionArchive)
+	}
+	return nil
+}
+
+// Read reads a marker from r.
+func Read(r io.Reader) (Marker, error) {
+	var m Marker
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return Marker{}, err
+	}
+	return m, m.Validate()
+}
+
+// ReadFile reads the marker of the host directory hostDir. It returns false
+// if the host is not retired.
+func ReadFile(hostDir string) (Marker, bool, error) {
+	f, err := os.Open(filepath.Join(hostDir, FileName))
+	if os.IsNotExist(err) {
+		return Marker{}, false, nil
+	}
+	if err != nil {
+		return Marker{}, false, err
+	}
+	defer f.Close()
+	m, err := Read(f)
+	if err != nil {
+		return Marker{}, false, fmt.Errorf("%s: %v", f.Name(), err)
+	}
+	return m, true, nil
+}
+
+// Write writes m to w.
+func (m Marker) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Hosts returns the markers of all retired hosts in dir, by host directory
+// name.
+func Hosts(dir string) (map[string]Marker, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]Marker)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		m, ok, err := ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hosts[entry.Name()] = m
+		}
+	}
+	return hosts, nil
+}
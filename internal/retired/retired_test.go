@@ -0,0 +1,47 @@
+package retired
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHosts(t *testing.T) {
+	dir := t.TempDir()
+	for _, host := range []string{"dr", "raspberrypi"} {
+		if err := os.Mkdir(filepath.Join(dir, host), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := Marker{
+		Time:      time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+		Messages:  MessagesQuarantine,
+		Retention: RetentionFreeze,
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "raspberrypi", FileName), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := Hosts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Marker{"raspberrypi": m}
+	if diff := cmp.Diff(want, hosts); diff != "" {
+		t.Errorf("Hosts: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "dr", FileName), []byte(`{"messages":"ignore","retention":"keep"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Hosts(dir); err == nil {
+		t.Errorf("Hosts(invalid messages action) = nil error, want error")
+	}
+}
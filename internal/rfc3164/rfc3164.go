@@ -0,0 +1,264 @@
+// Package rfc3164 parses BSD syslog messages (RFC 3164) the way gokrazy
+// devices and the usual senders (Go’s log/syslog, busybox, rsyslog, Docker’s
+// syslog driver, gokr-kmsg) send them:
+//
+//	<30>Aug 13 16:20:00 dr dhcpd[123]: DHCPDISCOVER from 00:0d:b9:4a:7e:20
+//	<30>2022-08-13T16:20:00.123Z dr ntpd[7]: clock synchronized
+//	<30>Aug 13 16:20:00 nginx/web-1[1234]: GET / HTTP/1.1
+//
+// Timestamps are either RFC 3164’s (without year and zone, see Parse) or RFC
+// 3339, with Z or a numeric offset, optionally with fractional seconds. The
+// hostname is optional, as glibc’s syslog(3) and Docker omit it.
+package rfc3164
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Errors returned by Parse alongside the fallback message of RFC 3164
+// sections 4.3.2 and 4.3.3.
+var (
+	ErrNoPriority = errors.New("no <priority> prefix")
+	ErrTimestamp  = errors.New("no valid timestamp after <priority>")
+)
+
+// Message is a parsed message.
+type Message struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string // empty if the message has none
+	Tag       string // empty if the message has none, without PID
+	Content   string
+}
+
+// Parse parses the message b, received at now. Timestamps without zone are
+// interpreted in loc (nil means UTC), and timestamps without year are in the
+// year which puts them closest to now (e.g. Dec 31 23:59:59 received on
+// January 1 is in the previous year).
+//
+// Messages without priority are returned as content of facility user with
+// severity notice, messages without valid timestamp as content with their
+// priority, both received at now, along with ErrNoPriority or ErrTimestamp.
+func Parse(b []byte, now time.Time, loc *time.Location) (Message, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	s := string(b)
+	msg := Message{Facility: 1, Severity: 5, Timestamp: now}
+	pri, rest, ok := parsePriority(s)
+	if !ok {
+		msg.Content = trimContent(s)
+		return msg, ErrNoPriority
+	}
+	msg.Facility, msg.Severity = pri/8, pri%8
+	ts, rest, ok := parseTimestamp(rest, now, loc)
+	if !ok {
+		msg.Content = trimContent(rest)
+		return msg, ErrTimestamp
+	}
+	msg.Timestamp = ts
+	msg.Hostname, rest = parseHostname(strings.TrimLeft(rest, " "))
+	msg.Tag, rest = parseTag(rest)
+	msg.Content = trimContent(rest)
+	return msg, nil
+}
+
+// parsePriority parses the <PRI> prefix of s, a number from 0 to 191.
+func parsePriority(s string) (pri int, rest string, ok bool) {
+	if !strings.HasPrefix(s, "<") {
+		return 0, s, false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, s, false
+	}
+	for _, c := range s[1:end] {
+		if c < '0' || c > '9' {
+			return 0, s, false
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return 0, s, false
+	}
+	return pri, s[end+1:], true
+}
+
+// parseTimestamp parses the timestamp at the start of s.
+func parseTimestamp(s string, now time.Time, loc *time.Location) (time.Time, string, bool) {
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		return parseRFC3339(s, loc)
+	}
+	return parseStamp(s, now, loc)
+}
+
+// parseRFC3339 parses an RFC 3339 timestamp up to the next space, e.g.
+// 2022-08-13T16:20:00.123Z, or one without offset (which RFC 3339 requires,
+// but some senders omit), which is in loc.
+func parseRFC3339(s string, loc *time.Location) (time.Time, string, bool) {
+	token, rest := s, ""
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		token, rest = s[:i], s[i:]
+	}
+	if t, err := time.Parse(time.RFC3339Nano, token); err == nil {
+		return t, rest, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", token, loc); err == nil {
+		return t, rest, true
+	}
+	return time.Time{}, s, false
+}
+
+var months = map[string]time.Month{
+	"Jan": time.January,
+	"Feb": time.February,
+	"Mar": time.March,
+	"Apr": time.April,
+	"May": time.May,
+	"Jun": time.June,
+	"Jul": time.July,
+	"Aug": time.August,
+	"Sep": time.September,
+	"Oct": time.October,
+	"Nov": time.November,
+	"Dec": time.December,
+}
+
+// digits consumes between min and max decimal digits from the start of s.
+func digits(s string, min, max int) (n int, rest string, ok bool) {
+	i := 0
+	for i < len(s) && i < max && s[i] >= '0' && s[i] <= '9' {
+		n = n*10 + int(s[i]-'0')
+		i++
+	}
+	return n, s[i:], i >= min
+}
+
+// parseStamp parses an RFC 3164 timestamp (Mmm dd hh:mm:ss, with the day
+// padded by a space or not), optionally with fractional seconds.
+func parseStamp(s string, now time.Time, loc *time.Location) (time.Time, string, bool) {
+	if len(s) < 4 || s[3] != ' ' {
+		return time.Time{}, s, false
+	}
+	month, ok := months[s[:3]]
+	if !ok {
+		return time.Time{}, s, false
+	}
+	rest := strings.TrimLeft(s[4:], " ")
+	day, rest, ok := digits(rest, 1, 2)
+	if !ok || day < 1 || day > 31 || !strings.HasPrefix(rest, " ") {
+		return time.Time{}, s, false
+	}
+	var clock [3]int
+	rest = rest[1:]
+	for i := range clock {
+		if i > 0 {
+			if !strings.HasPrefix(rest, ":") {
+				return time.Time{}, s, false
+			}
+			rest = rest[1:]
+		}
+		if clock[i], rest, ok = digits(rest, 2, 2); !ok {
+			return time.Time{}, s, false
+		}
+	}
+	if clock[0] > 23 || clock[1] > 59 || clock[2] > 59 {
+		return time.Time{}, s, false
+	}
+	nsec := 0
+	if strings.HasPrefix(rest, ".") {
+		frac, after, ok := digits(rest[1:], 1, 9)
+		if !ok {
+			return time.Time{}, s, false
+		}
+		for i := len(rest[1:]) - len(after); i < 9; i++ {
+			frac *= 10
+		}
+		nsec, rest = frac, after
+	}
+	if rest != "" && rest[0] != ' ' {
+		return time.Time{}, s, false
+	}
+	var (
+		best  time.Time
+		found bool
+	)
+	year := now.In(loc).Year()
+	for _, y := range []int{year - 1, year, year + 1} {
+		t := time.Date(y, month, day, clock[0], clock[1], clock[2], nsec, loc)
+		if t.Day() != day {
+			continue // e.g. Feb 29 outside of leap years
+		}
+		if !found || abs(t.Sub(now)) < abs(best.Sub(now)) {
+			best, found = t, true
+		}
+	}
+	if !found {
+		return time.Time{}, s, false
+	}
+	return best, rest, true
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// parseHostname parses the hostname at the start of s, if any: the first word,
+// unless it is (part of) the tag, i.e. ends with a colon (unless it is an IPv6
+// address) or a [PID].
+func parseHostname(s string) (hostname, rest string) {
+	i := strings.IndexByte(s, ' ')
+	if i <= 0 {
+		return "", s // a single word is content
+	}
+	token := s[:i]
+	if token == "-" {
+		return "", s[i+1:]
+	}
+	if strings.HasSuffix(token, ":") {
+		if _, err := netip.ParseAddr(token); err != nil { // e.g. fe80::
+			return "", s
+		}
+	}
+	if open := strings.IndexByte(token, '['); open > 0 && strings.HasSuffix(token, "]") {
+		return "", s
+	}
+	return token, s[i+1:]
+}
+
+// parseTag parses the tag at the start of s, e.g. dhcpd[123]: or kernel:.
+// Words not followed by a colon or [PID] are content.
+func parseTag(s string) (tag, rest string) {
+	end := strings.IndexAny(s, ":[ ")
+	if end == -1 {
+		return "", s
+	}
+	switch s[end] {
+	case ':':
+		return s[:end], strings.TrimPrefix(s[end+1:], " ")
+	case '[':
+		closing := strings.IndexByte(s[end:], ']')
+		if closing == -1 {
+			return "", s
+		}
+		after := s[end+closing+1:]
+		if !strings.HasPrefix(after, ":") && !strings.HasPrefix(after, " ") && after != "" {
+			return "", s
+		}
+		return s[:end], strings.TrimPrefix(strings.TrimPrefix(after, ":"), " ")
+	}
+	return "", s
+}
+
+// trimContent removes the spaces around s and the line break or NUL byte some
+// senders terminate messages with.
+func trimContent(s string) string {
+	return strings.TrimLeft(strings.TrimRight(s, " \r\n\x00"), " ")
+}
@@ -0,0 +1,304 @@
+package rfc3164
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 0, time.UTC)
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skip(err) // no time zone database
+	}
+	for _, tt := range []struct {
+		desc    string
+		raw     string
+		now     time.Time // defaults to now
+		loc     *time.Location
+		want    Message
+		wantErr error
+	}{
+		{
+			desc: "gokrazy",
+			raw:  "<30>Aug 13 16:20:00 dr dhcpd[123]: DHCPDISCOVER from 00:0d:b9:4a:7e:20",
+			want: Message{
+				Facility:  3,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "dr",
+				Tag:       "dhcpd",
+				Content:   "DHCPDISCOVER from 00:0d:b9:4a:7e:20",
+			},
+		},
+		{
+			desc: "in zone, space-padded day",
+			raw:  "<14>Aug  3 16:20:00 dr sshd: hello",
+			loc:  zurich,
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 3, 16, 20, 0, 0, zurich),
+				Hostname:  "dr",
+				Tag:       "sshd",
+				Content:   "hello",
+			},
+		},
+		{
+			desc: "fractional seconds",
+			raw:  "<14>Aug 13 16:20:00.25 dr sshd: hello",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 250*int(time.Millisecond), time.UTC),
+				Hostname:  "dr",
+				Tag:       "sshd",
+				Content:   "hello",
+			},
+		},
+		{
+			desc: "New Year’s Eve",
+			raw:  "<14>Dec 31 23:59:59 dr sshd: late",
+			now:  time.Date(2023, time.January, 1, 0, 0, 1, 0, time.UTC),
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.December, 31, 23, 59, 59, 0, time.UTC),
+				Hostname:  "dr",
+				Tag:       "sshd",
+				Content:   "late",
+			},
+		},
+		{
+			desc: "RFC3339 with Z and fraction",
+			raw:  "<30>2022-08-13T16:20:00.123Z dr ntpd[7]: clock synchronized",
+			want: Message{
+				Facility:  3,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 123*int(time.Millisecond), time.UTC),
+				Hostname:  "dr",
+				Tag:       "ntpd",
+				Content:   "clock synchronized",
+			},
+		},
+		{
+			desc: "RFC3339 with offset",
+			raw:  "<30>2022-08-13T18:20:00+02:00 dr ntpd: ok",
+			want: Message{
+				Facility:  3,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "dr",
+				Tag:       "ntpd",
+				Content:   "ok",
+			},
+		},
+		{
+			desc: "RFC3339 without offset",
+			raw:  "<30>2022-08-13T16:20:00 dr ntpd: ok",
+			loc:  zurich,
+			want: Message{
+				Facility:  3,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, zurich),
+				Hostname:  "dr",
+				Tag:       "ntpd",
+				Content:   "ok",
+			},
+		},
+		{
+			desc: "Docker, without hostname",
+			raw:  "<30>Aug 13 16:20:00 nginx/web-1[1234]: GET / HTTP/1.1",
+			want: Message{
+				Facility:  3,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Tag:       "nginx/web-1",
+				Content:   "GET / HTTP/1.1",
+			},
+		},
+		{
+			desc: "glibc, without hostname",
+			raw:  "<13>Aug 13 16:20:00 cron: job done\n",
+			want: Message{
+				Facility:  1,
+				Severity:  5,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Tag:       "cron",
+				Content:   "job done",
+			},
+		},
+		{
+			desc: "IPv6 hostname",
+			raw:  "<14>Aug 13 16:20:00 fe80::1 sshd: hello",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "fe80::1",
+				Tag:       "sshd",
+				Content:   "hello",
+			},
+		},
+		{
+			desc: "missing tag",
+			raw:  "<14>Aug 13 16:20:00 dr no tag here",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "dr",
+				Content:   "no tag here",
+			},
+		},
+		{
+			desc: "empty tag",
+			raw:  "<14>Aug 13 16:20:00 dr : no tag here",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "dr",
+				Content:   "no tag here",
+			},
+		},
+		{
+			desc: "nil hostname",
+			raw:  "<14>Aug 13 16:20:00 - sshd: hello",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Tag:       "sshd",
+				Content:   "hello",
+			},
+		},
+		{
+			desc: "no priority",
+			raw:  "DHCPDISCOVER",
+			want: Message{
+				Facility:  1,
+				Severity:  5,
+				Timestamp: now,
+				Content:   "DHCPDISCOVER",
+			},
+			wantErr: ErrNoPriority,
+		},
+		{
+			desc: "priority out of range",
+			raw:  "<192>Aug 13 16:20:00 dr sshd: hello",
+			want: Message{
+				Facility:  1,
+				Severity:  5,
+				Timestamp: now,
+				Content:   "<192>Aug 13 16:20:00 dr sshd: hello",
+			},
+			wantErr: ErrNoPriority,
+		},
+		{
+			desc: "RFC5424",
+			raw:  "<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: now,
+				Content:   "1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER",
+			},
+			wantErr: ErrTimestamp,
+		},
+		{
+			desc: "truncated",
+			raw:  "<0>",
+			want: Message{
+				Timestamp: now,
+			},
+			wantErr: ErrTimestamp,
+		},
+		{
+			desc: "invalid day",
+			raw:  "<14>Feb 30 16:20:00 dr sshd: hello",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: now,
+				Content:   "Feb 30 16:20:00 dr sshd: hello",
+			},
+			wantErr: ErrTimestamp,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			received := now
+			if !tt.now.IsZero() {
+				received = tt.now
+			}
+			got, err := Parse([]byte(tt.raw), received, tt.loc)
+			if err != tt.wantErr {
+				t.Fatalf("Parse(%q): err = %v, want %v", tt.raw, err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Parse(%q): unexpected diff (-want +got):\n%s", tt.raw, diff)
+			}
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, raw := range []string{
+		"<30>Aug 13 16:20:00 dr dhcpd[123]: DHCPDISCOVER",
+		"<30>Aug  3 16:20:00.123456 nginx/web-1[1234]: GET / HTTP/1.1",
+		"<30>2022-08-13T16:20:00.123Z dr ntpd[7]: clock synchronized",
+		"<30>2022-08-13T16:20:00 dr ntpd: ok",
+		"<14>Aug 13 16:20:00 fe80:: sshd: hello",
+		"<14>Aug 13 16:20:00 dr : no tag here",
+		"<14>Aug 13 16:20:00 dr [1]: hello",
+		"<14>Aug 13 16:20:00 dr",
+		"<0>",
+		"<14>1 2022-08-13T16:20:00Z dr dhcpd 123 - - DHCPDISCOVER",
+	} {
+		f.Add(raw)
+	}
+	now := time.Date(2022, time.August, 13, 16, 20, 30, 0, time.UTC)
+	f.Fuzz(func(t *testing.T, raw string) {
+		msg, err := Parse([]byte(raw), now, nil)
+		if msg.Facility < 0 || msg.Facility > 23 || msg.Severity < 0 || msg.Severity > 7 {
+			t.Fatalf("Parse(%q): priority out of range: %+v", raw, msg)
+		}
+		if msg.Content != trimContent(msg.Content) {
+			t.Fatalf("Parse(%q): content %q is not trimmed", raw, msg.Content)
+		}
+		if err != nil {
+			if msg.Hostname != "" || msg.Tag != "" || !msg.Timestamp.Equal(now) {
+				t.Fatalf("Parse(%q) = %+v, %v: want only content", raw, msg, err)
+			}
+			return
+		}
+		if strings.Contains(msg.Hostname, " ") || strings.ContainsAny(msg.Tag, " :[") {
+			t.Fatalf("Parse(%q): hostname %q or tag %q contain separators", raw, msg.Hostname, msg.Tag)
+		}
+		if msg.Timestamp.IsZero() {
+			t.Fatalf("Parse(%q): zero timestamp", raw)
+		}
+		if msg.Hostname == "" || msg.Tag == "" {
+			return
+		}
+		// Messages with hostname and tag parse into the same message when
+		// formatted again.
+		formatted := "<" + strconv.Itoa(msg.Facility*8+msg.Severity) + ">" +
+			msg.Timestamp.Format(time.RFC3339Nano) + " " + msg.Hostname + " " + msg.Tag + ": " + msg.Content
+		again, err := Parse([]byte(formatted), now, nil)
+		if err != nil {
+			t.Fatalf("Parse(%q) (formatted from %q): %v", formatted, raw, err)
+		}
+		if !again.Timestamp.Equal(msg.Timestamp) {
+			t.Fatalf("Parse(%q): timestamp %v, want %v", formatted, again.Timestamp, msg.Timestamp)
+		}
+		again.Timestamp = msg.Timestamp
+		if diff := cmp.Diff(msg, again); diff != "" {
+			t.Fatalf("Parse(%q) (formatted from %q): unexpected diff (-want +got):\n%s", formatted, raw, diff)
+		}
+	})
+}
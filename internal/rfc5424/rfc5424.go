@@ -0,0 +1,201 @@
+// Package rfc5424 parses syslog messages in the format of RFC 5424, which
+// rsyslog (RSYSLOG_SyslogProtocol23Format) and syslog-ng (syslog() destinations)
+// send:
+//
+//	<165>1 2022-08-13T16:20:00.003Z dr evntslog 123 ID47 [exampleSDID@32473 iut="3"] An application event
+package rfc5424
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a parsed message. Fields which the message sets to the NILVALUE
+// (-) are empty.
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time // zero if the message has none
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []Element
+	Content        string
+}
+
+// Element is an SD-ELEMENT of the structured data, e.g.
+// [exampleSDID@32473 iut="3"].
+type Element struct {
+	ID     string
+	Params []Param
+}
+
+// Param is an SD-PARAM of an Element, with escapes resolved.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Is reports whether b looks like an RFC 5424 message, i.e. starts with
+// <PRI>VERSION and a space, which RFC 3164 messages never do.
+func Is(b []byte) bool {
+	end := -1
+	for i := 1; i < len(b) && i <= 4; i++ {
+		if b[i] == '>' {
+			end = i
+			break
+		}
+	}
+	if len(b) == 0 || b[0] != '<' || end < 2 {
+		return false
+	}
+	rest := b[end+1:]
+	i := 0
+	for i < len(rest) && i < 3 && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	return i > 0 && rest[0] != '0' && i < len(rest) && rest[i] == ' '
+}
+
+var errTruncated = errors.New("truncated header")
+
+// Parse parses the message b.
+func Parse(b []byte) (Message, error) {
+	var msg Message
+	s := string(b)
+	if !strings.HasPrefix(s, "<") {
+		return msg, errors.New("no <priority> prefix")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return msg, errors.New("invalid <priority>")
+	}
+	pri := 0
+	for _, c := range s[1:end] {
+		if c < '0' || c > '9' {
+			return msg, errors.New("invalid <priority>")
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return msg, fmt.Errorf("priority %d out of range", pri)
+	}
+	msg.Facility, msg.Severity = pri/8, pri%8
+	rest := s[end+1:]
+
+	var header [6]string // VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	for i := range header {
+		sp := strings.IndexByte(rest, ' ')
+		if sp == -1 {
+			if i < len(header)-1 || rest == "" {
+				return msg, errTruncated
+			}
+			// MSGID without structured data and message, which some
+			// senders do not separate with a space.
+			header[i], rest = rest, ""
+			break
+		}
+		header[i], rest = rest[:sp], rest[sp+1:]
+		if header[i] == "" {
+			return msg, fmt.Errorf("empty header field %d", i+1)
+		}
+	}
+	if header[0] != "1" {
+		return msg, fmt.Errorf("unsupported version %q", header[0])
+	}
+	if header[1] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, header[1])
+		if err != nil {
+			return msg, fmt.Errorf("invalid timestamp %q", header[1])
+		}
+		msg.Timestamp = ts
+	}
+	nilValue := func(s string) string {
+		if s == "-" {
+			return ""
+		}
+		return s
+	}
+	msg.Hostname = nilValue(header[2])
+	msg.AppName = nilValue(header[3])
+	msg.ProcID = nilValue(header[4])
+	msg.MsgID = nilValue(header[5])
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else if rest != "" {
+		sd, after, err := parseStructuredData(rest)
+		if err != nil {
+			return msg, err
+		}
+		msg.StructuredData, rest = sd, after
+	}
+	if rest != "" && rest[0] != ' ' {
+		return msg, errors.New("no space after structured data")
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	rest = strings.TrimPrefix(rest, "\ufeff") // BOM marking UTF-8 content
+	msg.Content = strings.TrimRight(rest, " \r\n\x00")
+	return msg, nil
+}
+
+// parseStructuredData parses the SD-ELEMENTs at the start of s.
+func parseStructuredData(s string) ([]Element, string, error) {
+	var elements []Element
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end < 1 {
+			return nil, "", errors.New("invalid structured data ID")
+		}
+		el := Element{ID: s[:end]}
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq < 1 || strings.ContainsAny(s[:eq], ` ]"`) {
+				return nil, "", fmt.Errorf("invalid parameter in structured data element %q", el.ID)
+			}
+			name := s[:eq]
+			value, after, err := parseParamValue(s[eq+2:])
+			if err != nil {
+				return nil, "", fmt.Errorf("structured data element %q: %v", el.ID, err)
+			}
+			el.Params = append(el.Params, Param{Name: name, Value: value})
+			s = after
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated structured data element %q", el.ID)
+		}
+		s = s[1:]
+		elements = append(elements, el)
+	}
+	if elements == nil {
+		return nil, "", errors.New("invalid structured data")
+	}
+	return elements, s, nil
+}
+
+// parseParamValue parses the PARAM-VALUE at the start of s up to the closing
+// quote, resolving the escapes \", \\ and \].
+func parseParamValue(s string) (value, rest string, err error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated parameter value")
+}
@@ -0,0 +1,138 @@
+package rfc5424
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		raw     string
+		want    Message
+		wantErr bool
+	}{
+		{
+			raw: `<165>1 2022-08-13T16:20:00.003Z dr evntslog 123 ID47 [exampleSDID@32473 iut="3" eventSource="Application"][origin ip="10.0.0.16"] An application event`,
+			want: Message{
+				Facility:  20,
+				Severity:  5,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 3*int(time.Millisecond), time.UTC),
+				Hostname:  "dr",
+				AppName:   "evntslog",
+				ProcID:    "123",
+				MsgID:     "ID47",
+				StructuredData: []Element{
+					{ID: "exampleSDID@32473", Params: []Param{{"iut", "3"}, {"eventSource", "Application"}}},
+					{ID: "origin", Params: []Param{{"ip", "10.0.0.16"}}},
+				},
+				Content: "An application event",
+			},
+		},
+		{
+			// rsyslog’s RSYSLOG_SyslogProtocol23Format
+			raw: "<14>1 2022-08-13T18:20:00.123456+02:00 dr sshd 1234 - - \ufeffAccepted publickey\n",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 123456000, time.UTC),
+				Hostname:  "dr",
+				AppName:   "sshd",
+				ProcID:    "1234",
+				Content:   "Accepted publickey",
+			},
+		},
+		{
+			raw: `<14>1 - - - - - [meta note="a \"quoted\] \\ value"]`,
+			want: Message{
+				Facility: 1,
+				Severity: 6,
+				StructuredData: []Element{
+					{ID: "meta", Params: []Param{{"note", `a "quoted] \ value`}}},
+				},
+			},
+		},
+		{
+			raw: "<14>1 2022-08-13T16:20:00Z dr sshd - ID47",
+			want: Message{
+				Facility:  1,
+				Severity:  6,
+				Timestamp: time.Date(2022, time.August, 13, 16, 20, 0, 0, time.UTC),
+				Hostname:  "dr",
+				AppName:   "sshd",
+				MsgID:     "ID47",
+			},
+		},
+		{raw: "<14>Aug 13 16:20:00 dr sshd: hello", wantErr: true},
+		{raw: "<14>2 2022-08-13T16:20:00Z dr sshd - - - hello", wantErr: true},
+		{raw: "<14>1 2022-08-13T16:20:00Z dr sshd", wantErr: true},
+		{raw: "<14>1 yesterday dr sshd - - - hello", wantErr: true},
+		{raw: `<14>1 2022-08-13T16:20:00Z dr sshd - - [meta note="unterminated] hello`, wantErr: true},
+		{raw: `<14>1 2022-08-13T16:20:00Z dr sshd - - [meta]hello`, wantErr: true},
+	} {
+		got, err := Parse([]byte(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q): err = %v, want error: %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("Parse(%q): unexpected diff (-want +got):\n%s", tt.raw, diff)
+		}
+	}
+}
+
+func TestIs(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want bool
+	}{
+		{"<14>1 2022-08-13T16:20:00Z dr sshd - - - hello", true},
+		{"<14>1 - - - - -", true},
+		{"<14>Aug 13 16:20:00 dr sshd: hello", false},
+		{"<14>2022-08-13T16:20:00Z dr sshd: hello", false},
+		{"<14>1", false},
+		{"14>1 ", false},
+	} {
+		if got := Is([]byte(tt.raw)); got != tt.want {
+			t.Errorf("Is(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, raw := range []string{
+		`<165>1 2022-08-13T16:20:00.003Z dr evntslog 123 ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`,
+		"<14>1 2022-08-13T18:20:00+02:00 dr sshd 1234 - - \ufeffAccepted publickey",
+		`<14>1 - - - - - [meta note="a \"quoted\] \\ value"]`,
+		"<14>1 2022-08-13T16:20:00Z dr sshd - ID47",
+	} {
+		f.Add(raw)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		msg, err := Parse([]byte(raw))
+		if err != nil {
+			return
+		}
+		if !Is([]byte(raw)) {
+			t.Fatalf("Parse(%q) succeeded, but Is returns false", raw)
+		}
+		if msg.Facility < 0 || msg.Facility > 23 || msg.Severity < 0 || msg.Severity > 7 {
+			t.Fatalf("Parse(%q): priority out of range: %+v", raw, msg)
+		}
+		for _, field := range []string{msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID} {
+			if field == "-" || strings.Contains(field, " ") {
+				t.Fatalf("Parse(%q): invalid header field %q", raw, field)
+			}
+		}
+		for _, el := range msg.StructuredData {
+			if el.ID == "" || strings.ContainsAny(el.ID, " ]") {
+				t.Fatalf("Parse(%q): invalid structured data ID %q", raw, el.ID)
+			}
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Package rollup implements the rollup files which gokr-syslogd writes for
+// each day of log files with -rollups: <host>/rollup/<day>.json holds how
+// many messages (and errors) each tag logged per hour. Rollups are small, so
+// they can be kept far longer than the log files they summarize.
+package rollup
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/logline"
+)
+
+// DirName is the name of the directory within each host directory which holds
+// the rollup files.
+const DirName = "rollup"
+
+// FileName returns the name of the rollup file for day (2006-01-02).
+func FileName(day string) string {
+	return day + ".json"
+}
+
+// Count is the number of messages of a tag within an hour.
+type Count struct {
+	Hour     time.Time `json:"hour"`
+	Tag      string    `json:"tag"`
+	Messages uint64    `json:"messages"`
+	// Errors counts messages with severity err or more severe, which
+	// requires gokr-syslogd -store_severity.
+	Errors uint64 `json:"errors,omitempty"`
+}
+
+var errorSeverities = map[string]bool{
+	"emerg": true,
+	"alert": true,
+	"crit":  true,
+	"err":   true,
+}
+
+type key struct {
+	hour time.Time
+	tag  string
+}
+
+// Builder computes the counts of lines.
+type Builder struct {
+	counts map[key]*Count
+}
+
+// NewBuilder returns a Builder without counts.
+func NewBuilder() *Builder {
+	return &Builder{counts: make(map[key]*Count)}
+}
+
+// Add counts line, which is in the format of package logline. Lines without
+// a valid rfc3339= field are skipped.
+func (b *Builder) Add(line string) {
+	ts, ok := logline.Field(line, "rfc3339")
+	if !ok {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return
+	}
+	tag, _, _ := strings.Cut(logline.Strip(line), ": ")
+	k := key{hour: t.UTC().Truncate(time.Hour), tag: tag}
+	c, ok := b.counts[k]
+	if !ok {
+		c = &Count{Hour: k.hour, Tag: tag}
+		b.counts[k] = c
+	}
+	c.Messages++
+	if severity, ok := logline.Field(line, "severity"); ok && errorSeverities[severity] {
+		c.Errors++
+	}
+}
+
+// Counts returns the counts, sorted by hour and tag.
+func (b *Builder) Counts() []Count {
+	counts := make([]Count, 0, len(b.counts))
+	for _, c := range b.counts {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if !counts[i].Hour.Equal(counts[j].Hour) {
+			return counts[i].Hour.Before(counts[j].Hour)
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts
+}
+
+// Write writes counts in the format of a rollup file.
+func Write(w io.Writer, counts []Count) error {
+	if counts == nil {
+		counts = []Count{}
+	}
+	return json.NewEncoder(w).Encode(counts)
+}
+
+// Read reads the counts of a rollup file.
+func Read(r io.Reader) ([]Count, error) {
+	var counts []Count
+	if err := json.NewDecoder(r).Decode(&counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// ReadFile reads the rollup file fn.
+func ReadFile(fn string) ([]Count, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Days returns the days (2006-01-02, sorted) for which the host directory
+// hostDir has rollup files.
+func Days(hostDir string) ([]string, error) {
+	fis, err := os.ReadDir(filepath.Join(hostDir, DirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var days []string
+	for _, fi := range fis {
+		day := strings.TrimSuffix(fi.Name(), ".json")
+		if day == fi.Name() {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
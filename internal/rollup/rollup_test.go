@@ -0,0 +1,43 @@
+package rollup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder()
+	for _, line := range []string{
+		"rfc3339=2022-08-13T16:20:00+02:00 seq=1 severity=info dhcpd: DHCPDISCOVER",
+		"rfc3339=2022-08-13T16:59:59+02:00 seq=2 severity=err dhcpd: no free leases",
+		"rfc3339=2022-08-13T17:00:00+02:00 seq=3 dhcpd: DHCPOFFER",
+		"rfc3339=2022-08-13T16:30:00+02:00 seq=4 severity=crit kernel: Out of memory",
+		"not a log line",
+	} {
+		b.Add(line)
+	}
+	hour := func(h int) time.Time { return time.Date(2022, time.August, 13, h, 0, 0, 0, time.UTC) }
+	want := []Count{
+		{Hour: hour(14), Tag: "dhcpd", Messages: 2, Errors: 1},
+		{Hour: hour(14), Tag: "kernel", Messages: 1, Errors: 1},
+		{Hour: hour(15), Tag: "dhcpd", Messages: 1},
+	}
+	if diff := cmp.Diff(want, b.Counts()); diff != "" {
+		t.Errorf("Counts: unexpected diff (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Read(Write()): unexpected diff (-want +got):\n%s", diff)
+	}
+}
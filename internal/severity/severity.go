@@ -0,0 +1,40 @@
+// Package severity names the syslog severities, so that all flags, files and
+// queries of gokr-syslogd and gokr-syslogweb accept the same spellings, e.g.
+// -severity_retention=warn=90, floor in -tag_filters, sev>= in -output_rules
+// and queries, and severity >= in -rules.
+package severity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Names are the syslog severities as used in syslog.conf(5), indexed by their
+// code.
+var Names = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// aliases are the deprecated names which syslog.conf(5) accepts in addition to
+// Names.
+var aliases = map[string]int{
+	"panic": 0,
+	"error": 3,
+	"warn":  4,
+}
+
+// Parse returns the code of a severity name (see Names), alias (panic, error
+// or warn) or code (0 to 7).
+func Parse(s string) (int, error) {
+	for code, name := range Names {
+		if s == name {
+			return code, nil
+		}
+	}
+	if code, ok := aliases[s]; ok {
+		return code, nil
+	}
+	if code, err := strconv.Atoi(s); err == nil && code >= 0 && code < len(Names) {
+		return code, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q: expected one of %s", s, strings.Join(Names, ", "))
+}
@@ -0,0 +1,34 @@
+package severity
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want int
+	}{
+		{"emerg", 0},
+		{"panic", 0},
+		{"err", 3},
+		{"error", 3},
+		{"warning", 4},
+		{"warn", 4},
+		{"debug", 7},
+		{"4", 4},
+		{"0", 0},
+	} {
+		got, err := Parse(tt.s)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "Warning", "8", "-1", "fatal"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) unexpectedly succeeded", s)
+		}
+	}
+}
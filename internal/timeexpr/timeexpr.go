@@ -0,0 +1,195 @@
+// Package timeexpr parses the human time expressions shared by the query
+// language (since:, until:, time:), the gokr-syslogweb API and grog:
+//
+//	15m, 2h30m, 3d, 1w (optionally followed by "ago")
+//	now, today, yesterday
+//	3pm, 15:04, yesterday 3pm, 2024-07-01 15:04
+//	2024-07-01, 2024-07
+//	2022-08-13T16:00:00+02:00
+//	2024-07-01..2024-07-03
+//
+// An expression denotes a period: a day covers the whole day, a time of day or
+// a duration (before now) a single instant. A range a..b starts at the start of
+// a and ends at the end of b. Days and times of day are in the location of the
+// time at which the expression is evaluated.
+package timeexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a parsed time expression. The zero Expr is empty (see IsZero).
+type Expr struct {
+	text string
+	eval func(now time.Time) (start, end time.Time)
+}
+
+// At returns the expression of the instant t, or the zero Expr if t is zero.
+func At(t time.Time) Expr {
+	if t.IsZero() {
+		return Expr{}
+	}
+	return Expr{
+		text: t.Format(time.RFC3339Nano),
+		eval: func(time.Time) (time.Time, time.Time) { return t, t },
+	}
+}
+
+// IsZero reports whether e is the zero Expr.
+func (e Expr) IsZero() bool { return e.eval == nil }
+
+// String returns e as it was parsed, with whitespace normalized.
+func (e Expr) String() string { return e.text }
+
+// Equal reports whether e and o were parsed from the same text.
+func (e Expr) Equal(o Expr) bool { return e.text == o.text }
+
+// Period returns the period e denotes at now, which is a single instant unless
+// e is a day or a range. The zero Expr denotes zero times.
+func (e Expr) Period(now time.Time) (start, end time.Time) {
+	if e.eval == nil {
+		return time.Time{}, time.Time{}
+	}
+	return e.eval(now)
+}
+
+// Parse parses the time expression s.
+func Parse(s string) (Expr, error) {
+	text := strings.Join(strings.Fields(s), " ")
+	if from, to, ok := strings.Cut(strings.ToLower(text), ".."); ok {
+		fromEval, err := parse(from)
+		if err != nil {
+			return Expr{}, err
+		}
+		toEval, err := parse(to)
+		if err != nil {
+			return Expr{}, err
+		}
+		return Expr{
+			text: text,
+			eval: func(now time.Time) (time.Time, time.Time) {
+				start, _ := fromEval(now)
+				_, end := toEval(now)
+				return start, end
+			},
+		}, nil
+	}
+	eval, err := parse(strings.ToLower(text))
+	if err != nil {
+		return Expr{}, err
+	}
+	return Expr{text: text, eval: eval}, nil
+}
+
+func parse(s string) (func(now time.Time) (time.Time, time.Time), error) {
+	s = strings.TrimSpace(s)
+	if d, ok, err := parseDuration(strings.TrimSpace(strings.TrimSuffix(s, " ago"))); ok {
+		if err != nil {
+			return nil, err
+		}
+		return func(now time.Time) (time.Time, time.Time) {
+			t := now.Add(-d)
+			return t, t
+		}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, strings.ToUpper(s)); err == nil {
+		return func(time.Time) (time.Time, time.Time) { return t, t }, nil
+	}
+	dayStr, todStr, _ := strings.Cut(s, " ")
+	day, err := parseDay(dayStr)
+	if err != nil {
+		// A time of day without a day is today.
+		if _, _, _, todErr := parseTimeOfDay(dayStr); todErr != nil || todStr != "" {
+			return nil, fmt.Errorf("invalid time %q: expected e.g. 15m, yesterday 3pm, 2024-07-01 or an RFC3339 time", s)
+		}
+		day, todStr = parseRelativeDay(0), dayStr
+	}
+	if todStr == "" {
+		return day, nil
+	}
+	hour, min, sec, err := parseTimeOfDay(todStr)
+	if err != nil {
+		return nil, err
+	}
+	return func(now time.Time) (time.Time, time.Time) {
+		start, _ := day(now)
+		t := time.Date(start.Year(), start.Month(), start.Day(), hour, min, sec, 0, start.Location())
+		return t, t
+	}, nil
+}
+
+// parseDuration parses a Go duration like 2h30m, or a number of days (3d) or
+// weeks (1w). ok reports whether s looks like a duration.
+func parseDuration(s string) (d time.Duration, ok bool, _ error) {
+	if s == "" || (s[0] < '0' || s[0] > '9') && s[0] != '-' {
+		return 0, false, nil
+	}
+	unit := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[s[len(s)-1]]
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, false, nil
+		}
+		d = time.Duration(n) * unit
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, false, nil
+		}
+	}
+	if d <= 0 {
+		return 0, true, fmt.Errorf("invalid duration %q: must be positive", s)
+	}
+	return d, true, nil
+}
+
+// parseRelativeDay returns the day offset days from the day of now.
+func parseRelativeDay(offset int) func(now time.Time) (time.Time, time.Time) {
+	return func(now time.Time) (time.Time, time.Time) {
+		start := time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// parseDay parses now, today, yesterday or a 2006-01-02 day or 2006-01 month.
+func parseDay(s string) (func(now time.Time) (time.Time, time.Time), error) {
+	switch s {
+	case "now":
+		return func(now time.Time) (time.Time, time.Time) { return now, now }, nil
+	case "today":
+		return parseRelativeDay(0), nil
+	case "yesterday":
+		return parseRelativeDay(-1), nil
+	}
+	for _, f := range []struct {
+		layout string
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 1},
+		{"2006-01", 1, 0},
+	} {
+		t, err := time.Parse(f.layout, s)
+		if err != nil {
+			continue
+		}
+		return func(now time.Time) (time.Time, time.Time) {
+			start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())
+			return start, start.AddDate(0, f.months, f.days)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid day %q", s)
+}
+
+// parseTimeOfDay parses 3pm, 3:30pm, 15:04 or 15:04:05.
+func parseTimeOfDay(s string) (hour, min, sec int, _ error) {
+	for _, layout := range []string{"3pm", "3:04pm", "15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour(), t.Minute(), t.Second(), nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("invalid time of day %q: expected e.g. 3pm or 15:04", s)
+}
@@ -0,0 +1,61 @@
+package timeexpr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2024, time.July, 3, 16, 20, 0, 0, berlin)
+	day := func(d int) time.Time { return time.Date(2024, time.July, d, 0, 0, 0, 0, berlin) }
+	at := func(d, h, m int) time.Time { return time.Date(2024, time.July, d, h, m, 0, 0, berlin) }
+	for _, tt := range []struct {
+		expr       string
+		start, end time.Time
+	}{
+		{"15m", at(3, 16, 5), at(3, 16, 5)},
+		{"2h ago", at(3, 14, 20), at(3, 14, 20)},
+		{"1d", at(2, 16, 20), at(2, 16, 20)},
+		{"now", now, now},
+		{"today", day(3), day(4)},
+		{"Yesterday", day(2), day(3)},
+		{"yesterday 3pm", at(2, 15, 0), at(2, 15, 0)},
+		{"yesterday  3:30pm", at(2, 15, 30), at(2, 15, 30)},
+		{"9:15", at(3, 9, 15), at(3, 9, 15)},
+		{"2024-07-01 15:04", at(1, 15, 4), at(1, 15, 4)},
+		{"2024-07-01", day(1), day(2)},
+		{"2024-07", day(1), time.Date(2024, time.August, 1, 0, 0, 0, 0, berlin)},
+		{"2024-07-01..2024-07-03", day(1), day(4)},
+		{"2d..yesterday", at(1, 16, 20), day(3)},
+		{"2024-07-01T10:00:00Z", at(1, 12, 0), at(1, 12, 0)},
+	} {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		start, end := e.Period(now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("Parse(%q).Period = %v, %v, want %v, %v", tt.expr, start, end, tt.start, tt.end)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"-2h",
+		"0m",
+		"tomorrow",
+		"3pm yesterday",
+		"2024-13-01",
+		"2024-07-01..",
+		"yesterday 25:00",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) unexpectedly succeeded", expr)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package senderconfig fetches the sender configuration which gokr-syslogd
+// serves at Path, so that programs forwarding their logs (e.g. gokrazy’s
+// remote syslog writer) only need to know the name of the collector.
+package senderconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/syslogd/internal/mdns"
+)
+
+// Path is the well-known HTTP path of the sender configuration.
+const Path = "/.well-known/gokr-syslogd.json"
+
+// Config describes how senders should deliver their messages.
+type Config struct {
+	// Address is the host:port to send syslog messages to.
+	Address string `json:"address"`
+
+	// Protocol is the transport for Address, currently always "udp".
+	Protocol string `json:"protocol"`
+
+	// RequireHMAC is true if messages must be signed (see gokr-syslogd
+	// -require_hmac); the shared secret is not part of the configuration.
+	RequireHMAC bool `json:"require_hmac,omitempty"`
+}
+
+// Fetch fetches the sender configuration from u, which is either a URL or a
+// [host]:port of the gokr-syslogd HTTP server (see -http_listen), in which
+// case Path is requested via HTTP.
+func Fetch(ctx context.Context, u string) (*Config, error) {
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		u = "http://" + u + Path
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: unexpected HTTP status %v: %s", u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	var cfg Config
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	if cfg.Protocol != "udp" {
+		return nil, fmt.Errorf("%s: unsupported protocol %q", u, cfg.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("%s: address: %v", u, err)
+	}
+	return &cfg, nil
+}
+
+// ResolveTarget resolves the -target flag of the senders in this repository:
+// an http:// or https:// URL is fetched as sender configuration, mdns is
+// looked up via mDNS (see mdns.ResolveTarget) and any other target is
+// returned unchanged.
+func ResolveTarget(ctx context.Context, target string) (string, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		cfg, err := Fetch(ctx, target)
+		if err != nil {
+			return "", err
+		}
+		return cfg.Address, nil
+	}
+	return mdns.ResolveTarget(ctx, target)
+}